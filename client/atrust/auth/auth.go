@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
@@ -15,6 +16,8 @@ import (
 	"time"
 
 	"github.com/mythologyli/zju-connect/client"
+	"github.com/mythologyli/zju-connect/internal/captcha"
+	"github.com/mythologyli/zju-connect/internal/prompt"
 	"github.com/mythologyli/zju-connect/log"
 )
 
@@ -107,6 +110,12 @@ type Session struct {
 	antiReplayRand string
 	ticket         string
 
+	prompter      prompt.Prompter
+	browserLogin  bool
+	captchaSolver captcha.Solver
+	hasClientCert bool
+	ctx           context.Context
+
 	response map[string]json.RawMessage
 }
 
@@ -157,6 +166,49 @@ type LoginMethod interface {
 	login(*Session, AuthInfo) error
 }
 
+// SetPrompter sets where interactive authentication steps ask for input. A nil
+// prompter reads from the terminal.
+func (s *Session) SetPrompter(p prompt.Prompter) {
+	s.prompter = p
+}
+
+// SetBrowserLogin makes CAS and OAuth2 logins open the browser and capture the
+// callback on a loopback listener instead of asking for it to be pasted.
+func (s *Session) SetBrowserLogin(enable bool) {
+	s.browserLogin = enable
+}
+
+// SetContext makes waiting login steps, like polling the status of a QR code,
+// give up once ctx is done.
+func (s *Session) SetContext(ctx context.Context) {
+	s.ctx = ctx
+}
+
+func (s *Session) loginContext() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// SetCaptchaSolver makes graph check codes be solved by solver instead of
+// the user.
+func (s *Session) SetCaptchaSolver(solver captcha.Solver) {
+	s.captchaSolver = solver
+}
+
+func (s *Session) solveGraphCheckCode(imgData []byte) (string, error) {
+	answer, err := s.captchaSolver.Solve(s.loginContext(), captcha.Challenge{Kind: captcha.KindClick, Image: imgData})
+	if err != nil {
+		return "", err
+	}
+	return canonicalizeGraphCheckCode(answer, imgData)
+}
+
+func (s *Session) ask(req prompt.Request) (string, error) {
+	return prompt.Ask(s.loginContext(), s.prompter, req)
+}
+
 func (s *Session) randSdpId(n ...int) string {
 	length := 8
 	if len(n) > 0 {
@@ -185,21 +237,27 @@ func (s *Session) withGraphCheckCode(process func(string) (int, error), graphCod
 			return err
 		}
 
-		_, _, err = s.authConfig(false, true)
+		_, _, err = s.authConfig(s.loginContext(), false, true)
 		if err != nil {
 			return err
 		}
 
 		var graphCheckCode string
-		if graphCodeFile != "" {
+		if s.captchaSolver != nil {
+			graphCheckCode, err = s.solveGraphCheckCode(imgData)
+			if err != nil {
+				log.Printf("Captcha solver failed (attempt %d/%d): %v", attempt, maxAttempts, err)
+				// Skip submitting so that the next attempt gets a new captcha.
+				continue
+			}
+		} else if graphCodeFile != "" {
 			if writeErr := os.WriteFile(graphCodeFile, imgData, 0644); writeErr != nil {
 				log.Printf("Warning: failed to write graph code image to %s: %v", graphCodeFile, writeErr)
 			} else {
 				log.Printf("Graph check code saved to %s", graphCodeFile)
 			}
 
-			log.Print("Please enter the graph check code JSON: ")
-			_, err = fmt.Scanln(&graphCheckCode)
+			graphCheckCode, err = s.ask(prompt.Request{Kind: prompt.KindGraphCheckCode, Message: "Please enter the graph check code JSON: ", Image: imgData})
 			if err != nil {
 				return err
 			}
@@ -231,10 +289,24 @@ func (s *Session) withGraphCheckCode(process func(string) (int, error), graphCod
 }
 
 func (s *Session) GetAuthInfoList() ([]AuthInfo, error) {
-	_, list, err := s.authConfig(false, true)
+	_, list, err := s.authConfig(s.loginContext(), false, true)
 	return list, err
 }
 
+// OffersAuthType reports whether the server offers authType in loginDomain.
+func (s *Session) OffersAuthType(authType, loginDomain string) (bool, error) {
+	list, err := s.GetAuthInfoList()
+	if err != nil {
+		return false, err
+	}
+	for _, authInfo := range list {
+		if authInfo.AuthType == authType && authInfo.LoginDomain == loginDomain {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 func (s *Session) continueAuth(step authStep) error {
 	for attempt := 0; attempt < maxAuthSteps; attempt++ {
 		log.DebugPrintf("Continue authentication: service=%s smsMode=%d", step.Service, step.SMSMode)
@@ -279,7 +351,7 @@ func (s *Session) completeSMS(step authStep) (authStep, error) {
 	case smsWithAuthID:
 		// HITSZ-style gateways refresh the ticket-bearing auth config before
 		// querying the phone number and sending the SMS.
-		if _, _, err := s.authConfig(true, true); err != nil {
+		if _, _, err := s.authConfig(s.loginContext(), true, true); err != nil {
 			return authStep{}, err
 		}
 	case smsWithoutAuthID:
@@ -300,7 +372,7 @@ func (s *Session) completeSMS(step authStep) (authStep, error) {
 	}
 
 	if step.SMSMode == smsWithoutAuthID {
-		if _, _, err := s.authConfig(true, true); err != nil {
+		if _, _, err := s.authConfig(s.loginContext(), true, true); err != nil {
 			return authStep{}, err
 		}
 	}
@@ -308,27 +380,32 @@ func (s *Session) completeSMS(step authStep) (authStep, error) {
 	return s.smsCheckCode(step)
 }
 
-func (s *Session) Login(method LoginMethod, opts LoginOptions) (LoginResult, error) {
+// restoreCookies puts saved cookies into the cookie jar and returns the SID
+// among them.
+func (s *Session) restoreCookies(cookies []Cookie) string {
 	sid := ""
-	if len(opts.Cookies) > 0 {
-		for _, cookie := range opts.Cookies {
-			if cookie.Host == s.baseHost && cookie.Scheme == "https" && cookie.Name == "sid" {
-				sid = cookie.Value
-			}
+	for _, cookie := range cookies {
+		if cookie.Host == s.baseHost && cookie.Scheme == "https" && cookie.Name == "sid" {
+			sid = cookie.Value
+		}
 
-			c := &http.Cookie{
-				Name:  cookie.Name,
-				Value: cookie.Value,
-			}
-			s.client.Jar.SetCookies(&url.URL{Host: cookie.Host, Scheme: cookie.Scheme}, []*http.Cookie{c})
+		c := &http.Cookie{
+			Name:  cookie.Name,
+			Value: cookie.Value,
 		}
+		s.client.Jar.SetCookies(&url.URL{Host: cookie.Host, Scheme: cookie.Scheme}, []*http.Cookie{c})
 	}
+	return sid
+}
+
+func (s *Session) Login(method LoginMethod, opts LoginOptions) (LoginResult, error) {
+	sid := s.restoreCookies(opts.Cookies)
 
 	s.deviceID = opts.DeviceID
 	s.totpSecret = opts.TOTPSecret
 	s.env = base64.StdEncoding.EncodeToString([]byte(`{"deviceId":"` + opts.DeviceID + `"}`))
 
-	isLogin, authInfoList, err := s.authConfig(false, true)
+	isLogin, authInfoList, err := s.authConfig(s.loginContext(), false, true)
 	if err != nil {
 		return LoginResult{}, err
 	}
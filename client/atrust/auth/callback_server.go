@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/mythologyli/zju-connect/log"
+)
+
+const browserLoginTimeout = 10 * time.Minute
+
+// openLoginBrowser is replaced in tests.
+var openLoginBrowser = openBrowser
+
+var callbackPageTemplate = template.Must(template.New("callback").Parse(`<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+<meta charset="UTF-8">
+<meta name="viewport" content="width=device-width, initial-scale=1.0">
+<title>zju-connect</title>
+<style>
+  body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif; background: #f0f2f5; display: flex; justify-content: center; padding-top: 10vh; }
+  .card { background: #fff; border-radius: 12px; box-shadow: 0 2px 12px rgba(0,0,0,0.1); padding: 32px; max-width: 560px; }
+  h2 { color: #333; font-size: 20px; margin-bottom: 16px; }
+  p, li { color: #666; font-size: 14px; line-height: 1.6; }
+  a.button, button { display: inline-block; padding: 8px 18px; background: #1890ff; color: #fff; border: none; border-radius: 6px; font-size: 14px; text-decoration: none; cursor: pointer; }
+  a.bookmarklet { border: 1px dashed #1890ff; padding: 2px 8px; border-radius: 4px; }
+  input[type=text] { width: 100%; padding: 8px; box-sizing: border-box; margin: 8px 0; }
+</style>
+</head>
+<body>
+<div class="card">
+  <h2>登录 / Login</h2>
+  <p><a class="button" href="{{.LoginURL}}" target="_blank" rel="noopener">打开登录页面 / Open the login page</a></p>
+  <p>登录完成后，用以下任一方式把最终页面的地址交给 zju-connect：<br>After logging in, hand the address of the final page to zju-connect in either way:</p>
+  <ul>
+    <li>把 <a class="bookmarklet" href="{{.Bookmarklet}}">zju-connect login</a> 拖到书签栏，在最终页面点击它 / Drag it to the bookmarks bar and click it on the final page</li>
+    <li>复制地址栏并粘贴到这里 / Copy the address bar and paste it here:
+      <form method="post" action="/submit">
+        <input type="text" name="url" autocomplete="off" placeholder="https://...">
+        <button type="submit">提交 / Submit</button>
+      </form>
+    </li>
+  </ul>
+</div>
+</body>
+</html>`))
+
+const callbackDoneHTML = `<!DOCTYPE html>
+<html><head><meta charset="UTF-8"><title>zju-connect</title></head>
+<body style="font-family: sans-serif; text-align: center; padding-top: 20vh; color: #52c41a">
+登录信息已提交，可以关闭此页面 / Login received. You may close this page.
+</body></html>`
+
+// serveLoginCallback starts a temporary loopback HTTP server, opens the
+// browser at it and waits for the login callback URL. The page links loginURL
+// unchanged, so the login server redirects to its own redirect target. The
+// callback arrives either as a redirect to /callback carrying the login result
+// in its query (e.g. ?ticket= or ?code=, turned into a callback URL by
+// fromQuery), as /callback?url= from the bookmarklet, or pasted into the page.
+// parse validates a callback URL and returns it in canonical form.
+func serveLoginCallback(loginURL string, parse func(string) (string, error), fromQuery func(url.Values) string, timeout time.Duration) (string, error) {
+	resultCh := make(chan string, 1)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", fmt.Errorf("failed to start login callback server: %w", err)
+	}
+	addr := fmt.Sprintf("http://%s", listener.Addr().String())
+
+	accept := func(w http.ResponseWriter, callback string) {
+		callback, err := parse(callback)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		select {
+		case resultCh <- callback:
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(callbackDoneHTML))
+		default:
+			http.Error(w, "already submitted", http.StatusConflict)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		callbackPageTemplate.Execute(w, map[string]any{
+			"LoginURL": loginURL,
+			// template.URL: html/template would otherwise replace the
+			// javascript: URL with a placeholder.
+			"Bookmarklet": template.URL("javascript:location.href='" + addr + "/callback?url='+encodeURIComponent(location.href)"),
+		})
+	})
+	mux.HandleFunc("GET /callback", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		callback := query.Get("url")
+		if callback == "" {
+			callback = fromQuery(query)
+		}
+		accept(w, callback)
+	})
+	mux.HandleFunc("POST /submit", func(w http.ResponseWriter, r *http.Request) {
+		accept(w, r.FormValue("url"))
+	})
+
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+
+	log.Printf("Login callback server started at %s", addr)
+	openLoginBrowser(addr)
+
+	select {
+	case callback := <-resultCh:
+		log.Println("Login callback received from browser")
+		return callback, nil
+	case <-time.After(timeout):
+		return "", fmt.Errorf("browser login timed out after %v", timeout)
+	}
+}
@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startBrowserLogin runs login in the background with the browser replaced by
+// a function receiving the loopback address.
+func startBrowserLogin(t *testing.T, login func() (string, error)) (string, <-chan string) {
+	t.Helper()
+	addrCh := make(chan string, 1)
+	openLoginBrowser = func(addr string) { addrCh <- addr }
+	t.Cleanup(func() { openLoginBrowser = openBrowser })
+
+	done := make(chan string, 1)
+	go func() {
+		callback, err := login()
+		if err != nil {
+			t.Error(err)
+		}
+		done <- callback
+	}()
+	select {
+	case addr := <-addrCh:
+		return addr, done
+	case <-time.After(5 * time.Second):
+		t.Fatal("login callback server did not start")
+		return "", nil
+	}
+}
+
+func TestBrowserLoginCapturesCASRedirect(t *testing.T) {
+	session := NewSession("vpn.example.com", nil)
+	session.SetBrowserLogin(true)
+	addr, done := startBrowserLogin(t, func() (string, error) {
+		return session.interactiveCas("https://cas.example/login", "CAS")
+	})
+
+	resp, err := http.Get(addr + "/callback?ticket=ST-42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	callback := <-done
+	if want := session.casCallbackFromTicket("CAS", "ST-42"); callback != want {
+		t.Fatalf("callback = %q, want %q", callback, want)
+	}
+}
+
+func TestBrowserLoginKeepsRedirectTarget(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		param  string
+		target string
+		result string
+		login  func(s *Session, loginURL string) (string, error)
+	}{
+		{
+			name:   "CAS",
+			param:  "service",
+			target: "https://vpn.example.com/passport/v1/auth/cas?sfDomain=CAS",
+			result: "ticket=ST-42",
+			login: func(s *Session, loginURL string) (string, error) {
+				return s.interactiveCas(loginURL, "CAS")
+			},
+		},
+		{
+			name:   "OAuth2",
+			param:  "redirect_uri",
+			target: "https://vpn.example.com/passport/v1/auth/httpsOauth2?sfDomain=OAuth2",
+			result: "code=abc",
+			login: func(s *Session, loginURL string) (string, error) {
+				return s.interactiveHttpsOauth2(loginURL, "OAuth2")
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// The login server redirects to its redirect target after login.
+			idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Redirect(w, r, r.URL.Query().Get(tt.param)+"&"+tt.result, http.StatusFound)
+			}))
+			defer idp.Close()
+			loginURL := idp.URL + "/login?" + url.Values{tt.param: {tt.target}}.Encode()
+
+			session := NewSession("vpn.example.com", nil)
+			session.SetBrowserLogin(true)
+			addr, done := startBrowserLogin(t, func() (string, error) {
+				return tt.login(session, loginURL)
+			})
+			if !strings.HasPrefix(addr, "http://127.0.0.1:") {
+				t.Fatalf("browser opened at %s, want the loopback page", addr)
+			}
+
+			// The page links the login URL unchanged.
+			resp, err := http.Get(addr + "/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			page, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if !strings.Contains(string(page), template.HTMLEscapeString(loginURL)) {
+				t.Fatalf("page does not link %s", loginURL)
+			}
+
+			noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			}}
+			resp, err = noRedirect.Get(loginURL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			final := resp.Header.Get("Location")
+
+			// The bookmarklet forwards the final page to the server.
+			resp, err = http.Get(addr + "/callback?url=" + url.QueryEscape(final))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if want := tt.target + "&" + tt.result; <-done != want {
+				t.Fatalf("callback does not keep the redirect target %s", want)
+			}
+		})
+	}
+}
+
+func TestBrowserLoginValidatesPostedURL(t *testing.T) {
+	session := NewSession("vpn.example.com", nil)
+	session.SetBrowserLogin(true)
+	addr, done := startBrowserLogin(t, func() (string, error) {
+		return session.interactiveHttpsOauth2("https://idp.example/authorize", "OAuth2")
+	})
+
+	resp, err := http.Get(addr + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("page status = %d", resp.StatusCode)
+	}
+
+	// A callback for another host is refused and the server keeps waiting.
+	resp, err = http.PostForm(addr+"/submit", url.Values{"url": {"https://evil.example/passport/v1/auth/httpsOauth2?code=x"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("foreign callback status = %d, want 400", resp.StatusCode)
+	}
+
+	callback := "https://vpn.example.com/passport/v1/auth/httpsOauth2?code=abc&sfDomain=OAuth2&state=null"
+	resp, err = http.Get(addr + "/callback?url=" + url.QueryEscape(callback))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := <-done; got != callback {
+		t.Fatalf("callback = %q, want %q", got, callback)
+	}
+	if !strings.HasPrefix(addr, "http://127.0.0.1:") {
+		t.Fatalf("callback server is not on loopback: %s", addr)
+	}
+}
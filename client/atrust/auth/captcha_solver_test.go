@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mythologyli/zju-connect/internal/captcha"
+)
+
+type sequenceSolver struct {
+	answers []string
+	calls   int
+}
+
+func (s *sequenceSolver) Solve(_ context.Context, challenge captcha.Challenge) (string, error) {
+	if challenge.Kind != captcha.KindClick {
+		return "", captcha.ErrUnsupported
+	}
+	answer := s.answers[s.calls%len(s.answers)]
+	s.calls++
+	return answer, nil
+}
+
+func TestWithGraphCheckCodeRetriesSolver(t *testing.T) {
+	imgData := buildPNG(t, 320, 180)
+	var captchas int
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/passport/v1/public/checkCode" {
+			captchas++
+			_, _ = w.Write(imgData)
+			return
+		}
+		_, _ = w.Write([]byte(`{"code":0,"data":{}}`))
+	}))
+	defer server.Close()
+
+	// The first answer cannot be canonicalized, the second is rejected by the
+	// server and the third is accepted.
+	solver := &sequenceSolver{answers: []string{"not json", "[[1,1]]", "[[10,20],[30,40]]"}}
+	session := newTLSTestSession(server)
+	session.SetCaptchaSolver(solver)
+
+	var submitted []string
+	err := session.withGraphCheckCode(func(code string) (int, error) {
+		submitted = append(submitted, code)
+		if code == `{"coordinates":[[10,20],[30,40]],"width":320,"height":180}` {
+			return 0, nil
+		}
+		return 1, nil
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if solver.calls != 3 || captchas != 3 {
+		t.Fatalf("solver calls = %d, captchas = %d, want 3 each", solver.calls, captchas)
+	}
+	if len(submitted) != 3 || submitted[0] != "" {
+		t.Fatalf("submitted = %q", submitted)
+	}
+}
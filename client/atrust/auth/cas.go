@@ -6,12 +6,17 @@ import (
 	"net/http"
 	"net/url"
 
+	"github.com/mythologyli/zju-connect/internal/prompt"
 	"github.com/mythologyli/zju-connect/log"
 )
 
 type CASLogin struct {
 	Domain string
 	Ticket string
+	// Username and Password log in to the CAS server without user
+	// interaction when both are set.
+	Username string
+	Password string
 }
 
 func (m CASLogin) AuthType() string {
@@ -23,14 +28,23 @@ func (m CASLogin) LoginDomain() string {
 }
 
 func (m CASLogin) login(s *Session, authInfo AuthInfo) error {
-	return s.loginAuthCas(authInfo.LoginURL, m.Domain, m.Ticket)
+	return s.loginAuthCas(authInfo.LoginURL, m.Domain, m.Ticket, m.Username, m.Password)
 }
 
-func (s *Session) loginAuthCas(loginURL, loginDomain, ticket string) error {
-	callback := s.casCallbackFromTicket(loginDomain, ticket)
-	if ticket == "" {
+func (s *Session) loginAuthCas(loginURL, loginDomain, ticket, username, password string) error {
+	var callback string
+	if ticket != "" {
+		callback = s.casCallbackFromTicket(loginDomain, ticket)
+	} else if username != "" && password != "" {
+		var err error
+		callback, err = s.headlessCas(loginURL, username, password)
+		if err != nil {
+			log.Printf("Headless CAS login failed, falling back to interactive login: %v", err)
+		}
+	}
+	if callback == "" {
 		var err error
-		callback, err = s.interactiveCas(loginURL)
+		callback, err = s.interactiveCas(loginURL, loginDomain)
 		if err != nil {
 			return err
 		}
@@ -39,7 +53,7 @@ func (s *Session) loginAuthCas(loginURL, loginDomain, ticket string) error {
 	if err := s.cas(callback); err != nil {
 		return err
 	}
-	_, _, err := s.authConfig(true, false)
+	_, _, err := s.authConfig(s.loginContext(), true, false)
 	return err
 }
 
@@ -51,15 +65,25 @@ func (s *Session) casCallbackFromTicket(loginDomain, ticket string) string {
 	return s.baseURL + "/passport/v1/auth/cas?" + params.Encode()
 }
 
-func (s *Session) interactiveCas(loginURL string) (string, error) {
+func (s *Session) interactiveCas(loginURL, loginDomain string) (string, error) {
+	if s.browserLogin {
+		return serveLoginCallback(loginURL, s.parseCASCallback, func(query url.Values) string {
+			if query.Get("ticket") == "" {
+				return ""
+			}
+			return s.casCallbackFromTicket(loginDomain, query.Get("ticket"))
+		}, browserLoginTimeout)
+	}
+
 	log.Printf("Visit %s to login, and catch the callback url", loginURL)
-	log.Println("Please enter the callback url:")
-	var callback string
-	_, err := fmt.Scanln(&callback)
+	callback, err := s.ask(prompt.Request{Kind: prompt.KindCASCallback, Message: "Please enter the callback url:", URL: loginURL})
 	if err != nil {
 		return "", err
 	}
+	return s.parseCASCallback(callback)
+}
 
+func (s *Session) parseCASCallback(callback string) (string, error) {
 	callbackURL, err := url.Parse(callback)
 	if err != nil {
 		return "", err
@@ -0,0 +1,326 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mythologyli/zju-connect/log"
+	"golang.org/x/net/html"
+)
+
+const (
+	casPubKeyPath   = "v2/getPubKey"
+	maxCASRedirects = 10
+)
+
+// casRootCAs verifies the certificate of the CAS server, nil means the system
+// roots. It is replaced in tests.
+var casRootCAs *x509.CertPool
+
+// casForm is the login form found on a CAS login page.
+type casForm struct {
+	action        *url.URL
+	method        string
+	values        url.Values
+	usernameField string
+	passwordField string
+	// pubKeyURL is set when the page encrypts the password before posting it.
+	pubKeyURL *url.URL
+	message   string
+}
+
+// headlessCas logs in to the CAS server behind loginURL with username and
+// password and returns the aTrust callback URL carrying the service ticket.
+// The password is only sent encrypted, to a verified https CAS server.
+func (s *Session) headlessCas(loginURL, username, password string) (string, error) {
+	jar, _ := cookiejar.New(nil)
+	casClient := &http.Client{
+		Transport: s.casTransport(),
+		Jar:       jar,
+		Timeout:   20 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if s.isCASCallback(req.URL) {
+				return http.ErrUseLastResponse
+			}
+			if len(via) >= maxCASRedirects {
+				return errors.New("too many CAS redirects")
+			}
+			return nil
+		},
+	}
+
+	log.Println("Perform headless CAS login")
+	resp, err := casGet(casClient, loginURL)
+	if err != nil {
+		return "", err
+	}
+	if callback, ok := s.casCallbackFromResponse(resp); ok {
+		// The CAS session is still valid, no need to log in again.
+		_ = resp.Body.Close()
+		return callback, nil
+	}
+	form, err := parseCASLoginPage(resp)
+	_ = resp.Body.Close()
+	if err != nil {
+		return "", err
+	}
+
+	if form.pubKeyURL == nil {
+		return "", errors.New("CAS login page does not encrypt the password, refusing to send it")
+	}
+	encryptedPassword, err := encryptCASPassword(casClient, form.pubKeyURL, password)
+	if err != nil {
+		return "", err
+	}
+	form.values.Set(form.usernameField, username)
+	form.values.Set(form.passwordField, encryptedPassword)
+
+	var req *http.Request
+	if form.method == http.MethodGet {
+		action := *form.action
+		action.RawQuery = form.values.Encode()
+		req, err = http.NewRequest(http.MethodGet, action.String(), nil)
+	} else {
+		req, err = http.NewRequest(http.MethodPost, form.action.String(), strings.NewReader(form.values.Encode()))
+		if req != nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", UserAgent)
+	resp, err = casClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+	if callback, ok := s.casCallbackFromResponse(resp); ok {
+		return callback, nil
+	}
+
+	// Still on the login page: show why the CAS server refused us.
+	if failed, err := parseCASLoginPage(resp); err == nil && failed.message != "" {
+		return "", fmt.Errorf("CAS login failed: %s", failed.message)
+	}
+	return "", fmt.Errorf("CAS login failed: no ticket received (status %d)", resp.StatusCode)
+}
+
+// casTransport dials like the session, but verifies the certificate of the
+// server, which the session skips for the aTrust server.
+func (s *Session) casTransport() *http.Transport {
+	var tr *http.Transport
+	if sessionTransport, ok := s.client.Transport.(*http.Transport); ok {
+		tr = sessionTransport.Clone()
+	} else {
+		tr = http.DefaultTransport.(*http.Transport).Clone()
+	}
+	var keyLogWriter io.Writer
+	if tr.TLSClientConfig != nil {
+		keyLogWriter = tr.TLSClientConfig.KeyLogWriter
+	}
+	tr.TLSClientConfig = &tls.Config{RootCAs: casRootCAs, KeyLogWriter: keyLogWriter}
+	return tr
+}
+
+func casGet(casClient *http.Client, u string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", UserAgent)
+	return casClient.Do(req)
+}
+
+func (s *Session) isCASCallback(u *url.URL) bool {
+	return u.Host == s.baseHost && u.Path == "/passport/v1/auth/cas"
+}
+
+// casCallbackFromResponse returns the aTrust callback URL if resp redirects to
+// it with a valid ticket.
+func (s *Session) casCallbackFromResponse(resp *http.Response) (string, bool) {
+	if resp.StatusCode < 300 || resp.StatusCode >= 400 {
+		return "", false
+	}
+	location, err := resp.Location()
+	if err != nil || !s.isCASCallback(location) {
+		return "", false
+	}
+	if err := validateCASCallbackURL(location, s.baseHost); err != nil {
+		log.Printf("Ignore CAS redirect: %v", err)
+		return "", false
+	}
+	return location.String(), true
+}
+
+// parseCASLoginPage finds the form with a password field on a CAS login page.
+func parseCASLoginPage(resp *http.Response) (*casForm, error) {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return nil, err
+	}
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	var form *casForm
+	var message string
+	var walk func(n *html.Node, current *casForm)
+	walk = func(n *html.Node, current *casForm) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "form":
+				current = &casForm{method: strings.ToUpper(htmlAttr(n, "method")), values: url.Values{}}
+				action, err := resp.Request.URL.Parse(htmlAttr(n, "action"))
+				if err == nil {
+					current.action = action
+				}
+			case "input":
+				if current != nil {
+					addCASInput(current, n)
+					if current.passwordField != "" && form == nil {
+						form = current
+					}
+				}
+			}
+			if message == "" && isCASMessage(n) {
+				message = strings.TrimSpace(htmlText(n))
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c, current)
+		}
+	}
+	walk(doc, nil)
+
+	if form == nil || form.action == nil {
+		return nil, errors.New("CAS login form not found")
+	}
+	if form.action.Scheme != "https" || form.action.Host != resp.Request.URL.Host {
+		return nil, fmt.Errorf("CAS login form posts to %s, not to the https CAS server %s", form.action.Redacted(), resp.Request.URL.Host)
+	}
+	if form.usernameField == "" {
+		return nil, errors.New("CAS login form has no username field")
+	}
+	if form.method != http.MethodGet {
+		form.method = http.MethodPost
+	}
+	if bytes.Contains(body, []byte(casPubKeyPath)) {
+		form.pubKeyURL, _ = resp.Request.URL.Parse(casPubKeyPath)
+	}
+	form.message = message
+	return form, nil
+}
+
+func addCASInput(form *casForm, n *html.Node) {
+	name := htmlAttr(n, "name")
+	if name == "" {
+		return
+	}
+	switch strings.ToLower(htmlAttr(n, "type")) {
+	case "password":
+		if form.passwordField == "" {
+			form.passwordField = name
+		}
+	case "", "text", "email", "tel":
+		if form.usernameField == "" {
+			form.usernameField = name
+		} else {
+			form.values.Set(name, htmlAttr(n, "value"))
+		}
+	case "hidden", "submit":
+		// lt, execution, _eventId and the like must be sent back unchanged.
+		form.values.Set(name, htmlAttr(n, "value"))
+	case "checkbox", "radio":
+		if _, checked := htmlAttrLookup(n, "checked"); checked {
+			form.values.Set(name, htmlAttr(n, "value"))
+		}
+	}
+}
+
+func isCASMessage(n *html.Node) bool {
+	id := strings.ToLower(htmlAttr(n, "id"))
+	class := strings.ToLower(htmlAttr(n, "class"))
+	return id == "msg" || id == "errormsg" || strings.Contains(class, "error") || strings.Contains(class, "alert-danger")
+}
+
+func htmlAttr(n *html.Node, key string) string {
+	value, _ := htmlAttrLookup(n, key)
+	return value
+}
+
+func htmlAttrLookup(n *html.Node, key string) (string, bool) {
+	for _, attr := range n.Attr {
+		if strings.EqualFold(attr.Key, key) {
+			return attr.Val, true
+		}
+	}
+	return "", false
+}
+
+func htmlText(n *html.Node) string {
+	var sb strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return sb.String()
+}
+
+// encryptCASPassword fetches the RSA key of the CAS server and encrypts the
+// password the way its login page does: PKCS #1 v1.5, hex encoded.
+func encryptCASPassword(casClient *http.Client, pubKeyURL *url.URL, password string) (string, error) {
+	resp, err := casGet(casClient, pubKeyURL.String())
+	if err != nil {
+		return "", err
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+
+	var key struct {
+		Modulus  string `json:"modulus"`
+		Exponent string `json:"exponent"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&key); err != nil {
+		return "", fmt.Errorf("invalid CAS public key: %w", err)
+	}
+	modulus, ok := new(big.Int).SetString(key.Modulus, 16)
+	if !ok || modulus.Sign() <= 0 {
+		return "", fmt.Errorf("invalid CAS public key modulus")
+	}
+	exponent, err := strconv.ParseInt(key.Exponent, 16, 32)
+	if err != nil || exponent <= 0 {
+		return "", fmt.Errorf("invalid CAS public key exponent %q", key.Exponent)
+	}
+
+	encrypted, err := rsa.EncryptPKCS1v15(rand.Reader, &rsa.PublicKey{N: modulus, E: int(exponent)}, []byte(password))
+	if err != nil {
+		return "", fmt.Errorf("encrypt password for CAS: %w", err)
+	}
+	return hex.EncodeToString(encrypted), nil
+}
@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const fakeCASLoginPage = `<!DOCTYPE html>
+<html><body>
+<script src="js/login/security.js"></script>
+<script>$.get("v2/getPubKey", function (key) { /* ... */ });</script>
+<form id="fm1" action="/cas/login?service=%s" method="post">
+  <input id="username" name="username" type="text" value="">
+  <input id="password" name="password" type="password">
+  <input type="text" name="authcode" value="">
+  <input type="checkbox" name="rememberMe" value="true">
+  <input type="hidden" name="execution" value="e1s1-%s">
+  <input type="hidden" name="_eventId" value="submit">
+  %s
+</form>
+</body></html>`
+
+func newFakeCASServer(t *testing.T, service string) *httptest.Server {
+	t.Helper()
+	privateKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /cas/v2/getPubKey", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"modulus":  privateKey.N.Text(16),
+			"exponent": strconv.FormatInt(int64(privateKey.E), 16),
+		})
+	})
+	mux.HandleFunc("GET /cas/login", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "JSESSIONID", Value: "session", Path: "/cas"})
+		fmt.Fprintf(w, fakeCASLoginPage, url.QueryEscape(service), "token", "")
+	})
+	mux.HandleFunc("POST /cas/login", func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie("JSESSIONID"); err != nil || cookie.Value != "session" {
+			http.Error(w, "no session", http.StatusBadRequest)
+			return
+		}
+		encrypted, err := hex.DecodeString(r.PostFormValue("password"))
+		if err != nil {
+			http.Error(w, "bad password encoding", http.StatusBadRequest)
+			return
+		}
+		decrypted, err := rsa.DecryptPKCS1v15(nil, privateKey, encrypted)
+		if err != nil {
+			http.Error(w, "bad password encryption", http.StatusBadRequest)
+			return
+		}
+		password := string(decrypted)
+		if r.PostFormValue("execution") != "e1s1-token" || r.PostFormValue("_eventId") != "submit" ||
+			r.PostFormValue("rememberMe") != "" || r.URL.Query().Get("service") != service {
+			http.Error(w, "bad form", http.StatusBadRequest)
+			return
+		}
+		if r.PostFormValue("username") != "3200100000" || password != "correct horse" {
+			fmt.Fprintf(w, fakeCASLoginPage, url.QueryEscape(service), "token2", `<p id="errormsg">用户名或密码错误</p>`)
+			return
+		}
+		// Go through an intermediate redirect like real portals do.
+		http.Redirect(w, r, "/cas/redirect?to="+url.QueryEscape(service+"&ticket=ST-1-abc"), http.StatusFound)
+	})
+	mux.HandleFunc("GET /cas/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, r.URL.Query().Get("to"), http.StatusFound)
+	})
+	server := httptest.NewTLSServer(mux)
+	t.Cleanup(server.Close)
+	trustCASServer(t, server)
+	return server
+}
+
+// trustCASServer makes the CAS client trust the certificate of server.
+func trustCASServer(t *testing.T, server *httptest.Server) {
+	t.Helper()
+	oldRootCAs := casRootCAs
+	t.Cleanup(func() { casRootCAs = oldRootCAs })
+	casRootCAs = x509.NewCertPool()
+	casRootCAs.AddCert(server.Certificate())
+}
+
+func TestHeadlessCASLogin(t *testing.T) {
+	session := NewSession("vpn.example.com", nil)
+	service := session.baseURL + "/passport/v1/auth/cas?sfDomain=CAS"
+	server := newFakeCASServer(t, service)
+	loginURL := server.URL + "/cas/login?service=" + url.QueryEscape(service)
+
+	callback, err := session.headlessCas(loginURL, "3200100000", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	callbackURL, err := url.Parse(callback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := validateCASCallbackURL(callbackURL, session.baseHost); err != nil {
+		t.Fatal(err)
+	}
+	if ticket := callbackURL.Query().Get("ticket"); ticket != "ST-1-abc" {
+		t.Fatalf("ticket = %q", ticket)
+	}
+}
+
+func TestHeadlessCASLoginReportsError(t *testing.T) {
+	session := NewSession("vpn.example.com", nil)
+	service := session.baseURL + "/passport/v1/auth/cas?sfDomain=CAS"
+	server := newFakeCASServer(t, service)
+	loginURL := server.URL + "/cas/login?service=" + url.QueryEscape(service)
+
+	_, err := session.headlessCas(loginURL, "3200100000", "wrong")
+	if err == nil || !strings.Contains(err.Error(), "用户名或密码错误") {
+		t.Fatalf("headlessCas() error = %v, want the CAS error message", err)
+	}
+}
+
+func TestHeadlessCASLoginRejectsForeignCallback(t *testing.T) {
+	session := NewSession("vpn.example.com", nil)
+	// The CAS server sends the ticket to another host, which must not be
+	// accepted as the aTrust callback.
+	server := newFakeCASServer(t, "https://evil.example/passport/v1/auth/cas?sfDomain=CAS")
+	loginURL := server.URL + "/cas/login?service=" + url.QueryEscape("https://evil.example/passport/v1/auth/cas?sfDomain=CAS")
+
+	if _, err := session.headlessCas(loginURL, "3200100000", "correct horse"); err == nil {
+		t.Fatal("headlessCas() accepted a callback to another host")
+	}
+}
+
+func TestHeadlessCASLoginVerifiesCertificate(t *testing.T) {
+	session := NewSession("vpn.example.com", nil)
+	service := session.baseURL + "/passport/v1/auth/cas?sfDomain=CAS"
+	server := newFakeCASServer(t, service)
+	casRootCAs = x509.NewCertPool()
+	loginURL := server.URL + "/cas/login?service=" + url.QueryEscape(service)
+
+	if _, err := session.headlessCas(loginURL, "3200100000", "correct horse"); err == nil {
+		t.Fatal("headlessCas() sent the password to an unverified CAS server")
+	}
+}
+
+func TestHeadlessCASLoginRefusesUnsafeForms(t *testing.T) {
+	for name, page := range map[string]string{
+		"plaintext":    `<form action="/cas/login" method="post"><input name="username"><input name="password" type="password"></form>`,
+		"http action":  `<script>$.get("v2/getPubKey")</script><form action="http://cas.example/cas/login" method="post"><input name="username"><input name="password" type="password"></form>`,
+		"foreign host": `<script>$.get("v2/getPubKey")</script><form action="https://evil.example/cas/login" method="post"><input name="username"><input name="password" type="password"></form>`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			posted := false
+			server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodPost {
+					posted = true
+				}
+				fmt.Fprint(w, page)
+			}))
+			defer server.Close()
+			trustCASServer(t, server)
+
+			session := NewSession("vpn.example.com", nil)
+			if _, err := session.headlessCas(server.URL+"/cas/login", "3200100000", "correct horse"); err == nil || posted {
+				t.Fatalf("headlessCas() error = %v, posted = %v", err, posted)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/mythologyli/zju-connect/log"
+)
+
+type CertificateLogin struct {
+	Domain string
+}
+
+func (m CertificateLogin) AuthType() string {
+	return "auth/cert"
+}
+
+func (m CertificateLogin) LoginDomain() string {
+	return m.Domain
+}
+
+func (m CertificateLogin) login(s *Session, _ AuthInfo) error {
+	return s.loginAuthCert(m.Domain)
+}
+
+// SetClientCertificate presents cert in the TLS handshakes of the session,
+// which certificate login relies on.
+func (s *Session) SetClientCertificate(cert tls.Certificate) {
+	tr, ok := s.client.Transport.(*http.Transport)
+	if !ok || len(cert.Certificate) == 0 {
+		return
+	}
+	tr.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	s.hasClientCert = true
+}
+
+func (s *Session) loginAuthCert(loginDomain string) error {
+	if !s.hasClientCert {
+		return errors.New("certificate login requires a client certificate")
+	}
+
+	log.Println("Perform POST /passport/v1/auth/cert")
+
+	postBody, _ := json.Marshal(map[string]string{
+		"loginDomain": loginDomain,
+	})
+	u := s.baseURL + "/passport/v1/auth/cert"
+	req, _ := http.NewRequest("POST", u+"?"+WithSharedParams(nil).Encode(), bytes.NewReader(postBody))
+	req.Header.Set("User-Agent", UserAgent)
+	req.Header.Set("Content-Type", "application/json;charset=utf-8")
+	req.Header.Set("x-csrf-token", s.csrfToken)
+	req.Header.Set("x-sdp-env", s.env)
+	req.Header.Set("x-sdp-traceid", s.randSdpId())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+	body, _ := io.ReadAll(resp.Body)
+	log.DebugPrintf("Received cert: %s", string(body))
+
+	var re struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Data    struct {
+			Ticket   string `json:"ticket"`
+			Username string `json:"username"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &re); err != nil {
+		return err
+	}
+	if re.Code != 0 {
+		return fmt.Errorf("certificate authentication failed with code %d: %s", re.Code, re.Message)
+	}
+
+	s.ticket = re.Data.Ticket
+	if re.Data.Username != "" {
+		s.username = re.Data.Username
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestClientCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "3200100000"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestCertificateLogin(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/passport/v1/auth/cert" || len(r.TLS.PeerCertificates) == 0 {
+			fmt.Fprint(w, `{"code":401,"message":"no certificate"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"code":0,"data":{"ticket":"cert-ticket","username":"%s@Cert"}}`, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	session := newTLSTestSession(server)
+	if err := (CertificateLogin{Domain: "Cert"}).login(session, AuthInfo{}); err == nil || !strings.Contains(err.Error(), "requires a client certificate") {
+		t.Fatalf("expected missing certificate error, got %v", err)
+	}
+
+	session.SetClientCertificate(newTestClientCertificate(t))
+	if err := (CertificateLogin{Domain: "Cert"}).login(session, AuthInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	if session.ticket != "cert-ticket" || session.username != "3200100000@Cert" {
+		t.Fatalf("ticket = %q, username = %q", session.ticket, session.username)
+	}
+}
+
+func TestOffersAuthType(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"code":0,"data":{"authServerInfoList":[{"loginDomain":"Cert","authType":"auth/cert"},{"loginDomain":"Local","authType":"auth/psw"}]}}`)
+	}))
+	defer server.Close()
+
+	session := newTLSTestSession(server)
+	for _, tc := range []struct {
+		authType, loginDomain string
+		want                  bool
+	}{
+		{"auth/cert", "Cert", true},
+		{"auth/cert", "Local", false},
+		{"auth/psw", "Local", true},
+	} {
+		got, err := session.OffersAuthType(tc.authType, tc.loginDomain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Fatalf("OffersAuthType(%q, %q) = %v, want %v", tc.authType, tc.loginDomain, got, tc.want)
+		}
+	}
+}
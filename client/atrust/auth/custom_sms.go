@@ -9,6 +9,7 @@ import (
 	"net/url"
 	"strings"
 
+	"github.com/mythologyli/zju-connect/internal/prompt"
 	"github.com/mythologyli/zju-connect/log"
 )
 
@@ -17,10 +18,9 @@ func (s *Session) completeCustomSMS() (authStep, error) {
 		return authStep{}, err
 	}
 
-	code := ""
 	log.Println("Tips: Add prefix '$' to sms code to skip secondary authentication")
-	log.Print("Please enter the SMS verification code: ")
-	if _, err := fmt.Scanln(&code); err != nil {
+	code, err := s.ask(prompt.Request{Kind: prompt.KindSMSCode, Message: "Please enter the SMS verification code: "})
+	if err != nil {
 		return authStep{}, err
 	}
 
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/url"
 
+	"github.com/mythologyli/zju-connect/internal/prompt"
 	"github.com/mythologyli/zju-connect/log"
 )
 
@@ -33,7 +34,7 @@ func (s *Session) loginAuthHttpsOauth2(loginURL, loginDomain, code, callback str
 	}
 	if callback == "" {
 		var err error
-		callback, err = s.interactiveHttpsOauth2(loginURL)
+		callback, err = s.interactiveHttpsOauth2(loginURL, loginDomain)
 		if err != nil {
 			return err
 		}
@@ -43,7 +44,7 @@ func (s *Session) loginAuthHttpsOauth2(loginURL, loginDomain, code, callback str
 		return err
 	}
 
-	_, _, err := s.authConfig(true, false)
+	_, _, err := s.authConfig(s.loginContext(), true, false)
 	return err
 }
 
@@ -56,15 +57,25 @@ func (s *Session) httpsOauth2CallbackFromCode(loginDomain, code string) string {
 	return s.baseURL + "/passport/v1/auth/httpsOauth2?" + params.Encode()
 }
 
-func (s *Session) interactiveHttpsOauth2(loginURL string) (string, error) {
+func (s *Session) interactiveHttpsOauth2(loginURL, loginDomain string) (string, error) {
+	if s.browserLogin {
+		return serveLoginCallback(loginURL, s.parseHttpsOauth2Callback, func(query url.Values) string {
+			if query.Get("code") == "" {
+				return ""
+			}
+			return s.httpsOauth2CallbackFromCode(loginDomain, query.Get("code"))
+		}, browserLoginTimeout)
+	}
+
 	log.Printf("Visit %s to login, and catch the callback url", loginURL)
-	log.Println("Please enter the callback url:")
-	var callback string
-	_, err := fmt.Scanln(&callback)
+	callback, err := s.ask(prompt.Request{Kind: prompt.KindOAuth2Callback, Message: "Please enter the callback url:", URL: loginURL})
 	if err != nil {
 		return "", err
 	}
+	return s.parseHttpsOauth2Callback(callback)
+}
 
+func (s *Session) parseHttpsOauth2Callback(callback string) (string, error) {
 	callbackURL, err := url.Parse(callback)
 	if err != nil {
 		return "", err
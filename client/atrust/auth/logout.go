@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/mythologyli/zju-connect/log"
+)
+
+// Logout ends the session stored in opts.Cookies on the server, so that it
+// stops counting against the online session limit of the account. The SID
+// can not be reused afterwards.
+func (s *Session) Logout(ctx context.Context, opts LoginOptions) error {
+	if s.restoreCookies(opts.Cookies) == "" {
+		return nil
+	}
+	s.deviceID = opts.DeviceID
+	s.env = base64.StdEncoding.EncodeToString([]byte(`{"deviceId":"` + opts.DeviceID + `"}`))
+
+	// The CSRF token is bound to the session and only handed out by
+	// authConfig.
+	if _, _, err := s.authConfig(ctx, false, false); err != nil {
+		return err
+	}
+
+	log.Println("Perform POST /passport/v1/user/logout")
+
+	u := s.baseURL + "/passport/v1/user/logout"
+	req, _ := http.NewRequestWithContext(ctx, "POST", u+"?"+WithSharedParams(nil).Encode(), nil)
+	req.Header.Set("User-Agent", UserAgent)
+	req.Header.Set("x-csrf-token", s.csrfToken)
+	req.Header.Set("x-sdp-env", s.env)
+	req.Header.Set("x-sdp-traceid", s.randSdpId())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+	body, _ := io.ReadAll(resp.Body)
+	log.DebugPrintf("Received logout: %s", string(body))
+
+	var re struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &re); err != nil {
+		return err
+	}
+	if re.Code != 0 {
+		return fmt.Errorf("logout failed with code %d: %s", re.Code, re.Message)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLogout(t *testing.T) {
+	var loggedOut bool
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sid, err := r.Cookie("sid")
+		if err != nil || sid.Value != "test-sid" {
+			fmt.Fprint(w, `{"code":401,"message":"not logged in"}`)
+			return
+		}
+		switch r.URL.Path {
+		case "/passport/v1/public/authConfig":
+			fmt.Fprint(w, `{"code":0,"data":{"isLogin":1,"csrfToken":"test-csrf"}}`)
+		case "/passport/v1/user/logout":
+			if r.Method != http.MethodPost || r.Header.Get("x-csrf-token") != "test-csrf" {
+				fmt.Fprint(w, `{"code":403,"message":"bad request"}`)
+				return
+			}
+			loggedOut = true
+			fmt.Fprint(w, `{"code":0,"message":"success"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "https://")
+	session := newTLSTestSession(server)
+	err := session.Logout(context.Background(), LoginOptions{
+		DeviceID: "device",
+		Cookies:  []Cookie{{Host: host, Scheme: "https", Name: "sid", Value: "test-sid"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loggedOut {
+		t.Fatal("logout endpoint was not called")
+	}
+
+	session = newTLSTestSession(server)
+	err = session.Logout(context.Background(), LoginOptions{
+		DeviceID: "device",
+		Cookies:  []Cookie{{Host: host, Scheme: "https", Name: "sid", Value: "expired-sid"}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "code 401") {
+		t.Fatalf("expected logout to fail for an expired SID, got %v", err)
+	}
+}
+
+func TestLogoutHonorsContext(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	session := newTLSTestSession(server)
+	err := session.Logout(ctx, LoginOptions{
+		DeviceID: "device",
+		Cookies:  []Cookie{{Host: strings.TrimPrefix(server.URL, "https://"), Scheme: "https", Name: "sid", Value: "test-sid"}},
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Logout() error = %v, want the canceled context", err)
+	}
+}
+
+func TestLogoutWithoutSID(t *testing.T) {
+	session := NewSession("127.0.0.1:1", nil)
+	if err := session.Logout(context.Background(), LoginOptions{}); err != nil {
+		t.Fatalf("Logout() without SID = %v, want nil", err)
+	}
+}
@@ -0,0 +1,420 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/qr"
+	"github.com/mythologyli/zju-connect/log"
+)
+
+const (
+	qrCodeWaiting = iota
+	qrCodeScanned
+	qrCodeConfirmed
+	qrCodeExpired
+	qrCodeCancelled
+)
+
+const qrCodeImageSize = 256
+
+// qrCodePollInterval is shortened in tests.
+var qrCodePollInterval = 2 * time.Second
+
+type QRCodeLogin struct {
+	Domain string
+}
+
+func (m QRCodeLogin) AuthType() string {
+	return "auth/qrcode"
+}
+
+func (m QRCodeLogin) LoginDomain() string {
+	return m.Domain
+}
+
+func (m QRCodeLogin) login(s *Session, _ AuthInfo) error {
+	return s.loginAuthQRCode(m.Domain)
+}
+
+type qrCode struct {
+	ID      string `json:"qrCodeId"`
+	Content string `json:"qrCodeContent"`
+	// Expire is the lifetime of the QR code in seconds.
+	Expire int `json:"expire"`
+}
+
+// loginAuthQRCode shows a login QR code in the terminal and on a local page,
+// waits for it to be scanned and confirmed in the mobile app, and logs in
+// with it. Expired QR codes are replaced by new ones. Waiting is given up when
+// the context of the session is done.
+func (s *Session) loginAuthQRCode(loginDomain string) error {
+	ctx := s.loginContext()
+	page := newQRCodePage()
+	if err := page.start(); err != nil {
+		log.Printf("Warning: %v", err)
+	} else {
+		defer page.close()
+		if s.browserLogin {
+			openLoginBrowser(page.addr)
+		}
+	}
+
+	deadline := time.Now().Add(browserLoginTimeout)
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		code, err := s.qrCodeCreate(ctx, loginDomain)
+		if err != nil {
+			return err
+		}
+		if err := page.show(code.Content); err != nil {
+			return err
+		}
+		printQRCode(os.Stderr, code.Content)
+		if page.addr != "" {
+			log.Printf("Scan the QR code above with the aTrust app, or open %s to see it", page.addr)
+		} else {
+			log.Println("Scan the QR code above with the aTrust app")
+		}
+
+		expire := deadline
+		if code.Expire > 0 {
+			if codeExpire := time.Now().Add(time.Duration(code.Expire) * time.Second); codeExpire.Before(deadline) {
+				expire = codeExpire
+			}
+		}
+		status, err := s.waitQRCode(ctx, code.ID, expire)
+		if err != nil {
+			return err
+		}
+		switch status {
+		case qrCodeConfirmed:
+			page.done()
+			return s.qrCodeLogin(ctx, code.ID, loginDomain)
+		case qrCodeCancelled:
+			return errors.New("QR code login was cancelled in the app")
+		}
+		if time.Now().After(deadline) {
+			break
+		}
+		log.Println("QR code expired, fetching a new one")
+	}
+	return fmt.Errorf("QR code login timed out")
+}
+
+// waitQRCode polls the status of the QR code until it is confirmed, cancelled
+// or expired, until the expire time passes or until ctx is done.
+func (s *Session) waitQRCode(ctx context.Context, id string, expire time.Time) (int, error) {
+	ticker := time.NewTicker(qrCodePollInterval)
+	defer ticker.Stop()
+	timer := time.NewTimer(time.Until(expire))
+	defer timer.Stop()
+	scanned := false
+	for {
+		select {
+		case <-ticker.C:
+		case <-timer.C:
+			return qrCodeExpired, nil
+		case <-ctx.Done():
+			return 0, fmt.Errorf("QR code login aborted: %w", ctx.Err())
+		}
+		status, err := s.qrCodeStatus(ctx, id)
+		if err != nil {
+			return 0, err
+		}
+		switch status {
+		case qrCodeWaiting:
+		case qrCodeScanned:
+			if !scanned {
+				log.Println("QR code scanned, please confirm the login in the app")
+				scanned = true
+			}
+		case qrCodeConfirmed, qrCodeExpired, qrCodeCancelled:
+			return status, nil
+		default:
+			return 0, fmt.Errorf("unknown QR code status %d", status)
+		}
+	}
+}
+
+func (s *Session) qrCodeCreate(ctx context.Context, loginDomain string) (qrCode, error) {
+	log.Println("Perform GET /passport/v1/public/qrCode")
+
+	var code qrCode
+	err := s.qrCodeRequest(ctx, "GET", "/passport/v1/public/qrCode", url.Values{"loginDomain": {loginDomain}}, nil, &code)
+	if err != nil {
+		return qrCode{}, err
+	}
+	if code.ID == "" || code.Content == "" {
+		return qrCode{}, errors.New("server returned an empty QR code")
+	}
+	return code, nil
+}
+
+func (s *Session) qrCodeStatus(ctx context.Context, id string) (int, error) {
+	log.DebugPrintf("Perform GET /passport/v1/public/qrCodeStatus")
+
+	var data struct {
+		Status int `json:"status"`
+	}
+	err := s.qrCodeRequest(ctx, "GET", "/passport/v1/public/qrCodeStatus", url.Values{"qrCodeId": {id}}, nil, &data)
+	return data.Status, err
+}
+
+func (s *Session) qrCodeLogin(ctx context.Context, id, loginDomain string) error {
+	log.Println("Perform POST /passport/v1/auth/qrcode")
+
+	postBody, _ := json.Marshal(map[string]string{
+		"qrCodeId":    id,
+		"loginDomain": loginDomain,
+	})
+	var data struct {
+		Ticket   string `json:"ticket"`
+		Username string `json:"username"`
+	}
+	if err := s.qrCodeRequest(ctx, "POST", "/passport/v1/auth/qrcode", nil, postBody, &data); err != nil {
+		return err
+	}
+	s.ticket = data.Ticket
+	if data.Username != "" {
+		s.username = data.Username
+	}
+	return nil
+}
+
+func (s *Session) qrCodeRequest(ctx context.Context, method, path string, params url.Values, postBody []byte, data any) error {
+	var body io.Reader
+	if postBody != nil {
+		body = bytes.NewReader(postBody)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.baseURL+path+"?"+WithSharedParams(params).Encode(), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", UserAgent)
+	if postBody != nil {
+		req.Header.Set("Content-Type", "application/json;charset=utf-8")
+	}
+	req.Header.Set("x-csrf-token", s.csrfToken)
+	req.Header.Set("x-sdp-env", s.env)
+	req.Header.Set("x-sdp-traceid", s.randSdpId())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+	respBody, _ := io.ReadAll(resp.Body)
+	log.DebugPrintf("Received %s: %s", path, string(respBody))
+
+	var re struct {
+		Code    int             `json:"code"`
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(respBody, &re); err != nil {
+		return err
+	}
+	if re.Code != 0 {
+		return fmt.Errorf("%s failed with code %d: %s", path, re.Code, re.Message)
+	}
+	if len(re.Data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(re.Data, data)
+}
+
+// printQRCode renders content as a QR code with half block characters, two
+// modules per character cell. Light modules are drawn so that the code reads
+// on the usual dark terminal background.
+func printQRCode(w io.Writer, content string) {
+	code, err := qr.Encode(content, qr.M, qr.Auto)
+	if err != nil {
+		log.Printf("Failed to render QR code: %v", err)
+		return
+	}
+	_, _ = io.WriteString(w, renderQRCode(code))
+}
+
+func renderQRCode(code barcode.Barcode) string {
+	const quiet = 2
+	bounds := code.Bounds()
+	size := bounds.Dx()
+	light := func(x, y int) bool {
+		if x < 0 || y < 0 || x >= size || y >= size {
+			return true
+		}
+		r, _, _, _ := code.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+		return r > 0x7fff
+	}
+
+	var sb strings.Builder
+	for y := -quiet; y < size+quiet; y += 2 {
+		for x := -quiet; x < size+quiet; x++ {
+			top, bottom := light(x, y), light(x, y+1)
+			switch {
+			case top && bottom:
+				sb.WriteString("█")
+			case top:
+				sb.WriteString("▀")
+			case bottom:
+				sb.WriteString("▄")
+			default:
+				sb.WriteString(" ")
+			}
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+func qrCodePNG(content string) ([]byte, error) {
+	code, err := qr.Encode(content, qr.M, qr.Auto)
+	if err != nil {
+		return nil, err
+	}
+	code, err = barcode.Scale(code, qrCodeImageSize, qrCodeImageSize)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, code); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+const qrCodePageHTML = `<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+<meta charset="UTF-8">
+<meta name="viewport" content="width=device-width, initial-scale=1.0">
+<title>zju-connect</title>
+<style>
+  body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif; background: #f0f2f5; display: flex; justify-content: center; padding-top: 10vh; }
+  .card { background: #fff; border-radius: 12px; box-shadow: 0 2px 12px rgba(0,0,0,0.1); padding: 32px; text-align: center; }
+  h2 { color: #333; font-size: 20px; margin-bottom: 16px; }
+  p { color: #666; font-size: 14px; }
+</style>
+</head>
+<body>
+<div class="card">
+  <h2>扫码登录 / Scan to log in</h2>
+  <img id="qrcode" src="/qrcode.png" width="256" height="256" alt="QR code">
+  <p id="status">请使用 aTrust App 扫描二维码 / Scan the QR code with the aTrust app</p>
+</div>
+<script>
+var version = '';
+setInterval(function() {
+  var xhr = new XMLHttpRequest();
+  xhr.open('GET', '/status', true);
+  xhr.onload = function() {
+    if (xhr.status !== 200) return;
+    var s = JSON.parse(xhr.responseText);
+    if (s.done) {
+      document.getElementById('qrcode').style.display = 'none';
+      document.getElementById('status').textContent = '登录成功，可以关闭此页面 / Logged in. You may close this page.';
+    } else if (s.version !== version) {
+      version = s.version;
+      document.getElementById('qrcode').src = '/qrcode.png?v=' + version;
+    }
+  };
+  xhr.send();
+}, 2000);
+</script>
+</body>
+</html>`
+
+// qrCodePage serves the current login QR code as a PNG on a loopback page.
+// The page follows the QR code as it is replaced.
+type qrCodePage struct {
+	mu      sync.Mutex
+	image   []byte
+	version int
+	isDone  bool
+
+	srv  *http.Server
+	addr string
+}
+
+func newQRCodePage() *qrCodePage {
+	return &qrCodePage{}
+}
+
+func (p *qrCodePage) start() error {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("failed to start QR code server: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(qrCodePageHTML))
+	})
+	mux.HandleFunc("GET /qrcode.png", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		image := p.image
+		p.mu.Unlock()
+		if image == nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+		w.Write(image)
+	})
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"version": fmt.Sprint(p.version),
+			"done":    p.isDone,
+		})
+	})
+
+	p.srv = &http.Server{Handler: mux}
+	p.addr = fmt.Sprintf("http://%s", listener.Addr().String())
+	go p.srv.Serve(listener)
+	return nil
+}
+
+func (p *qrCodePage) show(content string) error {
+	image, err := qrCodePNG(content)
+	if err != nil {
+		return fmt.Errorf("failed to render QR code: %w", err)
+	}
+	p.mu.Lock()
+	p.image = image
+	p.version++
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *qrCodePage) done() {
+	p.mu.Lock()
+	p.isDone = true
+	p.mu.Unlock()
+}
+
+func (p *qrCodePage) close() {
+	// Leave the page a moment to notice that the login succeeded.
+	time.AfterFunc(3*time.Second, func() {
+		_ = p.srv.Shutdown(context.Background())
+	})
+}
@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/boombuler/barcode/qr"
+)
+
+func TestQRCodeLogin(t *testing.T) {
+	oldInterval, oldOpen := qrCodePollInterval, openLoginBrowser
+	defer func() { qrCodePollInterval, openLoginBrowser = oldInterval, oldOpen }()
+	qrCodePollInterval = time.Millisecond
+	pageAddr := make(chan string, 1)
+	openLoginBrowser = func(addr string) { pageAddr <- addr }
+
+	var mu sync.Mutex
+	created := 0
+	polls := map[string]int{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /passport/v1/public/qrCode", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("loginDomain") != "Mobile" {
+			http.Error(w, "bad domain", http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		created++
+		id := fmt.Sprintf("qr-%d", created)
+		mu.Unlock()
+		fmt.Fprintf(w, `{"code":0,"data":{"qrCodeId":%q,"qrCodeContent":"https://vpn.example/qr/%s","expire":60}}`, id, id)
+	})
+	mux.HandleFunc("GET /passport/v1/public/qrCodeStatus", func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("qrCodeId")
+		mu.Lock()
+		polls[id]++
+		n := polls[id]
+		mu.Unlock()
+		// The first code expires unscanned, the second is scanned and
+		// confirmed.
+		status := qrCodeWaiting
+		switch {
+		case id == "qr-1" && n > 1:
+			status = qrCodeExpired
+		case id == "qr-2" && n == 2:
+			status = qrCodeScanned
+		case id == "qr-2" && n > 2:
+			status = qrCodeConfirmed
+		}
+		fmt.Fprintf(w, `{"code":0,"data":{"status":%d}}`, status)
+	})
+	mux.HandleFunc("POST /passport/v1/auth/qrcode", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body["qrCodeId"] != "qr-2" {
+			fmt.Fprint(w, `{"code":1,"message":"bad qr code"}`)
+			return
+		}
+		fmt.Fprint(w, `{"code":0,"data":{"ticket":"qr-ticket","username":"alice@Mobile"}}`)
+	})
+	server := httptest.NewTLSServer(mux)
+	defer server.Close()
+
+	session := newTLSTestSession(server)
+	session.SetBrowserLogin(true)
+	if err := (QRCodeLogin{Domain: "Mobile"}).login(session, AuthInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	if session.ticket != "qr-ticket" || session.username != "alice@Mobile" {
+		t.Fatalf("ticket = %q, username = %q", session.ticket, session.username)
+	}
+
+	addr := <-pageAddr
+	resp, err := http.Get(addr + "/qrcode.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if _, err := png.Decode(bytes.NewReader(image)); err != nil {
+		t.Fatalf("QR code page image: %v", err)
+	}
+	resp, err = http.Get(addr + "/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status struct {
+		Version string `json:"version"`
+		Done    bool   `json:"done"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&status)
+	resp.Body.Close()
+	if !status.Done || status.Version != "2" {
+		t.Fatalf("page status = %+v", status)
+	}
+}
+
+func TestQRCodeLoginCancelled(t *testing.T) {
+	oldInterval := qrCodePollInterval
+	defer func() { qrCodePollInterval = oldInterval }()
+	qrCodePollInterval = time.Millisecond
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/passport/v1/public/qrCode" {
+			fmt.Fprint(w, `{"code":0,"data":{"qrCodeId":"qr","qrCodeContent":"payload"}}`)
+			return
+		}
+		fmt.Fprintf(w, `{"code":0,"data":{"status":%d}}`, qrCodeCancelled)
+	}))
+	defer server.Close()
+
+	err := newTLSTestSession(server).loginAuthQRCode("Mobile")
+	if err == nil || !strings.Contains(err.Error(), "cancelled") {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+}
+
+func TestRenderQRCode(t *testing.T) {
+	code, err := qr.Encode("https://vpn.example/qr", qr.M, qr.Auto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(renderQRCode(code), "\n"), "\n")
+	size := code.Bounds().Dx() + 4
+	if len(lines) != (size+1)/2 {
+		t.Fatalf("got %d lines for %d modules", len(lines), size)
+	}
+	for _, line := range lines {
+		if n := len([]rune(line)); n != size {
+			t.Fatalf("line has %d cells, want %d", n, size)
+		}
+	}
+	// The quiet zone is light.
+	if !strings.HasPrefix(lines[0], "██") {
+		t.Fatalf("first line %q has no quiet zone", lines[0])
+	}
+}
+
+func TestQRCodeLoginAborted(t *testing.T) {
+	oldInterval := qrCodePollInterval
+	defer func() { qrCodePollInterval = oldInterval }()
+	qrCodePollInterval = time.Millisecond
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/passport/v1/public/qrCode" {
+			fmt.Fprint(w, `{"code":0,"data":{"qrCodeId":"qr","qrCodeContent":"payload"}}`)
+			return
+		}
+		fmt.Fprintf(w, `{"code":0,"data":{"status":%d}}`, qrCodeWaiting)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	session := newTLSTestSession(server)
+	session.SetContext(ctx)
+	done := make(chan error, 1)
+	go func() { done <- session.loginAuthQRCode("Mobile") }()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected the login to be aborted, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("QR code login did not stop when the context was done")
+	}
+}
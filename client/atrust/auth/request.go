@@ -2,6 +2,7 @@ package auth
 
 import (
 	"bytes"
+	"context"
 	"crypto/subtle"
 	"encoding/json"
 	"fmt"
@@ -12,6 +13,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/mythologyli/zju-connect/internal/prompt"
 	"github.com/mythologyli/zju-connect/log"
 )
 
@@ -43,7 +45,7 @@ func (s *Session) ServerVersionInfo() ([]byte, error) {
 	return body, nil
 }
 
-func (s *Session) authConfig(mod, needTicket bool) (int, []AuthInfo, error) {
+func (s *Session) authConfig(ctx context.Context, mod, needTicket bool) (int, []AuthInfo, error) {
 	log.Println("Perform GET /passport/v1/public/authConfig")
 
 	params := WithSharedParams(nil)
@@ -55,7 +57,7 @@ func (s *Session) authConfig(mod, needTicket bool) (int, []AuthInfo, error) {
 	}
 
 	u := s.baseURL + "/passport/v1/public/authConfig"
-	req, _ := http.NewRequest("GET", u+"?"+params.Encode(), nil)
+	req, _ := http.NewRequestWithContext(ctx, "GET", u+"?"+params.Encode(), nil)
 	req.Header.Set("User-Agent", UserAgent)
 	req.Header.Set("x-csrf-token", s.csrfToken)
 	req.Header.Set("x-sdp-rid", s.rid)
@@ -500,10 +502,8 @@ func (s *Session) authSms(step authStep) error {
 func (s *Session) smsCheckCode(step authStep) (authStep, error) {
 	log.Println("Perform POST /passport/v1/auth/sms")
 
-	code := ""
 	log.Println("Tips: Add prefix '$' to sms code to skip secondary authentication")
-	log.Print("Please enter the SMS verification code: ")
-	_, err := fmt.Scanln(&code)
+	code, err := s.ask(prompt.Request{Kind: prompt.KindSMSCode, Message: "Please enter the SMS verification code: "})
 	if err != nil {
 		return authStep{}, err
 	}
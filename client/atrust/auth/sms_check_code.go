@@ -7,6 +7,7 @@ import (
 	"io"
 	"net/http"
 
+	"github.com/mythologyli/zju-connect/internal/prompt"
 	"github.com/mythologyli/zju-connect/log"
 )
 
@@ -45,9 +46,7 @@ func (s *Session) loginAuthSmsCheckCode(phone, loginDomain, graphCodeFile string
 		return err
 	}
 
-	code := ""
-	log.Print("Please enter the SMS verification code: ")
-	_, err = fmt.Scanln(&code)
+	code, err := s.ask(prompt.Request{Kind: prompt.KindSMSCode, Message: "Please enter the SMS verification code: "})
 	if err != nil {
 		return err
 	}
@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
@@ -154,7 +155,7 @@ func TestAuthConfigAcceptsMatchingAntiMITMCertificate(t *testing.T) {
 	encodedCertificate = base64.StdEncoding.EncodeToString(server.Certificate().Raw)
 
 	session := newTLSTestSession(server)
-	if _, _, err := session.authConfig(false, true); err != nil {
+	if _, _, err := session.authConfig(context.Background(), false, true); err != nil {
 		t.Fatal(err)
 	}
 }
@@ -172,7 +173,7 @@ func TestAuthConfigReportsButDoesNotPropagateSecondStageFailure(t *testing.T) {
 	encodedCertificate = base64.StdEncoding.EncodeToString(server.Certificate().Raw)
 
 	session := newTLSTestSession(server)
-	if _, _, err := session.authConfig(false, true); err != nil {
+	if _, _, err := session.authConfig(context.Background(), false, true); err != nil {
 		t.Fatalf("official authentication caller does not propagate the check result: %v", err)
 	}
 }
@@ -186,7 +187,7 @@ func TestAuthConfigReportsButDoesNotPropagateMismatchedAntiMITMCertificate(t *te
 	defer server.Close()
 
 	session := newTLSTestSession(server)
-	if _, _, err := session.authConfig(false, true); err != nil {
+	if _, _, err := session.authConfig(context.Background(), false, true); err != nil {
 		t.Fatalf("official authentication caller does not propagate the check result: %v", err)
 	}
 }
@@ -200,7 +201,7 @@ func TestAuthConfigDoesNotParseAdvertisedSM2Certificate(t *testing.T) {
 	encodedCertificate = base64.StdEncoding.EncodeToString(server.Certificate().Raw)
 
 	session := newTLSTestSession(server)
-	if _, _, err := session.authConfig(false, true); err != nil {
+	if _, _, err := session.authConfig(context.Background(), false, true); err != nil {
 		t.Fatal(err)
 	}
 }
@@ -212,7 +213,7 @@ func TestAuthConfigIgnoresUnknownAntiMITMEnableValue(t *testing.T) {
 	defer server.Close()
 
 	session := newTLSTestSession(server)
-	if _, _, err := session.authConfig(false, true); err != nil {
+	if _, _, err := session.authConfig(context.Background(), false, true); err != nil {
 		t.Fatal(err)
 	}
 }
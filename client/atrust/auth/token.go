@@ -9,6 +9,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/mythologyli/zju-connect/internal/prompt"
 	"github.com/mythologyli/zju-connect/log"
 	"github.com/pquerna/otp/totp"
 )
@@ -28,8 +29,9 @@ func (s *Session) completeTOTP() (authStep, error) {
 			return authStep{}, fmt.Errorf("generate TOTP token: %w", err)
 		}
 	} else {
-		log.Print("Please enter the TOTP token: ")
-		if _, err := fmt.Scanln(&token); err != nil {
+		var err error
+		token, err = s.ask(prompt.Request{Kind: prompt.KindTOTP, Message: "Please enter the TOTP token: "})
+		if err != nil {
 			return authStep{}, err
 		}
 	}
@@ -46,9 +48,8 @@ func (s *Session) completeTOTP() (authStep, error) {
 }
 
 func (s *Session) completeRadius(service string) (authStep, error) {
-	log.Print("Please enter the RADIUS token: ")
-	token := ""
-	if _, err := fmt.Scanln(&token); err != nil {
+	token, err := s.ask(prompt.Request{Kind: prompt.KindRadiusToken, Message: "Please enter the RADIUS token: "})
+	if err != nil {
 		return authStep{}, err
 	}
 
@@ -1,11 +1,14 @@
 package auth
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+
+	"github.com/mythologyli/zju-connect/internal/prompt"
 )
 
 func TestAuthStepFromTokenService(t *testing.T) {
@@ -126,3 +129,17 @@ func TestAccessCheck(t *testing.T) {
 		t.Fatalf("unexpected next step: %+v", step)
 	}
 }
+
+func TestAskUsesLoginContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	session := &Session{}
+	session.SetContext(ctx)
+	session.SetPrompter(prompt.Func(func(ctx context.Context, req prompt.Request) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}))
+	if _, err := session.ask(prompt.Request{}); err != context.Canceled {
+		t.Fatalf("ask() error = %v, want the canceled login context", err)
+	}
+}
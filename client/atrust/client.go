@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/md5"
 	"crypto/rand"
+	"crypto/tls"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -12,12 +13,16 @@ import (
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/mythologyli/zju-connect/client"
 	"github.com/mythologyli/zju-connect/client/atrust/auth"
+	"github.com/mythologyli/zju-connect/internal/captcha"
+	"github.com/mythologyli/zju-connect/internal/credential"
 	"github.com/mythologyli/zju-connect/internal/ipresource"
 	"github.com/mythologyli/zju-connect/internal/keylog"
+	"github.com/mythologyli/zju-connect/internal/prompt"
 	"github.com/mythologyli/zju-connect/internal/underlay"
 	"github.com/mythologyli/zju-connect/log"
 	"inet.af/netaddr"
@@ -31,6 +36,8 @@ type Client struct {
 	SignKey      string
 
 	serverAddress   string
+	authServerHost  string
+	authCookies     []auth.Cookie
 	ipResources     []client.IPResource
 	resourceIndex   *ipresource.Index
 	domainResources client.DomainResources
@@ -59,6 +66,16 @@ type Client struct {
 	underlayDialer   *underlay.Dialer
 	tlsKeyLogWriter  io.Writer
 	tcpTunnelZeroRTT bool
+	udpRelay         bool
+	udpUnsupported   atomic.Bool
+	tunnelPool       *tcpTunnelPool
+	l3TunnelConns    int
+	l3TunnelMaxConns int
+	prompter         prompt.Prompter
+	browserLogin     bool
+	captchaSolver    captcha.Solver
+	clientCert       tls.Certificate
+	credentials      *credential.Resolver
 }
 
 func NewClient(username, sid, deviceID, signKey string, underlayDialer *underlay.Dialer, tlsKeyLogWriter io.Writer) *Client {
@@ -75,6 +92,71 @@ func NewClient(username, sid, deviceID, signKey string, underlayDialer *underlay
 	}
 }
 
+// SetPrompter sets where interactive login steps ask for input. A nil prompter
+// reads from the terminal.
+func (c *Client) SetPrompter(p prompt.Prompter) {
+	c.prompter = p
+}
+
+// SetBrowserLogin makes CAS and OAuth2 logins capture the callback from the
+// browser through a loopback listener.
+func (c *Client) SetBrowserLogin(enable bool) {
+	c.browserLogin = enable
+}
+
+// SetCaptchaSolver makes graph check codes be solved by solver instead of the
+// user.
+func (c *Client) SetCaptchaSolver(solver captcha.Solver) {
+	c.captchaSolver = solver
+}
+
+// SetCredentials makes the login resolve the username, password and TOTP
+// secret again instead of using the ones given to Setup.
+func (c *Client) SetCredentials(r *credential.Resolver) {
+	c.credentials = r
+}
+
+// SetTCPTunnelPool keeps up to size idle TLS connections per node for TCP
+// tunnel connections, opening prewarm of them ahead of time, and closes those
+// idle for longer than idleTimeout. A size of 0 disables the pool.
+func (c *Client) SetTCPTunnelPool(size, prewarm int, idleTimeout time.Duration) {
+	if size <= 0 {
+		c.tunnelPool = nil
+		return
+	}
+	c.tunnelPool = newTCPTunnelPool(func(ctx context.Context, nodeAddr string) (net.Conn, error) {
+		ctx, cancel := context.WithTimeout(ctx, tcpTunnelHandshakeTimeout)
+		defer cancel()
+		conn, err := c.underlayDialer.DialTLSContext(ctx, "tcp", nodeAddr, tunnelTLSConfig(c.tlsKeyLogWriter))
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	}, size, prewarm, idleTimeout)
+	go c.tunnelPool.run(c.lifecycleCtx)
+}
+
+// SetTCPTunnelUDP enables relaying UDP through the TCP tunnel. The relay
+// framing is not confirmed against the official client, so it is off by
+// default.
+func (c *Client) SetTCPTunnelUDP(enable bool) {
+	c.udpRelay = enable
+}
+
+// SetL3TunnelConns makes the L3 tunnel use conns connections per node group
+// and open more, up to maxConns, while writes queue up on them. No more are
+// opened if maxConns is not above conns.
+func (c *Client) SetL3TunnelConns(conns, maxConns int) {
+	c.l3TunnelConns = conns
+	c.l3TunnelMaxConns = maxConns
+}
+
+// SetClientCertificate sets the certificate presented to the server, used by
+// certificate login.
+func (c *Client) SetClientCertificate(cert tls.Certificate) {
+	c.clientCert = cert
+}
+
 func (c *Client) Close() {
 	c.closeOnce.Do(func() {
 		c.lifecycleCancel()
@@ -87,6 +169,42 @@ func (c *Client) Close() {
 	})
 }
 
+// Logout ends the session on the server, so that it stops counting against
+// the online session limit of the account. Call it before Close, as it needs
+// the underlay dialer.
+func (c *Client) Logout(ctx context.Context) error {
+	if c.authServerHost == "" || c.SID == "" {
+		return nil
+	}
+	cookies := c.authCookies
+	hasSID := false
+	for _, cookie := range cookies {
+		if cookie.Host == c.authServerHost && cookie.Scheme == "https" && cookie.Name == "sid" {
+			hasSID = true
+			break
+		}
+	}
+	if !hasSID {
+		cookies = append(cookies[:len(cookies):len(cookies)], auth.Cookie{
+			Host:   c.authServerHost,
+			Scheme: "https",
+			Name:   "sid",
+			Value:  c.SID,
+		})
+	}
+
+	sess := auth.NewSession(c.authServerHost, c.tlsKeyLogWriter, c.underlayDialer.DialContext)
+	sess.SetClientCertificate(c.clientCert)
+	if err := sess.Logout(ctx, auth.LoginOptions{
+		DeviceID: c.DeviceID,
+		Cookies:  cookies,
+	}); err != nil {
+		return err
+	}
+	log.Println("Logged out")
+	return nil
+}
+
 func (c *Client) IP() (net.IP, error) {
 	c.ipMu.RLock()
 	defer c.ipMu.RUnlock()
@@ -305,7 +423,13 @@ func (c *Client) Setup(serverAddress string, serverPort int, username, password,
 	} else {
 		authServerHost = fmt.Sprintf("%s:%d", serverAddress, serverPort)
 	}
+	c.authServerHost = authServerHost
 	sess := auth.NewSession(authServerHost, c.tlsKeyLogWriter, c.underlayDialer.DialContext)
+	sess.SetPrompter(c.prompter)
+	sess.SetBrowserLogin(c.browserLogin)
+	sess.SetCaptchaSolver(c.captchaSolver)
+	sess.SetClientCertificate(c.clientCert)
+	sess.SetContext(c.lifecycleCtx)
 	serverVersionInfo, manifestErr := sess.ServerVersionInfo()
 	serverVersionInfo, err := resolveServerVersionInfo(clientAuthData.ServerVersionInfo, serverVersionInfo, manifestErr)
 	if err != nil {
@@ -337,11 +461,28 @@ func (c *Client) Setup(serverAddress string, serverPort int, username, password,
 		c.ConnectionID = buildConnectionID(c.DeviceID)
 		c.SignKey = randHex(64)
 
+		if c.credentials != nil {
+			creds, err := c.credentials.Resolve(c.lifecycleCtx)
+			if err != nil {
+				return nil, err
+			}
+			username, password, totpSecret = creds.Username, creds.Password, creds.TOTPSecret
+		}
+
 		if authType == "" {
 			if username != "" && password != "" {
 				authType = "auth/psw"
 			} else if phone != "" {
 				authType = "auth/smsCheckCode"
+			} else if len(c.clientCert.Certificate) > 0 {
+				offered, err := sess.OffersAuthType("auth/cert", loginDomain)
+				if err != nil {
+					return nil, err
+				}
+				if !offered {
+					return nil, fmt.Errorf("a client certificate is loaded, but the server does not offer certificate login in login domain %q", loginDomain)
+				}
+				authType = "auth/cert"
 			}
 		}
 
@@ -357,8 +498,10 @@ func (c *Client) Setup(serverAddress string, serverPort int, username, password,
 			}
 		case "auth/cas":
 			loginMethod = auth.CASLogin{
-				Domain: loginDomain,
-				Ticket: casTicket,
+				Domain:   loginDomain,
+				Ticket:   casTicket,
+				Username: username,
+				Password: password,
 			}
 		case "auth/httpsOauth2":
 			loginMethod = auth.HTTPSOauth2Login{
@@ -371,6 +514,14 @@ func (c *Client) Setup(serverAddress string, serverPort int, username, password,
 				Domain:        loginDomain,
 				GraphCodeFile: graphCodeFile,
 			}
+		case "auth/cert":
+			loginMethod = auth.CertificateLogin{
+				Domain: loginDomain,
+			}
+		case "auth/qrcode":
+			loginMethod = auth.QRCodeLogin{
+				Domain: loginDomain,
+			}
 		case "":
 			log.Println("No auth type specified, trying to skip auth")
 		default:
@@ -397,6 +548,7 @@ func (c *Client) Setup(serverAddress string, serverPort int, username, password,
 		}
 
 	}
+	c.authCookies = clientAuthData.Cookies
 	authData, err = json.Marshal(clientAuthData)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal client data: %w", err)
@@ -429,6 +581,15 @@ func (c *Client) Setup(serverAddress string, serverPort int, username, password,
 		go c.updateBestNodes(c.lifecycleCtx, updateBestNodesInterval)
 	}
 
+	if c.tunnelPool != nil {
+		c.BestNodesRWMutex.RLock()
+		nodeAddr := c.BestNodes[c.MajorNodeGroup]
+		c.BestNodesRWMutex.RUnlock()
+		if nodeAddr != "" {
+			c.tunnelPool.warm(c.lifecycleCtx, nodeAddr)
+		}
+	}
+
 	return authData, nil
 }
 
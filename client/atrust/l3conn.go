@@ -4,6 +4,8 @@ import (
 	"io"
 	"net"
 	"sync"
+
+	"github.com/mythologyli/zju-connect/internal/packetbuf"
 )
 
 type L3Conn struct {
@@ -50,6 +52,14 @@ func (c *L3Conn) Write(p []byte) (n int, err error) {
 	return n, err
 }
 
+// WritePacket is like Write, but takes over the buffer of the packet. The
+// tunnel copies what it keeps, so the buffer is released on return.
+func (c *L3Conn) WritePacket(buf *packetbuf.Buffer) error {
+	defer buf.Release()
+	_, err := c.Write(buf.Bytes())
+	return err
+}
+
 func (c *L3Conn) Close() error {
 	c.closeOnce.Do(func() { close(c.closeCh) })
 	return nil
@@ -20,12 +20,17 @@ type L3Tunnel struct {
 
 	resourceIndex *ipresource.Index
 
+	// conns, connecting and conntrackMgrs are keyed by l3TunnelConnKey.
 	conns             map[string]*l3TunnelConn
 	connsMu           sync.Mutex
 	connecting        map[string]*l3TunnelConnectCall
 	conntrackMgrs     map[string]*conntrackMgr
 	connect           func(context.Context, string, *conntrackMgr) (*l3TunnelConn, error)
 	reconnectInterval time.Duration
+	scaleDownIdle     time.Duration
+	groups            map[string]*l3TunnelGroup
+	minConns          int
+	maxConns          int
 
 	vipMu   sync.Mutex
 	vipList []net.IP
@@ -52,6 +57,9 @@ func NewL3Tunnel(aTrustClient *Client) (*L3Tunnel, error) {
 		connecting:        make(map[string]*l3TunnelConnectCall),
 		conntrackMgrs:     make(map[string]*conntrackMgr),
 		reconnectInterval: defaultReconnectInterval,
+		groups:            make(map[string]*l3TunnelGroup),
+		minConns:          aTrustClient.l3TunnelConns,
+		maxConns:          aTrustClient.l3TunnelMaxConns,
 		dataChan:          make(chan []byte, 4096),
 		closeCh:           make(chan struct{}),
 	}
@@ -141,13 +149,13 @@ func (t *L3Tunnel) Close() {
 	})
 }
 
-func (t *L3Tunnel) getConn(nodeGroupID string) (*l3TunnelConn, error) {
+func (t *L3Tunnel) getConn(connKey string) (*l3TunnelConn, error) {
 	t.connsMu.Lock()
-	if conn := t.conns[nodeGroupID]; conn != nil {
+	if conn := t.conns[connKey]; conn != nil {
 		t.connsMu.Unlock()
 		return conn, nil
 	}
-	if call := t.connecting[nodeGroupID]; call != nil {
+	if call := t.connecting[connKey]; call != nil {
 		t.connsMu.Unlock()
 		return t.waitConnectCall(call)
 	}
@@ -155,9 +163,9 @@ func (t *L3Tunnel) getConn(nodeGroupID string) (*l3TunnelConn, error) {
 	if t.connecting == nil {
 		t.connecting = make(map[string]*l3TunnelConnectCall)
 	}
-	t.connecting[nodeGroupID] = call
+	t.connecting[connKey] = call
 	t.connsMu.Unlock()
-	go t.connectWithRetry(nodeGroupID, call, true)
+	go t.connectWithRetry(connKey, call, true)
 	return t.waitConnectCall(call)
 }
 
@@ -170,7 +178,8 @@ func (t *L3Tunnel) waitConnectCall(call *l3TunnelConnectCall) (*l3TunnelConn, er
 	}
 }
 
-func (t *L3Tunnel) connectConn(nodeGroupID string) (*l3TunnelConn, error) {
+func (t *L3Tunnel) connectConn(connKey string) (*l3TunnelConn, error) {
+	nodeGroupID := l3TunnelConnGroup(connKey)
 	t.client.BestNodesRWMutex.RLock()
 	addr := t.client.BestNodes[nodeGroupID]
 	if addr == "" {
@@ -183,14 +192,14 @@ func (t *L3Tunnel) connectConn(nodeGroupID string) (*l3TunnelConn, error) {
 
 	ctx, cancel := context.WithTimeout(t.client.lifecycleCtx, 10*time.Second)
 	defer cancel()
-	conntrackMgr, err := t.getConntrackMgr(nodeGroupID)
+	conntrackMgr, err := t.getConntrackMgr(connKey)
 	if err != nil {
 		return nil, err
 	}
 	return t.connect(ctx, addr, conntrackMgr)
 }
 
-func (t *L3Tunnel) getConntrackMgr(nodeGroupID string) (*conntrackMgr, error) {
+func (t *L3Tunnel) getConntrackMgr(connKey string) (*conntrackMgr, error) {
 	t.connsMu.Lock()
 	defer t.connsMu.Unlock()
 	select {
@@ -201,19 +210,19 @@ func (t *L3Tunnel) getConntrackMgr(nodeGroupID string) (*conntrackMgr, error) {
 	if t.conntrackMgrs == nil {
 		t.conntrackMgrs = make(map[string]*conntrackMgr)
 	}
-	if manager := t.conntrackMgrs[nodeGroupID]; manager != nil {
+	if manager := t.conntrackMgrs[connKey]; manager != nil {
 		return manager, nil
 	}
 	manager := newConntrackMgr()
-	t.conntrackMgrs[nodeGroupID] = manager
+	t.conntrackMgrs[connKey] = manager
 	return manager, nil
 }
 
-func (t *L3Tunnel) evictConn(nodeGroupID string, conn *l3TunnelConn) {
+func (t *L3Tunnel) evictConn(connKey string, conn *l3TunnelConn) {
 	t.connsMu.Lock()
 	removed := false
-	if existing := t.conns[nodeGroupID]; existing == conn {
-		delete(t.conns, nodeGroupID)
+	if existing := t.conns[connKey]; existing == conn {
+		delete(t.conns, connKey)
 		removed = true
 	}
 	t.connsMu.Unlock()
@@ -225,13 +234,13 @@ func (t *L3Tunnel) evictConn(nodeGroupID string, conn *l3TunnelConn) {
 func (t *L3Tunnel) evictStaleConns(bestNodes map[string]string, majorNodeGroup string) {
 	t.connsMu.Lock()
 	stale := make([]*l3TunnelConn, 0)
-	for group, conn := range t.conns {
-		addr := bestNodes[group]
+	for key, conn := range t.conns {
+		addr := bestNodes[l3TunnelConnGroup(key)]
 		if addr == "" {
 			addr = bestNodes[majorNodeGroup]
 		}
 		if addr != "" && conn.addr != addr {
-			delete(t.conns, group)
+			delete(t.conns, key)
 			stale = append(stale, conn)
 		}
 	}
@@ -243,12 +252,12 @@ func (t *L3Tunnel) evictStaleConns(bestNodes map[string]string, majorNodeGroup s
 	}
 }
 
-func (t *L3Tunnel) forwardFromConn(nodeGroupID string, conn *l3TunnelConn) {
+func (t *L3Tunnel) forwardFromConn(connKey string, conn *l3TunnelConn) {
 	for {
 		pkt, err := conn.ReadPacket()
 		if err != nil {
-			t.evictConn(nodeGroupID, conn)
-			t.startReconnect(nodeGroupID)
+			t.evictConn(connKey, conn)
+			t.startReconnect(connKey)
 			return
 		}
 		logPacket("recv", pkt)
@@ -262,7 +271,7 @@ func (t *L3Tunnel) forwardFromConn(nodeGroupID string, conn *l3TunnelConn) {
 	}
 }
 
-func (t *L3Tunnel) startReconnect(nodeGroupID string) {
+func (t *L3Tunnel) startReconnect(connKey string) {
 	if t.client == nil || t.connect == nil {
 		return
 	}
@@ -272,7 +281,12 @@ func (t *L3Tunnel) startReconnect(nodeGroupID string) {
 	default:
 	}
 	t.connsMu.Lock()
-	if t.conns[nodeGroupID] != nil || t.connecting[nodeGroupID] != nil {
+	if t.conns[connKey] != nil || t.connecting[connKey] != nil {
+		t.connsMu.Unlock()
+		return
+	}
+	// The connection was closed by scaling down.
+	if group, index := splitL3TunnelConnKey(connKey); index > 0 && index >= t.groupLocked(group).conns {
 		t.connsMu.Unlock()
 		return
 	}
@@ -280,12 +294,12 @@ func (t *L3Tunnel) startReconnect(nodeGroupID string) {
 		t.connecting = make(map[string]*l3TunnelConnectCall)
 	}
 	call := &l3TunnelConnectCall{done: make(chan struct{})}
-	t.connecting[nodeGroupID] = call
+	t.connecting[connKey] = call
 	t.connsMu.Unlock()
-	go t.connectWithRetry(nodeGroupID, call, false)
+	go t.connectWithRetry(connKey, call, false)
 }
 
-func (t *L3Tunnel) connectWithRetry(nodeGroupID string, call *l3TunnelConnectCall, immediate bool) {
+func (t *L3Tunnel) connectWithRetry(connKey string, call *l3TunnelConnectCall, immediate bool) {
 	interval := t.reconnectInterval
 	if interval <= 0 {
 		interval = defaultReconnectInterval
@@ -302,35 +316,35 @@ func (t *L3Tunnel) connectWithRetry(nodeGroupID string, call *l3TunnelConnectCal
 					default:
 					}
 				}
-				t.finishConnect(nodeGroupID, call, nil, net.ErrClosed)
+				t.finishConnect(connKey, call, nil, net.ErrClosed)
 				return
 			}
 		}
-		conn, err := t.connectConn(nodeGroupID)
+		conn, err := t.connectConn(connKey)
 		if err == nil {
-			t.finishConnect(nodeGroupID, call, conn, nil)
+			t.finishConnect(connKey, call, conn, nil)
 			return
 		}
-		log.DebugPrintf("l3-tunnel connect attempt %d failed for group %s: %v", attempt+1, nodeGroupID, err)
+		log.DebugPrintf("l3-tunnel connect attempt %d failed for %s: %v", attempt+1, connKey, err)
 	}
 }
 
-func (t *L3Tunnel) finishConnect(nodeGroupID string, call *l3TunnelConnectCall, conn *l3TunnelConn, err error) {
+func (t *L3Tunnel) finishConnect(connKey string, call *l3TunnelConnectCall, conn *l3TunnelConn, err error) {
 	t.connsMu.Lock()
-	if t.connecting[nodeGroupID] != call {
+	if t.connecting[connKey] != call {
 		t.connsMu.Unlock()
 		if conn != nil {
 			_ = conn.Close()
 		}
 		return
 	}
-	delete(t.connecting, nodeGroupID)
+	delete(t.connecting, connKey)
 	if err == nil {
 		select {
 		case <-t.closeCh:
 			err = net.ErrClosed
 		default:
-			t.conns[nodeGroupID] = conn
+			t.conns[connKey] = conn
 		}
 	}
 	call.conn = conn
@@ -340,6 +354,6 @@ func (t *L3Tunnel) finishConnect(nodeGroupID string, call *l3TunnelConnectCall,
 	if err != nil && conn != nil {
 		_ = conn.Close()
 	} else if err == nil {
-		go t.forwardFromConn(nodeGroupID, conn)
+		go t.forwardFromConn(connKey, conn)
 	}
 }
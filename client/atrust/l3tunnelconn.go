@@ -41,9 +41,12 @@ const (
 	defaultAuthRetryWait      = 10 * time.Second
 	defaultAuthMaxAttempts    = 3
 	defaultAuthBatchSize      = 64
-	authImmediateRetryStatus  = 0x84
-	authRetryStatusMin        = 0x85
-	authRetryStatusMax        = 0x87
+	// l3TunnelSendQueueSize is the number of data frames that may wait for
+	// the writer of a connection before WritePacket blocks.
+	l3TunnelSendQueueSize    = 256
+	authImmediateRetryStatus = 0x84
+	authRetryStatusMin       = 0x85
+	authRetryStatusMax       = 0x87
 )
 
 var errL3TunnelAuthTimeout = errors.New("l3-tunnel auth timeout")
@@ -70,6 +73,7 @@ type l3TunnelConn struct {
 	tlsConn            *tls.Conn
 	reader             *bufio.Reader
 	writeMu            sync.Mutex
+	pendingWrites      int32
 	incoming           chan []byte
 	closeOnce          sync.Once
 	closeCh            chan struct{}
@@ -84,6 +88,13 @@ type l3TunnelConn struct {
 	authWake           chan struct{}
 	writeFrameHook     func([]byte) error
 	dataStream         []byte
+
+	// sendQueue holds the data frames written by sendLoop. Without it, as in
+	// tests, data frames are written synchronously.
+	sendQueue chan *dataFrame
+	// lastDataSend is the time in Unix nanoseconds a data frame was last
+	// queued or written.
+	lastDataSend atomic.Int64
 }
 
 type authIP struct {
@@ -198,12 +209,15 @@ func newL3TunnelConn(ctx context.Context, dialTLS func(context.Context, string,
 		heartbeatInterval:  defaultHeartbeatInterval,
 		heartbeatMissLimit: defaultHeartbeatMissLimit,
 		authWake:           make(chan struct{}, 1),
+		sendQueue:          make(chan *dataFrame, l3TunnelSendQueueSize),
 	}
+	c.lastDataSend.Store(time.Now().UnixNano())
 	if err := c.withContextDeadline(ctx, c.authTunnel); err != nil {
 		_ = c.Close()
 		return nil, err
 	}
 
+	go c.sendLoop()
 	go c.authLoop()
 	go c.readLoop()
 	go c.heartbeatLoop()
@@ -440,13 +454,40 @@ func (c *l3TunnelConn) writeAuthenticatedPacket(ct *conntrack, meta packetMeta,
 		return fmt.Errorf("l3-tunnel connect token too long: %d", len(token))
 	}
 	frame := getDataPayload(token, pkt)
-	defer putDataPayload(frame)
-	payload := frame.payload
 	if log.DebugEnabled() {
-		log.DebugPrintf("l3-tunnel send data meta=%s appID=%s group=%s authID=%d tokenLen=%d pktLen=%d payloadLen=%d", formatMeta(meta), appID, nodeGroupID, ct.authID, len(token), len(pkt), len(payload))
+		log.DebugPrintf("l3-tunnel send data meta=%s appID=%s group=%s authID=%d tokenLen=%d pktLen=%d payloadLen=%d", formatMeta(meta), appID, nodeGroupID, ct.authID, len(token), len(pkt), len(frame.payload))
+	}
+	c.lastDataSend.Store(time.Now().UnixNano())
+	if c.sendQueue == nil {
+		defer putDataPayload(frame)
+		return c.writeFrame(frame.payload)
+	}
+	select {
+	case c.sendQueue <- frame:
+		return nil
+	case <-c.closeCh:
+		putDataPayload(frame)
+		return net.ErrClosed
+	}
+}
+
+// sendLoop writes the queued data frames, so that packets queue up on the
+// connection instead of in its callers when it cannot keep up with them.
+func (c *l3TunnelConn) sendLoop() {
+	for {
+		select {
+		case frame := <-c.sendQueue:
+			err := c.writeFrame(frame.payload)
+			putDataPayload(frame)
+			if err != nil {
+				log.DebugPrintf("l3-tunnel write data failed: %v", err)
+				_ = c.Close()
+				return
+			}
+		case <-c.closeCh:
+			return
+		}
 	}
-	err := c.writeFrame(payload)
-	return err
 }
 
 func (c *l3TunnelConn) notifyAuth() {
@@ -597,7 +638,19 @@ func (c *l3TunnelConn) completeAuthentication(authID uint64, token string, authE
 	}
 }
 
+// queueDepth returns the number of frames being written or waiting to be.
+func (c *l3TunnelConn) queueDepth() int {
+	return len(c.sendQueue) + int(atomic.LoadInt32(&c.pendingWrites))
+}
+
+// idleFor returns how long no data frame has been sent on the connection.
+func (c *l3TunnelConn) idleFor(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, c.lastDataSend.Load()))
+}
+
 func (c *l3TunnelConn) writeFrame(data []byte) error {
+	atomic.AddInt32(&c.pendingWrites, 1)
+	defer atomic.AddInt32(&c.pendingWrites, -1)
 	if c.writeFrameHook != nil {
 		err := c.writeFrameHook(data)
 		if err == nil {
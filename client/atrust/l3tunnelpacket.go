@@ -53,7 +53,8 @@ func (t *L3Tunnel) writePacket(packet zctcpip.IPv4Packet, appID, nodeGroupID str
 	}
 	meta.key = connTrackKey(meta)
 
-	conn, err := t.getConn(nodeGroupID)
+	connKey := t.connKeyForFlow(nodeGroupID, meta)
+	conn, err := t.getConn(connKey)
 	if err != nil {
 		if isClosedConnErr(err) {
 			log.Printf("Drop packet while l3-tunnel reconnects after connection failure: %v", err)
@@ -61,15 +62,16 @@ func (t *L3Tunnel) writePacket(packet zctcpip.IPv4Packet, appID, nodeGroupID str
 		}
 		return err
 	}
-	log.DebugPrintf("l3-tunnel send packet appID=%s group=%s len=%d", appID, nodeGroupID, len(packet))
+	t.maybeScaleUp(nodeGroupID, conn)
+	log.DebugPrintf("l3-tunnel send packet appID=%s group=%s conn=%s len=%d", appID, nodeGroupID, connKey, len(packet))
 	logPacket("send", packet)
 	err = conn.WritePacket(meta, appID, nodeGroupID, packet)
 	for retry := 0; retry < 1 && isClosedConnErr(err); retry++ {
 		// If the cached tunnel conn was closed by network flaps, evict it and retry.
 		log.Printf("Write packet failed with closed connection, evicting conn and retrying: %v", err)
-		t.evictConn(nodeGroupID, conn)
-		t.startReconnect(nodeGroupID)
-		retryConn, retryErr := t.getConn(nodeGroupID)
+		t.evictConn(connKey, conn)
+		t.startReconnect(connKey)
+		retryConn, retryErr := t.getConn(connKey)
 		if retryErr != nil {
 			if isClosedConnErr(retryErr) {
 				err = retryErr
@@ -86,8 +88,8 @@ func (t *L3Tunnel) writePacket(packet zctcpip.IPv4Packet, appID, nodeGroupID str
 	}
 	if isClosedConnErr(err) {
 		log.Printf("Drop packet while l3-tunnel reconnect remains unavailable: %v", err)
-		t.evictConn(nodeGroupID, conn)
-		t.startReconnect(nodeGroupID)
+		t.evictConn(connKey, conn)
+		t.startReconnect(connKey)
 		return nil
 	}
 	return err
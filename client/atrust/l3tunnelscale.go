@@ -0,0 +1,212 @@
+package atrust
+
+import (
+	"hash/fnv"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mythologyli/zju-connect/log"
+)
+
+// A node group may be served by several L3 tunnel connections. Connection i of
+// a group is stored under the key "group#i", connection 0 under the bare group
+// ID. Each connection has its own conntrack manager, so a flow is
+// authenticated on the connection that carries it.
+const (
+	// l3TunnelScaleUpQueueDepth is the number of frames queued for writing on
+	// a connection at which another connection is opened for its group.
+	l3TunnelScaleUpQueueDepth = 4
+	// l3TunnelScaleUpInterval is the minimum time between two connections
+	// opened for the same group, so that a short burst does not open all of
+	// them at once. It also delays a new attempt after a failed one.
+	l3TunnelScaleUpInterval = time.Second
+	// defaultL3TunnelScaleDownIdle is how long no data may be sent on a
+	// connection opened by scaling up before it is closed again.
+	defaultL3TunnelScaleDownIdle = time.Minute
+)
+
+type l3TunnelGroup struct {
+	// conns is the number of connections flows are spread over.
+	conns      int
+	growing    bool
+	nextGrowAt time.Time
+}
+
+func l3TunnelConnKey(nodeGroupID string, index int) string {
+	if index == 0 {
+		return nodeGroupID
+	}
+	return nodeGroupID + "#" + strconv.Itoa(index)
+}
+
+func l3TunnelConnGroup(connKey string) string {
+	group, _ := splitL3TunnelConnKey(connKey)
+	return group
+}
+
+func splitL3TunnelConnKey(connKey string) (string, int) {
+	i := strings.LastIndexByte(connKey, '#')
+	if i < 0 {
+		return connKey, 0
+	}
+	index, err := strconv.Atoi(connKey[i+1:])
+	if err != nil {
+		return connKey, 0
+	}
+	return connKey[:i], index
+}
+
+// minL3TunnelConns and maxL3TunnelConns return the configured bounds of the
+// number of connections per group. Both are at least 1.
+func (t *L3Tunnel) minL3TunnelConns() int {
+	return max(t.minConns, 1)
+}
+
+func (t *L3Tunnel) maxL3TunnelConns() int {
+	return max(t.maxConns, t.minL3TunnelConns())
+}
+
+// groupLocked returns the scaling state of a group. t.connsMu must be held.
+func (t *L3Tunnel) groupLocked(nodeGroupID string) *l3TunnelGroup {
+	if t.groups == nil {
+		t.groups = make(map[string]*l3TunnelGroup)
+	}
+	group := t.groups[nodeGroupID]
+	if group == nil {
+		group = &l3TunnelGroup{conns: t.minL3TunnelConns()}
+		t.groups[nodeGroupID] = group
+	}
+	return group
+}
+
+// connKeyForFlow returns the key of the connection the flow of meta is sent
+// on. Flows are hashed by their 5-tuple, but a flow the conntrack of another
+// connection already knows stays there, so that opening a connection neither
+// reorders nor authenticates again the flows that exist.
+func (t *L3Tunnel) connKeyForFlow(nodeGroupID string, meta packetMeta) string {
+	t.connsMu.Lock()
+	defer t.connsMu.Unlock()
+	conns := t.groupLocked(nodeGroupID).conns
+	if conns <= 1 {
+		return nodeGroupID
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(meta.key))
+	index := int(h.Sum32() % uint32(conns))
+	key := l3TunnelConnKey(nodeGroupID, index)
+	if manager := t.conntrackMgrs[key]; manager != nil && manager.getByKey(meta.key) != nil {
+		return key
+	}
+	for i := 0; i < conns; i++ {
+		if i == index {
+			continue
+		}
+		if manager := t.conntrackMgrs[l3TunnelConnKey(nodeGroupID, i)]; manager != nil && manager.getByKey(meta.key) != nil {
+			return l3TunnelConnKey(nodeGroupID, i)
+		}
+	}
+	return key
+}
+
+// maybeScaleUp opens another connection for the group of conn when data
+// frames queue up on conn faster than it writes them.
+func (t *L3Tunnel) maybeScaleUp(nodeGroupID string, conn *l3TunnelConn) {
+	if conn.queueDepth() < l3TunnelScaleUpQueueDepth || t.client == nil || t.connect == nil {
+		return
+	}
+	now := time.Now()
+	t.connsMu.Lock()
+	select {
+	case <-t.closeCh:
+		t.connsMu.Unlock()
+		return
+	default:
+	}
+	group := t.groupLocked(nodeGroupID)
+	if group.growing || group.conns >= t.maxL3TunnelConns() || now.Before(group.nextGrowAt) {
+		t.connsMu.Unlock()
+		return
+	}
+	group.growing = true
+	index := group.conns
+	t.connsMu.Unlock()
+
+	go t.scaleUp(nodeGroupID, index)
+}
+
+func (t *L3Tunnel) scaleUp(nodeGroupID string, index int) {
+	key := l3TunnelConnKey(nodeGroupID, index)
+	conn, err := t.connectConn(key)
+
+	t.connsMu.Lock()
+	group := t.groupLocked(nodeGroupID)
+	group.growing = false
+	group.nextGrowAt = time.Now().Add(l3TunnelScaleUpInterval)
+	if err == nil {
+		select {
+		case <-t.closeCh:
+			err = net.ErrClosed
+		default:
+			t.conns[key] = conn
+			group.conns = index + 1
+		}
+	}
+	t.connsMu.Unlock()
+
+	if err != nil {
+		if conn != nil {
+			_ = conn.Close()
+		}
+		log.DebugPrintf("l3-tunnel failed to open connection %d for group %s: %v", index+1, nodeGroupID, err)
+		return
+	}
+	log.Printf("l3-tunnel opened connection %d for group %s", index+1, nodeGroupID)
+	go t.forwardFromConn(key, conn)
+	go t.scaleDownWhenIdle(nodeGroupID, index)
+}
+
+// scaleDownWhenIdle closes connection index of a group once it is the last
+// one of the group and no data has been sent on it for t.scaleDownIdle.
+// Its flows are hashed to the other connections again.
+func (t *L3Tunnel) scaleDownWhenIdle(nodeGroupID string, index int) {
+	idle := t.scaleDownIdle
+	if idle <= 0 {
+		idle = defaultL3TunnelScaleDownIdle
+	}
+	key := l3TunnelConnKey(nodeGroupID, index)
+	ticker := time.NewTicker(idle / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			t.connsMu.Lock()
+			group := t.groupLocked(nodeGroupID)
+			if group.conns <= index {
+				t.connsMu.Unlock()
+				return
+			}
+			conn := t.conns[key]
+			if conn == nil || group.growing || index != group.conns-1 || index < t.minL3TunnelConns() || conn.idleFor(now) < idle {
+				t.connsMu.Unlock()
+				continue
+			}
+			group.conns = index
+			delete(t.conns, key)
+			manager := t.conntrackMgrs[key]
+			delete(t.conntrackMgrs, key)
+			t.connsMu.Unlock()
+
+			_ = conn.Close()
+			if manager != nil {
+				manager.close()
+			}
+			log.Printf("l3-tunnel closed idle connection %d for group %s", index+1, nodeGroupID)
+			return
+		case <-t.closeCh:
+			return
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package atrust
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestL3TunnelConnKey(t *testing.T) {
+	for _, tt := range []struct {
+		group string
+		index int
+		key   string
+	}{
+		{"group", 0, "group"},
+		{"group", 2, "group#2"},
+		{"a#b", 1, "a#b#1"},
+	} {
+		key := l3TunnelConnKey(tt.group, tt.index)
+		if key != tt.key {
+			t.Errorf("l3TunnelConnKey(%q, %d) = %q, want %q", tt.group, tt.index, key, tt.key)
+		}
+		if group := l3TunnelConnGroup(key); group != tt.group {
+			t.Errorf("l3TunnelConnGroup(%q) = %q, want %q", key, group, tt.group)
+		}
+	}
+}
+
+func testFlowMeta(srcPort uint16) packetMeta {
+	meta := packetMeta{
+		atype:   4,
+		proto:   6,
+		srcIP:   net.IPv4(10, 0, 0, 1),
+		dstIP:   net.IPv4(10, 0, 0, 2),
+		srcPort: srcPort,
+		dstPort: 443,
+	}
+	meta.key = connTrackKey(meta)
+	return meta
+}
+
+func TestConnKeyForFlowSpreadsFlows(t *testing.T) {
+	tunnel := &L3Tunnel{minConns: 4, maxConns: 4}
+	seen := make(map[string]bool)
+	for port := uint16(1000); port < 1100; port++ {
+		meta := testFlowMeta(port)
+		key := tunnel.connKeyForFlow("group", meta)
+		if l3TunnelConnGroup(key) != "group" {
+			t.Fatalf("connKeyForFlow() = %q, not a connection of group", key)
+		}
+		if again := tunnel.connKeyForFlow("group", meta); again != key {
+			t.Fatalf("flow moved from %q to %q", key, again)
+		}
+		seen[key] = true
+	}
+	if len(seen) != 4 {
+		t.Fatalf("flows used %d connections, want 4", len(seen))
+	}
+}
+
+func TestConnKeyForFlowKeepsTrackedFlow(t *testing.T) {
+	meta := testFlowMeta(1000)
+	// Find a number of connections with which the flow hashes away from
+	// connection 0.
+	tunnel := &L3Tunnel{groups: map[string]*l3TunnelGroup{"group": {}}}
+	var hashed string
+	for conns := 2; hashed == "" || hashed == "group"; conns++ {
+		tunnel.groups["group"].conns = conns
+		hashed = tunnel.connKeyForFlow("group", meta)
+	}
+
+	tunnel.conntrackMgrs = map[string]*conntrackMgr{"group": newConntrackMgr(), hashed: newConntrackMgr()}
+	tunnel.conntrackMgrs["group"].getOrCreate(meta.key, "app", "group")
+	if got := tunnel.connKeyForFlow("group", meta); got != "group" {
+		t.Fatalf("tracked flow moved to %q", got)
+	}
+	other := testFlowMeta(1001)
+	tunnel.conntrackMgrs[hashed].getOrCreate(other.key, "app", "group")
+	if got := tunnel.connKeyForFlow("group", other); got == "group" {
+		t.Fatal("flow not tracked by connection 0 was sent on it")
+	}
+}
+
+func TestMaybeScaleUpIsOffByDefault(t *testing.T) {
+	client := NewClient("user", "sid", "device", "", nil, nil)
+	client.BestNodes = map[string]string{"group": "node:443"}
+	tunnel := &L3Tunnel{
+		client:   client,
+		conns:    make(map[string]*l3TunnelConn),
+		dataChan: make(chan []byte, 1),
+		closeCh:  make(chan struct{}),
+	}
+	defer tunnel.Close()
+	connected := make(chan struct{}, 1)
+	tunnel.connect = func(context.Context, string, *conntrackMgr) (*l3TunnelConn, error) {
+		connected <- struct{}{}
+		return nil, errors.New("unexpected connection")
+	}
+	busy := &l3TunnelConn{sendQueue: make(chan *dataFrame, l3TunnelSendQueueSize), closeCh: make(chan struct{})}
+	ct := &conntrack{}
+	for i := 0; i < l3TunnelScaleUpQueueDepth; i++ {
+		if err := busy.writeAuthenticatedPacket(ct, testFlowMeta(1000), "app", "group", "token", []byte{0x45}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tunnel.maybeScaleUp("group", busy)
+	select {
+	case <-connected:
+		t.Fatal("opened a connection without l3-tunnel-max-conns")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMaybeScaleUpOpensConnectionWhileWritesQueue(t *testing.T) {
+	client := NewClient("user", "sid", "device", "", nil, nil)
+	client.BestNodes = map[string]string{"group": "node:443"}
+	tunnel := &L3Tunnel{
+		client:   client,
+		conns:    make(map[string]*l3TunnelConn),
+		dataChan: make(chan []byte, 1),
+		closeCh:  make(chan struct{}),
+		maxConns: 2,
+	}
+	defer tunnel.Close()
+	connected := make(chan *l3TunnelConn, 2)
+	tunnel.connect = func(_ context.Context, _ string, manager *conntrackMgr) (*l3TunnelConn, error) {
+		conn := &l3TunnelConn{
+			tlsConn:      tls.Client(&trackingNetConn{closed: make(chan struct{})}, &tls.Config{InsecureSkipVerify: true}),
+			incoming:     make(chan []byte),
+			closeCh:      make(chan struct{}),
+			conntrackMgr: manager,
+		}
+		connected <- conn
+		return conn, nil
+	}
+	// The stacks write packets from a single goroutine, so frames queue up
+	// for the writer of the connection rather than in concurrent writes.
+	busy := &l3TunnelConn{sendQueue: make(chan *dataFrame, l3TunnelSendQueueSize), closeCh: make(chan struct{})}
+	ct := &conntrack{}
+	queueFrames := func(n int) {
+		for i := 0; i < n; i++ {
+			if err := busy.writeAuthenticatedPacket(ct, testFlowMeta(1000), "app", "group", "token", []byte{0x45}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	queueFrames(1)
+
+	tunnel.maybeScaleUp("group", busy)
+	select {
+	case <-connected:
+		t.Fatal("opened a connection without queued writes")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	queueFrames(l3TunnelScaleUpQueueDepth - 1)
+	tunnel.maybeScaleUp("group", busy)
+	var conn *l3TunnelConn
+	select {
+	case conn = <-connected:
+	case <-time.After(time.Second):
+		t.Fatal("no connection was opened while writes queued")
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		tunnel.connsMu.Lock()
+		got, conns := tunnel.conns["group#1"], tunnel.groups["group"].conns
+		manager := tunnel.conntrackMgrs["group#1"]
+		tunnel.connsMu.Unlock()
+		if got == conn {
+			if conns != 2 {
+				t.Fatalf("group has %d connections, want 2", conns)
+			}
+			if manager == nil || manager != conn.conntrackMgr || manager == tunnel.conntrackMgrs["group"] {
+				t.Fatal("new connection does not have its own conntrack manager")
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("new connection was not added to the group")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// The group is at its maximum.
+	tunnel.connsMu.Lock()
+	tunnel.groups["group"].nextGrowAt = time.Time{}
+	tunnel.connsMu.Unlock()
+	tunnel.maybeScaleUp("group", busy)
+	select {
+	case <-connected:
+		t.Fatal("opened more connections than the maximum")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestScaleDownClosesIdleConnection(t *testing.T) {
+	client := NewClient("user", "sid", "device", "", nil, nil)
+	client.BestNodes = map[string]string{"group": "node:443"}
+	tunnel := &L3Tunnel{
+		client:   client,
+		conns:    make(map[string]*l3TunnelConn),
+		dataChan: make(chan []byte, 1),
+		closeCh:  make(chan struct{}),
+		maxConns: 2,
+		// Connections of the test never send data.
+		scaleDownIdle: 20 * time.Millisecond,
+	}
+	defer tunnel.Close()
+	connected := make(chan *l3TunnelConn, 2)
+	tunnel.connect = func(_ context.Context, _ string, manager *conntrackMgr) (*l3TunnelConn, error) {
+		conn := &l3TunnelConn{
+			tlsConn:      tls.Client(&trackingNetConn{closed: make(chan struct{})}, &tls.Config{InsecureSkipVerify: true}),
+			incoming:     make(chan []byte),
+			closeCh:      make(chan struct{}),
+			conntrackMgr: manager,
+		}
+		connected <- conn
+		return conn, nil
+	}
+
+	tunnel.scaleUp("group", 1)
+	conn := <-connected
+	select {
+	case <-conn.closeCh:
+	case <-time.After(time.Second):
+		t.Fatal("idle connection was not closed")
+	}
+	tunnel.connsMu.Lock()
+	conns, left, manager := tunnel.groups["group"].conns, tunnel.conns["group#1"], tunnel.conntrackMgrs["group#1"]
+	tunnel.connsMu.Unlock()
+	if conns != 1 || left != nil || manager != nil {
+		t.Fatalf("group has %d connections, connection %v and conntrack %v left, want 1 and none", conns, left, manager)
+	}
+
+	// The closed connection is not opened again.
+	select {
+	case <-connected:
+		t.Fatal("closed idle connection was reconnected")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
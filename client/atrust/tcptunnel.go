@@ -9,12 +9,14 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/mythologyli/zju-connect/client"
@@ -34,10 +36,25 @@ type tcpTunnelConn struct {
 
 	closeWriteOnce sync.Once
 	closeWriteErr  error
+
+	// With reuse, the connection goes back to pool once both sides have
+	// closed the flow.
+	pool         *tcpTunnelPool
+	nodeAddr     string
+	serverClosed atomic.Bool
+	released     atomic.Bool
 }
 
 const tcpTunnelHandshakeTimeout = 18 * time.Second
 
+// Commands of the SOCKS5 style destination message.
+const (
+	tcpTunnelCmdConnect      = 0x01
+	tcpTunnelCmdUDPAssociate = 0x03
+)
+
+var errTCPTunnelCommandNotSupported = errors.New("tcp tunnel command not supported")
+
 func tcpTunnelHandshakeDeadline(ctx context.Context, now time.Time) time.Time {
 	deadline := now.Add(tcpTunnelHandshakeTimeout)
 	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
@@ -141,11 +158,19 @@ func writeTCPTunnelInitialMessages(writer io.Writer, initMsg, destMsg []byte) er
 }
 
 func encodeTCPTunnelDestination(host string, port int, zeroRTT bool) ([]byte, error) {
+	return encodeTunnelDestination(tcpTunnelCmdConnect, host, port, zeroRTT)
+}
+
+func encodeTunnelDestination(cmd byte, host string, port int, zeroRTT bool) ([]byte, error) {
 	rsv := byte(0)
 	if zeroRTT {
 		rsv = 1
 	}
-	msg := []byte{0x05, 0x01, rsv}
+	return appendTunnelAddress([]byte{0x05, cmd, rsv}, host, port), nil
+}
+
+// appendTunnelAddress appends host and port as ATYP, address and port.
+func appendTunnelAddress(msg []byte, host string, port int) []byte {
 	if ip := net.ParseIP(host); ip != nil {
 		if ipv4 := ip.To4(); ipv4 != nil {
 			msg = append(msg, 0x01)
@@ -158,7 +183,7 @@ func encodeTCPTunnelDestination(host string, port int, zeroRTT bool) ([]byte, er
 		msg = append(msg, 0x03, byte(len(host)))
 		msg = append(msg, host...)
 	}
-	return binary.BigEndian.AppendUint16(msg, uint16(port)), nil
+	return binary.BigEndian.AppendUint16(msg, uint16(port))
 }
 
 func parseTCPTunnelAuthResponse(data string) error {
@@ -274,7 +299,7 @@ func waitForTCPConnectReply(ctx context.Context, conn net.Conn, reader *bufio.Re
 	case 0x06:
 		return false, fmt.Errorf("tcp tunnel TTL expired")
 	case 0x07:
-		return false, fmt.Errorf("tcp tunnel command not supported")
+		return false, errTCPTunnelCommandNotSupported
 	case 0x08:
 		return false, fmt.Errorf("tcp tunnel address type not supported")
 	default:
@@ -286,6 +311,9 @@ func (c *tcpTunnelConn) Read(b []byte) (int, error) {
 	c.readMu.Lock()
 	defer c.readMu.Unlock()
 
+	if c.released.Load() {
+		return 0, net.ErrClosed
+	}
 	if len(b) == 0 {
 		return 0, nil
 	}
@@ -338,6 +366,7 @@ func (c *tcpTunnelConn) Read(b []byte) (int, error) {
 			return n, nil
 		case header[0] == 0x01 && header[1] == 0x01:
 			log.DebugPrint("TCP tunnel closed by server")
+			c.serverClosed.Store(true)
 			return 0, io.EOF
 		default:
 			return 0, fmt.Errorf("unexpected TCP tunnel data frame header: % x", header)
@@ -349,6 +378,9 @@ func (c *tcpTunnelConn) Write(b []byte) (int, error) {
 	c.writeMu.Lock()
 	defer c.writeMu.Unlock()
 
+	if c.released.Load() {
+		return 0, net.ErrClosed
+	}
 	if len(b) == 0 {
 		return 0, nil
 	}
@@ -386,7 +418,13 @@ func (c *tcpTunnelConn) Write(b []byte) (int, error) {
 }
 
 func (c *tcpTunnelConn) Close() error {
+	if c.released.Load() {
+		return nil
+	}
 	writeErr := c.CloseWrite()
+	if writeErr == nil && c.release() {
+		return nil
+	}
 	closeErr := c.tlsConn.Close()
 	if writeErr != nil {
 		return writeErr
@@ -394,6 +432,30 @@ func (c *tcpTunnelConn) Close() error {
 	return closeErr
 }
 
+// release hands the TLS connection back to the pool if the server allows to
+// reuse it and the flow has been closed in both directions.
+func (c *tcpTunnelConn) release() bool {
+	if !c.reuse || c.pool == nil || !c.serverClosed.Load() {
+		return false
+	}
+	// A Read in progress means that the flow is still in use.
+	if !c.readMu.TryLock() {
+		return false
+	}
+	defer c.readMu.Unlock()
+	if len(c.readBuf) != 0 || c.released.Load() {
+		return false
+	}
+	// Mark the connection released before another flow can take it from the
+	// pool. If the pool is full, Close closes it anyway.
+	c.released.Store(true)
+	if !c.pool.put(c.nodeAddr, c.tlsConn, c.reader) {
+		return false
+	}
+	log.DebugPrintf("TCP tunnel connection to %s returned to pool", c.nodeAddr)
+	return true
+}
+
 func (c *tcpTunnelConn) CloseRead() error {
 	if conn, ok := c.tlsConn.(interface{ CloseRead() error }); ok {
 		return conn.CloseRead()
@@ -425,14 +487,23 @@ func (c *tcpTunnelConn) RemoteAddr() net.Addr {
 }
 
 func (c *tcpTunnelConn) SetDeadline(t time.Time) error {
+	if c.released.Load() {
+		return net.ErrClosed
+	}
 	return c.tlsConn.SetDeadline(t)
 }
 
 func (c *tcpTunnelConn) SetReadDeadline(t time.Time) error {
+	if c.released.Load() {
+		return net.ErrClosed
+	}
 	return c.tlsConn.SetReadDeadline(t)
 }
 
 func (c *tcpTunnelConn) SetWriteDeadline(t time.Time) error {
+	if c.released.Load() {
+		return net.ErrClosed
+	}
 	return c.tlsConn.SetWriteDeadline(t)
 }
 
@@ -501,6 +572,39 @@ func (c *Client) DialTCP(ctx context.Context, addr *net.TCPAddr) (net.Conn, erro
 		return nil, fmt.Errorf("host:%s port:%d is not resource: %w", addr.IP, addr.Port, client.ErrResourceNotFound)
 	}
 
+	realDstHost := tcpTunnelRealDstHost(addr, domain, addrPretend)
+	destAddr, destIP := tcpTunnelAuthDestinations(realDstHost, addr.Port, domain, addrPretend)
+	conn, err := c.openTunnel(ctx, tcpTunnelCmdConnect, tunnelTarget{
+		appID:       appID,
+		nodeGroupID: nodeGroupID,
+		host:        realDstHost,
+		port:        addr.Port,
+		url:         "tcp://" + destAddr,
+		destAddr:    destAddr,
+		destIP:      destIP,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// tunnelTarget is what a tunnel connection is authenticated for.
+type tunnelTarget struct {
+	appID       string
+	nodeGroupID string
+	host        string
+	port        int
+	url         string
+	destAddr    string
+	destIP      string
+}
+
+// openTunnel connects to the node of the target's node group, authenticates
+// and sends the destination message with cmd. An idle connection from the
+// pool is used if there is one.
+func (c *Client) openTunnel(ctx context.Context, cmd byte, target tunnelTarget) (*tcpTunnelConn, error) {
+	nodeGroupID := target.nodeGroupID
 	c.BestNodesRWMutex.RLock()
 	nodeAddr := c.BestNodes[nodeGroupID]
 	if nodeAddr == "" {
@@ -510,25 +614,52 @@ func (c *Client) DialTCP(ctx context.Context, addr *net.TCPAddr) (net.Conn, erro
 	if nodeAddr == "" {
 		return nil, fmt.Errorf("no available aTrust node for group %q", nodeGroupID)
 	}
-	conn, err := c.underlayDialer.DialTLSContext(ctx, "tcp", nodeAddr, tunnelTLSConfig(c.tlsKeyLogWriter))
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to aTrust server: %w", err)
+
+	for {
+		conn, reader, pooled := c.idleTunnelConn(nodeAddr)
+		if conn == nil {
+			tlsConn, err := c.underlayDialer.DialTLSContext(ctx, "tcp", nodeAddr, tunnelTLSConfig(c.tlsKeyLogWriter))
+			if err != nil {
+				return nil, fmt.Errorf("failed to connect to aTrust server: %w", err)
+			}
+			conn, reader = tlsConn, bufio.NewReader(tlsConn)
+		}
+		tunnelConn, err := c.handshakeTunnel(ctx, conn, reader, cmd, target)
+		if err == nil {
+			if tunnelConn.reuse && c.tunnelPool != nil {
+				tunnelConn.pool = c.tunnelPool
+				tunnelConn.nodeAddr = nodeAddr
+			}
+			return tunnelConn, nil
+		}
+		if !pooled || ctx.Err() != nil || !isDeadTunnelConnErr(err) {
+			return nil, err
+		}
+		log.DebugPrintf("Idle tcp tunnel connection to %s was closed, retrying: %v", nodeAddr, err)
+	}
+}
+
+func (c *Client) idleTunnelConn(nodeAddr string) (net.Conn, *bufio.Reader, bool) {
+	if c.tunnelPool == nil {
+		return nil, nil, false
 	}
+	defer c.tunnelPool.warm(c.lifecycleCtx, nodeAddr)
+	return c.tunnelPool.get(nodeAddr)
+}
+
+func (c *Client) handshakeTunnel(ctx context.Context, conn net.Conn, reader *bufio.Reader, cmd byte, target tunnelTarget) (*tcpTunnelConn, error) {
 	if err := conn.SetReadDeadline(tcpTunnelHandshakeDeadline(ctx, time.Now())); err != nil {
 		_ = conn.Close()
 		return nil, fmt.Errorf("failed to set tcp tunnel handshake timeout: %w", err)
 	}
 	procName := "google-chrome-stable"
 	procPath := "/usr/bin/google-chrome-stable"
-	if addr.Port == 22 {
+	if target.port == 22 {
 		procName = "ssh"
 		procPath = "/usr/bin/ssh"
 	}
 	procHash := fmt.Sprintf("%X", sha256.Sum256([]byte(procPath)))
 
-	realDstHost := tcpTunnelRealDstHost(addr, domain, addrPretend)
-	destAddr, destIP := tcpTunnelAuthDestinations(realDstHost, addr.Port, domain, addrPretend)
-
 	signKeyBytes, err := hex.DecodeString(c.SignKey)
 	if err != nil {
 		_ = conn.Close()
@@ -536,15 +667,15 @@ func (c *Client) DialTCP(ctx context.Context, addr *net.TCPAddr) (net.Conn, erro
 	}
 	authRequest := tcpTunnelAuthRequest{
 		SID:          c.SID,
-		AppID:        appID,
-		URL:          "tcp://" + destAddr,
+		AppID:        target.appID,
+		URL:          target.url,
 		DeviceID:     c.DeviceID,
 		ConnectionID: c.ConnectionID,
 		ProcHash:     procHash,
 		UserName:     c.Username,
 		Lang:         "en-US",
-		DestAddr:     destAddr,
-		DestIP:       destIP,
+		DestAddr:     target.destAddr,
+		DestIP:       target.destIP,
 		XRequestSig:  "",
 	}
 	authRequest.Env.Application.Runtime.Process = tcpTunnelProcess{
@@ -571,7 +702,7 @@ func (c *Client) DialTCP(ctx context.Context, addr *net.TCPAddr) (net.Conn, erro
 	initHeader := []byte{0x05, 0x01, 0x81, 0x53, 0x03}
 	initMsg := append(initHeader, lenBytes[:]...)
 	initMsg = append(initMsg, msgBytes...)
-	destMsg, err := encodeTCPTunnelDestination(realDstHost, addr.Port, c.tcpTunnelZeroRTT)
+	destMsg, err := encodeTunnelDestination(cmd, target.host, target.port, c.tcpTunnelZeroRTT)
 	if err != nil {
 		_ = conn.Close()
 		return nil, err
@@ -586,7 +717,7 @@ func (c *Client) DialTCP(ctx context.Context, addr *net.TCPAddr) (net.Conn, erro
 
 	tunnelConn := &tcpTunnelConn{
 		tlsConn: conn,
-		reader:  bufio.NewReader(conn),
+		reader:  reader,
 		raw:     !c.tcpTunnelZeroRTT,
 	}
 	reuse, waitErr := waitForTCPConnectReply(ctx, conn, tunnelConn.reader)
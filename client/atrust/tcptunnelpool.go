@@ -0,0 +1,178 @@
+package atrust
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mythologyli/zju-connect/log"
+)
+
+// tcpTunnelPool keeps idle TLS connections to the nodes, so that a tunnel
+// connection skips the TCP and TLS handshakes. A connection is idle either
+// because it was opened ahead of time, or because the server allowed to
+// reuse it after a finished flow. The tunnel authentication is bound to the
+// destination and is still sent for every flow.
+type tcpTunnelPool struct {
+	dial        func(ctx context.Context, nodeAddr string) (net.Conn, error)
+	size        int
+	prewarm     int
+	idleTimeout time.Duration
+
+	mu      sync.Mutex
+	idle    map[string][]idleTunnelConn
+	warming map[string]int
+	closed  bool
+}
+
+type idleTunnelConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	since  time.Time
+}
+
+// newTCPTunnelPool returns a pool keeping up to size idle connections per
+// node, of which it opens prewarm ahead of time for the nodes in use.
+func newTCPTunnelPool(dial func(context.Context, string) (net.Conn, error), size, prewarm int, idleTimeout time.Duration) *tcpTunnelPool {
+	return &tcpTunnelPool{
+		dial:        dial,
+		size:        size,
+		prewarm:     min(prewarm, size),
+		idleTimeout: idleTimeout,
+		idle:        make(map[string][]idleTunnelConn),
+		warming:     make(map[string]int),
+	}
+}
+
+// get returns the most recently idle connection to nodeAddr, if any.
+func (p *tcpTunnelPool) get(nodeAddr string) (net.Conn, *bufio.Reader, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	conns := p.idle[nodeAddr]
+	for len(conns) > 0 {
+		idle := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+		if time.Since(idle.since) < p.idleTimeout {
+			p.idle[nodeAddr] = conns
+			return idle.conn, idle.reader, true
+		}
+		_ = idle.conn.Close()
+	}
+	delete(p.idle, nodeAddr)
+	return nil, nil, false
+}
+
+// put keeps conn for later use and reports whether it did. reader holds
+// what was read from conn so far and must have nothing buffered.
+func (p *tcpTunnelPool) put(nodeAddr string, conn net.Conn, reader *bufio.Reader) bool {
+	if reader.Buffered() != 0 || conn.SetDeadline(time.Time{}) != nil {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed || len(p.idle[nodeAddr]) >= p.size {
+		return false
+	}
+	p.idle[nodeAddr] = append(p.idle[nodeAddr], idleTunnelConn{conn: conn, reader: reader, since: time.Now()})
+	return true
+}
+
+// warm opens connections to nodeAddr in the background until prewarm of
+// them are idle.
+func (p *tcpTunnelPool) warm(ctx context.Context, nodeAddr string) {
+	p.mu.Lock()
+	need := p.prewarm - len(p.idle[nodeAddr]) - p.warming[nodeAddr]
+	if p.closed || need <= 0 {
+		p.mu.Unlock()
+		return
+	}
+	p.warming[nodeAddr] += need
+	p.mu.Unlock()
+
+	for range need {
+		go func() {
+			conn, err := p.dial(ctx, nodeAddr)
+			p.mu.Lock()
+			if p.warming[nodeAddr]--; p.warming[nodeAddr] == 0 {
+				delete(p.warming, nodeAddr)
+			}
+			p.mu.Unlock()
+			if err != nil {
+				log.DebugPrintf("Failed to prewarm tcp tunnel connection to %s: %v", nodeAddr, err)
+				return
+			}
+			if !p.put(nodeAddr, conn, bufio.NewReader(conn)) {
+				_ = conn.Close()
+			}
+		}()
+	}
+}
+
+// expire closes the connections that have been idle for too long.
+func (p *tcpTunnelPool) expire(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for nodeAddr, conns := range p.idle {
+		kept := conns[:0]
+		for _, idle := range conns {
+			if now.Sub(idle.since) < p.idleTimeout {
+				kept = append(kept, idle)
+			} else {
+				_ = idle.conn.Close()
+			}
+		}
+		if len(kept) == 0 {
+			delete(p.idle, nodeAddr)
+		} else {
+			p.idle[nodeAddr] = kept
+		}
+	}
+}
+
+// run expires idle connections until ctx is done, then closes the pool.
+func (p *tcpTunnelPool) run(ctx context.Context) {
+	ticker := time.NewTicker(max(p.idleTimeout/2, time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			p.close()
+			return
+		case now := <-ticker.C:
+			p.expire(now)
+		}
+	}
+}
+
+func (p *tcpTunnelPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	for _, conns := range p.idle {
+		for _, idle := range conns {
+			_ = idle.conn.Close()
+		}
+	}
+	p.idle = make(map[string][]idleTunnelConn)
+}
+
+// isDeadTunnelConnErr reports whether err means that the server closed the
+// connection, which happens to idle connections at any time.
+func isDeadTunnelConnErr(err error) bool {
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE)
+}
@@ -0,0 +1,158 @@
+package atrust
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestTunnelPool(size, prewarm int, idleTimeout time.Duration) (*tcpTunnelPool, *atomic.Int32) {
+	dials := new(atomic.Int32)
+	pool := newTCPTunnelPool(func(context.Context, string) (net.Conn, error) {
+		dials.Add(1)
+		conn, _ := net.Pipe()
+		return conn, nil
+	}, size, prewarm, idleTimeout)
+	return pool, dials
+}
+
+func TestTCPTunnelPoolReturnsMostRecentConn(t *testing.T) {
+	pool, _ := newTestTunnelPool(2, 0, time.Minute)
+	first, _ := net.Pipe()
+	second, _ := net.Pipe()
+	third, _ := net.Pipe()
+	for _, conn := range []net.Conn{first, second} {
+		if !pool.put("node:443", conn, bufio.NewReader(conn)) {
+			t.Fatal("put() rejected a connection")
+		}
+	}
+	if pool.put("node:443", third, bufio.NewReader(third)) {
+		t.Fatal("put() kept a connection beyond the pool size")
+	}
+
+	if conn, _, ok := pool.get("node:443"); !ok || conn != second {
+		t.Fatalf("get() = %v, %t; want the most recent connection", conn, ok)
+	}
+	if conn, _, ok := pool.get("node:443"); !ok || conn != first {
+		t.Fatalf("get() = %v, %t; want the older connection", conn, ok)
+	}
+	if _, _, ok := pool.get("node:443"); ok {
+		t.Fatal("get() returned a connection from an empty pool")
+	}
+}
+
+func TestTCPTunnelPoolExpiresIdleConns(t *testing.T) {
+	pool, _ := newTestTunnelPool(2, 0, time.Minute)
+	conn, _ := net.Pipe()
+	pool.put("node:443", conn, bufio.NewReader(conn))
+
+	pool.expire(time.Now().Add(2 * time.Minute))
+	if _, _, ok := pool.get("node:443"); ok {
+		t.Fatal("get() returned an expired connection")
+	}
+	if _, err := conn.Write([]byte{0}); !errors.Is(err, io.ErrClosedPipe) {
+		t.Fatalf("expired connection Write() error = %v, want io.ErrClosedPipe", err)
+	}
+}
+
+func TestTCPTunnelPoolWarmOpensMissingConns(t *testing.T) {
+	pool, dials := newTestTunnelPool(4, 2, time.Minute)
+	pool.warm(context.Background(), "node:443")
+	pool.warm(context.Background(), "node:443")
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		pool.mu.Lock()
+		idle := len(pool.idle["node:443"])
+		pool.mu.Unlock()
+		if idle == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d idle connections after warm(), want 2", idle)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if n := dials.Load(); n != 2 {
+		t.Fatalf("warm() dialed %d times, want 2", n)
+	}
+}
+
+func TestTCPTunnelPoolRejectsConnsAfterClose(t *testing.T) {
+	pool, _ := newTestTunnelPool(2, 0, time.Minute)
+	pool.close()
+	conn, _ := net.Pipe()
+	if pool.put("node:443", conn, bufio.NewReader(conn)) {
+		t.Fatal("put() kept a connection after close()")
+	}
+}
+
+func TestTCPTunnelConnReturnsToPoolAfterBothSidesClose(t *testing.T) {
+	pool, _ := newTestTunnelPool(2, 0, time.Minute)
+	local, remote := net.Pipe()
+	defer remote.Close()
+	conn := &tcpTunnelConn{
+		tlsConn:  local,
+		reader:   bufio.NewReader(local),
+		reuse:    true,
+		pool:     pool,
+		nodeAddr: "node:443",
+	}
+
+	go func() {
+		_, _ = remote.Write([]byte{0x01, 0x01, 0x00, 0x00})
+		buf := make([]byte, 4)
+		_, _ = remote.Read(buf)
+	}()
+	if _, err := conn.Read(make([]byte, 16)); err == nil {
+		t.Fatal("Read() did not report the server close frame")
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if pooled, _, ok := pool.get("node:443"); !ok || pooled != local {
+		t.Fatal("connection was not returned to the pool")
+	}
+	if _, err := conn.Write([]byte("late")); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Write() after release error = %v, want net.ErrClosed", err)
+	}
+}
+
+func TestTCPTunnelConnWithoutServerCloseIsNotPooled(t *testing.T) {
+	pool, _ := newTestTunnelPool(2, 0, time.Minute)
+	local, remote := net.Pipe()
+	defer remote.Close()
+	conn := &tcpTunnelConn{
+		tlsConn:  local,
+		reader:   bufio.NewReader(local),
+		reuse:    true,
+		pool:     pool,
+		nodeAddr: "node:443",
+	}
+
+	go func() {
+		buf := make([]byte, 4)
+		_, _ = remote.Read(buf)
+	}()
+	_ = conn.Close()
+	if _, _, ok := pool.get("node:443"); ok {
+		t.Fatal("connection was pooled before the server closed the flow")
+	}
+}
+
+func TestIsDeadTunnelConnErr(t *testing.T) {
+	local, remote := net.Pipe()
+	_ = remote.Close()
+	_, err := local.Read(make([]byte, 1))
+	if !isDeadTunnelConnErr(err) {
+		t.Fatalf("isDeadTunnelConnErr(%v) = false", err)
+	}
+	if isDeadTunnelConnErr(errTCPTunnelCommandNotSupported) {
+		t.Fatal("isDeadTunnelConnErr() = true for a protocol error")
+	}
+}
@@ -0,0 +1,208 @@
+package atrust
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mythologyli/zju-connect/client"
+	"github.com/mythologyli/zju-connect/internal/ipresource"
+	"github.com/mythologyli/zju-connect/log"
+	"github.com/mythologyli/zju-connect/resolve"
+)
+
+// UDP is relayed through a tunnel connection opened with the UDP associate
+// command. After the connect reply every datagram, in both directions, is
+//
+//	length | ATYP | address | port | payload
+//
+// that is the address part of a SOCKS5 UDP request header followed by the
+// payload, prefixed with their length as a big endian uint16.
+
+const maxUDPTunnelFrameSize = 0xFFFF
+
+func matchUDPIPResource(index *ipresource.Index, addr *net.UDPAddr) (client.IPResource, bool) {
+	return index.MatchLastWhere(addr.IP, "udp", addr.Port, nil)
+}
+
+// DialUDP relays UDP to addr through the TCP tunnel if enabled with
+// SetTCPTunnelUDP. It returns an error wrapping client.ErrUDPNotSupported if
+// the relay is disabled or the server does not support UDP associate, which
+// is remembered so that later calls fail without a round trip.
+func (c *Client) DialUDP(ctx context.Context, addr *net.UDPAddr) (net.Conn, error) {
+	if !c.udpRelay || c.udpUnsupported.Load() {
+		return nil, client.ErrUDPNotSupported
+	}
+
+	appID := ""
+	nodeGroupID := ""
+	if resource, ok := ctx.Value(resolve.ContextKeyDomainResource).(client.DomainResource); ok {
+		if resource.Protocol == "udp" || resource.Protocol == "all" {
+			appID = resource.AppID
+			nodeGroupID = resource.NodeGroupID
+		}
+	}
+	if appID == "" {
+		if resource, ok := matchUDPIPResource(c.resourceIndex, addr); ok {
+			appID = resource.AppID
+			nodeGroupID = resource.NodeGroupID
+		}
+	}
+	if appID == "" {
+		return nil, fmt.Errorf("host:%s port:%d is not UDP resource: %w", addr.IP, addr.Port, client.ErrResourceNotFound)
+	}
+
+	host := addr.IP.String()
+	destAddr := net.JoinHostPort(host, strconv.Itoa(addr.Port))
+	conn, err := c.openTunnel(ctx, tcpTunnelCmdUDPAssociate, tunnelTarget{
+		appID:       appID,
+		nodeGroupID: nodeGroupID,
+		host:        host,
+		port:        addr.Port,
+		url:         "udp://" + destAddr,
+		destAddr:    destAddr,
+	})
+	if errors.Is(err, errTCPTunnelCommandNotSupported) {
+		if c.udpUnsupported.CompareAndSwap(false, true) {
+			log.Println("aTrust server does not relay UDP through the TCP tunnel")
+		}
+		return nil, fmt.Errorf("%w: %v", client.ErrUDPNotSupported, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &udpTunnelConn{conn: conn, addr: addr}, nil
+}
+
+// udpTunnelConn is a net.PacketConn, so that net.Resolver exchanges DNS
+// messages with it as datagrams.
+type udpTunnelConn struct {
+	conn net.Conn
+	addr *net.UDPAddr
+
+	readMu  sync.Mutex
+	readBuf [maxUDPTunnelFrameSize]byte
+	writeMu sync.Mutex
+}
+
+func (c *udpTunnelConn) Read(b []byte) (int, error) {
+	n, _, err := c.ReadFrom(b)
+	return n, err
+}
+
+// ReadFrom reads one datagram. Like a UDP socket, it discards the part that
+// does not fit into b.
+func (c *udpTunnelConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	var lengthBytes [2]byte
+	if _, err := io.ReadFull(c.conn, lengthBytes[:]); err != nil {
+		return 0, nil, err
+	}
+	frame := c.readBuf[:binary.BigEndian.Uint16(lengthBytes[:])]
+	if _, err := io.ReadFull(c.conn, frame); err != nil {
+		return 0, nil, err
+	}
+	from, payload, err := parseUDPTunnelFrame(frame)
+	if err != nil {
+		return 0, nil, err
+	}
+	if from == nil {
+		from = c.addr
+	}
+	return copy(b, payload), from, nil
+}
+
+func parseUDPTunnelFrame(frame []byte) (*net.UDPAddr, []byte, error) {
+	if len(frame) < 1 {
+		return nil, nil, errors.New("empty udp tunnel frame")
+	}
+	var ip net.IP
+	var rest []byte
+	switch frame[0] {
+	case 0x01:
+		if len(frame) < 1+net.IPv4len+2 {
+			return nil, nil, errors.New("short udp tunnel frame")
+		}
+		ip = net.IP(frame[1 : 1+net.IPv4len])
+		rest = frame[1+net.IPv4len:]
+	case 0x04:
+		if len(frame) < 1+net.IPv6len+2 {
+			return nil, nil, errors.New("short udp tunnel frame")
+		}
+		ip = net.IP(frame[1 : 1+net.IPv6len])
+		rest = frame[1+net.IPv6len:]
+	case 0x03:
+		if len(frame) < 2 || len(frame) < 2+int(frame[1])+2 {
+			return nil, nil, errors.New("short udp tunnel frame")
+		}
+		rest = frame[2+int(frame[1]):]
+	default:
+		return nil, nil, fmt.Errorf("unexpected udp tunnel address type: 0x%02X", frame[0])
+	}
+	port := int(binary.BigEndian.Uint16(rest))
+	if ip == nil {
+		return nil, rest[2:], nil
+	}
+	return &net.UDPAddr{IP: append(net.IP(nil), ip...), Port: port}, rest[2:], nil
+}
+
+func (c *udpTunnelConn) Write(b []byte) (int, error) {
+	return c.writeTo(b, c.addr)
+}
+
+func (c *udpTunnelConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, fmt.Errorf("unsupported address type %T", addr)
+	}
+	return c.writeTo(b, udpAddr)
+}
+
+func (c *udpTunnelConn) writeTo(b []byte, addr *net.UDPAddr) (int, error) {
+	frame := make([]byte, 2, 2+1+net.IPv6len+2+len(b))
+	frame = appendTunnelAddress(frame, addr.IP.String(), addr.Port)
+	frame = append(frame, b...)
+	if len(frame)-2 > maxUDPTunnelFrameSize {
+		return 0, fmt.Errorf("udp datagram too large: %d bytes", len(b))
+	}
+	binary.BigEndian.PutUint16(frame, uint16(len(frame)-2))
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := writeTCPTunnelHandshakeMessage(c.conn, frame); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *udpTunnelConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *udpTunnelConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *udpTunnelConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func (c *udpTunnelConn) SetDeadline(t time.Time) error {
+	return c.conn.SetDeadline(t)
+}
+
+func (c *udpTunnelConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *udpTunnelConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
@@ -0,0 +1,114 @@
+package atrust
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/mythologyli/zju-connect/client"
+	"github.com/mythologyli/zju-connect/internal/ipresource"
+)
+
+func TestUDPTunnelConnFramesDatagrams(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	addr := &net.UDPAddr{IP: net.IPv4(10, 10, 0, 21), Port: 53}
+	conn := &udpTunnelConn{conn: local, addr: addr}
+
+	go func() {
+		_, _ = conn.Write([]byte("query"))
+	}()
+	var lengthBytes [2]byte
+	if _, err := io.ReadFull(remote, lengthBytes[:]); err != nil {
+		t.Fatal(err)
+	}
+	frame := make([]byte, binary.BigEndian.Uint16(lengthBytes[:]))
+	if _, err := io.ReadFull(remote, frame); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x01, 10, 10, 0, 21, 0x00, 0x35, 'q', 'u', 'e', 'r', 'y'}
+	if !bytes.Equal(frame, want) {
+		t.Fatalf("frame = % x, want % x", frame, want)
+	}
+
+	go func() {
+		reply := []byte{0x00, 0x0A, 0x01, 10, 10, 0, 21, 0x00, 0x35, 'a', 'n', 's'}
+		_, _ = remote.Write(reply)
+	}()
+	buf := make([]byte, 2)
+	n, from, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "an" {
+		t.Fatalf("ReadFrom() = %q, want the truncated datagram %q", buf[:n], "an")
+	}
+	if from.String() != addr.String() {
+		t.Fatalf("ReadFrom() address = %v, want %v", from, addr)
+	}
+}
+
+func TestParseUDPTunnelFrameRejectsShortFrames(t *testing.T) {
+	for _, frame := range [][]byte{
+		{},
+		{0x01, 10, 10, 0},
+		{0x04, 0, 0},
+		{0x03, 5, 'a'},
+		{0x02, 0, 0, 0},
+	} {
+		if _, _, err := parseUDPTunnelFrame(frame); err == nil {
+			t.Fatalf("parseUDPTunnelFrame(% x) succeeded", frame)
+		}
+	}
+}
+
+func TestDialUDPRejectsDestinationWithoutUDPResource(t *testing.T) {
+	atrustClient := &Client{udpRelay: true, resourceIndex: ipresource.New([]client.IPResource{{
+		IPMin:    net.IPv4(10, 0, 0, 0),
+		IPMax:    net.IPv4(10, 255, 255, 255),
+		PortMin:  1,
+		PortMax:  65535,
+		Protocol: "tcp",
+		AppID:    "app",
+	}})}
+	_, err := atrustClient.DialUDP(context.Background(), &net.UDPAddr{IP: net.IPv4(10, 10, 0, 21), Port: 53})
+	if !errors.Is(err, client.ErrResourceNotFound) {
+		t.Fatalf("DialUDP() error = %v, want client.ErrResourceNotFound", err)
+	}
+}
+
+func TestDialUDPIsOptIn(t *testing.T) {
+	atrustClient := &Client{resourceIndex: ipresource.New([]client.IPResource{{
+		IPMin:    net.IPv4(10, 0, 0, 0),
+		IPMax:    net.IPv4(10, 255, 255, 255),
+		PortMin:  1,
+		PortMax:  65535,
+		Protocol: "udp",
+		AppID:    "app",
+	}})}
+	_, err := atrustClient.DialUDP(context.Background(), &net.UDPAddr{IP: net.IPv4(10, 10, 0, 21), Port: 123})
+	if !errors.Is(err, client.ErrUDPNotSupported) {
+		t.Fatalf("DialUDP() without relay error = %v, want client.ErrUDPNotSupported", err)
+	}
+}
+
+func TestDialUDPRemembersUnsupportedServer(t *testing.T) {
+	atrustClient := &Client{udpRelay: true, resourceIndex: ipresource.New(nil)}
+	atrustClient.udpUnsupported.Store(true)
+	_, err := atrustClient.DialUDP(context.Background(), &net.UDPAddr{IP: net.IPv4(10, 10, 0, 21), Port: 53})
+	if !errors.Is(err, client.ErrUDPNotSupported) {
+		t.Fatalf("DialUDP() error = %v, want client.ErrUDPNotSupported", err)
+	}
+}
+
+func TestWaitForTCPConnectReportsUnsupportedCommand(t *testing.T) {
+	if err := runTCPConnectExchange(t, 0x07); !errors.Is(err, errTCPTunnelCommandNotSupported) {
+		t.Fatalf("waitForTCPConnect() error = %v, want errTCPTunnelCommandNotSupported", err)
+	}
+}
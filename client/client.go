@@ -6,11 +6,16 @@ import (
 	"io"
 	"net"
 
+	"github.com/mythologyli/zju-connect/internal/packetbuf"
 	"inet.af/netaddr"
 )
 
 var ErrResourceNotFound = errors.New("resource not found")
 
+// ErrUDPNotSupported is returned by UDPDialer.DialUDP when the server does not
+// relay UDP.
+var ErrUDPNotSupported = errors.New("UDP not supported by server")
+
 type DialContextFunc func(context.Context, string, string) (net.Conn, error)
 
 type IPResource struct {
@@ -65,6 +70,27 @@ type Client interface {
 	NewL3Conn() (io.ReadWriteCloser, error)
 }
 
+// UDPDialer is implemented by clients that can relay UDP without the L3
+// tunnel.
+type UDPDialer interface {
+	DialUDP(ctx context.Context, addr *net.UDPAddr) (net.Conn, error)
+}
+
+// PacketConn is implemented by the connections returned by NewL3Conn that
+// take packets to send in pooled buffers, sparing the copy of Write. Received
+// packets are read with Read into the buffer of the caller.
+type PacketConn interface {
+	// WritePacket sends a packet and takes over its buffer, also when it
+	// returns an error.
+	WritePacket(*packetbuf.Buffer) error
+}
+
+// Logouter is implemented by clients that can end their session on the
+// server.
+type Logouter interface {
+	Logout(ctx context.Context) error
+}
+
 type IPUpdateHandlerSetter interface {
 	SetIPUpdateHandler(func(net.IP) error)
 }
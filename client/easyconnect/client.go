@@ -11,7 +11,10 @@ import (
 	"time"
 
 	"github.com/mythologyli/zju-connect/client"
+	"github.com/mythologyli/zju-connect/internal/captcha"
+	"github.com/mythologyli/zju-connect/internal/credential"
 	"github.com/mythologyli/zju-connect/internal/hook_func"
+	"github.com/mythologyli/zju-connect/internal/prompt"
 	"github.com/mythologyli/zju-connect/internal/underlay"
 	"github.com/mythologyli/zju-connect/log"
 	"inet.af/netaddr"
@@ -38,6 +41,10 @@ type Client struct {
 	underlayDialer    *underlay.Dialer
 	tlsKeyLogWriter   io.Writer
 	rawRequestTimeout time.Duration
+	prompter          prompt.Prompter
+	captchaSolver     captcha.Solver
+	credentials       *credential.Resolver
+	loggedIn          bool
 
 	twfID string
 	token *[48]byte
@@ -61,6 +68,9 @@ type Client struct {
 	requestIPKeepAlive sync.Once
 	keepAliveStarted   sync.Once
 	closeOnce          sync.Once
+
+	sendConns   int
+	batchWrites bool
 }
 
 func NewClient(server, username, password, totpSecret string, tlsCert tls.Certificate, twfID string, testMultiLine, parseResource, useDomainResource bool, underlayDialer *underlay.Dialer, tlsKeyLogWriter io.Writer) *Client {
@@ -86,6 +96,35 @@ func NewClient(server, username, password, totpSecret string, tlsCert tls.Certif
 	return c
 }
 
+// SetPrompter sets where the rand, SMS and TOTP codes are asked for. A nil
+// prompter reads from the terminal.
+func (c *Client) SetPrompter(p prompt.Prompter) {
+	c.prompter = p
+}
+
+// SetCaptchaSolver makes rand codes be solved by solver instead of the user.
+func (c *Client) SetCaptchaSolver(solver captcha.Solver) {
+	c.captchaSolver = solver
+}
+
+// SetCredentials makes every re-login resolve the username, password and TOTP
+// secret again instead of reusing the ones given to NewClient.
+func (c *Client) SetCredentials(r *credential.Resolver) {
+	c.credentials = r
+}
+
+// SetSendConns makes the L3 tunnel send over up to n parallel send streams,
+// as many as the server accepts. Each flow stays on one of them.
+func (c *Client) SetSendConns(n int) {
+	c.sendConns = n
+}
+
+// SetBatchWrites makes the L3 tunnel queue packets and write those queued
+// together in one TLS record.
+func (c *Client) SetBatchWrites(enable bool) {
+	c.batchWrites = enable
+}
+
 // Close releases background resources held by the client. Safe to call
 // multiple times.
 func (c *Client) Close() {
@@ -1,95 +1,416 @@
 package easyconnect
 
 import (
+	"encoding/binary"
+	"hash/fnv"
 	"io"
+	"net"
 	"sync"
 
+	"github.com/mythologyli/zju-connect/internal/packetbuf"
 	"github.com/mythologyli/zju-connect/log"
 )
 
+const (
+	// maxConnRetries is how many times a send or receive stream is set up
+	// again after failing before the error is given up on.
+	maxConnRetries = 5
+
+	// recvReadSize is large enough for any TLS record, which may hold
+	// several packets.
+	recvReadSize = 16*1024 + 2048
+	// recvQueueSize is the number of received packets buffered for Read.
+	recvQueueSize = 256
+
+	// sendQueueSize is the number of packets queued per send stream when
+	// writes are batched.
+	sendQueueSize = 256
+	// maxSendBatchSize limits a batch to what fits in one TLS record.
+	maxSendBatchSize = 16 * 1024
+)
+
 type L3Conn struct {
-	easyConnectClient *Client
+	newSendConn func() (io.WriteCloser, error)
+	newRecvConn func() (io.ReadCloser, error)
 
-	sendConn     io.WriteCloser
-	sendLock     sync.Mutex
-	sendErrCount int
+	lanes []*sendLane
 
 	recvConn     io.ReadCloser
-	recvLock     sync.Mutex
+	recvConnLock sync.Mutex
 	recvErrCount int
+	recvQueue    chan *packetbuf.Buffer
+	recvErr      error
+
+	closeOnce sync.Once
+	closeCh   chan struct{}
+}
+
+// A sendLane is one send stream. Packets of a flow always use the same lane,
+// so that they stay in order.
+type sendLane struct {
+	conn         *L3Conn
+	sendConn     io.WriteCloser
+	sendConnLock sync.Mutex
+	sendLock     sync.Mutex
+	errCount     int
+
+	// queue is only used when writes are batched.
+	queue   chan *packetbuf.Buffer
+	errLock sync.Mutex
+	err     error
 }
 
-// try best to read, if return err!=nil, please panic
-func (c *L3Conn) Read(p []byte) (n int, err error) {
-	c.recvLock.Lock()
-	defer c.recvLock.Unlock()
-	for n, err = c.recvConn.Read(p); err != nil && c.recvErrCount < 5; {
+// Read returns the next packet received from the server. If it returns an
+// error, the receive stream could not be set up again.
+func (c *L3Conn) Read(p []byte) (int, error) {
+	select {
+	case buf, ok := <-c.recvQueue:
+		if !ok {
+			return 0, c.recvErr
+		}
+		n := copy(p, buf.Bytes())
+		buf.Release()
+		return n, nil
+	case <-c.closeCh:
+		return 0, net.ErrClosed
+	}
+}
+
+func (c *L3Conn) recvLoop() {
+	defer close(c.recvQueue)
+	buf := make([]byte, recvReadSize)
+	for {
+		n, err := c.readRecord(buf)
+		if err != nil {
+			c.recvErr = err
+			return
+		}
+		for _, packet := range splitPackets(buf[:n]) {
+			packetBuf := packetbuf.Get(len(packet))
+			copy(packetBuf.Bytes(), packet)
+			select {
+			case c.recvQueue <- packetBuf:
+			case <-c.closeCh:
+				packetBuf.Release()
+				c.recvErr = net.ErrClosed
+				return
+			}
+		}
+	}
+}
 
+// try best to read
+func (c *L3Conn) readRecord(p []byte) (int, error) {
+	for {
+		c.recvConnLock.Lock()
+		recvConn := c.recvConn
+		c.recvConnLock.Unlock()
+		n, err := recvConn.Read(p)
+		if err == nil || c.recvErrCount >= maxConnRetries {
+			return n, err
+		}
+		select {
+		case <-c.closeCh:
+			return 0, net.ErrClosed
+		default:
+		}
 		log.Printf("Error occurred while receiving, retrying: %v", err)
 
 		// Do handshake again and create a new recvConn
-		_ = c.recvConn.Close()
-		c.recvConn, err = c.easyConnectClient.RecvConn()
+		_ = recvConn.Close()
+		c.recvErrCount++
+		recvConn, err = c.newRecvConn()
 		if err != nil {
 			return 0, err
 		}
-		c.recvErrCount++
-		if c.recvErrCount >= 5 {
-			return 0, err
+		c.recvConnLock.Lock()
+		c.recvConn = recvConn
+		c.recvConnLock.Unlock()
+		select {
+		case <-c.closeCh:
+			_ = recvConn.Close()
+			return 0, net.ErrClosed
+		default:
+		}
+	}
+}
+
+// splitPackets splits data read from the receive stream into IP packets. Data
+// that does not look like a sequence of whole packets is returned as is.
+func splitPackets(data []byte) [][]byte {
+	first := packetLen(data)
+	if first <= 0 || first >= len(data) {
+		return [][]byte{data}
+	}
+	var packets [][]byte
+	for rest := data; len(rest) > 0; {
+		n := packetLen(rest)
+		if n <= 0 || n > len(rest) {
+			return [][]byte{data}
 		}
+		packets = append(packets, rest[:n])
+		rest = rest[n:]
+	}
+	return packets
+}
+
+// packetLen returns the length of the IP packet at the start of data, or 0.
+func packetLen(data []byte) int {
+	if len(data) == 0 {
+		return 0
+	}
+	switch data[0] >> 4 {
+	case 4:
+		if len(data) < 20 {
+			return 0
+		}
+		return int(binary.BigEndian.Uint16(data[2:4]))
+	case 6:
+		if len(data) < 40 {
+			return 0
+		}
+		return 40 + int(binary.BigEndian.Uint16(data[4:6]))
+	}
+	return 0
+}
+
+// Write sends a packet to the server. If it returns an error, the send stream
+// could not be set up again. When writes are batched, the packet is queued and
+// an error of an earlier batch is returned.
+func (c *L3Conn) Write(p []byte) (int, error) {
+	lane := c.lane(p)
+	if lane.queue == nil {
+		return lane.write(p)
+	}
+
+	buf := packetbuf.Get(len(p))
+	copy(buf.Bytes(), p)
+	if err := lane.enqueue(buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// WritePacket is like Write, but takes over the buffer of the packet.
+func (c *L3Conn) WritePacket(buf *packetbuf.Buffer) error {
+	lane := c.lane(buf.Bytes())
+	if lane.queue == nil {
+		_, err := lane.write(buf.Bytes())
+		buf.Release()
+		return err
+	}
+	return lane.enqueue(buf)
+}
+
+func (c *L3Conn) lane(p []byte) *sendLane {
+	if len(c.lanes) == 1 {
+		return c.lanes[0]
+	}
+	return c.lanes[flowHash(p)%uint32(len(c.lanes))]
+}
+
+// flowHash hashes the addresses, protocol and ports of an IPv4 packet.
+func flowHash(p []byte) uint32 {
+	if len(p) < 20 || p[0]>>4 != 4 {
+		return 0
+	}
+	h := fnv.New32a()
+	_, _ = h.Write(p[9:10])
+	_, _ = h.Write(p[12:20])
+	headerLen := int(p[0]&0x0f) * 4
+	fragmentOffset := binary.BigEndian.Uint16(p[6:8]) & 0x1fff
+	// Only the first fragment has the ports, so all fragments are hashed
+	// without them to keep them together.
+	if (p[9] == 6 || p[9] == 17) && fragmentOffset == 0 && len(p) >= headerLen+4 && p[6]&0x20 == 0 {
+		_, _ = h.Write(p[headerLen : headerLen+4])
 	}
-	return
+	return h.Sum32()
 }
 
-// try best to write, if return err!=nil, please panic
-func (c *L3Conn) Write(p []byte) (n int, err error) {
-	c.sendLock.Lock()
-	defer c.sendLock.Unlock()
-	for n, err = c.sendConn.Write(p); err != nil && c.sendErrCount < 5; {
+// try best to write
+func (l *sendLane) write(p []byte) (int, error) {
+	l.sendLock.Lock()
+	defer l.sendLock.Unlock()
+	for {
+		l.sendConnLock.Lock()
+		sendConn := l.sendConn
+		l.sendConnLock.Unlock()
+		n, err := sendConn.Write(p)
+		if err == nil || l.errCount >= maxConnRetries {
+			return n, err
+		}
+		select {
+		case <-l.conn.closeCh:
+			return 0, net.ErrClosed
+		default:
+		}
 		log.Printf("Error occurred while sending, retrying: %v", err)
 
 		// Do handshake again and create a new sendConn
-		_ = c.sendConn.Close()
-		c.sendConn, err = c.easyConnectClient.SendConn()
+		_ = sendConn.Close()
+		l.errCount++
+		sendConn, err = l.conn.newSendConn()
 		if err != nil {
-			return 0, err
+			sendConn = closedConn{}
 		}
-		c.sendErrCount++
-		if c.sendErrCount >= 5 {
+		l.sendConnLock.Lock()
+		l.sendConn = sendConn
+		l.sendConnLock.Unlock()
+		select {
+		case <-l.conn.closeCh:
+			_ = sendConn.Close()
+			return 0, net.ErrClosed
+		default:
+		}
+		if err != nil {
 			return 0, err
 		}
 	}
-	return
 }
 
-func (c *L3Conn) Close() error {
-	if c.sendConn != nil {
-		_ = c.sendConn.Close()
+func (l *sendLane) enqueue(buf *packetbuf.Buffer) error {
+	l.errLock.Lock()
+	err := l.err
+	l.errLock.Unlock()
+	if err != nil {
+		buf.Release()
+		return err
 	}
-	if c.recvConn != nil {
-		_ = c.recvConn.Close()
+	select {
+	case l.queue <- buf:
+		return nil
+	case <-l.conn.closeCh:
+		buf.Release()
+		return net.ErrClosed
+	}
+}
+
+// batchLoop writes the queued packets, as many as fit in one TLS record at a
+// time.
+func (l *sendLane) batchLoop() {
+	batch := make([]byte, 0, maxSendBatchSize)
+	var next *packetbuf.Buffer
+	for {
+		buf := next
+		next = nil
+		if buf == nil {
+			select {
+			case buf = <-l.queue:
+			case <-l.conn.closeCh:
+				return
+			}
+		}
+		batch = append(batch[:0], buf.Bytes()...)
+		buf.Release()
+	fill:
+		for len(batch) < maxSendBatchSize {
+			select {
+			case buf = <-l.queue:
+				if len(batch)+len(buf.Bytes()) > maxSendBatchSize {
+					next = buf
+					break fill
+				}
+				batch = append(batch, buf.Bytes()...)
+				buf.Release()
+			default:
+				break fill
+			}
+		}
+
+		if _, err := l.write(batch); err != nil {
+			l.errLock.Lock()
+			l.err = err
+			l.errLock.Unlock()
+			log.Printf("Error occurred while sending, giving up: %v", err)
+			return
+		}
 	}
+}
+
+func (c *L3Conn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closeCh)
+		// Closing the streams unblocks pending reads and writes.
+		for _, lane := range c.lanes {
+			lane.sendConnLock.Lock()
+			_ = lane.sendConn.Close()
+			lane.sendConnLock.Unlock()
+		}
+		c.recvConnLock.Lock()
+		_ = c.recvConn.Close()
+		c.recvConnLock.Unlock()
+	})
 	return nil
 }
 
+// closedConn takes the place of a stream that could not be set up again.
+type closedConn struct{}
+
+func (closedConn) Read([]byte) (int, error)  { return 0, net.ErrClosed }
+func (closedConn) Write([]byte) (int, error) { return 0, net.ErrClosed }
+func (closedConn) Close() error              { return nil }
+
 func (c *Client) NewL3Conn() (io.ReadWriteCloser, error) {
+	newSendConn := func() (io.WriteCloser, error) {
+		conn, err := c.SendConn()
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	}
+	newRecvConn := func() (io.ReadCloser, error) {
+		conn, err := c.RecvConn()
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	}
+	return newL3Conn(newSendConn, newRecvConn, c.sendConns, c.batchWrites)
+}
+
+// newL3Conn sets up the receive stream and up to sendConns send streams. Only
+// the first send stream is required; the others are used as far as the server
+// accepts them.
+func newL3Conn(newSendConn func() (io.WriteCloser, error), newRecvConn func() (io.ReadCloser, error), sendConns int, batchWrites bool) (*L3Conn, error) {
 	conn := &L3Conn{
-		easyConnectClient: c,
-		sendErrCount:      0,
-		recvErrCount:      0,
+		newSendConn: newSendConn,
+		newRecvConn: newRecvConn,
+		recvQueue:   make(chan *packetbuf.Buffer, recvQueueSize),
+		closeCh:     make(chan struct{}),
 	}
 
-	var err error
-	conn.sendConn, err = c.SendConn()
-	if err != nil {
-		log.Printf("Error occurred while creating sendConn: %v", err)
-		return nil, err
+	for i := 0; i < max(sendConns, 1); i++ {
+		sendConn, err := newSendConn()
+		if err != nil {
+			if i == 0 {
+				log.Printf("Error occurred while creating sendConn: %v", err)
+				return nil, err
+			}
+			log.Printf("Server refused send stream %d, using %d: %v", i+1, i, err)
+			break
+		}
+		lane := &sendLane{conn: conn, sendConn: sendConn}
+		if batchWrites {
+			lane.queue = make(chan *packetbuf.Buffer, sendQueueSize)
+		}
+		conn.lanes = append(conn.lanes, lane)
 	}
 
-	conn.recvConn, err = c.RecvConn()
+	var err error
+	conn.recvConn, err = newRecvConn()
 	if err != nil {
 		log.Printf("Error occurred while creating recvConn: %v", err)
+		for _, lane := range conn.lanes {
+			_ = lane.sendConn.Close()
+		}
 		return nil, err
 	}
+
+	for _, lane := range conn.lanes {
+		if lane.queue != nil {
+			go lane.batchLoop()
+		}
+	}
+	go conn.recvLoop()
 	return conn, nil
 }
@@ -0,0 +1,264 @@
+package easyconnect
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mythologyli/zju-connect/internal/packetbuf"
+)
+
+func testPacket(srcPort uint16, payload string) []byte {
+	packet := make([]byte, 28+len(payload))
+	packet[0] = 0x45
+	binary.BigEndian.PutUint16(packet[2:4], uint16(len(packet)))
+	packet[9] = 17
+	copy(packet[12:16], []byte{10, 0, 0, 1})
+	copy(packet[16:20], []byte{10, 0, 0, 2})
+	binary.BigEndian.PutUint16(packet[20:22], srcPort)
+	binary.BigEndian.PutUint16(packet[22:24], 53)
+	copy(packet[28:], payload)
+	return packet
+}
+
+type recordingConn struct {
+	mu      sync.Mutex
+	writes  [][]byte
+	block   chan struct{}
+	blocked chan struct{}
+	closed  bool
+}
+
+func (c *recordingConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	block := c.block
+	c.block = nil
+	c.mu.Unlock()
+	if block != nil {
+		close(c.blocked)
+		<-block
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writes = append(c.writes, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+func (c *recordingConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+func (c *recordingConn) recorded() [][]byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([][]byte(nil), c.writes...)
+}
+
+type recordReader struct {
+	records [][]byte
+	err     error
+}
+
+func (r *recordReader) Read(p []byte) (int, error) {
+	if len(r.records) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		select {}
+	}
+	n := copy(p, r.records[0])
+	r.records = r.records[1:]
+	return n, nil
+}
+
+func (r *recordReader) Close() error { return nil }
+
+func TestSplitPackets(t *testing.T) {
+	first, second := testPacket(1000, "a"), testPacket(1001, "bc")
+	packets := splitPackets(append(append([]byte(nil), first...), second...))
+	if len(packets) != 2 || !bytes.Equal(packets[0], first) || !bytes.Equal(packets[1], second) {
+		t.Fatalf("splitPackets() = %x", packets)
+	}
+
+	truncated := append(append([]byte(nil), first...), second[:10]...)
+	if packets := splitPackets(truncated); len(packets) != 1 || !bytes.Equal(packets[0], truncated) {
+		t.Fatalf("splitPackets() of a truncated packet = %x", packets)
+	}
+	if packets := splitPackets(first); len(packets) != 1 || !bytes.Equal(packets[0], first) {
+		t.Fatalf("splitPackets() of one packet = %x", packets)
+	}
+}
+
+func TestL3ConnReadSplitsRecordsAndReconnects(t *testing.T) {
+	first, second, third := testPacket(1000, "a"), testPacket(1001, "bc"), testPacket(1002, "def")
+	recvConns := []*recordReader{
+		{records: [][]byte{append(append([]byte(nil), first...), second...)}, err: io.ErrUnexpectedEOF},
+		{records: [][]byte{third}},
+	}
+	newRecvConn := func() (io.ReadCloser, error) {
+		conn := recvConns[0]
+		recvConns = recvConns[1:]
+		return conn, nil
+	}
+	conn, err := newL3Conn(func() (io.WriteCloser, error) { return &recordingConn{}, nil }, newRecvConn, 1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 1500)
+	for _, want := range [][]byte{first, second, third} {
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf[:n], want) {
+			t.Fatalf("Read() = %x, want %x", buf[:n], want)
+		}
+	}
+}
+
+func TestL3ConnSpreadsFlowsOverSendStreams(t *testing.T) {
+	var sendConns []*recordingConn
+	newSendConn := func() (io.WriteCloser, error) {
+		if len(sendConns) == 3 {
+			return nil, ErrSangforReconnectLater
+		}
+		conn := &recordingConn{}
+		sendConns = append(sendConns, conn)
+		return conn, nil
+	}
+	newRecvConn := func() (io.ReadCloser, error) { return &recordReader{}, nil }
+	conn, err := newL3Conn(newSendConn, newRecvConn, 4, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if len(conn.lanes) != 3 {
+		t.Fatalf("using %d send streams, want the 3 the server accepted", len(conn.lanes))
+	}
+
+	for port := uint16(1000); port < 1100; port++ {
+		for i := 0; i < 2; i++ {
+			if _, err := conn.Write(testPacket(port, "x")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for i, sendConn := range sendConns {
+		writes := sendConn.recorded()
+		if len(writes) == 0 {
+			t.Fatalf("send stream %d was not used", i)
+		}
+		seen := make(map[uint16]int)
+		for _, packet := range writes {
+			seen[binary.BigEndian.Uint16(packet[20:22])]++
+		}
+		for port, count := range seen {
+			if count != 2 {
+				t.Fatalf("flow from port %d sent %d packets on send stream %d, want 2", port, count, i)
+			}
+		}
+	}
+}
+
+func TestL3ConnRequiresFirstSendStream(t *testing.T) {
+	_, err := newL3Conn(func() (io.WriteCloser, error) {
+		return nil, ErrSangforShutdown
+	}, func() (io.ReadCloser, error) {
+		t.Fatal("receive stream set up without a send stream")
+		return nil, nil
+	}, 2, false)
+	if !errors.Is(err, ErrSangforShutdown) {
+		t.Fatalf("newL3Conn() error = %v, want ErrSangforShutdown", err)
+	}
+}
+
+func TestL3ConnBatchesQueuedWrites(t *testing.T) {
+	sendConn := &recordingConn{block: make(chan struct{}), blocked: make(chan struct{})}
+	release := sendConn.block
+	conn, err := newL3Conn(func() (io.WriteCloser, error) {
+		return sendConn, nil
+	}, func() (io.ReadCloser, error) {
+		return &recordReader{}, nil
+	}, 1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	packets := [][]byte{testPacket(1000, "a"), testPacket(1000, "b"), testPacket(1000, "c"), testPacket(1000, "d")}
+	if _, err := conn.Write(packets[0]); err != nil {
+		t.Fatal(err)
+	}
+	<-sendConn.blocked
+	for _, packet := range packets[1:] {
+		if _, err := conn.Write(packet); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(release)
+
+	want := [][]byte{packets[0], bytes.Join(packets[1:], nil)}
+	deadline := time.Now().Add(time.Second)
+	for {
+		writes := sendConn.recorded()
+		if len(writes) == len(want) {
+			for i := range want {
+				if !bytes.Equal(writes[i], want[i]) {
+					t.Fatalf("write %d = %x, want %x", i, writes[i], want[i])
+				}
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d writes, want %d", len(writes), len(want))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestL3ConnPacketMethods(t *testing.T) {
+	for _, batchWrites := range []bool{false, true} {
+		sendConn := &recordingConn{}
+		want := testPacket(1000, "a")
+		conn, err := newL3Conn(func() (io.WriteCloser, error) {
+			return sendConn, nil
+		}, func() (io.ReadCloser, error) {
+			return &recordReader{records: [][]byte{want}}, nil
+		}, 1, batchWrites)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		buf := packetbuf.Get(len(want))
+		copy(buf.Bytes(), want)
+		if err := conn.WritePacket(buf); err != nil {
+			t.Fatal(err)
+		}
+		packet := make([]byte, len(want))
+		n, err := conn.Read(packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(packet[:n], want) {
+			t.Fatalf("Read() = %x, want %x", packet[:n], want)
+		}
+
+		deadline := time.Now().Add(time.Second)
+		for len(sendConn.recorded()) == 0 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		if writes := sendConn.recorded(); len(writes) != 1 || !bytes.Equal(writes[0], want) {
+			t.Fatalf("batchWrites=%v: sent %x, want %x", batchWrites, writes, want)
+		}
+		_ = conn.Close()
+	}
+}
@@ -24,6 +24,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/mythologyli/zju-connect/internal/captcha"
+	"github.com/mythologyli/zju-connect/internal/prompt"
 	"github.com/mythologyli/zju-connect/log"
 	"github.com/pquerna/otp/totp"
 	utls "github.com/refraction-networking/utls"
@@ -33,9 +35,27 @@ var errSMSRequired = errors.New("SMS code required")
 var errTOTPRequired = errors.New("TOTP required")
 var errCertRequired = errors.New("cert required")
 var errNotFound = errors.New("not found")
+var errRandCodeRejected = errors.New("rand code rejected")
+
+const maxRandCodeAttempts = 5
 
 func (c *Client) requestTwfID(graphCodeFile string) error {
+	// The credentials given to NewClient are already resolved for the first
+	// login; later ones pick up rotated secrets.
+	if c.credentials != nil && c.loggedIn {
+		creds, err := c.credentials.Resolve(c.lifecycleCtx)
+		if err != nil {
+			return err
+		}
+		c.username, c.password, c.totpSecret = creds.Username, creds.Password, creds.TOTPSecret
+	}
+	c.loggedIn = true
+
 	err := c.loginAuthAndPsw(graphCodeFile)
+	for attempt := 2; errors.Is(err, errRandCodeRejected) && attempt <= maxRandCodeAttempts; attempt++ {
+		log.Printf("Rand code rejected, retrying with a new one (attempt %d/%d)", attempt, maxRandCodeAttempts)
+		err = c.loginAuthAndPsw(graphCodeFile)
+	}
 	if err != nil {
 		if errors.Is(err, errSMSRequired) {
 			err = c.loginSMS()
@@ -138,8 +158,9 @@ func (c *Client) loginAuthAndPsw(graphCodeFile string) error {
 	}
 
 	randCode := ""
+	solvedRandCode := false
 	if rndImg == "1" {
-		if graphCodeFile != "" {
+		if graphCodeFile != "" || c.captchaSolver != nil {
 			addr = "https://" + c.server + "/por/rand_code.csp?apiversion=1"
 			log.Printf("Request: %s", addr)
 			req, err := http.NewRequestWithContext(c.lifecycleCtx, http.MethodGet, addr, nil)
@@ -163,16 +184,24 @@ func (c *Client) loginAuthAndPsw(graphCodeFile string) error {
 				_ = Body.Close()
 			}(resp.Body)
 
-			if writeErr := os.WriteFile(graphCodeFile, buf.Bytes(), 0644); writeErr != nil {
-				log.Printf("Warning: failed to write graph code image to %s: %v", graphCodeFile, writeErr)
+			if c.captchaSolver != nil {
+				randCode, err = c.captchaSolver.Solve(c.lifecycleCtx, captcha.Challenge{Kind: captcha.KindText, Image: buf.Bytes()})
+				if err != nil {
+					// Another login gets a new rand code that may be easier.
+					return fmt.Errorf("%w: solver failed: %w", errRandCodeRejected, err)
+				}
+				solvedRandCode = true
 			} else {
-				log.Printf("Graph check code saved to %s", graphCodeFile)
-			}
-
-			fmt.Print("Please enter rand code: ")
-			_, err = fmt.Scanln(&randCode)
-			if err != nil {
-				return err
+				if writeErr := os.WriteFile(graphCodeFile, buf.Bytes(), 0644); writeErr != nil {
+					log.Printf("Warning: failed to write graph code image to %s: %v", graphCodeFile, writeErr)
+				} else {
+					log.Printf("Graph check code saved to %s", graphCodeFile)
+				}
+
+				randCode, err = prompt.Ask(c.lifecycleCtx, c.prompter, prompt.Request{Kind: prompt.KindRandCode, Message: "Please enter rand code: ", Image: buf.Bytes()})
+				if err != nil {
+					return err
+				}
 			}
 		} else {
 			log.Print("Warning: rand code required, but no graph code file provided.")
@@ -236,6 +265,9 @@ func (c *Client) loginAuthAndPsw(graphCodeFile string) error {
 	}
 
 	if !strings.Contains(buf.String(), "<Result>1</Result>") {
+		if solvedRandCode && isRandCodeError(buf.String()) {
+			return fmt.Errorf("%w: %s", errRandCodeRejected, buf.String())
+		}
 		return errors.New("Login failed: " + buf.String())
 	}
 
@@ -250,6 +282,12 @@ func (c *Client) loginAuthAndPsw(graphCodeFile string) error {
 	return nil
 }
 
+// isRandCodeError tells whether a failed login was refused because of a wrong
+// rand code rather than wrong credentials, which must not be retried.
+func isRandCodeError(response string) bool {
+	return strings.Contains(response, "验证码") || strings.Contains(response, "校验码") || strings.Contains(strings.ToLower(response), "rand code")
+}
+
 func (c *Client) loginSMS() error {
 	addr := "https://" + c.server + "/por/login_sms.csp?apiversion=1"
 	log.Printf("SMS request: %s", addr)
@@ -283,9 +321,7 @@ func (c *Client) loginSMS() error {
 
 	log.Printf("SMS code is sent or still valid")
 
-	fmt.Print("Please enter your SMS code: ")
-	smsCode := ""
-	_, err = fmt.Scanln(&smsCode)
+	smsCode, err := prompt.Ask(c.lifecycleCtx, c.prompter, prompt.Request{Kind: prompt.KindSMSCode, Message: "Please enter your SMS code: "})
 	if err != nil {
 		return err
 	}
@@ -338,8 +374,7 @@ func (c *Client) loginTOTP() error {
 	var totpCode string
 	var err error
 	if c.totpSecret == "" {
-		fmt.Print("Please enter your TOTP code:")
-		_, err = fmt.Scanln(&totpCode)
+		totpCode, err = prompt.Ask(c.lifecycleCtx, c.prompter, prompt.Request{Kind: prompt.KindTOTP, Message: "Please enter your TOTP code:"})
 	} else {
 		totpCode, err = totp.GenerateCode(c.totpSecret, time.Now())
 		fmt.Println("Generate TOTP code:", totpCode)
@@ -556,6 +591,48 @@ func (c *Client) requestUpdateSession(ctx context.Context) error {
 	return nil
 }
 
+// Logout ends the session identified by the TWFID on the server, so that it
+// stops counting against the online session limit of the account. The TWFID
+// can not be reused afterwards.
+func (c *Client) Logout(ctx context.Context) error {
+	if c.twfID == "" {
+		return nil
+	}
+	u := url.URL{
+		Scheme: "https",
+		Host:   c.server,
+		Path:   "/por/logout.csp",
+	}
+	q := url.Values{}
+	q.Set("twfid", c.twfID)
+	q.Set("type", "logout")
+	u.RawQuery = q.Encode()
+	log.Printf("Request: https://%s/por/logout.csp", c.server)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Cookie", "TWFID="+c.twfID)
+	req.Header.Set("User-Agent", "EasyConnect_Linux_Ubuntu")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("logout: unexpected status %d", resp.StatusCode)
+	}
+	log.Println("Logged out")
+	c.twfID = ""
+	return nil
+}
+
 func (c *Client) requestResources() (string, error) {
 	addr := "https://" + c.server + "/por/rclist.csp"
 	log.Printf("Request: %s", addr)
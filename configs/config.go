@@ -8,9 +8,13 @@ type (
 		ServerPort          int
 		Username            string
 		Password            string
+		PasswordFile        string
+		PasswordCommand     string
+		Keyring             bool
 		SocksBind           string
 		SocksUser           string
 		SocksPasswd         string
+		SocksPortProbe      bool
 		HTTPBind            string
 		PortForwardingList  []SinglePortForwarding
 		ShadowsocksURL      string
@@ -21,31 +25,80 @@ type (
 		RemoteDNSServer     string
 		SecondaryDNSServer  string
 		DNSServerBind       string
+		DNSServerFakeIP     bool
+		DNSQueryLog         bool
+		DNSQueryLogFile     string
+		DNSStrict           bool
+		DNSStrictDomain     []string
 		LocalDNSServer      string
 		CustomDNSList       []SingleCustomDNS
 		DisableKeepAlive    bool
 		KeepAliveURL        string
+		LogoutOnExit        bool
 		TCPTunnelMode       bool
+		HybridMode          bool
 		TUNMode             bool
 		AddRoute            bool
+		TUNRouteTable       int
+		TUNFwmark           int
+		TUNDNSConfig        string
+		MTU                 int
+		TCPCongestion       string
+		TCPSendBuffer       int
+		TCPSendBufferMax    int
+		TCPReceiveBuffer    int
+		TCPReceiveBufferMax int
+		TCPBufferAutoTune   bool
+		TCPKeepAlive        int
+		TCPKeepAliveIntvl   int
+		TCPIdleTimeout      int
+		TCPMaxLifetime      int
+		UDPIdleTimeout      int
 		DNSHijack           bool
 		FakeIP              bool
+		FakeIPRange         string
+		FakeIPRange6        string
+		FakeIPIdleTimeout   int
+		FakeIPFile          string
+		HostsFile           string
 		GraphCodeFile       string
+		Prompt              string
+		PromptBind          string
+		PromptCommand       string
+		PromptFile          string
+		SMSInboxBind        string
+		SMSInboxToken       string
+		SMSInboxPattern     []string
+		SMSInboxTimeout     int
+		CaptchaSolver       string
+		CaptchaCommand      string
+		CaptchaWebhook      string
+		CaptchaWebhookToken string
+		CaptchaOCRModel     string
 		DebugDump           bool
 		DebugPCAPFile       string
 		DebugTLSLogFile     string
 		BindInterface       string
 		AutoDetectInterface bool
+		AdminBind           string
+		AdminToken          string
 
 		// EasyConnect fields
 		TOTPSecret          string
+		TOTPSecretFile      string
+		TOTPSecretCommand   string
 		CertFile            string
+		CertKeyFile         string
 		CertPassword        string
+		CertPasswordFile    string
+		CertPasswordCommand string
 		DisableServerConfig bool
 		SkipDomainResource  bool
 		DisableMultiLine    bool
 		ProxyAll            bool
 		CustomProxyDomain   []string
+		SendConns           int
+		BatchWrites         bool
 		TwfID               string
 
 		// aTrust fields
@@ -53,13 +106,23 @@ type (
 		Phone                   string
 		LoginDomain             string
 		ClientDataFile          string
+		ClientDataKey           string
+		ClientDataKeyFile       string
+		ClientDataKeyCommand    string
 		CasTicket               string
+		BrowserLogin            bool
 		OAuth2Code              string
 		SID                     string
 		DeviceID                string
 		SignKey                 string
 		ResourceFile            string
 		UpdateBestNodesInterval int
+		TCPTunnelPoolSize       int
+		TCPTunnelPoolPrewarm    int
+		TCPTunnelPoolIdle       int
+		TCPTunnelUDP            bool
+		L3TunnelConns           int
+		L3TunnelMaxConns        int
 	}
 
 	SinglePortForwarding struct {
@@ -69,8 +132,9 @@ type (
 	}
 
 	SingleCustomDNS struct {
-		HostName string `toml:"host_name"`
-		IP       string `toml:"ip"`
+		HostName string   `toml:"host_name"`
+		IPs      []string `toml:"ips"`
+		CNAME    string   `toml:"cname"`
 	}
 )
 
@@ -81,34 +145,85 @@ type (
 		ServerPort              *int                       `toml:"server_port"`
 		Username                *string                    `toml:"username"`
 		Password                *string                    `toml:"password"`
+		PasswordFile            *string                    `toml:"password_file"`
+		PasswordCommand         *string                    `toml:"password_command"`
+		Keyring                 *bool                      `toml:"keyring"`
 		TOTPSecret              *string                    `toml:"totp_secret"`
+		TOTPSecretFile          *string                    `toml:"totp_secret_file"`
+		TOTPSecretCommand       *string                    `toml:"totp_secret_command"`
 		CertFile                *string                    `toml:"cert_file"`
+		CertKeyFile             *string                    `toml:"cert_key_file"`
 		CertPassword            *string                    `toml:"cert_password"`
+		CertPasswordFile        *string                    `toml:"cert_password_file"`
+		CertPasswordCommand     *string                    `toml:"cert_password_command"`
 		DisableServerConfig     *bool                      `toml:"disable_server_config"`
 		SkipDomainResource      *bool                      `toml:"skip_domain_resource"`
 		DisableZJUConfig        *bool                      `toml:"disable_zju_config"`
 		DisableRemoteDNS        *bool                      `toml:"disable_zju_dns"` // TODO: rename to disable_remote_dns
 		DisableMultiLine        *bool                      `toml:"disable_multi_line"`
 		ProxyAll                *bool                      `toml:"proxy_all"`
+		SendConns               *int                       `toml:"send_conns"`
+		BatchWrites             *bool                      `toml:"batch_writes"`
 		SocksBind               *string                    `toml:"socks_bind"`
 		SocksUser               *string                    `toml:"socks_user"`
 		SocksPasswd             *string                    `toml:"socks_passwd"`
+		SocksPortProbe          *bool                      `toml:"socks_probe_closed_port"`
 		HTTPBind                *string                    `toml:"http_bind"`
 		ShadowsocksURL          *string                    `toml:"shadowsocks_url"`
 		DialDirectProxy         *string                    `toml:"dial_direct_proxy"`
 		TCPTunnelMode           *bool                      `toml:"tcp_tunnel_mode"`
+		HybridMode              *bool                      `toml:"hybrid_mode"`
 		TUNMode                 *bool                      `toml:"tun_mode"`
 		AddRoute                *bool                      `toml:"add_route"`
+		TUNRouteTable           *int                       `toml:"tun_route_table"`
+		TUNFwmark               *int                       `toml:"tun_fwmark"`
+		TUNDNSConfig            *string                    `toml:"tun_dns_config"`
+		MTU                     *int                       `toml:"mtu"`
+		TCPCongestion           *string                    `toml:"tcp_congestion_control"`
+		TCPSendBuffer           *int                       `toml:"tcp_send_buffer"`
+		TCPSendBufferMax        *int                       `toml:"tcp_send_buffer_max"`
+		TCPReceiveBuffer        *int                       `toml:"tcp_receive_buffer"`
+		TCPReceiveBufferMax     *int                       `toml:"tcp_receive_buffer_max"`
+		TCPBufferAutoTune       *bool                      `toml:"tcp_buffer_auto_tune"`
+		TCPKeepAlive            *int                       `toml:"tcp_keep_alive"`
+		TCPKeepAliveIntvl       *int                       `toml:"tcp_keep_alive_interval"`
+		TCPIdleTimeout          *int                       `toml:"tcp_idle_timeout"`
+		TCPMaxLifetime          *int                       `toml:"tcp_max_lifetime"`
+		UDPIdleTimeout          *int                       `toml:"udp_idle_timeout"`
 		DNSTTL                  *uint64                    `toml:"dns_ttl"`
 		DisableKeepAlive        *bool                      `toml:"disable_keep_alive"`
 		KeepAliveURL            *string                    `toml:"keep_alive_url"`
+		LogoutOnExit            *bool                      `toml:"logout_on_exit"`
 		RemoteDNSServer         *string                    `toml:"zju_dns_server"` // TODO: rename to remote_dns_server
 		SecondaryDNSServer      *string                    `toml:"secondary_dns_server"`
 		DNSServerBind           *string                    `toml:"dns_server_bind"`
+		DNSServerFakeIP         *bool                      `toml:"dns_server_fake_ip"`
+		DNSQueryLog             *bool                      `toml:"dns_query_log"`
+		DNSQueryLogFile         *string                    `toml:"dns_query_log_file"`
+		DNSStrict               *bool                      `toml:"dns_strict"`
+		DNSStrictDomain         []string                   `toml:"dns_strict_domain"`
 		LocalDNSServer          *string                    `toml:"local_dns_server"`
 		DNSHijack               *bool                      `toml:"dns_hijack"`
 		FakeIP                  *bool                      `toml:"fake_ip"`
+		FakeIPRange             *string                    `toml:"fake_ip_range"`
+		FakeIPRange6            *string                    `toml:"fake_ip_range6"`
+		FakeIPIdleTimeout       *int                       `toml:"fake_ip_idle_timeout"`
+		FakeIPFile              *string                    `toml:"fake_ip_file"`
+		HostsFile               *string                    `toml:"hosts_file"`
 		GraphCodeFile           *string                    `toml:"graph_code_file"`
+		Prompt                  *string                    `toml:"prompt"`
+		PromptBind              *string                    `toml:"prompt_bind"`
+		PromptCommand           *string                    `toml:"prompt_command"`
+		PromptFile              *string                    `toml:"prompt_file"`
+		SMSInboxBind            *string                    `toml:"sms_inbox_bind"`
+		SMSInboxToken           *string                    `toml:"sms_inbox_token"`
+		SMSInboxPattern         []string                   `toml:"sms_inbox_pattern"`
+		SMSInboxTimeout         *int                       `toml:"sms_inbox_timeout"`
+		CaptchaSolver           *string                    `toml:"captcha_solver"`
+		CaptchaCommand          *string                    `toml:"captcha_command"`
+		CaptchaWebhook          *string                    `toml:"captcha_webhook"`
+		CaptchaWebhookToken     *string                    `toml:"captcha_webhook_token"`
+		CaptchaOCRModel         *string                    `toml:"captcha_ocr_model"`
 		DebugDump               *bool                      `toml:"debug_dump"`
 		DebugPCAPFile           *string                    `toml:"debug_pcap_file"`
 		DebugTLSLogFile         *string                    `toml:"debug_tls_log_file"`
@@ -119,15 +234,27 @@ type (
 		Phone                   *string                    `toml:"phone"`
 		LoginDomain             *string                    `toml:"login_domain"`
 		ClientDataFile          *string                    `toml:"client_data_file"`
+		ClientDataKey           *string                    `toml:"client_data_key"`
+		ClientDataKeyFile       *string                    `toml:"client_data_key_file"`
+		ClientDataKeyCommand    *string                    `toml:"client_data_key_command"`
 		CasTicket               *string                    `toml:"cas_ticket"`
+		BrowserLogin            *bool                      `toml:"browser_login"`
 		OAuth2Code              *string                    `toml:"oauth2_code"`
 		SID                     *string                    `toml:"sid"`
 		DeviceID                *string                    `toml:"device_id"`
 		SignKey                 *string                    `toml:"sign_key"`
 		ResourceFile            *string                    `toml:"resource_file"`
 		UpdateBestNodesInterval *int                       `toml:"update_best_nodes_interval"`
+		TCPTunnelPoolSize       *int                       `toml:"tcp_tunnel_pool_size"`
+		TCPTunnelPoolPrewarm    *int                       `toml:"tcp_tunnel_pool_prewarm"`
+		TCPTunnelPoolIdle       *int                       `toml:"tcp_tunnel_pool_idle_timeout"`
+		TCPTunnelUDP            *bool                      `toml:"tcp_tunnel_udp"`
+		L3TunnelConns           *int                       `toml:"l3_tunnel_conns"`
+		L3TunnelMaxConns        *int                       `toml:"l3_tunnel_max_conns"`
 		BindInterface           *string                    `toml:"bind_interface"`
 		AutoDetectInterface     *bool                      `toml:"auto_detect_interface"`
+		AdminBind               *string                    `toml:"admin_bind"`
+		AdminToken              *string                    `toml:"admin_token"`
 	}
 
 	SinglePortForwardingTOML struct {
@@ -137,7 +264,9 @@ type (
 	}
 
 	SingleCustomDNSTOML struct {
-		HostName *string `toml:"host_name"`
-		IP       *string `toml:"ip"`
+		HostName *string  `toml:"host_name"`
+		IP       *string  `toml:"ip"`
+		IPs      []string `toml:"ips"`
+		CNAME    *string  `toml:"cname"`
 	}
 )
@@ -15,6 +15,8 @@ import (
 import (
 	"context"
 	"errors"
+	"fmt"
+	"time"
 )
 
 // ErrACLDenied is returned by DialIPPort when the caller forced VPN routing
@@ -25,6 +27,14 @@ import (
 // client does and keeps the tunnel alive.
 var ErrACLDenied = errors.New("destination not in sangfor IPResources whitelist (would trigger tunnel SHUTDOWN)")
 
+// ErrFakeIPUnresolvable is returned when a fake IP maps to a domain whose real
+// address cannot be resolved and the resource cannot be reached by name.
+var ErrFakeIPUnresolvable = errors.New("fake IP domain cannot be resolved")
+
+// ErrNotVPNTarget is returned by Ping for hosts that are not reached through
+// the VPN, whose reachability says nothing about the tunnel.
+var ErrNotVPNTarget = errors.New("host is not reached through the VPN")
+
 type Dialer struct {
 	stack                stack.Stack
 	resolver             *resolve.Resolver
@@ -74,6 +84,12 @@ func (d *Dialer) DialIPPort(ctx context.Context, network, ipAddr string) (net.Co
 		// hostAddr doesn't have port field at now
 		hostAddr = ctx.Value(resolve.ContextKeyResolveHost).(string)
 	}
+	if host, portStr, err := net.SplitHostPort(ipAddr); err == nil {
+		if domain, resources, ok := d.lookupFakeIP(net.ParseIP(host)); ok {
+			return d.dialFakeIP(ctx, network, domain, resources, net.ParseIP(host), portStr)
+		}
+	}
+
 	parts := strings.Split(ipAddr, ":")
 	if len(parts) >= 2 {
 		// maybe need extra check for parts[len(parts)-1] is port or not?
@@ -175,6 +191,50 @@ func (d *Dialer) DialIPPort(ctx context.Context, network, ipAddr string) (net.Co
 	}
 }
 
+func (d *Dialer) lookupFakeIP(ip net.IP) (string, []client.DomainResource, bool) {
+	if ip == nil || d.resolver == nil || d.resolver.IPPool == nil || !d.resolver.IPPool.Contains(ip) {
+		return "", nil, false
+	}
+	return d.resolver.IPPool.GetDomain(ip)
+}
+
+// dialFakeIP dials a fake IP handed out by the DNS server. The domain it was
+// generated for is resolved again so that the connection follows the same
+// rules as if the client had asked for the host name. When the domain cannot
+// be resolved, TCP resources that let the tunnel connect by name are still
+// dialed through the fake IP.
+func (d *Dialer) dialFakeIP(ctx context.Context, network, domain string, resources []client.DomainResource, fakeIP net.IP, port string) (net.Conn, error) {
+	log.DebugPrintf("Fake IP %s -> %s", fakeIP, domain)
+
+	resolveCtx, ip, err := d.resolver.Resolve(ctx, domain)
+	if err == nil && !d.resolver.IPPool.Contains(ip) {
+		if ip.To4() == nil {
+			return d.dialDirectIP(resolveCtx, network, net.JoinHostPort(ip.String(), port), net.JoinHostPort(domain, port))
+		}
+		return d.DialIPPort(resolveCtx, network, net.JoinHostPort(ip.String(), port))
+	}
+
+	portNum, convErr := strconv.Atoi(port)
+	if convErr != nil {
+		return nil, errors.New("Invalid port in address: " + port)
+	}
+	if network == "tcp" && fakeIP.To4() != nil {
+		if resource, ok := client.MatchDomainResourceWhere(resources, network, portNum, func(resource client.DomainResource) bool {
+			return !resource.EnableTCPPrefL3 && resource.AddrPretend
+		}); ok {
+			ctx = context.WithValue(ctx, resolve.ContextKeyDomainResource, resource)
+			ctx = context.WithValue(ctx, resolve.ContextKeyResolveHost, domain)
+			log.Printf("%s:%s -> VPN", domain, port)
+			return d.stack.DialTCP(ctx, &net.TCPAddr{IP: fakeIP, Port: portNum})
+		}
+	}
+
+	if err == nil {
+		err = ErrFakeIPUnresolvable
+	}
+	return nil, fmt.Errorf("dial %s (%s): %w", domain, fakeIP, err)
+}
+
 func matchDomainResourceForTunnel(resources []client.DomainResource, network string, port int) (client.DomainResource, bool) {
 	if network == "tcp" {
 		if resource, ok := client.MatchDomainResourceWhere(resources, network, port, func(resource client.DomainResource) bool {
@@ -202,6 +262,49 @@ func matchIPResourceForTunnel(index *ipresource.Index, target net.IP, network st
 	return index.Match(target, network, port)
 }
 
+// Ping sends an ICMP echo request to host through the VPN and returns the
+// address it pinged and the round-trip time. Hosts are routed like DialIPPort
+// routes connections: only IPv4 destinations covered by an ICMP (or "all")
+// resource are pinged, so that the ACL of the server is never violated.
+func (d *Dialer) Ping(ctx context.Context, host string) (net.IP, time.Duration, error) {
+	ip := net.ParseIP(host)
+	if domain, _, ok := d.lookupFakeIP(ip); ok {
+		host, ip = domain, nil
+	}
+	if ip == nil {
+		if d.resolver == nil {
+			return nil, 0, fmt.Errorf("resolve %s: no resolver", host)
+		}
+		var err error
+		ctx, ip, err = d.resolver.Resolve(ctx, host)
+		if err != nil {
+			return nil, 0, fmt.Errorf("resolve %s: %w", host, err)
+		}
+		if d.resolver.IPPool != nil && d.resolver.IPPool.Contains(ip) {
+			return ip, 0, fmt.Errorf("ping %s (%s): %w", host, ip, ErrFakeIPUnresolvable)
+		}
+	}
+	if ip.To4() == nil || !d.pingsThroughVPN(ctx, ip) {
+		return ip, 0, fmt.Errorf("ping %s: %w", host, ErrNotVPNTarget)
+	}
+
+	log.DebugPrintf("Ping %s -> VPN", ip)
+	rtt, err := d.stack.Ping(ctx, ip)
+	return ip, rtt, err
+}
+
+func (d *Dialer) pingsThroughVPN(ctx context.Context, ip net.IP) bool {
+	if resources, ok := ctx.Value(resolve.ContextKeyDomainResource).([]client.DomainResource); ok {
+		if _, matched := client.MatchDomainResource(resources, "icmp", 0); matched {
+			return true
+		}
+	}
+	if d.ipResources == nil {
+		return d.alwaysUseVPN
+	}
+	return matchesIPResource(d.resourceIndex, ip, "icmp", 0)
+}
+
 func (d *Dialer) Dial(ctx context.Context, network string, addr string) (net.Conn, error) {
 	// If addr is IPv6, use direct connection
 	if strings.Count(addr, ":") > 1 {
@@ -2,8 +2,10 @@ package dial
 
 import (
 	"context"
+	"errors"
 	"net"
 	"testing"
+	"time"
 
 	"github.com/mythologyli/zju-connect/client"
 	"github.com/mythologyli/zju-connect/internal/ippool"
@@ -92,15 +94,65 @@ func TestDialIPPortCarriesTCPPrefL3IPResource(t *testing.T) {
 	}
 }
 
+func TestDialIPPortMapsFakeIPBackToDomain(t *testing.T) {
+	const domain = "intranet.example.com"
+	resources := client.DomainResources{"example.com": {{PortMin: 443, PortMax: 443, Protocol: "tcp", AppID: "intranet"}}}
+	pool, err := ippool.NewIPPool[[]client.DomainResource]("198.18.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fakeIP, err := pool.GenerateIP(domain, resources["example.com"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	stack := &capturingStack{}
+	resolver := resolve.NewResolver(stack, "", "", 60, resources, nil, false, pool)
+	defer resolver.Close()
+	resolver.SetPermanentDNS(domain, net.IPv4(10, 0, 0, 5))
+	dialer := &Dialer{stack: stack, resolver: resolver, resourceIndex: ipresource.New(nil)}
+
+	if _, err := dialer.DialIPPort(context.Background(), "tcp", net.JoinHostPort(fakeIP.String(), "443")); err != nil {
+		t.Fatalf("DialIPPort() error = %v", err)
+	}
+	if stack.domainResource.AppID != "intranet" {
+		t.Fatalf("selected AppID = %q, want intranet", stack.domainResource.AppID)
+	}
+	if !stack.tcpAddr.IP.Equal(net.IPv4(10, 0, 0, 5)) || stack.tcpAddr.Port != 443 {
+		t.Fatalf("dialed %s, want 10.0.0.5:443", stack.tcpAddr)
+	}
+}
+
+func TestPingFollowsICMPResources(t *testing.T) {
+	resources := []client.IPResource{
+		{IPMin: net.IPv4(10, 0, 0, 1), IPMax: net.IPv4(10, 0, 0, 1), PortMin: 1, PortMax: 65535, Protocol: "icmp"},
+		{IPMin: net.IPv4(10, 0, 0, 2), IPMax: net.IPv4(10, 0, 0, 2), PortMin: 80, PortMax: 80, Protocol: "tcp"},
+	}
+	stack := &capturingStack{}
+	dialer := &Dialer{stack: stack, ipResources: resources, resourceIndex: ipresource.New(resources), alwaysUseVPN: true}
+
+	ip, rtt, err := dialer.Ping(context.Background(), "10.0.0.1")
+	if err != nil || rtt != time.Millisecond || !ip.Equal(net.IPv4(10, 0, 0, 1)) || !stack.pingIP.Equal(ip) {
+		t.Fatalf("Ping() = %s, %s, %v, want 10.0.0.1 pinged through the stack", ip, rtt, err)
+	}
+
+	stack.pingIP = nil
+	if _, _, err := dialer.Ping(context.Background(), "10.0.0.2"); !errors.Is(err, ErrNotVPNTarget) || stack.pingIP != nil {
+		t.Fatalf("Ping() error = %v, want ErrNotVPNTarget without pinging", err)
+	}
+}
+
 type capturingStack struct {
 	domainResource client.DomainResource
 	ipResource     client.IPResource
+	tcpAddr        *net.TCPAddr
+	pingIP         net.IP
 }
 
 func (s *capturingStack) Run()                                                {}
 func (s *capturingStack) SetupResolve(zcdns.LocalServer)                      {}
 func (s *capturingStack) SetupIPPool(*ippool.IPPool[[]client.DomainResource]) {}
-func (s *capturingStack) DialTCP(ctx context.Context, _ *net.TCPAddr) (net.Conn, error) {
+func (s *capturingStack) DialTCP(ctx context.Context, addr *net.TCPAddr) (net.Conn, error) {
+	s.tcpAddr = addr
 	if resource, ok := ctx.Value(resolve.ContextKeyDomainResource).(client.DomainResource); ok {
 		s.domainResource = resource
 	}
@@ -112,3 +164,7 @@ func (s *capturingStack) DialTCP(ctx context.Context, _ *net.TCPAddr) (net.Conn,
 func (s *capturingStack) DialUDP(context.Context, *net.UDPAddr) (net.Conn, error) {
 	return nil, nil
 }
+func (s *capturingStack) Ping(_ context.Context, ip net.IP) (time.Duration, error) {
+	s.pingIP = ip
+	return time.Millisecond, nil
+}
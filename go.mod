@@ -7,16 +7,20 @@ replace inet.af/netaddr => github.com/inetaf/netaddr v0.0.0-20230525184311-b8eac
 require (
 	github.com/BurntSushi/toml v1.6.0
 	github.com/beevik/etree v1.6.0
+	github.com/boombuler/barcode v1.1.0
 	github.com/containers/winquit v1.1.0
+	github.com/godbus/dbus/v5 v5.1.0
 	github.com/miekg/dns v1.1.72
 	github.com/mythologyli/sing-tun v0.0.0-20260201144630-c04d9db95dc7
 	github.com/patrickmn/go-cache v2.1.0+incompatible
 	github.com/pquerna/otp v1.5.0
 	github.com/refraction-networking/utls v1.8.2
+	github.com/sagernet/netlink v0.0.0-20240916134442-83396419aa8b
 	github.com/sagernet/sing v0.7.18
 	github.com/shadowsocks/go-shadowsocks2 v0.1.5
 	github.com/shirou/gopsutil/v4 v4.26.4
 	github.com/things-go/go-socks5 v0.1.1
+	github.com/youmark/pkcs8 v0.0.0-20240726163527-a2c0da244d78
 	golang.org/x/crypto v0.52.0
 	golang.org/x/net v0.55.0
 	golang.org/x/sys v0.45.0
@@ -24,11 +28,11 @@ require (
 	golang.zx2c4.com/wireguard/windows v1.0.1
 	gvisor.dev/gvisor v0.0.0-20260129214308-cb856800aa1c
 	inet.af/netaddr v0.0.0-20230525184311-b8eac61e914a
+	software.sslmate.com/src/go-pkcs12 v0.7.3
 )
 
 require (
 	github.com/andybalholm/brotli v1.2.1 // indirect
-	github.com/boombuler/barcode v1.1.0 // indirect
 	github.com/ebitengine/purego v0.10.0 // indirect
 	github.com/fsnotify/fsnotify v1.10.1 // indirect
 	github.com/go-ole/go-ole v1.3.0 // indirect
@@ -38,7 +42,6 @@ require (
 	github.com/power-devops/perfstat v0.0.0-20240221224432-82ca36839d55 // indirect
 	github.com/riobard/go-bloom v0.0.0-20200614022211-cdc8013cb5b3 // indirect
 	github.com/sagernet/go-tun2socks v1.16.12-0.20220818015926-16cb67876a61 // indirect
-	github.com/sagernet/netlink v0.0.0-20240916134442-83396419aa8b // indirect
 	github.com/scjalliance/comshim v0.0.0-20251021001035-b69f3cdad6f3 // indirect
 	github.com/sirupsen/logrus v1.9.4 // indirect
 	github.com/vishvananda/netns v0.0.5 // indirect
@@ -1,19 +1,28 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"os"
 	"regexp"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/mythologyli/zju-connect/client"
 	"github.com/mythologyli/zju-connect/client/atrust"
 	"github.com/mythologyli/zju-connect/configs"
+	"github.com/mythologyli/zju-connect/internal/credential"
+	"github.com/mythologyli/zju-connect/internal/securefile"
+	"github.com/mythologyli/zju-connect/service"
+	"github.com/mythologyli/zju-connect/stack/tuning"
 )
 
 var (
@@ -21,6 +30,20 @@ var (
 	CommitID          string
 )
 
+var credentialResolver *credential.Resolver
+
+// The trust-device and untrust-device options change whether the server
+// trusts this device instead of serving.
+var atrustTrustDevice, atrustUntrustDevice bool
+var clientDataMigrate bool
+
+// The ping subcommand pings pingHost through the VPN instead of serving.
+var (
+	pingHost    string
+	pingCount   int
+	pingTimeout time.Duration
+)
+
 func zjuConnectVersionString() string {
 	if CommitID != "" {
 		return zjuConnectVersion + "-" + CommitID
@@ -28,6 +51,108 @@ func zjuConnectVersionString() string {
 	return zjuConnectVersion
 }
 
+func newCredentialResolver(conf configs.Config) *credential.Resolver {
+	resolver := &credential.Resolver{
+		Username: credential.Source{Name: "username", Value: conf.Username, Env: "ZJU_CONNECT_USERNAME"},
+		Password: credential.Source{
+			Name:    "password",
+			Value:   conf.Password,
+			File:    conf.PasswordFile,
+			Command: conf.PasswordCommand,
+			Env:     "ZJU_CONNECT_PASSWORD",
+			Keyring: "password",
+		},
+		TOTPSecret: credential.Source{
+			Name:    "TOTP secret",
+			Value:   conf.TOTPSecret,
+			File:    conf.TOTPSecretFile,
+			Command: conf.TOTPSecretCommand,
+			Env:     "ZJU_CONNECT_TOTP_SECRET",
+			Keyring: "totp_secret",
+		},
+		CertPassword: credential.Source{
+			Name:    "certificate password",
+			Value:   conf.CertPassword,
+			File:    conf.CertPasswordFile,
+			Command: conf.CertPasswordCommand,
+			Env:     "ZJU_CONNECT_CERT_PASSWORD",
+			Keyring: "cert_password",
+		},
+		ClientDataKey: credential.Source{
+			Name:    "client data key",
+			Value:   conf.ClientDataKey,
+			File:    conf.ClientDataKeyFile,
+			Command: conf.ClientDataKeyCommand,
+			Env:     "ZJU_CONNECT_CLIENT_DATA_KEY",
+			Keyring: "client_data_key",
+		},
+	}
+	if conf.Keyring {
+		resolver.Keyring = credential.SecretService{}
+	}
+	return resolver
+}
+
+func newStackTuning(conf configs.Config) tuning.Options {
+	return tuning.Options{
+		SendBuffer:        conf.TCPSendBuffer,
+		SendBufferMax:     conf.TCPSendBufferMax,
+		ReceiveBuffer:     conf.TCPReceiveBuffer,
+		ReceiveBufferMax:  conf.TCPReceiveBufferMax,
+		BufferAutoTune:    conf.TCPBufferAutoTune,
+		CongestionControl: conf.TCPCongestion,
+		MTU:               uint32(max(conf.MTU, 0)),
+		KeepAlive:         time.Duration(conf.TCPKeepAlive) * time.Second,
+		KeepAliveInterval: time.Duration(conf.TCPKeepAliveIntvl) * time.Second,
+		TCPIdleTimeout:    time.Duration(conf.TCPIdleTimeout) * time.Second,
+		TCPMaxLifetime:    time.Duration(conf.TCPMaxLifetime) * time.Second,
+		UDPIdleTimeout:    time.Duration(conf.UDPIdleTimeout) * time.Second,
+	}
+}
+
+// tunRoutePolicy returns the routing table and firewall mark of the TUN
+// routes, both 0 when they go into the main table.
+func tunRoutePolicy(conf configs.Config) (table, fwmark uint32) {
+	if !conf.TUNMode || conf.TUNRouteTable <= 0 {
+		return 0, 0
+	}
+	table, fwmark = uint32(conf.TUNRouteTable), uint32(max(conf.TUNFwmark, 0))
+	if fwmark == 0 {
+		fwmark = table
+	}
+	return table, fwmark
+}
+
+// tunDNSDomains returns the domains the system should resolve with the DNS
+// server of the TUN interface.
+func tunDNSDomains(conf configs.Config, domainResources client.DomainResources, dnsResource map[string][]net.IP) []string {
+	names := make([]string, 0, len(domainResources)+len(dnsResource)+len(conf.CustomDNSList)+1)
+	if conf.Protocol == "easyconnect" && !conf.DisableZJUConfig {
+		names = append(names, "zju.edu.cn")
+	}
+	for name := range domainResources {
+		names = append(names, name)
+	}
+	for name := range dnsResource {
+		names = append(names, name)
+	}
+	for _, customDNS := range conf.CustomDNSList {
+		names = append(names, customDNS.HostName)
+	}
+
+	var domains []string
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		name = strings.TrimPrefix(strings.TrimPrefix(name, "*"), ".")
+		if name == "" || strings.ContainsAny(name, "*/:") || net.ParseIP(name) != nil {
+			continue
+		}
+		domains = append(domains, name)
+	}
+	slices.Sort(domains)
+	return slices.Compact(domains)
+}
+
 func getTOMLVal[T int | uint64 | string | bool](valPointer *T, defaultVal T) T {
 	if valPointer == nil {
 		return defaultVal
@@ -49,50 +174,113 @@ func parseTOMLConfig(configFile string, conf *configs.Config) error {
 	conf.ServerPort = getTOMLVal(confTOML.ServerPort, 443)
 	conf.Username = getTOMLVal(confTOML.Username, "")
 	conf.Password = getTOMLVal(confTOML.Password, "")
+	conf.PasswordFile = getTOMLVal(confTOML.PasswordFile, "")
+	conf.PasswordCommand = getTOMLVal(confTOML.PasswordCommand, "")
+	conf.Keyring = getTOMLVal(confTOML.Keyring, false)
 	conf.TOTPSecret = getTOMLVal(confTOML.TOTPSecret, "")
+	conf.TOTPSecretFile = getTOMLVal(confTOML.TOTPSecretFile, "")
+	conf.TOTPSecretCommand = getTOMLVal(confTOML.TOTPSecretCommand, "")
 	conf.CertFile = getTOMLVal(confTOML.CertFile, "")
+	conf.CertKeyFile = getTOMLVal(confTOML.CertKeyFile, "")
 	conf.CertPassword = getTOMLVal(confTOML.CertPassword, "")
+	conf.CertPasswordFile = getTOMLVal(confTOML.CertPasswordFile, "")
+	conf.CertPasswordCommand = getTOMLVal(confTOML.CertPasswordCommand, "")
 	conf.DisableServerConfig = getTOMLVal(confTOML.DisableServerConfig, false)
 	conf.SkipDomainResource = getTOMLVal(confTOML.SkipDomainResource, false)
 	conf.DisableZJUConfig = getTOMLVal(confTOML.DisableZJUConfig, false)
 	conf.DisableRemoteDNS = getTOMLVal(confTOML.DisableRemoteDNS, false)
 	conf.DisableMultiLine = getTOMLVal(confTOML.DisableMultiLine, false)
 	conf.ProxyAll = getTOMLVal(confTOML.ProxyAll, false)
+	conf.SendConns = getTOMLVal(confTOML.SendConns, 1)
+	conf.BatchWrites = getTOMLVal(confTOML.BatchWrites, false)
 	conf.SocksBind = getTOMLVal(confTOML.SocksBind, ":1080")
 	conf.SocksUser = getTOMLVal(confTOML.SocksUser, "")
 	conf.SocksPasswd = getTOMLVal(confTOML.SocksPasswd, "")
+	conf.SocksPortProbe = getTOMLVal(confTOML.SocksPortProbe, false)
 	conf.HTTPBind = getTOMLVal(confTOML.HTTPBind, ":1081")
 	conf.ShadowsocksURL = getTOMLVal(confTOML.ShadowsocksURL, "")
 	conf.DialDirectProxy = getTOMLVal(confTOML.DialDirectProxy, "")
 	conf.TCPTunnelMode = getTOMLVal(confTOML.TCPTunnelMode, false)
+	conf.HybridMode = getTOMLVal(confTOML.HybridMode, false)
 	conf.TUNMode = getTOMLVal(confTOML.TUNMode, false)
 	conf.AddRoute = getTOMLVal(confTOML.AddRoute, false)
+	conf.TUNRouteTable = getTOMLVal(confTOML.TUNRouteTable, 0)
+	conf.TUNFwmark = getTOMLVal(confTOML.TUNFwmark, 0)
+	conf.TUNDNSConfig = getTOMLVal(confTOML.TUNDNSConfig, "resolved")
+	conf.MTU = getTOMLVal(confTOML.MTU, 1400)
+	conf.TCPCongestion = getTOMLVal(confTOML.TCPCongestion, "cubic")
+	conf.TCPSendBuffer = getTOMLVal(confTOML.TCPSendBuffer, 0)
+	conf.TCPSendBufferMax = getTOMLVal(confTOML.TCPSendBufferMax, 0)
+	conf.TCPReceiveBuffer = getTOMLVal(confTOML.TCPReceiveBuffer, 0)
+	conf.TCPReceiveBufferMax = getTOMLVal(confTOML.TCPReceiveBufferMax, 0)
+	conf.TCPBufferAutoTune = getTOMLVal(confTOML.TCPBufferAutoTune, false)
+	conf.TCPKeepAlive = getTOMLVal(confTOML.TCPKeepAlive, 0)
+	conf.TCPKeepAliveIntvl = getTOMLVal(confTOML.TCPKeepAliveIntvl, 15)
+	conf.TCPIdleTimeout = getTOMLVal(confTOML.TCPIdleTimeout, 0)
+	conf.TCPMaxLifetime = getTOMLVal(confTOML.TCPMaxLifetime, 0)
+	conf.UDPIdleTimeout = getTOMLVal(confTOML.UDPIdleTimeout, 0)
 	conf.DNSTTL = getTOMLVal(confTOML.DNSTTL, uint64(3600))
 	conf.DebugDump = getTOMLVal(confTOML.DebugDump, false)
 	conf.DebugPCAPFile = getTOMLVal(confTOML.DebugPCAPFile, "")
 	conf.DebugTLSLogFile = getTOMLVal(confTOML.DebugTLSLogFile, "")
 	conf.DisableKeepAlive = getTOMLVal(confTOML.DisableKeepAlive, false)
 	conf.KeepAliveURL = getTOMLVal(confTOML.KeepAliveURL, "")
+	conf.LogoutOnExit = getTOMLVal(confTOML.LogoutOnExit, false)
 	conf.RemoteDNSServer = getTOMLVal(confTOML.RemoteDNSServer, "auto")
 	conf.SecondaryDNSServer = getTOMLVal(confTOML.SecondaryDNSServer, "auto")
 	conf.DNSServerBind = getTOMLVal(confTOML.DNSServerBind, "")
+	conf.DNSServerFakeIP = getTOMLVal(confTOML.DNSServerFakeIP, false)
+	conf.DNSQueryLog = getTOMLVal(confTOML.DNSQueryLog, false)
+	conf.DNSQueryLogFile = getTOMLVal(confTOML.DNSQueryLogFile, "")
+	conf.DNSStrict = getTOMLVal(confTOML.DNSStrict, false)
+	conf.DNSStrictDomain = confTOML.DNSStrictDomain
 	conf.LocalDNSServer = getTOMLVal(confTOML.LocalDNSServer, "")
 	conf.DNSHijack = getTOMLVal(confTOML.DNSHijack, false)
 	conf.FakeIP = getTOMLVal(confTOML.FakeIP, false)
+	conf.FakeIPRange = getTOMLVal(confTOML.FakeIPRange, "198.18.0.0/16")
+	conf.FakeIPRange6 = getTOMLVal(confTOML.FakeIPRange6, "")
+	conf.FakeIPIdleTimeout = getTOMLVal(confTOML.FakeIPIdleTimeout, 300)
+	conf.FakeIPFile = getTOMLVal(confTOML.FakeIPFile, "")
+	conf.HostsFile = getTOMLVal(confTOML.HostsFile, "")
 	conf.GraphCodeFile = getTOMLVal(confTOML.GraphCodeFile, "")
+	conf.Prompt = getTOMLVal(confTOML.Prompt, "terminal")
+	conf.PromptBind = getTOMLVal(confTOML.PromptBind, "")
+	conf.PromptCommand = getTOMLVal(confTOML.PromptCommand, "")
+	conf.PromptFile = getTOMLVal(confTOML.PromptFile, "")
+	conf.SMSInboxBind = getTOMLVal(confTOML.SMSInboxBind, "")
+	conf.SMSInboxToken = getTOMLVal(confTOML.SMSInboxToken, "")
+	conf.SMSInboxPattern = confTOML.SMSInboxPattern
+	conf.SMSInboxTimeout = getTOMLVal(confTOML.SMSInboxTimeout, 120)
+	conf.CaptchaSolver = getTOMLVal(confTOML.CaptchaSolver, "manual")
+	conf.CaptchaCommand = getTOMLVal(confTOML.CaptchaCommand, "")
+	conf.CaptchaWebhook = getTOMLVal(confTOML.CaptchaWebhook, "")
+	conf.CaptchaWebhookToken = getTOMLVal(confTOML.CaptchaWebhookToken, "")
+	conf.CaptchaOCRModel = getTOMLVal(confTOML.CaptchaOCRModel, "")
 	conf.BindInterface = getTOMLVal(confTOML.BindInterface, "")
 	conf.AutoDetectInterface = getTOMLVal(confTOML.AutoDetectInterface, false)
+	conf.AdminBind = getTOMLVal(confTOML.AdminBind, "")
+	conf.AdminToken = getTOMLVal(confTOML.AdminToken, "")
 	conf.AuthType = getTOMLVal(confTOML.AuthType, "")
 	conf.Phone = getTOMLVal(confTOML.Phone, "")
 	conf.LoginDomain = getTOMLVal(confTOML.LoginDomain, "Radius")
 	conf.ClientDataFile = getTOMLVal(confTOML.ClientDataFile, "")
+	conf.ClientDataKey = getTOMLVal(confTOML.ClientDataKey, "")
+	conf.ClientDataKeyFile = getTOMLVal(confTOML.ClientDataKeyFile, "")
+	conf.ClientDataKeyCommand = getTOMLVal(confTOML.ClientDataKeyCommand, "")
 	conf.CasTicket = getTOMLVal(confTOML.CasTicket, "")
+	conf.BrowserLogin = getTOMLVal(confTOML.BrowserLogin, false)
 	conf.OAuth2Code = getTOMLVal(confTOML.OAuth2Code, "")
 	conf.SID = getTOMLVal(confTOML.SID, "")
 	conf.DeviceID = getTOMLVal(confTOML.DeviceID, "")
 	conf.SignKey = getTOMLVal(confTOML.SignKey, "")
 	conf.ResourceFile = getTOMLVal(confTOML.ResourceFile, "")
 	conf.UpdateBestNodesInterval = getTOMLVal(confTOML.UpdateBestNodesInterval, 300)
+	conf.TCPTunnelPoolSize = getTOMLVal(confTOML.TCPTunnelPoolSize, 4)
+	conf.TCPTunnelPoolPrewarm = getTOMLVal(confTOML.TCPTunnelPoolPrewarm, 1)
+	conf.TCPTunnelPoolIdle = getTOMLVal(confTOML.TCPTunnelPoolIdle, 30)
+	conf.TCPTunnelUDP = getTOMLVal(confTOML.TCPTunnelUDP, false)
+	conf.L3TunnelConns = getTOMLVal(confTOML.L3TunnelConns, 1)
+	conf.L3TunnelMaxConns = getTOMLVal(confTOML.L3TunnelMaxConns, 0)
 
 	for _, singlePortForwarding := range confTOML.PortForwarding {
 		if singlePortForwarding.NetworkType == nil {
@@ -119,15 +307,20 @@ func parseTOMLConfig(configFile string, conf *configs.Config) error {
 			return errors.New("ZJU Connect: host name is not set")
 		}
 
-		if singleCustomDns.IP == nil {
+		customDNS := configs.SingleCustomDNS{
+			HostName: *singleCustomDns.HostName,
+			IPs:      singleCustomDns.IPs,
+			CNAME:    getTOMLVal(singleCustomDns.CNAME, ""),
+		}
+		if singleCustomDns.IP != nil {
+			customDNS.IPs = append([]string{*singleCustomDns.IP}, customDNS.IPs...)
+		}
+		if len(customDNS.IPs) == 0 && customDNS.CNAME == "" {
 			fmt.Println("ZJU Connect: IP is not set")
 			return errors.New("ZJU Connect: IP is not set")
 		}
 
-		conf.CustomDNSList = append(conf.CustomDNSList, configs.SingleCustomDNS{
-			HostName: *singleCustomDns.HostName,
-			IP:       *singleCustomDns.IP,
-		})
+		conf.CustomDNSList = append(conf.CustomDNSList, customDNS)
 	}
 
 	for _, singleCustomProxyDomain := range confTOML.CustomProxyDomain {
@@ -143,71 +336,132 @@ func parseTOMLConfig(configFile string, conf *configs.Config) error {
 }
 
 func init() {
-	configFile, tcpPortForwarding, udpPortForwarding, customDns, customProxyDomain := "", "", "", "", ""
+	configFile, tcpPortForwarding, udpPortForwarding, customDns, customProxyDomain, dnsStrictDomain := "", "", "", "", "", ""
 	showVersion := false
 	atrustAuthInfo := false
-	atrustTrustDevice := false
-	atrustUntrustDevice := false
 
 	flag.StringVar(&conf.Protocol, "protocol", "easyconnect", "Protocol (easyconnect, atrust)")
 	flag.StringVar(&conf.ServerAddress, "server", "rvpn.zju.edu.cn", "EasyConnect/aTrust server address")
 	flag.IntVar(&conf.ServerPort, "port", 443, "EasyConnect/aTrust port address")
 	flag.StringVar(&conf.Username, "username", "", "Your username")
 	flag.StringVar(&conf.Password, "password", "", "Your password")
+	flag.StringVar(&conf.PasswordFile, "password-file", "", "File whose first line is your password")
+	flag.StringVar(&conf.PasswordCommand, "password-command", "", "Command whose first output line is your password (e.g. pass show zju)")
+	flag.BoolVar(&conf.Keyring, "keyring", false, "Look up missing credentials in the Secret Service keyring (Linux)")
 	flag.StringVar(&conf.TOTPSecret, "totp-secret", "", "TOTP secret")
-	flag.StringVar(&conf.CertFile, "cert-file", "", "Client certificate p12 file path for certificate login")
+	flag.StringVar(&conf.TOTPSecretFile, "totp-secret-file", "", "File whose first line is the TOTP secret")
+	flag.StringVar(&conf.TOTPSecretCommand, "totp-secret-command", "", "Command whose first output line is the TOTP secret")
+	flag.StringVar(&conf.CertFile, "cert-file", "", "Client certificate PKCS#12 or PEM file path for certificate login")
+	flag.StringVar(&conf.CertKeyFile, "cert-key-file", "", "Private key PEM file of the client certificate, if not in cert-file")
 	flag.StringVar(&conf.CertPassword, "cert-password", "", "Client certificate password")
+	flag.StringVar(&conf.CertPasswordFile, "cert-password-file", "", "File whose first line is the client certificate password")
+	flag.StringVar(&conf.CertPasswordCommand, "cert-password-command", "", "Command whose first output line is the client certificate password")
 	flag.BoolVar(&conf.DisableServerConfig, "disable-server-config", false, "Don't parse server config")
 	flag.BoolVar(&conf.SkipDomainResource, "skip-domain-resource", false, "Don't use server domain resource to decide whether to use RVPN.")
 	flag.BoolVar(&conf.DisableZJUConfig, "disable-zju-config", false, "Don't use ZJU config (for easyconnect protocol only)")
 	flag.BoolVar(&conf.DisableRemoteDNS, "disable-zju-dns", false, "Use local DNS instead of remote DNS") // TODO: rename to disable-remote-dns
 	flag.BoolVar(&conf.DisableMultiLine, "disable-multi-line", false, "Disable multi line auto select")
 	flag.BoolVar(&conf.ProxyAll, "proxy-all", false, "Proxy all IPv4 traffic")
+	flag.IntVar(&conf.SendConns, "send-conns", 1, "Parallel EasyConnect send streams, as many as the server accepts")
+	flag.BoolVar(&conf.BatchWrites, "batch-writes", false, "Write queued EasyConnect packets together in one TLS record")
 	flag.StringVar(&conf.SocksBind, "socks-bind", ":1080", "The address SOCKS5 server listens on (e.g. 127.0.0.1:1080)")
 	flag.StringVar(&conf.SocksUser, "socks-user", "", "SOCKS5 username, default is don't use auth")
 	flag.StringVar(&conf.SocksPasswd, "socks-passwd", "", "SOCKS5 password, default is don't use auth")
+	flag.BoolVar(&conf.SocksPortProbe, "socks-probe-closed-port", false, "Ping the host when a SOCKS5 TCP connection fails, and reply connection refused if it answers (not in TCP tunnel and hybrid mode)")
 	flag.StringVar(&conf.HTTPBind, "http-bind", ":1081", "The address HTTP server listens on (e.g. 127.0.0.1:1081)")
 	flag.StringVar(&conf.ShadowsocksURL, "shadowsocks-url", "", "The address Shadowsocks server listens on (e.g. ss://method:password@host:port)")
 	flag.StringVar(&conf.DialDirectProxy, "dial-direct-proxy", "", "Dial with proxy when the connection doesn't match RVPN rules (e.g. http://127.0.0.1:7890)")
 	flag.BoolVar(&conf.TCPTunnelMode, "tcp-tunnel-mode", false, "Use TCP tunnel only and disable L3 tunnel, only works with atrust protocol")
+	flag.BoolVar(&conf.HybridMode, "hybrid-mode", false, "Use TCP tunnel for TCP resources and start L3 tunnel only when UDP or L3-preferred resources need it, only works with atrust protocol")
 	flag.BoolVar(&conf.TUNMode, "tun-mode", false, "Enable TUN mode (experimental)")
 	flag.BoolVar(&conf.AddRoute, "add-route", false, "Add route from rules for TUN interface")
+	flag.IntVar(&conf.TUNRouteTable, "tun-route-table", 0, "Routing table for the routes of the TUN interface, looked up for packets without tun-fwmark (Linux). Set to 0 to use the main table")
+	flag.IntVar(&conf.TUNFwmark, "tun-fwmark", 0, "Firewall mark of the VPN underlay connections, which skip tun-route-table (Linux). Set to 0 to use the table number")
+	flag.StringVar(&conf.TUNDNSConfig, "tun-dns-config", "resolved", "How to make the system use the DNS server of the TUN interface for campus domains (Linux): resolved (systemd-resolved), resolv.conf (rewrite /etc/resolv.conf) or off")
+	flag.IntVar(&conf.MTU, "mtu", 1400, "MTU of the userspace stack and the TUN interface, TCP MSS follows from it")
+	flag.StringVar(&conf.TCPCongestion, "tcp-congestion-control", "cubic", "TCP congestion control of the userspace stack (cubic, reno)")
+	flag.IntVar(&conf.TCPSendBuffer, "tcp-send-buffer", 0, "Initial TCP send buffer size in bytes. Set to 0 to use the default 1 MiB")
+	flag.IntVar(&conf.TCPSendBufferMax, "tcp-send-buffer-max", 0, "Maximum TCP send buffer size in bytes. Set to 0 to use the default 4 MiB")
+	flag.IntVar(&conf.TCPReceiveBuffer, "tcp-receive-buffer", 0, "Initial TCP receive buffer size in bytes. Set to 0 to use the default 1 MiB")
+	flag.IntVar(&conf.TCPReceiveBufferMax, "tcp-receive-buffer-max", 0, "Maximum TCP receive buffer size in bytes. Set to 0 to use the default 4 MiB")
+	flag.BoolVar(&conf.TCPBufferAutoTune, "tcp-buffer-auto-tune", false, "Grow TCP receive buffers up to the maximum as the connection needs")
+	flag.IntVar(&conf.TCPKeepAlive, "tcp-keep-alive", 0, "Send TCP keepalive probes on tunneled connections idle for this many seconds. Set to 0 to keep the default")
+	flag.IntVar(&conf.TCPKeepAliveIntvl, "tcp-keep-alive-interval", 15, "Seconds between TCP keepalive probes")
+	flag.IntVar(&conf.TCPIdleTimeout, "tcp-idle-timeout", 0, "Close tunneled TCP connections idle for this many seconds. Set to 0 to disable")
+	flag.IntVar(&conf.TCPMaxLifetime, "tcp-max-lifetime", 0, "Close tunneled TCP connections open for this many seconds. Set to 0 to disable")
+	flag.IntVar(&conf.UDPIdleTimeout, "udp-idle-timeout", 0, "Close tunneled UDP flows idle for this many seconds. Set to 0 to disable")
 	flag.Uint64Var(&conf.DNSTTL, "dns-ttl", 3600, "DNS record time to live, unit is second")
 	flag.BoolVar(&conf.DebugDump, "debug-dump", false, "Enable traffic debug dump (only for debug usage)")
 	flag.StringVar(&conf.DebugPCAPFile, "debug-pcap-file", "", "Save reconstructed VPN underlay TCP traffic to a PCAP file (debug only)")
 	flag.StringVar(&conf.DebugTLSLogFile, "debug-tls-log-file", "", "Save TLS session secrets in NSS key log format (debug only)")
 	flag.BoolVar(&conf.DisableKeepAlive, "disable-keep-alive", false, "Disable keep alive")
 	flag.StringVar(&conf.KeepAliveURL, "keep-alive-url", "", "Keep alive URL, default is empty (use DNS keep alive)")
+	flag.BoolVar(&conf.LogoutOnExit, "logout-on-exit", false, "Log out of the VPN session on the server when exiting, the session saved in the client data file can not be reused afterwards")
 	flag.StringVar(&conf.RemoteDNSServer, "zju-dns-server", "auto", "Remote DNS server address. Set to 'auto' to use remote DNS server provided by server") // TODO: rename to remote-dns-server
 	flag.StringVar(&conf.SecondaryDNSServer, "secondary-dns-server", "auto", "Secondary DNS server address. Use auto for the server policy value")
 	flag.StringVar(&conf.DNSServerBind, "dns-server-bind", "", "The address DNS server listens on (e.g. 127.0.0.1:53)")
+	flag.BoolVar(&conf.DNSServerFakeIP, "dns-server-fake-ip", false, "Answer resource domains with Fake IPs on the DNS server, which are mapped back by SOCKS5/HTTP/Shadowsocks servers")
+	flag.BoolVar(&conf.DNSQueryLog, "dns-query-log", false, "Record DNS queries and per-name/per-client statistics, shown on the admin API")
+	flag.StringVar(&conf.DNSQueryLogFile, "dns-query-log-file", "", "Also write every DNS query to this file as a JSON line")
+	flag.BoolVar(&conf.DNSStrict, "dns-strict", false, "Never resolve resource or intranet domains with secondary DNS, answer SERVFAIL instead. Forces DNS hijack in TUN mode")
+	flag.StringVar(&dnsStrictDomain, "dns-strict-domain", "", "Intranet domain suffixes protected by DNS strict mode besides domain resources (e.g. zju.edu.cn,cc98.org)")
 	flag.StringVar(&conf.LocalDNSServer, "local-dns-server", "", "DNS server used to resolve the VPN server hostname (IP or IP:port)")
 	flag.BoolVar(&conf.DNSHijack, "dns-hijack", false, "Hijack all dns query to ZJU Connect. False by default.")
 	flag.BoolVar(&conf.FakeIP, "fake-ip", false, "Enable Fake IP for DNS hijack")
+	flag.StringVar(&conf.FakeIPRange, "fake-ip-range", "198.18.0.0/16", "IPv4 CIDR which Fake IPs are allocated from")
+	flag.StringVar(&conf.FakeIPRange6, "fake-ip-range6", "", "IPv6 CIDR which Fake IPs are allocated from (e.g. fdfe:dcba:9876::/64). Empty to disable")
+	flag.IntVar(&conf.FakeIPIdleTimeout, "fake-ip-idle-timeout", 300, "Minimum idle time in seconds before a Fake IP can be recycled for another domain")
+	flag.StringVar(&conf.FakeIPFile, "fake-ip-file", "", "File to persist Fake IP mappings across restarts")
+	flag.StringVar(&conf.HostsFile, "hosts-file", "", "Hosts file with extra custom DNS entries, reloaded when changed")
 	flag.StringVar(&conf.GraphCodeFile, "graph-code-file", "", "Graph Check Code File")
+	flag.StringVar(&conf.Prompt, "prompt", "terminal", "Where interactive login steps (SMS code, TOTP, CAS callback...) ask for input: terminal, http, command, file or admin")
+	flag.StringVar(&conf.PromptBind, "prompt-bind", "", "The address the http prompt form listens on, default is a random loopback port")
+	flag.StringVar(&conf.PromptCommand, "prompt-command", "", "Command run by the command prompt, its first output line is the answer")
+	flag.StringVar(&conf.PromptFile, "prompt-file", "", "Named pipe or file the file prompt reads answers from")
+	flag.StringVar(&conf.SMSInboxBind, "sms-inbox-bind", "", "Listening address of the SMS inbox SMS forwarders post verification codes to")
+	flag.StringVar(&conf.SMSInboxToken, "sms-inbox-token", "", "Token SMS forwarders must send to the SMS inbox")
+	flag.IntVar(&conf.SMSInboxTimeout, "sms-inbox-timeout", 120, "Seconds to wait for the SMS inbox before asking for the SMS code")
+	flag.StringVar(&conf.CaptchaSolver, "captcha-solver", "manual", "How captchas are solved: manual, command, webhook or ocr")
+	flag.StringVar(&conf.CaptchaCommand, "captcha-command", "", "Command solving captchas, the image is its stdin and its first output line the answer")
+	flag.StringVar(&conf.CaptchaWebhook, "captcha-webhook", "", "URL the captcha image is posted to for solving")
+	flag.StringVar(&conf.CaptchaWebhookToken, "captcha-webhook-token", "", "Bearer token sent to the captcha webhook")
+	flag.StringVar(&conf.CaptchaOCRModel, "captcha-ocr-model", "", "OCR model file for solving text captchas")
 	flag.StringVar(&conf.BindInterface, "bind-interface", "", "Bind VPN underlay connections to this network interface (takes precedence over auto detection)")
 	flag.BoolVar(&conf.AutoDetectInterface, "auto-detect-interface", false, "Automatically detect and bind the VPN underlay interface")
+	flag.StringVar(&conf.AdminBind, "admin-bind", "", "The address admin HTTP API listens on (e.g. 127.0.0.1:1082)")
+	flag.StringVar(&conf.AdminToken, "admin-token", "", "Bearer token required by admin HTTP API, default is don't use auth")
 	flag.StringVar(&conf.TwfID, "twf-id", "", "Login using twfID captured (mostly for debug usage)")
-	flag.StringVar(&conf.AuthType, "auth-type", "", "aTrust authentication type (auth/psw, auth/cas, auth/httpsOauth2, auth/smsCheckCode)")
+	flag.StringVar(&conf.AuthType, "auth-type", "", "aTrust authentication type (auth/psw, auth/cas, auth/httpsOauth2, auth/smsCheckCode, auth/qrcode, auth/cert)")
 	flag.StringVar(&conf.Phone, "phone", "", "Phone number with country code for aTrust SMS check code login (e.g. 852-114514)")
 	flag.StringVar(&conf.LoginDomain, "login-domain", "Radius", "aTrust login domain")
 	flag.StringVar(&conf.ClientDataFile, "client-data-file", "", "aTrust Client Data File")
+	flag.StringVar(&conf.ClientDataKey, "client-data-key", "", "Passphrase encrypting the client data file")
+	flag.StringVar(&conf.ClientDataKeyFile, "client-data-key-file", "", "File whose first line is the passphrase encrypting the client data file")
+	flag.StringVar(&conf.ClientDataKeyCommand, "client-data-key-command", "", "Command whose first output line is the passphrase encrypting the client data file")
 	flag.StringVar(&conf.CasTicket, "cas-ticket", "", "aTrust CAS Ticket (optional, interactive mode if not set)")
+	flag.BoolVar(&conf.BrowserLogin, "browser-login", false, "Open the browser for aTrust CAS/OAuth2 login and capture the callback automatically")
 	flag.StringVar(&conf.OAuth2Code, "oauth2-code", "", "aTrust OAuth2 code (optional, interactive mode if not set)")
 	flag.StringVar(&conf.SID, "sid", "", "aTrust SID (mostly for debug usage)")
 	flag.StringVar(&conf.DeviceID, "device-id", "", "aTrust Device ID (mostly for debug usage)")
 	flag.StringVar(&conf.SignKey, "sign-key", "", "aTrust Sign Key (mostly for debug usage)")
 	flag.StringVar(&conf.ResourceFile, "resource-file", "", "aTrust Resource File (mostly for debug usage)")
 	flag.IntVar(&conf.UpdateBestNodesInterval, "update-best-nodes-interval", 300, "Interval to update best nodes in seconds. Set to 0 to disable")
+	flag.IntVar(&conf.TCPTunnelPoolSize, "tcp-tunnel-pool-size", 4, "Idle TLS connections kept per aTrust node for the TCP tunnel. Set to 0 to disable")
+	flag.IntVar(&conf.TCPTunnelPoolPrewarm, "tcp-tunnel-pool-prewarm", 1, "TLS connections opened ahead of time per aTrust node for the TCP tunnel")
+	flag.IntVar(&conf.TCPTunnelPoolIdle, "tcp-tunnel-pool-idle-timeout", 30, "Close idle TCP tunnel TLS connections after this many seconds")
+	flag.BoolVar(&conf.TCPTunnelUDP, "tcp-tunnel-udp", false, "Relay UDP other than DNS through the aTrust TCP tunnel (experimental)")
+	flag.IntVar(&conf.L3TunnelConns, "l3-tunnel-conns", 1, "aTrust L3 tunnel connections per node group")
+	flag.IntVar(&conf.L3TunnelMaxConns, "l3-tunnel-max-conns", 0, "Maximum aTrust L3 tunnel connections per node group, opened while writes queue up. Set to 0 to use l3-tunnel-conns")
 	flag.StringVar(&tcpPortForwarding, "tcp-port-forwarding", "", "TCP port forwarding (e.g. 0.0.0.0:9898-10.10.98.98:80,127.0.0.1:9899-10.10.98.98:80)")
 	flag.StringVar(&udpPortForwarding, "udp-port-forwarding", "", "UDP port forwarding (e.g. 127.0.0.1:53-10.10.0.21:53)")
-	flag.StringVar(&customDns, "custom-dns", "", "Custom set dns lookup (e.g. www.cc98.org:10.10.98.98,appservice.zju.edu.cn:10.203.8.198)")
+	flag.StringVar(&customDns, "custom-dns", "", "Custom set dns lookup, supports *. wildcards, repeated host names and aliases (e.g. www.cc98.org:10.10.98.98,*.lab.zju.edu.cn:10.203.8.198,git.example:cname=git.zju.edu.cn)")
 	flag.StringVar(&customProxyDomain, "custom-proxy-domain", "", "Custom set domains which force use RVPN proxy  (e.g. science.org, nature.com)")
 	flag.StringVar(&configFile, "config", "", "Config file")
 	flag.BoolVar(&showVersion, "version", false, "Show version")
 	flag.BoolVar(&atrustAuthInfo, "auth-info", false, "Fetch aTrust authentication information, but not login")
 	flag.BoolVar(&atrustTrustDevice, "trust-device", false, "Trust the current device for aTrust with client data, but not connect")
 	flag.BoolVar(&atrustUntrustDevice, "untrust-device", false, "Untrust the current device for aTrust with client data, but not connect")
+	flag.BoolVar(&clientDataMigrate, "client-data-migrate", false, "Accept a plaintext client data file once and encrypt it with the client data key")
 
 	flag.Parse()
 
@@ -216,6 +470,22 @@ func init() {
 		os.Exit(0)
 	}
 
+	if flag.Arg(0) == "ping" {
+		pingFlags := flag.NewFlagSet("ping", flag.ExitOnError)
+		pingFlags.Usage = func() {
+			fmt.Fprintln(pingFlags.Output(), "Usage: zju-connect [options] ping [-c count] [-W timeout] host")
+			pingFlags.PrintDefaults()
+		}
+		pingFlags.IntVar(&pingCount, "c", service.DefaultPingCount, "Number of echo requests to send")
+		pingFlags.DurationVar(&pingTimeout, "W", service.DefaultPingTimeout, "Time to wait for each reply")
+		_ = pingFlags.Parse(flag.Args()[1:])
+		if pingFlags.NArg() != 1 || pingCount <= 0 || pingTimeout <= 0 {
+			pingFlags.Usage()
+			os.Exit(2)
+		}
+		pingHost = pingFlags.Arg(0)
+	}
+
 	if atrustAuthInfo {
 		if conf.Protocol != "atrust" {
 			fmt.Fprintln(os.Stderr, "Auth info is only supported by the atrust protocol")
@@ -236,34 +506,6 @@ func init() {
 		os.Exit(0)
 	}
 
-	if atrustTrustDevice || atrustUntrustDevice {
-		if conf.Protocol != "atrust" {
-			fmt.Fprintln(os.Stderr, "Trust/Untrust device is only supported by the atrust protocol")
-			os.Exit(1)
-		}
-		if conf.ClientDataFile == "" {
-			fmt.Fprintln(os.Stderr, "Client data file is required for trust/untrust device")
-			os.Exit(1)
-		}
-		clientData, err := os.ReadFile(conf.ClientDataFile)
-		if err != nil {
-			log.Printf("Read client data file error: %s", err)
-			os.Exit(1)
-		}
-
-		err = atrust.SetTrusted(conf.ServerAddress, conf.ServerPort, clientData, atrustTrustDevice, conf.BindInterface, conf.AutoDetectInterface, conf.LocalDNSServer, conf.DebugTLSLogFile)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "Trust/Untrust device error:", err)
-			os.Exit(1)
-		}
-		if atrustTrustDevice {
-			log.Println("Device trusted successfully")
-		} else {
-			log.Println("Device untrusted successfully")
-		}
-		os.Exit(0)
-	}
-
 	if configFile != "" {
 		err := parseTOMLConfig(configFile, &conf)
 		if err != nil {
@@ -308,16 +550,32 @@ func init() {
 		if customDns != "" {
 			dnsList := strings.Split(customDns, ",")
 			for _, dnsString := range dnsList {
-				dnsStringSplit := strings.Split(dnsString, ":")
-				if len(dnsStringSplit) != 2 {
+				hostName, target, found := strings.Cut(dnsString, ":")
+				if !found || hostName == "" || target == "" {
 					fmt.Fprintln(os.Stderr, "ZJU Connect: wrong custom dns format")
 					os.Exit(1)
 				}
 
-				conf.CustomDNSList = append(conf.CustomDNSList, configs.SingleCustomDNS{
-					HostName: dnsStringSplit[0],
-					IP:       dnsStringSplit[1],
-				})
+				// An alias is marked, so that a mistyped IP is not taken
+				// for one.
+				customDNS := configs.SingleCustomDNS{HostName: hostName}
+				if alias, isAlias := strings.CutPrefix(target, "cname="); isAlias && alias != "" {
+					customDNS.CNAME = alias
+				} else if net.ParseIP(target) != nil {
+					customDNS.IPs = []string{target}
+				} else {
+					fmt.Fprintf(os.Stderr, "ZJU Connect: custom dns %s is not an IP, write %s:cname=%s for an alias\n", target, hostName, target)
+					os.Exit(1)
+				}
+				conf.CustomDNSList = append(conf.CustomDNSList, customDNS)
+			}
+		}
+
+		if dnsStrictDomain != "" {
+			for _, domain := range strings.Split(dnsStrictDomain, ",") {
+				if domain = strings.TrimSpace(domain); domain != "" {
+					conf.DNSStrictDomain = append(conf.DNSStrictDomain, domain)
+				}
 			}
 		}
 
@@ -334,6 +592,69 @@ func init() {
 		}
 	}
 
+	if conf.DNSStrict && conf.TUNMode && !conf.DNSHijack {
+		fmt.Println("ZJU Connect: DNS strict mode enables DNS hijack in TUN mode")
+		conf.DNSHijack = true
+	}
+
+	if conf.Protocol == "atrust" && conf.ServerAddress == "rvpn.zju.edu.cn" {
+		fmt.Println("ZJU Connect: set default aTrust server address to vpn.zju.edu.cn")
+		conf.ServerAddress = "vpn.zju.edu.cn"
+	} else if conf.Protocol == "easyconnect" && conf.ServerAddress == "vpn.zju.edu.cn" {
+		fmt.Println("ZJU Connect: set default EasyConnect server address to rvpn.zju.edu.cn")
+		conf.ServerAddress = "rvpn.zju.edu.cn"
+	}
+}
+
+// setTrustedDevice trusts or untrusts the device of the client data file and
+// exits.
+func setTrustedDevice() {
+	if conf.Protocol != "atrust" {
+		fmt.Fprintln(os.Stderr, "Trust/Untrust device is only supported by the atrust protocol")
+		os.Exit(1)
+	}
+	if conf.ClientDataFile == "" {
+		fmt.Fprintln(os.Stderr, "Client data file is required for trust/untrust device")
+		os.Exit(1)
+	}
+	resolver := newCredentialResolver(conf)
+	clientDataKey, err := resolver.ClientDataKey.Resolve(context.Background(), resolver.Keyring, conf.Username)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ZJU Connect: resolve client data key error:", err)
+		os.Exit(1)
+	}
+	clientData, _, err := securefile.ReadFile(conf.ClientDataFile, clientDataKey, clientDataMigrate)
+	if err != nil {
+		log.Printf("Read client data file error: %s", err)
+		os.Exit(1)
+	}
+
+	err = atrust.SetTrusted(conf.ServerAddress, conf.ServerPort, clientData, atrustTrustDevice, conf.BindInterface, conf.AutoDetectInterface, conf.LocalDNSServer, conf.DebugTLSLogFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Trust/Untrust device error:", err)
+		os.Exit(1)
+	}
+	if atrustTrustDevice {
+		log.Println("Device trusted successfully")
+	} else {
+		log.Println("Device untrusted successfully")
+	}
+	os.Exit(0)
+}
+
+// resolveCredentials looks up the credentials that are not in the
+// configuration itself, and exits if required ones are missing.
+func resolveCredentials() {
+	credentialResolver = newCredentialResolver(conf)
+	creds, err := credentialResolver.Resolve(context.Background())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ZJU Connect: resolve credentials error:", err)
+		os.Exit(1)
+	}
+	conf.Username, conf.Password = creds.Username, creds.Password
+	conf.TOTPSecret, conf.CertPassword = creds.TOTPSecret, creds.CertPassword
+	conf.ClientDataKey = creds.ClientDataKey
+
 	missing := conf.ServerAddress == ""
 	if !missing && conf.Protocol == "easyconnect" {
 		missing = (conf.Username == "" || conf.Password == "") && conf.TwfID == ""
@@ -344,6 +665,8 @@ func init() {
 			missing = conf.Username == "" || conf.Password == ""
 		case "auth/smsCheckCode":
 			missing = conf.Phone == ""
+		case "auth/cert":
+			missing = conf.CertFile == ""
 		}
 		if missing {
 			missing = conf.SID == "" || conf.DeviceID == "" || conf.ResourceFile == ""
@@ -357,12 +680,4 @@ func init() {
 
 		os.Exit(1)
 	}
-
-	if conf.Protocol == "atrust" && conf.ServerAddress == "rvpn.zju.edu.cn" {
-		fmt.Println("ZJU Connect: set default aTrust server address to vpn.zju.edu.cn")
-		conf.ServerAddress = "vpn.zju.edu.cn"
-	} else if conf.Protocol == "easyconnect" && conf.ServerAddress == "vpn.zju.edu.cn" {
-		fmt.Println("ZJU Connect: set default EasyConnect server address to rvpn.zju.edu.cn")
-		conf.ServerAddress = "rvpn.zju.edu.cn"
-	}
 }
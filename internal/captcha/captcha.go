@@ -0,0 +1,30 @@
+// Package captcha solves login captchas without user interaction.
+package captcha
+
+import (
+	"context"
+	"errors"
+)
+
+type Kind string
+
+const (
+	// KindText asks for the characters shown in the image, e.g. the
+	// EasyConnect rand code.
+	KindText Kind = "text"
+	// KindClick asks for the positions to click in order, e.g. the aTrust
+	// graph check code. The answer is JSON coordinates in one of the forms
+	// accepted for manual entry.
+	KindClick Kind = "click"
+)
+
+var ErrUnsupported = errors.New("captcha kind not supported by solver")
+
+type Challenge struct {
+	Kind  Kind
+	Image []byte
+}
+
+type Solver interface {
+	Solve(ctx context.Context, challenge Challenge) (string, error)
+}
@@ -0,0 +1,178 @@
+package captcha
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/mythologyli/zju-connect/internal/extcmd"
+)
+
+var testGlyphs = []OCRGlyph{
+	{Label: "1", Bitmap: []string{
+		"..#.",
+		".##.",
+		"..#.",
+		"..#.",
+		".###",
+	}},
+	{Label: "7", Bitmap: []string{
+		"####",
+		"...#",
+		"..#.",
+		".#..",
+		".#..",
+	}},
+	{Label: "L", Bitmap: []string{
+		"#...",
+		"#...",
+		"#...",
+		"#...",
+		"####",
+	}},
+}
+
+// renderGlyphs draws the labels scaled by scale with blank gaps in between.
+func renderGlyphs(t *testing.T, labels string, scale int) []byte {
+	t.Helper()
+	byLabel := map[rune]OCRGlyph{}
+	for _, glyph := range testGlyphs {
+		byLabel[rune(glyph.Label[0])] = glyph
+	}
+	width := len(labels)*(4*scale+3) + 3
+	img := image.NewGray(image.Rect(0, 0, width, 5*scale+6))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	for i, label := range labels {
+		glyph := byLabel[label]
+		offsetX := 3 + i*(4*scale+3)
+		for y, row := range glyph.Bitmap {
+			for x, c := range row {
+				if c != '#' {
+					continue
+				}
+				for dy := 0; dy < scale; dy++ {
+					for dx := 0; dx < scale; dx++ {
+						img.SetGray(offsetX+x*scale+dx, 3+y*scale+dy, color.Gray{Y: 0x20})
+					}
+				}
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func newTestModel(t *testing.T, length int) *OCRModel {
+	t.Helper()
+	model := &OCRModel{Width: 4, Height: 5, Length: length, Glyphs: append([]OCRGlyph(nil), testGlyphs...)}
+	if err := model.init(); err != nil {
+		t.Fatal(err)
+	}
+	return model
+}
+
+func TestOCRModelRecognizesScaledText(t *testing.T) {
+	model := newTestModel(t, 4)
+	for _, scale := range []int{1, 3} {
+		answer, err := model.Solve(context.Background(), Challenge{Kind: KindText, Image: renderGlyphs(t, "71L1", scale)})
+		if err != nil || answer != "71L1" {
+			t.Fatalf("scale %d: Solve() = %q, %v", scale, answer, err)
+		}
+	}
+}
+
+func TestOCRModelRejectsWrongLength(t *testing.T) {
+	model := newTestModel(t, 4)
+	if _, err := model.Solve(context.Background(), Challenge{Kind: KindText, Image: renderGlyphs(t, "71", 2)}); err == nil {
+		t.Fatal("Solve() accepted an answer of the wrong length")
+	}
+	if _, err := model.Solve(context.Background(), Challenge{Kind: KindClick}); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("Solve() error = %v, want ErrUnsupported", err)
+	}
+}
+
+func TestLoadOCRModel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "model.json")
+	err := os.WriteFile(path, []byte(`{"width": 2, "height": 1, "glyphs": [{"label": "a", "bitmap": ["#."]}]}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	model, err := LoadOCRModel(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if model.Threshold != 128 || model.MaxDistance != 0.3 {
+		t.Fatalf("defaults not applied: %+v", model)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"width": 2, "height": 1, "glyphs": [{"label": "a", "bitmap": ["#.#"]}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadOCRModel(path); err == nil {
+		t.Fatal("LoadOCRModel() accepted a glyph of the wrong size")
+	}
+}
+
+func TestCommandReadsImageFromStdin(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	command := &Command{cmd: extcmd.Command{Name: "sh", Args: []string{"-c", `printf '%s-%s\nignored\n' "$ZJU_CONNECT_CAPTCHA_KIND" "$(cat)"`}}}
+	answer, err := command.Solve(context.Background(), Challenge{Kind: KindText, Image: []byte("abcd")})
+	if err != nil || answer != "text-abcd" {
+		t.Fatalf("Solve() = %q, %v", answer, err)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		image, _ := io.ReadAll(r.Body)
+		switch r.Header.Get("X-Captcha-Kind") {
+		case string(KindText):
+			_, _ = w.Write([]byte(`{"answer": "` + string(image) + `"}`))
+		case string(KindClick):
+			_, _ = w.Write([]byte(`{"answer": [[1, 2], [3, 4]]}`))
+		default:
+			_, _ = w.Write([]byte("plain\n"))
+		}
+	}))
+	defer server.Close()
+
+	webhook := NewWebhook(server.URL, "secret")
+	for _, tt := range []struct {
+		kind Kind
+		want string
+	}{
+		{KindText, "xyz"},
+		{KindClick, "[[1, 2], [3, 4]]"},
+		{"other", "plain"},
+	} {
+		answer, err := webhook.Solve(context.Background(), Challenge{Kind: tt.kind, Image: []byte("xyz")})
+		if err != nil || answer != tt.want {
+			t.Errorf("%s: Solve() = %q, %v, want %q", tt.kind, answer, err, tt.want)
+		}
+	}
+
+	if _, err := NewWebhook(server.URL, "wrong").Solve(context.Background(), Challenge{Kind: KindText}); err == nil {
+		t.Fatal("Solve() ignored the error status")
+	}
+}
@@ -0,0 +1,34 @@
+package captcha
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/mythologyli/zju-connect/internal/extcmd"
+)
+
+// Command runs an external program for each captcha. The image is written to
+// its standard input and the kind is passed as ZJU_CONNECT_CAPTCHA_KIND; the
+// first line of its standard output is the answer.
+type Command struct {
+	cmd extcmd.Command
+}
+
+// NewCommand returns a Command running commandLine, see extcmd.Parse.
+func NewCommand(commandLine string) (*Command, error) {
+	cmd, err := extcmd.Parse(commandLine)
+	if err != nil {
+		return nil, fmt.Errorf("captcha command: %w", err)
+	}
+	return &Command{cmd: cmd}, nil
+}
+
+func (c *Command) Solve(ctx context.Context, challenge Challenge) (string, error) {
+	env := []string{"ZJU_CONNECT_CAPTCHA_KIND=" + string(challenge.Kind)}
+	answer, err := c.cmd.Run(ctx, env, bytes.NewReader(challenge.Image))
+	if err != nil {
+		return "", fmt.Errorf("captcha command failed: %w", err)
+	}
+	return answer, nil
+}
@@ -0,0 +1,202 @@
+package captcha
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"strings"
+)
+
+// OCRModel recognizes simple text captchas by matching each character against
+// glyph templates. The image is binarized, split into characters at blank
+// columns, and every character is scaled to the template size and compared
+// pixel by pixel.
+//
+// The model is a JSON file:
+//
+//	{
+//	  "width": 8, "height": 12, "threshold": 128, "length": 4,
+//	  "glyphs": [{"label": "A", "bitmap": ["..##....", ...]}, ...]
+//	}
+//
+// where each bitmap has height rows of width characters, '#' for ink. Several
+// glyphs may share a label. Pixels darker than threshold are ink. When length
+// is set, answers of another length are rejected.
+type OCRModel struct {
+	Width     int        `json:"width"`
+	Height    int        `json:"height"`
+	Threshold uint8      `json:"threshold"`
+	Length    int        `json:"length"`
+	Glyphs    []OCRGlyph `json:"glyphs"`
+	// MaxDistance is the largest share of differing pixels accepted for a
+	// match, default 0.3.
+	MaxDistance float64 `json:"max_distance"`
+}
+
+type OCRGlyph struct {
+	Label  string   `json:"label"`
+	Bitmap []string `json:"bitmap"`
+
+	pixels []bool
+}
+
+// minGlyphWidth drops specks narrower than this many columns.
+const minGlyphWidth = 2
+
+func LoadOCRModel(path string) (*OCRModel, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var model OCRModel
+	if err := json.Unmarshal(data, &model); err != nil {
+		return nil, fmt.Errorf("parse OCR model: %w", err)
+	}
+	if err := model.init(); err != nil {
+		return nil, fmt.Errorf("invalid OCR model: %w", err)
+	}
+	return &model, nil
+}
+
+func (m *OCRModel) init() error {
+	if m.Width <= 0 || m.Height <= 0 {
+		return errors.New("width and height must be positive")
+	}
+	if len(m.Glyphs) == 0 {
+		return errors.New("no glyphs")
+	}
+	if m.Threshold == 0 {
+		m.Threshold = 128
+	}
+	if m.MaxDistance <= 0 {
+		m.MaxDistance = 0.3
+	}
+	for i := range m.Glyphs {
+		glyph := &m.Glyphs[i]
+		if len(glyph.Bitmap) != m.Height {
+			return fmt.Errorf("glyph %q has %d rows, want %d", glyph.Label, len(glyph.Bitmap), m.Height)
+		}
+		glyph.pixels = make([]bool, 0, m.Width*m.Height)
+		for _, row := range glyph.Bitmap {
+			if len(row) != m.Width {
+				return fmt.Errorf("glyph %q has a row of %d columns, want %d", glyph.Label, len(row), m.Width)
+			}
+			for _, c := range row {
+				glyph.pixels = append(glyph.pixels, c == '#')
+			}
+		}
+	}
+	return nil
+}
+
+func (m *OCRModel) Solve(_ context.Context, challenge Challenge) (string, error) {
+	if challenge.Kind != KindText {
+		return "", ErrUnsupported
+	}
+	img, _, err := image.Decode(bytes.NewReader(challenge.Image))
+	if err != nil {
+		return "", fmt.Errorf("decode captcha image: %w", err)
+	}
+	return m.Recognize(img)
+}
+
+// Recognize returns the text in img.
+func (m *OCRModel) Recognize(img image.Image) (string, error) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	ink := make([]bool, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			gray := color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray)
+			ink[y*width+x] = gray.Y < m.Threshold
+		}
+	}
+
+	var text strings.Builder
+	var count int
+	for x := 0; x < width; {
+		if !columnHasInk(ink, width, height, x) {
+			x++
+			continue
+		}
+		start := x
+		for x < width && columnHasInk(ink, width, height, x) {
+			x++
+		}
+		if x-start < minGlyphWidth {
+			continue
+		}
+		label, err := m.match(ink, width, height, start, x)
+		if err != nil {
+			return "", err
+		}
+		text.WriteString(label)
+		count++
+	}
+
+	if count == 0 {
+		return "", errors.New("no characters found in captcha")
+	}
+	if m.Length > 0 && count != m.Length {
+		return "", fmt.Errorf("found %d characters in captcha, want %d", count, m.Length)
+	}
+	return text.String(), nil
+}
+
+func columnHasInk(ink []bool, width, height, x int) bool {
+	for y := 0; y < height; y++ {
+		if ink[y*width+x] {
+			return true
+		}
+	}
+	return false
+}
+
+// match scales the character between columns left and right to the template
+// size and returns the label of the closest glyph.
+func (m *OCRModel) match(ink []bool, width, height, left, right int) (string, error) {
+	top, bottom := height, 0
+	for y := 0; y < height; y++ {
+		for x := left; x < right; x++ {
+			if ink[y*width+x] {
+				top = min(top, y)
+				bottom = max(bottom, y+1)
+				break
+			}
+		}
+	}
+
+	sample := make([]bool, m.Width*m.Height)
+	for y := 0; y < m.Height; y++ {
+		srcY := top + y*(bottom-top)/m.Height
+		for x := 0; x < m.Width; x++ {
+			srcX := left + x*(right-left)/m.Width
+			sample[y*m.Width+x] = ink[srcY*width+srcX]
+		}
+	}
+
+	bestLabel, bestDistance := "", len(sample)+1
+	for _, glyph := range m.Glyphs {
+		distance := 0
+		for i, pixel := range glyph.pixels {
+			if pixel != sample[i] {
+				distance++
+			}
+		}
+		if distance < bestDistance {
+			bestLabel, bestDistance = glyph.Label, distance
+		}
+	}
+	if float64(bestDistance) > m.MaxDistance*float64(len(sample)) {
+		return "", errors.New("unrecognized character in captcha")
+	}
+	return bestLabel, nil
+}
@@ -0,0 +1,66 @@
+package captcha
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Webhook posts the image to an HTTP endpoint. The request carries the kind in
+// the X-Captcha-Kind header and the token, if any, as a bearer token. The
+// response is either {"answer": ...} or the answer as plain text; a non-string
+// JSON answer is used as is, so click solvers may return the coordinates
+// directly.
+type Webhook struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func NewWebhook(url, token string) *Webhook {
+	return &Webhook{url: url, token: token, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func (w *Webhook) Solve(ctx context.Context, challenge Challenge) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(challenge.Image))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", http.DetectContentType(challenge.Image))
+	req.Header.Set("X-Captcha-Kind", string(challenge.Kind))
+	if w.token != "" {
+		req.Header.Set("Authorization", "Bearer "+w.token)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("captcha webhook returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var response struct {
+		Answer json.RawMessage `json:"answer"`
+	}
+	if json.Unmarshal(body, &response) == nil && len(response.Answer) > 0 {
+		var answer string
+		if json.Unmarshal(response.Answer, &answer) == nil {
+			return answer, nil
+		}
+		return string(response.Answer), nil
+	}
+	return strings.TrimSpace(string(body)), nil
+}
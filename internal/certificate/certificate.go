@@ -0,0 +1,192 @@
+// Package certificate loads client certificates for certificate login from
+// PKCS#12 files or PEM files, including encrypted keys and full chains.
+package certificate
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+var (
+	ErrNoCertificate = errors.New("no certificate found")
+	ErrNoPrivateKey  = errors.New("no private key found")
+	ErrKeyMismatch   = errors.New("no certificate matches the private key")
+	ErrIncorrectPass = errors.New("incorrect password")
+)
+
+// Load reads a client certificate. certFile is either a PKCS#12 file or a PEM
+// file with one or more certificates; the private key is taken from keyFile
+// when set, otherwise from a PEM certFile itself. password decrypts the
+// PKCS#12 file or an encrypted PEM key.
+//
+// The returned certificate starts with the certificate of the private key and
+// is followed by the intermediates leading to it that were found.
+func Load(certFile, keyFile, password string) (tls.Certificate, error) {
+	certData, err := os.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("read certificate file: %w", err)
+	}
+
+	var key crypto.PrivateKey
+	var certs []*x509.Certificate
+	if isPEM(certData) {
+		certs, key, err = parsePEM(certData, password)
+	} else {
+		key, certs, err = DecodePKCS12(certData, password)
+	}
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("decode certificate file: %w", err)
+	}
+
+	if keyFile != "" {
+		keyData, err := os.ReadFile(keyFile)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("read key file: %w", err)
+		}
+		moreCerts, fileKey, err := parsePEM(keyData, password)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("decode key file: %w", err)
+		}
+		certs = append(certs, moreCerts...)
+		if fileKey != nil {
+			key = fileKey
+		}
+	}
+
+	return buildChain(key, certs)
+}
+
+func isPEM(data []byte) bool {
+	return bytes.Contains(data, []byte("-----BEGIN "))
+}
+
+// parsePEM returns the certificates and the first private key in data.
+func parsePEM(data []byte, password string) ([]*x509.Certificate, crypto.PrivateKey, error) {
+	var certs []*x509.Certificate
+	var key crypto.PrivateKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, nil, err
+			}
+			certs = append(certs, cert)
+		case "PRIVATE KEY", "ENCRYPTED PRIVATE KEY", "RSA PRIVATE KEY", "EC PRIVATE KEY":
+			if key != nil {
+				continue
+			}
+			var err error
+			key, err = parsePEMKey(block, password)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return certs, key, nil
+}
+
+func parsePEMKey(block *pem.Block, password string) (crypto.PrivateKey, error) {
+	der := block.Bytes
+	if block.Type == "ENCRYPTED PRIVATE KEY" {
+		return decryptPKCS8Key(der, password)
+	}
+	// Keys encrypted the legacy OpenSSL way, with a Proc-Type header.
+	if x509.IsEncryptedPEMBlock(block) {
+		var err error
+		der, err = x509.DecryptPEMBlock(block, []byte(password))
+		if err != nil {
+			return nil, ErrIncorrectPass
+		}
+	}
+	return parsePrivateKey(der)
+}
+
+func parsePrivateKey(der []byte) (crypto.PrivateKey, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("unsupported private key format")
+}
+
+// buildChain puts the certificate of key first, followed by the certificates
+// that issued it in order. Unrelated certificates are dropped.
+func buildChain(key crypto.PrivateKey, certs []*x509.Certificate) (tls.Certificate, error) {
+	if len(certs) == 0 {
+		return tls.Certificate{}, ErrNoCertificate
+	}
+	if key == nil {
+		return tls.Certificate{}, ErrNoPrivateKey
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return tls.Certificate{}, fmt.Errorf("unsupported private key type %T", key)
+	}
+
+	var leaf *x509.Certificate
+	for _, cert := range certs {
+		if publicKeyEqual(cert.PublicKey, signer.Public()) {
+			leaf = cert
+			break
+		}
+	}
+	if leaf == nil {
+		return tls.Certificate{}, ErrKeyMismatch
+	}
+
+	chain := [][]byte{leaf.Raw}
+	used := map[*x509.Certificate]bool{leaf: true}
+	for current := leaf; !bytes.Equal(current.RawIssuer, current.RawSubject); {
+		var issuer *x509.Certificate
+		for _, cert := range certs {
+			if !used[cert] && bytes.Equal(cert.RawSubject, current.RawIssuer) && current.CheckSignatureFrom(cert) == nil {
+				issuer = cert
+				break
+			}
+		}
+		if issuer == nil {
+			break
+		}
+		chain = append(chain, issuer.Raw)
+		used[issuer] = true
+		current = issuer
+	}
+
+	return tls.Certificate{
+		Certificate: chain,
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+func publicKeyEqual(a, b crypto.PublicKey) bool {
+	switch a := a.(type) {
+	case *rsa.PublicKey:
+		return a.Equal(b)
+	case *ecdsa.PublicKey:
+		return a.Equal(b)
+	case ed25519.PublicKey:
+		return a.Equal(b)
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package certificate
+
+import (
+	"crypto/x509"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// The files in testdata hold a certificate for "3200100000" issued by "Test
+// Intermediate CA", which is issued by "Test Root CA". The password is
+// "secret".
+const testPassword = "secret"
+
+func testdata(name string) string {
+	return filepath.Join("testdata", name)
+}
+
+func TestLoad(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		certFile string
+		keyFile  string
+	}{
+		{"PEM bundle", "bundle.pem", ""},
+		{"PEM chain with encrypted PKCS#8 key", "chain.pem", "key_encrypted.pem"},
+		{"PKCS#12 with AES and SHA-256 MAC", "modern.p12", ""},
+		{"PKCS#12 with 3DES", "legacy.p12", ""},
+		{"PKCS#12 with RC2", "rc2.p12", ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			keyFile := ""
+			if tt.keyFile != "" {
+				keyFile = testdata(tt.keyFile)
+			}
+			cert, err := Load(testdata(tt.certFile), keyFile, testPassword)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cert.Leaf == nil || cert.Leaf.Subject.CommonName != "3200100000" {
+				t.Fatalf("leaf = %v", cert.Leaf)
+			}
+			if len(cert.Certificate) != 2 {
+				t.Fatalf("chain has %d certificates, want 2", len(cert.Certificate))
+			}
+			intermediate, err := x509.ParseCertificate(cert.Certificate[1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if intermediate.Subject.CommonName != "Test Intermediate CA" {
+				t.Fatalf("second certificate is %q", intermediate.Subject.CommonName)
+			}
+			if cert.PrivateKey == nil {
+				t.Fatal("no private key")
+			}
+		})
+	}
+}
+
+func TestLoadWrongPassword(t *testing.T) {
+	for _, files := range [][2]string{
+		{"modern.p12", ""},
+		{"legacy.p12", ""},
+		{"rc2.p12", ""},
+		{"chain.pem", "key_encrypted.pem"},
+	} {
+		keyFile := ""
+		if files[1] != "" {
+			keyFile = testdata(files[1])
+		}
+		if _, err := Load(testdata(files[0]), keyFile, "wrong"); !errors.Is(err, ErrIncorrectPass) {
+			t.Errorf("Load(%s) error = %v, want ErrIncorrectPass", files[0], err)
+		}
+	}
+}
+
+func TestLoadWithoutKey(t *testing.T) {
+	if _, err := Load(testdata("chain.pem"), "", ""); !errors.Is(err, ErrNoPrivateKey) {
+		t.Fatalf("Load() error = %v, want ErrNoPrivateKey", err)
+	}
+}
@@ -0,0 +1,37 @@
+package certificate
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+
+	"github.com/youmark/pkcs8"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// DecodePKCS12 returns the private key and all certificates in a PKCS#12
+// file, protected either the modern way, with PBES2 (AES) encryption and
+// SHA-2 MACs, or the legacy way with 3DES or RC2.
+func DecodePKCS12(data []byte, password string) (crypto.PrivateKey, []*x509.Certificate, error) {
+	key, cert, caCerts, err := pkcs12.DecodeChain(data, password)
+	if err != nil {
+		if errors.Is(err, pkcs12.ErrIncorrectPassword) {
+			return nil, nil, ErrIncorrectPass
+		}
+		return nil, nil, err
+	}
+	return key, append([]*x509.Certificate{cert}, caCerts...), nil
+}
+
+// decryptPKCS8Key decrypts and parses a PBES2 encrypted PKCS#8 private key.
+func decryptPKCS8Key(der []byte, password string) (crypto.PrivateKey, error) {
+	key, err := pkcs8.ParsePKCS8PrivateKey(der, []byte(password))
+	if err != nil {
+		// pkcs8 has no error value for this.
+		if err.Error() == "pkcs8: incorrect password" {
+			return nil, ErrIncorrectPass
+		}
+		return nil, err
+	}
+	return key, nil
+}
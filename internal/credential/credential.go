@@ -0,0 +1,113 @@
+// Package credential resolves login credentials from places other than the
+// configuration itself, so that they do not have to be stored in plaintext or
+// passed on the command line.
+package credential
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/mythologyli/zju-connect/internal/extcmd"
+)
+
+// Source describes where one credential comes from. The first non-empty of
+// Value, File, Command, the environment variable Env and the keyring entry
+// Keyring is used.
+type Source struct {
+	// Name is used in error messages, e.g. "password".
+	Name    string
+	Value   string
+	File    string
+	Command string
+	Env     string
+	// Keyring is the kind looked up in the Secret Service, empty to skip it.
+	Keyring string
+}
+
+// Resolve returns the credential, or an empty string if none of the places
+// holds it. A configured file or command that fails is an error.
+func (s Source) Resolve(ctx context.Context, keyring Keyring, account string) (string, error) {
+	if s.Value != "" {
+		return s.Value, nil
+	}
+	if s.File != "" {
+		content, err := os.ReadFile(s.File)
+		if err != nil {
+			return "", fmt.Errorf("read %s file: %w", s.Name, err)
+		}
+		if value := extcmd.FirstLine(content); value != "" {
+			return value, nil
+		}
+	}
+	if s.Command != "" {
+		cmd, err := extcmd.Parse(s.Command)
+		if err != nil {
+			return "", fmt.Errorf("%s command: %w", s.Name, err)
+		}
+		value, err := cmd.Run(ctx, nil, nil)
+		if err != nil {
+			return "", fmt.Errorf("run %s command: %w", s.Name, err)
+		}
+		if value != "" {
+			return value, nil
+		}
+	}
+	if s.Env != "" {
+		if value := os.Getenv(s.Env); value != "" {
+			return value, nil
+		}
+	}
+	if s.Keyring != "" && keyring != nil {
+		value, err := keyring.Lookup(ctx, account, s.Keyring)
+		if err != nil && !errors.Is(err, ErrKeyringUnavailable) {
+			return "", fmt.Errorf("look up %s in keyring: %w", s.Name, err)
+		}
+		return value, nil
+	}
+	return "", nil
+}
+
+// Credentials are the resolved login credentials.
+type Credentials struct {
+	Username     string
+	Password     string
+	TOTPSecret   string
+	CertPassword string
+	// ClientDataKey encrypts the saved login session.
+	ClientDataKey string
+}
+
+// Resolver resolves all login credentials. It is used at startup and again on
+// each re-login, so rotated secrets are picked up without a restart.
+type Resolver struct {
+	Username      Source
+	Password      Source
+	TOTPSecret    Source
+	CertPassword  Source
+	ClientDataKey Source
+	// Keyring is consulted by sources with a Keyring kind; nil disables it.
+	Keyring Keyring
+}
+
+func (r *Resolver) Resolve(ctx context.Context) (Credentials, error) {
+	var creds Credentials
+	var err error
+	if creds.Username, err = r.Username.Resolve(ctx, r.Keyring, ""); err != nil {
+		return Credentials{}, err
+	}
+	if creds.Password, err = r.Password.Resolve(ctx, r.Keyring, creds.Username); err != nil {
+		return Credentials{}, err
+	}
+	if creds.TOTPSecret, err = r.TOTPSecret.Resolve(ctx, r.Keyring, creds.Username); err != nil {
+		return Credentials{}, err
+	}
+	if creds.CertPassword, err = r.CertPassword.Resolve(ctx, r.Keyring, creds.Username); err != nil {
+		return Credentials{}, err
+	}
+	if creds.ClientDataKey, err = r.ClientDataKey.Resolve(ctx, r.Keyring, creds.Username); err != nil {
+		return Credentials{}, err
+	}
+	return creds, nil
+}
@@ -0,0 +1,167 @@
+package credential
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/mythologyli/zju-connect/internal/dbustest"
+)
+
+type fakeKeyring map[string]string
+
+func (k fakeKeyring) Lookup(_ context.Context, account, kind string) (string, error) {
+	if k == nil {
+		return "", ErrKeyringUnavailable
+	}
+	return k[account+"/"+kind], nil
+}
+
+func TestSourcePrecedence(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "password")
+	if err := os.WriteFile(file, []byte("from-file\nsecond line\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ZJU_CONNECT_TEST_PASSWORD", "from-env")
+	keyring := fakeKeyring{"alice/password": "from-keyring"}
+
+	for _, tt := range []struct {
+		name   string
+		source Source
+		want   string
+	}{
+		{"value", Source{Value: "from-value", File: file, Env: "ZJU_CONNECT_TEST_PASSWORD"}, "from-value"},
+		{"file", Source{File: file, Env: "ZJU_CONNECT_TEST_PASSWORD"}, "from-file"},
+		{"env", Source{Env: "ZJU_CONNECT_TEST_PASSWORD", Keyring: "password"}, "from-env"},
+		{"keyring", Source{Env: "ZJU_CONNECT_TEST_UNSET", Keyring: "password"}, "from-keyring"},
+		{"none", Source{Env: "ZJU_CONNECT_TEST_UNSET"}, ""},
+	} {
+		got, err := tt.source.Resolve(context.Background(), keyring, "alice")
+		if err != nil || got != tt.want {
+			t.Errorf("%s: Resolve() = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestSourceCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires echo")
+	}
+	got, err := Source{Name: "password", Command: "echo  s3cret"}.Resolve(context.Background(), nil, "")
+	if err != nil || got != "s3cret" {
+		t.Fatalf("Resolve() = %q, %v", got, err)
+	}
+	if _, err := (Source{Name: "password", Command: "false"}).Resolve(context.Background(), nil, ""); err == nil {
+		t.Fatal("failing command did not return an error")
+	}
+}
+
+func TestSourceMissingFile(t *testing.T) {
+	_, err := Source{Name: "password", File: filepath.Join(t.TempDir(), "missing")}.Resolve(context.Background(), nil, "")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Resolve() error = %v, want ErrNotExist", err)
+	}
+}
+
+func TestSourceUnavailableKeyring(t *testing.T) {
+	got, err := Source{Keyring: "password"}.Resolve(context.Background(), fakeKeyring(nil), "alice")
+	if err != nil || got != "" {
+		t.Fatalf("Resolve() = %q, %v", got, err)
+	}
+}
+
+func TestResolverUsesUsernameAsKeyringAccount(t *testing.T) {
+	t.Setenv("ZJU_CONNECT_TEST_USERNAME", "bob")
+	resolver := &Resolver{
+		Username:   Source{Env: "ZJU_CONNECT_TEST_USERNAME"},
+		Password:   Source{Keyring: "password"},
+		TOTPSecret: Source{Keyring: "totp_secret"},
+		Keyring:    fakeKeyring{"bob/password": "pw", "bob/totp_secret": "JBSWY3DP"},
+	}
+	creds, err := resolver.Resolve(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds != (Credentials{Username: "bob", Password: "pw", TOTPSecret: "JBSWY3DP"}) {
+		t.Fatalf("Resolve() = %+v", creds)
+	}
+}
+
+// fakeSecretService serves the Secret Service API from items, which maps
+// the account attribute to the secret. The item of "locked" is locked.
+type fakeSecretService struct {
+	items map[string]string
+}
+
+func (f *fakeSecretService) SearchItems(attributes map[string]string) ([]dbus.ObjectPath, []dbus.ObjectPath, *dbus.Error) {
+	if attributes["service"] != keyringService || attributes["kind"] != "password" {
+		return nil, nil, nil
+	}
+	if _, ok := f.items[attributes["account"]]; !ok {
+		return nil, nil, nil
+	}
+	item := dbus.ObjectPath("/org/freedesktop/secrets/collection/login/" + attributes["account"])
+	if attributes["account"] == "locked" {
+		return nil, []dbus.ObjectPath{item}, nil
+	}
+	return []dbus.ObjectPath{item}, nil, nil
+}
+
+func (f *fakeSecretService) Unlock(items []dbus.ObjectPath) ([]dbus.ObjectPath, dbus.ObjectPath, *dbus.Error) {
+	return items, "/", nil
+}
+
+func (f *fakeSecretService) OpenSession(algorithm string, _ dbus.Variant) (dbus.Variant, dbus.ObjectPath, *dbus.Error) {
+	if algorithm != "plain" {
+		return dbus.Variant{}, "", &dbus.Error{Name: "org.freedesktop.DBus.Error.NotSupported"}
+	}
+	return dbus.MakeVariant(""), "/org/freedesktop/secrets/session/1", nil
+}
+
+func (f *fakeSecretService) GetSecrets(items []dbus.ObjectPath, session dbus.ObjectPath) (map[dbus.ObjectPath]secret, *dbus.Error) {
+	secrets := make(map[dbus.ObjectPath]secret)
+	for _, item := range items {
+		account := path.Base(string(item))
+		secrets[item] = secret{Session: session, Value: []byte(f.items[account]), ContentType: "text/plain"}
+	}
+	return secrets, nil
+}
+
+func TestSecretServiceLookup(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Secret Service lookup is only used on linux")
+	}
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", dbustest.StartBus(t))
+
+	// Without a provider on the bus the keyring is reported as missing.
+	if _, err := (SecretService{}).Lookup(context.Background(), "alice", "password"); err == nil || !strings.Contains(err.Error(), "no Secret Service provider") {
+		t.Fatalf("Lookup() without provider error = %v", err)
+	}
+
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	service := &fakeSecretService{items: map[string]string{"alice": "from-keyring\n", "locked": "unlocked-secret"}}
+	if err := conn.Export(service, secretServicePath, secretServiceIface); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := conn.RequestName(secretServiceName, dbus.NameFlagDoNotQueue); err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("RequestName() = %v, %v", reply, err)
+	}
+
+	for account, want := range map[string]string{"alice": "from-keyring", "locked": "unlocked-secret", "bob": ""} {
+		got, err := SecretService{}.Lookup(context.Background(), account, "password")
+		if err != nil || got != want {
+			t.Fatalf("Lookup(%q) = %q, %v, want %q", account, got, err, want)
+		}
+	}
+}
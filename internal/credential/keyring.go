@@ -0,0 +1,141 @@
+package credential
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/mythologyli/zju-connect/internal/extcmd"
+)
+
+const keyringService = "zju-connect"
+
+var ErrKeyringUnavailable = errors.New("keyring unavailable")
+
+// Keyring looks up a secret of the given kind for an account.
+type Keyring interface {
+	Lookup(ctx context.Context, account, kind string) (string, error)
+}
+
+const (
+	secretServiceName  = "org.freedesktop.secrets"
+	secretServicePath  = dbus.ObjectPath("/org/freedesktop/secrets")
+	secretServiceIface = "org.freedesktop.Secret.Service"
+	secretPromptIface  = "org.freedesktop.Secret.Prompt"
+	secretSessionIface = "org.freedesktop.Secret.Session"
+)
+
+// secret is the Secret struct of the Secret Service API.
+type secret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+// SecretService reads secrets from the freedesktop Secret Service (GNOME
+// Keyring, KWallet...) over the D-Bus session bus. Secrets are stored with the
+// attributes service=zju-connect, account and kind, e.g. with secret-tool of
+// libsecret:
+//
+//	secret-tool store --label=zju-connect service zju-connect account 3200100000 kind password
+type SecretService struct{}
+
+func (SecretService) Lookup(ctx context.Context, account, kind string) (string, error) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "freebsd" {
+		return "", ErrKeyringUnavailable
+	}
+	if os.Getenv("DBUS_SESSION_BUS_ADDRESS") == "" && os.Getenv("XDG_RUNTIME_DIR") == "" {
+		return "", ErrKeyringUnavailable
+	}
+	conn, err := dbus.ConnectSessionBus(dbus.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("%w: connect to the session bus: %v", ErrKeyringUnavailable, err)
+	}
+	defer conn.Close()
+	service := conn.Object(secretServiceName, secretServicePath)
+
+	attributes := map[string]string{"service": keyringService, "kind": kind}
+	if account != "" {
+		attributes["account"] = account
+	}
+	var unlocked, locked []dbus.ObjectPath
+	if err := service.CallWithContext(ctx, secretServiceIface+".SearchItems", 0, attributes).Store(&unlocked, &locked); err != nil {
+		return "", secretServiceError(err)
+	}
+	items := unlocked
+	if len(items) == 0 && len(locked) > 0 {
+		if items, err = unlockSecrets(ctx, conn, service, locked[:1]); err != nil {
+			return "", err
+		}
+	}
+	if len(items) == 0 {
+		return "", nil
+	}
+
+	// The plain algorithm passes the secret unencrypted, which is what
+	// libsecret does too when talking to the keyring of the user over the
+	// local session bus.
+	var output dbus.Variant
+	var session dbus.ObjectPath
+	if err := service.CallWithContext(ctx, secretServiceIface+".OpenSession", 0, "plain", dbus.MakeVariant("")).Store(&output, &session); err != nil {
+		return "", secretServiceError(err)
+	}
+	defer conn.Object(secretServiceName, session).CallWithContext(ctx, secretSessionIface+".Close", 0)
+
+	var secrets map[dbus.ObjectPath]secret
+	if err := service.CallWithContext(ctx, secretServiceIface+".GetSecrets", 0, items[:1], session).Store(&secrets); err != nil {
+		return "", secretServiceError(err)
+	}
+	return extcmd.FirstLine(secrets[items[0]].Value), nil
+}
+
+// unlockSecrets unlocks items, waiting for the keyring to ask the user if it
+// needs to, and returns the unlocked ones.
+func unlockSecrets(ctx context.Context, conn *dbus.Conn, service dbus.BusObject, items []dbus.ObjectPath) ([]dbus.ObjectPath, error) {
+	var unlocked []dbus.ObjectPath
+	var prompt dbus.ObjectPath
+	if err := service.CallWithContext(ctx, secretServiceIface+".Unlock", 0, items).Store(&unlocked, &prompt); err != nil {
+		return nil, secretServiceError(err)
+	}
+	if prompt == "/" {
+		return unlocked, nil
+	}
+
+	signals := make(chan *dbus.Signal, 1)
+	conn.Signal(signals)
+	defer conn.RemoveSignal(signals)
+	if err := conn.AddMatchSignalContext(ctx, dbus.WithMatchObjectPath(prompt), dbus.WithMatchInterface(secretPromptIface), dbus.WithMatchMember("Completed")); err != nil {
+		return nil, secretServiceError(err)
+	}
+	if err := conn.Object(secretServiceName, prompt).CallWithContext(ctx, secretPromptIface+".Prompt", 0, "").Err; err != nil {
+		return nil, secretServiceError(err)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case signal := <-signals:
+			if signal.Path != prompt || signal.Name != secretPromptIface+".Completed" || len(signal.Body) < 2 {
+				continue
+			}
+			if dismissed, _ := signal.Body[0].(bool); dismissed {
+				return nil, errors.New("unlocking the keyring was dismissed")
+			}
+			result, _ := signal.Body[1].(dbus.Variant)
+			unlocked, _ = result.Value().([]dbus.ObjectPath)
+			return unlocked, nil
+		}
+	}
+}
+
+func secretServiceError(err error) error {
+	var dbusErr dbus.Error
+	if errors.As(err, &dbusErr) && dbusErr.Name == "org.freedesktop.DBus.Error.ServiceUnknown" {
+		return errors.New("no Secret Service provider (GNOME Keyring, KWallet...) is running on the session bus")
+	}
+	return fmt.Errorf("secret service: %w", err)
+}
@@ -0,0 +1,55 @@
+// Package extcmd runs the external programs that answer prompts, solve
+// captchas and print credentials.
+package extcmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Command is a program and its arguments.
+type Command struct {
+	Name string
+	Args []string
+}
+
+// Parse splits commandLine on white space; no shell quoting is applied.
+func Parse(commandLine string) (Command, error) {
+	fields := strings.Fields(commandLine)
+	if len(fields) == 0 {
+		return Command{}, errors.New("empty command")
+	}
+	return Command{Name: fields[0], Args: fields[1:]}, nil
+}
+
+// Run runs the command with env added to its environment and stdin as its
+// standard input, and returns the first line of its standard output. The
+// error of a failed run includes what the command wrote to standard error.
+func (c Command) Run(ctx context.Context, env []string, stdin io.Reader) (string, error) {
+	cmd := exec.CommandContext(ctx, c.Name, c.Args...)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.Stdin = stdin
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return FirstLine(output), nil
+}
+
+// FirstLine returns the first line of content without surrounding white
+// space.
+func FirstLine(content []byte) string {
+	line, _, _ := bufio.NewReader(bytes.NewReader(content)).ReadLine()
+	return strings.TrimSpace(string(line))
+}
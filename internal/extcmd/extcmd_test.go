@@ -0,0 +1,45 @@
+package extcmd
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	if _, err := Parse("  "); err == nil {
+		t.Fatal("Parse accepted an empty command line")
+	}
+	command, err := Parse(" pass  show vpn ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if command.Name != "pass" || strings.Join(command.Args, ",") != "show,vpn" {
+		t.Fatalf("Parse() = %+v", command)
+	}
+}
+
+func TestRunReturnsFirstLine(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	command := Command{Name: "sh", Args: []string{"-c", `printf ' %s-%s \nignored\n' "$KIND" "$(cat)"`}}
+	got, err := command.Run(context.Background(), []string{"KIND=text"}, strings.NewReader("abcd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "text-abcd" {
+		t.Fatalf("Run() = %q, want %q", got, "text-abcd")
+	}
+}
+
+func TestRunErrorIncludesStderr(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	command := Command{Name: "sh", Args: []string{"-c", "echo denied >&2; exit 1"}}
+	if _, err := command.Run(context.Background(), nil, nil); err == nil || !strings.Contains(err.Error(), "denied") {
+		t.Fatalf("Run() error = %v, want the standard error output", err)
+	}
+}
@@ -0,0 +1,216 @@
+package hook_func
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/mythologyli/zju-connect/log"
+	"golang.org/x/sys/unix"
+)
+
+// resolvConfHeader starts a resolv.conf written by SetLinkDNSWithHook, whose
+// original is kept next to it with resolvConfBackupSuffix.
+const (
+	resolvConfHeader       = "# Generated by ZJU Connect"
+	resolvConfBackupSuffix = ".zju-connect"
+)
+
+var (
+	// resolvedBus connects to the bus of systemd-resolved, replaced in tests.
+	resolvedBus    = dbus.ConnectSystemBus
+	resolvConfPath = "/etc/resolv.conf"
+)
+
+// resolvedLinkDNS and resolvedLinkDomain are the arguments of SetLinkDNS and
+// SetLinkDomains.
+type resolvedLinkDNS struct {
+	Family  int32
+	Address []byte
+}
+
+type resolvedLinkDomain struct {
+	Domain      string
+	RoutingOnly bool
+}
+
+// SetLinkDNSWithHook makes the system resolve domains with the DNS server dns
+// on link, and registers a terminal func that undoes it. With mode "resolved"
+// the DNS server and routing domains are set on the link through the D-Bus
+// API of systemd-resolved, so that only these domains go to it. With mode
+// "resolv.conf", resolv.conf is backed up and rewritten to ask dns first for
+// all names, which must be asked for explicitly.
+func SetLinkDNSWithHook(mode, link string, dns net.IP, domains []string) error {
+	if mode != "resolv.conf" {
+		if err := setResolvedLinkDNS(link, dns, domains); err != nil {
+			return fmt.Errorf("%w. Without systemd-resolved, set tun-dns-config to resolv.conf to rewrite %s, or to off", err, resolvConfPath)
+		}
+		log.Printf("Set DNS of %s for %d domains with systemd-resolved", link, len(domains))
+		RegisterTerminalFunc("RevertLinkDNS_"+link, func(ctx context.Context) error {
+			return revertResolvedLinkDNS(ctx, link)
+		})
+		return nil
+	}
+
+	if err := rewriteResolvConf(dns); err != nil {
+		return err
+	}
+	log.Printf("Set DNS server %s in %s", dns, resolvConfPath)
+	RegisterTerminalFunc("RestoreResolvConf", func(ctx context.Context) error {
+		return RestoreResolvConf()
+	})
+	return nil
+}
+
+// UpdateLinkDNS points the DNS set up by SetLinkDNSWithHook at the new address
+// dns of the DNS server, e.g. after the virtual IP changed.
+func UpdateLinkDNS(mode, link string, dns net.IP, domains []string) error {
+	if mode != "resolv.conf" {
+		return setResolvedLinkDNS(link, dns, domains)
+	}
+	if err := RestoreResolvConf(); err != nil {
+		return err
+	}
+	return rewriteResolvConf(dns)
+}
+
+func setResolvedLinkDNS(link string, dns net.IP, domains []string) error {
+	ifce, err := net.InterfaceByName(link)
+	if err != nil {
+		return err
+	}
+	ip := dns.To4()
+	if ip == nil {
+		return fmt.Errorf("DNS server %s is not IPv4", dns)
+	}
+	index := int32(ifce.Index)
+	ctx := context.Background()
+	conn, err := resolvedBus(dbus.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("systemd-resolved: connect to the system bus: %w", err)
+	}
+	defer conn.Close()
+
+	if err := callResolved(ctx, conn, "SetLinkDNS", index, []resolvedLinkDNS{{Family: unix.AF_INET, Address: ip}}); err != nil {
+		return err
+	}
+	// Routing-only domains, like ~zju.edu.cn.
+	linkDomains := make([]resolvedLinkDomain, 0, len(domains))
+	for _, domain := range domains {
+		linkDomains = append(linkDomains, resolvedLinkDomain{Domain: domain, RoutingOnly: true})
+	}
+	if err := callResolved(ctx, conn, "SetLinkDomains", index, linkDomains); err != nil {
+		_ = callResolved(ctx, conn, "RevertLink", index)
+		return err
+	}
+	// Other names keep using the DNS of other links. Older versions of
+	// systemd-resolved do not have this method and behave the same.
+	_ = callResolved(ctx, conn, "SetLinkDefaultRoute", index, false)
+	return nil
+}
+
+func revertResolvedLinkDNS(ctx context.Context, link string) error {
+	ifce, err := net.InterfaceByName(link)
+	if err != nil {
+		// systemd-resolved forgets the link when it is removed.
+		return nil
+	}
+	conn, err := resolvedBus(dbus.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("systemd-resolved: connect to the system bus: %w", err)
+	}
+	defer conn.Close()
+	return callResolved(ctx, conn, "RevertLink", int32(ifce.Index))
+}
+
+func callResolved(ctx context.Context, conn *dbus.Conn, method string, args ...any) error {
+	resolved := conn.Object("org.freedesktop.resolve1", "/org/freedesktop/resolve1")
+	if err := resolved.CallWithContext(ctx, "org.freedesktop.resolve1.Manager."+method, 0, args...).Err; err != nil {
+		return fmt.Errorf("systemd-resolved %s: %w", method, err)
+	}
+	return nil
+}
+
+// rewriteResolvConf puts dns before the name servers of resolv.conf after
+// backing it up.
+func rewriteResolvConf(dns net.IP) error {
+	info, err := os.Lstat(resolvConfPath)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, _ := os.Readlink(resolvConfPath)
+		return fmt.Errorf("%s is a link to %s, which is managed by another program", resolvConfPath, target)
+	}
+	original, err := os.ReadFile(resolvConfPath)
+	if err != nil {
+		return err
+	}
+	if bytes.HasPrefix(original, []byte(resolvConfHeader)) {
+		return fmt.Errorf("%s is already rewritten by ZJU Connect", resolvConfPath)
+	}
+	backup := resolvConfPath + resolvConfBackupSuffix
+	if err := os.WriteFile(backup, original, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("back up %s: %w", resolvConfPath, err)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s, the original is %s\n", resolvConfHeader, backup)
+	fmt.Fprintf(&b, "nameserver %s\n", dns)
+	for _, line := range strings.SplitAfter(string(original), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 && fields[0] == "nameserver" && net.ParseIP(fields[1]).Equal(dns) {
+			continue
+		}
+		b.WriteString(line)
+	}
+	if err := writeFileAtomic(resolvConfPath, []byte(b.String()), info.Mode().Perm()); err != nil {
+		_ = os.Remove(backup)
+		return err
+	}
+	return nil
+}
+
+// RestoreResolvConf restores the resolv.conf backed up by SetLinkDNSWithHook,
+// also after a crash. A resolv.conf that was rewritten by another program in
+// the meantime is kept.
+func RestoreResolvConf() error {
+	backup := resolvConfPath + resolvConfBackupSuffix
+	if _, err := os.Stat(backup); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	current, err := os.ReadFile(resolvConfPath)
+	if err == nil && !bytes.HasPrefix(current, []byte(resolvConfHeader)) {
+		log.Printf("%s was changed by another program, not restoring it", resolvConfPath)
+		return os.Remove(backup)
+	}
+	if err := os.Rename(backup, resolvConfPath); err != nil {
+		return fmt.Errorf("restore %s: %w", resolvConfPath, err)
+	}
+	return nil
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Chmod(perm); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
@@ -0,0 +1,180 @@
+package hook_func
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/mythologyli/zju-connect/internal/dbustest"
+)
+
+// fakeResolved serves the methods of org.freedesktop.resolve1.Manager used
+// by SetLinkDNSWithHook and records their calls.
+type fakeResolved struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (f *fakeResolved) record(call string) *dbus.Error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, call)
+	return nil
+}
+
+func (f *fakeResolved) SetLinkDNS(index int32, servers []resolvedLinkDNS) *dbus.Error {
+	return f.record(fmt.Sprintf("SetLinkDNS %d %v", index, servers))
+}
+
+func (f *fakeResolved) SetLinkDomains(index int32, domains []resolvedLinkDomain) *dbus.Error {
+	return f.record(fmt.Sprintf("SetLinkDomains %d %v", index, domains))
+}
+
+func (f *fakeResolved) SetLinkDefaultRoute(index int32, enable bool) *dbus.Error {
+	return f.record(fmt.Sprintf("SetLinkDefaultRoute %d %v", index, enable))
+}
+
+func (f *fakeResolved) RevertLink(index int32) *dbus.Error {
+	return f.record(fmt.Sprintf("RevertLink %d", index))
+}
+
+// fakeResolvedBus points resolvedBus at a private bus, serving resolved on it
+// if it is not nil.
+func fakeResolvedBus(t *testing.T, resolved *fakeResolved) {
+	t.Helper()
+	addr := dbustest.StartBus(t)
+	oldBus := resolvedBus
+	resolvedBus = func(opts ...dbus.ConnOption) (*dbus.Conn, error) {
+		return dbus.Connect(addr, opts...)
+	}
+	t.Cleanup(func() { resolvedBus = oldBus })
+	if resolved == nil {
+		return
+	}
+
+	conn, err := resolvedBus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if err := conn.Export(resolved, "/org/freedesktop/resolve1", "org.freedesktop.resolve1.Manager"); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := conn.RequestName("org.freedesktop.resolve1", dbus.NameFlagDoNotQueue); err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("RequestName() = %v, %v", reply, err)
+	}
+}
+
+func fakeResolvConf(t *testing.T, content string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	oldPath := resolvConfPath
+	resolvConfPath = path
+	t.Cleanup(func() { resolvConfPath = oldPath })
+}
+
+func TestSetLinkDNSWithResolved(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skip("no loopback interface")
+	}
+	resolved := &fakeResolved{}
+	fakeResolvedBus(t, resolved)
+	if err := SetLinkDNSWithHook("resolved", "lo", net.ParseIP("10.5.0.1"), []string{"cc98.org", "zju.edu.cn"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateLinkDNS("resolved", "lo", net.ParseIP("10.5.0.2"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := revertResolvedLinkDNS(context.Background(), "lo"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		fmt.Sprintf("SetLinkDNS %d [{2 [10 5 0 1]}]", lo.Index),
+		fmt.Sprintf("SetLinkDomains %d [{cc98.org true} {zju.edu.cn true}]", lo.Index),
+		fmt.Sprintf("SetLinkDefaultRoute %d false", lo.Index),
+		fmt.Sprintf("SetLinkDNS %d [{2 [10 5 0 2]}]", lo.Index),
+		fmt.Sprintf("SetLinkDomains %d []", lo.Index),
+		fmt.Sprintf("SetLinkDefaultRoute %d false", lo.Index),
+		fmt.Sprintf("RevertLink %d", lo.Index),
+	}
+	resolved.mu.Lock()
+	defer resolved.mu.Unlock()
+	if !slices.Equal(resolved.calls, want) {
+		t.Fatalf("resolved calls = %q, want %q", resolved.calls, want)
+	}
+}
+
+func TestSetLinkDNSRewritesResolvConfOnRequest(t *testing.T) {
+	fakeResolvedBus(t, nil)
+	original := "search example.com\nnameserver 10.5.0.1\nnameserver 192.168.1.1\n"
+	fakeResolvConf(t, original)
+
+	if err := SetLinkDNSWithHook("resolved", "lo", net.ParseIP("10.5.0.1"), nil); err == nil {
+		t.Fatal("resolved mode should not fall back")
+	}
+	if b, _ := os.ReadFile(resolvConfPath); string(b) != original {
+		t.Fatalf("resolv.conf was rewritten without asking: %q", b)
+	}
+	if err := SetLinkDNSWithHook("resolv.conf", "lo", net.ParseIP("10.5.0.1"), nil); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(resolvConfPath)
+	rewritten := string(b)
+	if !strings.HasPrefix(rewritten, resolvConfHeader) ||
+		!strings.Contains(rewritten, "\nnameserver 10.5.0.1\nsearch example.com\nnameserver 192.168.1.1\n") {
+		t.Fatalf("rewritten resolv.conf = %q", rewritten)
+	}
+	if err := SetLinkDNSWithHook("resolv.conf", "lo", net.ParseIP("10.5.0.1"), nil); err == nil {
+		t.Fatal("rewriting twice should fail, or the backup would be lost")
+	}
+
+	if err := RestoreResolvConf(); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(resolvConfPath); string(b) != original {
+		t.Fatalf("restored resolv.conf = %q, want %q", b, original)
+	}
+	if _, err := os.Stat(resolvConfPath + resolvConfBackupSuffix); !os.IsNotExist(err) {
+		t.Fatalf("backup is not removed: %v", err)
+	}
+}
+
+func TestRestoreResolvConfKeepsForeignChanges(t *testing.T) {
+	fakeResolvConf(t, "nameserver 192.168.1.1\n")
+	if err := rewriteResolvConf(net.ParseIP("10.5.0.1")); err != nil {
+		t.Fatal(err)
+	}
+	// e.g. a DHCP client writing resolv.conf while connected
+	if err := os.WriteFile(resolvConfPath, []byte("nameserver 192.168.2.1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := RestoreResolvConf(); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(resolvConfPath); string(b) != "nameserver 192.168.2.1\n" {
+		t.Fatalf("resolv.conf = %q, want the foreign change", b)
+	}
+}
+
+func TestRewriteResolvConfRefusesSymlink(t *testing.T) {
+	fakeResolvConf(t, "nameserver 127.0.0.53\n")
+	link := filepath.Join(filepath.Dir(resolvConfPath), "link")
+	if err := os.Symlink(resolvConfPath, link); err != nil {
+		t.Fatal(err)
+	}
+	resolvConfPath = link
+	if err := rewriteResolvConf(net.ParseIP("10.5.0.1")); err == nil {
+		t.Fatal("a linked resolv.conf should not be rewritten")
+	}
+}
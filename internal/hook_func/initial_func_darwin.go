@@ -42,13 +42,7 @@ func SetDNSServer(service, dns string) error {
 }
 
 func SetDNSServerWithHook(service, dns string) error {
-	// networksetup -setdnsservers "service name" DNS_IP
-	cmd := exec.Command("networksetup", "-setdnsservers", service, dns)
-
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-
-	if err := cmd.Run(); err != nil {
+	if err := SetDNSServer(service, dns); err != nil {
 		return err
 	}
 
@@ -64,6 +58,18 @@ func SetDNSServerWithHook(service, dns string) error {
 	return nil
 }
 
+// SetDNSServer sets the DNS server of a network service, e.g. again after the
+// DNS server set by SetDNSServerWithHook moved.
+func SetDNSServer(service, dns string) error {
+	// networksetup -setdnsservers "service name" DNS_IP
+	cmd := exec.Command("networksetup", "-setdnsservers", service, dns)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	return cmd.Run()
+}
+
 func init() {
 	RegisterInitialFunc("clean resolver file", func(ctx context.Context, config configs.Config) error {
 		// discard error
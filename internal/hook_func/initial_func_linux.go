@@ -9,6 +9,13 @@ import (
 )
 
 func init() {
+	RegisterInitialFunc("restore resolv.conf", func(ctx context.Context, config configs.Config) error {
+		// left behind if the last run crashed
+		if err := RestoreResolvConf(); err != nil {
+			log.Println("Restore resolv.conf failed:", err)
+		}
+		return nil
+	})
 	RegisterInitialFunc("check tun mode cap", func(ctx context.Context, config configs.Config) error {
 		// discard error
 		if config.TUNMode {
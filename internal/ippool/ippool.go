@@ -1,106 +1,332 @@
 package ippool
 
 import (
-	"encoding/binary"
+	"container/list"
 	"errors"
+	"fmt"
 	"net"
+	"net/netip"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// ErrExhausted is returned when every address of a range is mapped and none of
+// the mappings has been idle long enough to be recycled.
+var ErrExhausted = errors.New("fake IP range exhausted")
+
+// DefaultIdleTimeout is the minimum time an entry must stay unused before it
+// may be recycled for another domain.
+const DefaultIdleTimeout = 5 * time.Minute
+
 type entry[T any] struct {
-	ipUint   uint32
+	addr     netip.Addr
 	domain   string
 	resource T
+
+	// lastUsed is updated on lookups under the read lock, so it is atomic.
+	// listedAt is the lastUsed value the LRU position was computed from.
+	lastUsed atomic.Int64
+	listedAt int64
+	element  *list.Element
+	fake     bool
+}
+
+type addrRange struct {
+	prefix netip.Prefix
+	next   netip.Addr
+	free   []netip.Addr
+	lru    list.List
+}
+
+type domainKey struct {
+	domain string
+	ipv6   bool
 }
 
 type IPPool[T any] struct {
-	mu         sync.RWMutex
-	domainToIP map[string]*entry[T]
-	ipToDomain map[uint32]*entry[T]
-	minIP      uint32
-	maxIP      uint32
-	currentIP  uint32
+	mu          sync.RWMutex
+	domainToIP  map[domainKey]*entry[T]
+	ipToDomain  map[netip.Addr]*entry[T]
+	range4      *addrRange
+	range6      *addrRange
+	idleTimeout time.Duration
+	dirty       atomic.Bool
+	now         func() time.Time
 }
 
-func NewIPPool[T any](cidr string) (*IPPool[T], error) {
-	_, ipNet, err := net.ParseCIDR(cidr)
-	if err != nil {
-		return nil, err
+// Entry is a snapshot of a single mapping.
+type Entry[T any] struct {
+	IP       string    `json:"ip"`
+	Domain   string    `json:"domain"`
+	Resource T         `json:"resource"`
+	Fake     bool      `json:"fake"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// Stats summarizes the pool usage.
+type Stats struct {
+	Range4    string `json:"range4,omitempty"`
+	Range6    string `json:"range6,omitempty"`
+	Used4     int    `json:"used4"`
+	Used6     int    `json:"used6"`
+	Capacity4 uint64 `json:"capacity4"`
+	Capacity6 uint64 `json:"capacity6"`
+	Static    int    `json:"static"`
+}
+
+// NewIPPool creates a fake IP pool. At most one IPv4 and one IPv6 CIDR may be
+// given; empty strings are ignored.
+func NewIPPool[T any](cidrs ...string) (*IPPool[T], error) {
+	p := &IPPool[T]{
+		domainToIP:  make(map[domainKey]*entry[T]),
+		ipToDomain:  make(map[netip.Addr]*entry[T]),
+		idleTimeout: DefaultIdleTimeout,
+		now:         time.Now,
+	}
+
+	for _, cidr := range cidrs {
+		if cidr == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, err
+		}
+		prefix = prefix.Masked()
+		r, err := newAddrRange(prefix)
+		if err != nil {
+			return nil, err
+		}
+		if prefix.Addr().Is4() {
+			if p.range4 != nil {
+				return nil, fmt.Errorf("duplicate IPv4 fake IP range %s", cidr)
+			}
+			p.range4 = r
+		} else {
+			if p.range6 != nil {
+				return nil, fmt.Errorf("duplicate IPv6 fake IP range %s", cidr)
+			}
+			p.range6 = r
+		}
 	}
 
-	ones, bits := ipNet.Mask.Size()
-	numIPs := uint32(1 << (bits - ones))
-	minIP := binary.BigEndian.Uint32(ipNet.IP)
+	return p, nil
+}
 
-	return &IPPool[T]{
-		domainToIP: make(map[string]*entry[T]),
-		ipToDomain: make(map[uint32]*entry[T]),
-		minIP:      minIP,
-		maxIP:      minIP + numIPs - 1,
-		currentIP:  minIP + 2,
-	}, nil
+func newAddrRange(prefix netip.Prefix) (*addrRange, error) {
+	// Skip the network address and the first host, which is usually taken by
+	// the gateway when the range is routed to a TUN device.
+	first := prefix.Addr().Next().Next()
+	if !first.IsValid() || !prefix.Contains(first) {
+		return nil, fmt.Errorf("fake IP range %s is too small", prefix)
+	}
+	return &addrRange{prefix: prefix, next: first}, nil
 }
 
+// SetIdleTimeout changes the minimum idle time before an entry may be recycled.
+func (p *IPPool[T]) SetIdleTimeout(timeout time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.idleTimeout = timeout
+}
+
+// Contains reports whether ip belongs to one of the fake IP ranges.
+func (p *IPPool[T]) Contains(ip net.IP) bool {
+	addr, ok := toAddr(ip)
+	if !ok {
+		return false
+	}
+	return p.rangeFor(addr) != nil
+}
+
+func (p *IPPool[T]) rangeFor(addr netip.Addr) *addrRange {
+	if addr.Is4() {
+		if p.range4 != nil && p.range4.prefix.Contains(addr) {
+			return p.range4
+		}
+		return nil
+	}
+	if p.range6 != nil && p.range6.prefix.Contains(addr) {
+		return p.range6
+	}
+	return nil
+}
+
+// SetIPDomain records a real address for domain. Such entries are never
+// recycled and do not consume fake IP capacity.
 func (p *IPPool[T]) SetIPDomain(ip net.IP, domain string, res T) error {
-	ip4 := ip.To4()
-	if ip4 == nil {
-		return errors.New("only IPv4 is supported")
+	addr, ok := toAddr(ip)
+	if !ok {
+		return errors.New("invalid IP address")
 	}
-	ipUint := binary.BigEndian.Uint32(ip4)
 
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	if old, ok := p.ipToDomain[addr]; ok && old.fake {
+		return fmt.Errorf("%s is a fake IP in use", addr)
+	}
+
 	newEntry := &entry[T]{
-		ipUint:   ipUint,
+		addr:     addr,
 		domain:   domain,
 		resource: res,
 	}
+	newEntry.lastUsed.Store(p.now().UnixNano())
 
-	p.domainToIP[domain] = newEntry
-	p.ipToDomain[ipUint] = newEntry
+	key := domainKey{domain: domain, ipv6: addr.Is6()}
+	if old, ok := p.domainToIP[key]; ok && old.fake {
+		p.releaseLocked(old)
+	}
+	p.domainToIP[key] = newEntry
+	p.ipToDomain[addr] = newEntry
+	p.dirty.Store(true)
 
 	return nil
 }
 
-func (p *IPPool[T]) GenerateIP(domain string, res T) net.IP {
+// GenerateIP returns the IPv4 fake IP of domain, allocating one if needed.
+func (p *IPPool[T]) GenerateIP(domain string, res T) (net.IP, error) {
+	return p.generate(domain, res, false)
+}
+
+// GenerateIP6 returns the IPv6 fake IP of domain, allocating one if needed.
+func (p *IPPool[T]) GenerateIP6(domain string, res T) (net.IP, error) {
+	return p.generate(domain, res, true)
+}
+
+// HasIPv6 reports whether an IPv6 fake IP range is configured.
+func (p *IPPool[T]) HasIPv6() bool {
+	return p.range6 != nil
+}
+
+func (p *IPPool[T]) generate(domain string, res T, ipv6 bool) (net.IP, error) {
+	r := p.range4
+	if ipv6 {
+		r = p.range6
+	}
+	if r == nil {
+		return nil, errors.New("no fake IP range configured")
+	}
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	if e, ok := p.domainToIP[domain]; ok {
-		return uint32ToIP(e.ipUint)
+	now := p.now().UnixNano()
+	key := domainKey{domain: domain, ipv6: ipv6}
+	if e, ok := p.domainToIP[key]; ok && e.fake {
+		e.lastUsed.Store(now)
+		return net.IP(e.addr.AsSlice()), nil
 	}
 
-	if p.currentIP > p.maxIP {
-		panic("Fake IP range exhausted")
+	addr, err := p.allocateLocked(r, now)
+	if err != nil {
+		return nil, err
 	}
 
-	newIP := p.currentIP
 	newEntry := &entry[T]{
-		ipUint:   newIP,
+		addr:     addr,
 		domain:   domain,
 		resource: res,
+		listedAt: now,
+		fake:     true,
+	}
+	newEntry.lastUsed.Store(now)
+	newEntry.element = r.lru.PushFront(newEntry)
+
+	p.domainToIP[key] = newEntry
+	p.ipToDomain[addr] = newEntry
+	p.dirty.Store(true)
+
+	return net.IP(addr.AsSlice()), nil
+}
+
+func (p *IPPool[T]) allocateLocked(r *addrRange, now int64) (netip.Addr, error) {
+	for len(r.free) > 0 {
+		addr := r.free[len(r.free)-1]
+		r.free = r.free[:len(r.free)-1]
+		if _, used := p.ipToDomain[addr]; !used {
+			return addr, nil
+		}
 	}
 
-	p.domainToIP[domain] = newEntry
-	p.ipToDomain[newIP] = newEntry
-	p.currentIP++
+	for r.next.IsValid() && r.prefix.Contains(r.next) {
+		addr := r.next
+		r.next = r.next.Next()
+		if _, used := p.ipToDomain[addr]; !used {
+			return addr, nil
+		}
+	}
+
+	if e := p.evictLocked(r, now); e != nil {
+		return e.addr, nil
+	}
+	return netip.Addr{}, ErrExhausted
+}
 
-	return uint32ToIP(newIP)
+// evictLocked removes the least recently used entry of r, as long as it has
+// been idle for at least idleTimeout. Lookups only bump an atomic timestamp,
+// so entries found at the back with a newer timestamp get a second chance and
+// are moved to the front instead.
+func (p *IPPool[T]) evictLocked(r *addrRange, now int64) *entry[T] {
+	for {
+		back := r.lru.Back()
+		if back == nil {
+			return nil
+		}
+		e := back.Value.(*entry[T])
+		if used := e.lastUsed.Load(); used > e.listedAt {
+			e.listedAt = used
+			r.lru.MoveToFront(back)
+			continue
+		}
+		if time.Duration(now-e.listedAt) < p.idleTimeout {
+			return nil
+		}
+		p.removeLocked(e)
+		return e
+	}
 }
 
+func (p *IPPool[T]) removeLocked(e *entry[T]) {
+	if e.element != nil {
+		if r := p.rangeFor(e.addr); r != nil {
+			r.lru.Remove(e.element)
+		}
+		e.element = nil
+	}
+	if p.ipToDomain[e.addr] == e {
+		delete(p.ipToDomain, e.addr)
+	}
+	key := domainKey{domain: e.domain, ipv6: e.addr.Is6()}
+	if p.domainToIP[key] == e {
+		delete(p.domainToIP, key)
+	}
+	p.dirty.Store(true)
+}
+
+func (p *IPPool[T]) releaseLocked(e *entry[T]) {
+	p.removeLocked(e)
+	if r := p.rangeFor(e.addr); r != nil && e.fake {
+		r.free = append(r.free, e.addr)
+	}
+}
+
+// GetDomain looks up the domain mapped to ip. A successful lookup counts as
+// activity and keeps the entry from being recycled.
 func (p *IPPool[T]) GetDomain(ip net.IP) (string, T, bool) {
-	ip4 := ip.To4()
-	if ip4 == nil {
+	addr, ok := toAddr(ip)
+	if !ok {
 		var zero T
 		return "", zero, false
 	}
-	ipUint := binary.BigEndian.Uint32(ip4)
 
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
-	if e, ok := p.ipToDomain[ipUint]; ok {
+	if e, ok := p.ipToDomain[addr]; ok {
+		e.lastUsed.Store(p.now().UnixNano())
 		return e.domain, e.resource, true
 	}
 
@@ -108,8 +334,90 @@ func (p *IPPool[T]) GetDomain(ip net.IP) (string, T, bool) {
 	return "", zero, false
 }
 
-func uint32ToIP(n uint32) net.IP {
-	ip := make(net.IP, 4)
-	binary.BigEndian.PutUint32(ip, n)
-	return ip
+// Entries returns a snapshot of all mappings.
+func (p *IPPool[T]) Entries() []Entry[T] {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	entries := make([]Entry[T], 0, len(p.ipToDomain))
+	for _, e := range p.ipToDomain {
+		entries = append(entries, Entry[T]{
+			IP:       e.addr.String(),
+			Domain:   e.domain,
+			Resource: e.resource,
+			Fake:     e.fake,
+			LastUsed: time.Unix(0, e.lastUsed.Load()),
+		})
+	}
+	return entries
+}
+
+// Stats returns the current pool usage.
+func (p *IPPool[T]) Stats() Stats {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	var stats Stats
+	if p.range4 != nil {
+		stats.Range4 = p.range4.prefix.String()
+		stats.Used4 = p.range4.lru.Len()
+		stats.Capacity4 = rangeCapacity(p.range4.prefix)
+	}
+	if p.range6 != nil {
+		stats.Range6 = p.range6.prefix.String()
+		stats.Used6 = p.range6.lru.Len()
+		stats.Capacity6 = rangeCapacity(p.range6.prefix)
+	}
+	stats.Static = len(p.ipToDomain) - stats.Used4 - stats.Used6
+	return stats
+}
+
+func rangeCapacity(prefix netip.Prefix) uint64 {
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	if hostBits >= 64 {
+		return ^uint64(0)
+	}
+	return (uint64(1) << hostBits) - 2
+}
+
+// Flush removes all mappings and returns how many were dropped.
+func (p *IPPool[T]) Flush() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := len(p.ipToDomain)
+	p.domainToIP = make(map[domainKey]*entry[T])
+	p.ipToDomain = make(map[netip.Addr]*entry[T])
+	for _, r := range []*addrRange{p.range4, p.range6} {
+		if r != nil {
+			r.lru.Init()
+			r.free = nil
+			r.next = r.prefix.Addr().Next().Next()
+		}
+	}
+	p.dirty.Store(true)
+	return n
+}
+
+// FlushDomain removes the mappings of domain and returns how many were dropped.
+func (p *IPPool[T]) FlushDomain(domain string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := 0
+	for _, ipv6 := range []bool{false, true} {
+		if e, ok := p.domainToIP[domainKey{domain: domain, ipv6: ipv6}]; ok {
+			p.releaseLocked(e)
+			n++
+		}
+	}
+	return n
+}
+
+func toAddr(ip net.IP) (netip.Addr, bool) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
 }
@@ -0,0 +1,165 @@
+package ippool
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func newTestPool(t *testing.T, cidrs ...string) (*IPPool[string], *fakeClock) {
+	t.Helper()
+	pool, err := NewIPPool[string](cidrs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	pool.now = clock.Now
+	return pool, clock
+}
+
+func TestGenerateIPReturnsStableMapping(t *testing.T) {
+	pool, _ := newTestPool(t, "198.18.0.0/16")
+	first, err := pool.GenerateIP("a.example.com", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first.Equal(net.IPv4(198, 18, 0, 2)) {
+		t.Fatalf("first fake IP = %s, want 198.18.0.2", first)
+	}
+	again, err := pool.GenerateIP("a.example.com", "a")
+	if err != nil || !again.Equal(first) {
+		t.Fatalf("GenerateIP() again = %s, %v, want %s", again, err, first)
+	}
+	domain, resource, ok := pool.GetDomain(first)
+	if !ok || domain != "a.example.com" || resource != "a" {
+		t.Fatalf("GetDomain() = %q, %q, %t", domain, resource, ok)
+	}
+}
+
+func TestGenerateIPRecyclesLeastRecentlyUsed(t *testing.T) {
+	pool, clock := newTestPool(t, "198.18.0.0/30")
+	pool.SetIdleTimeout(time.Minute)
+
+	a, _ := pool.GenerateIP("a", "")
+	clock.now = clock.now.Add(time.Second)
+	b, _ := pool.GenerateIP("b", "")
+	if a == nil || b == nil {
+		t.Fatalf("GenerateIP() returned nil: %v %v", a, b)
+	}
+
+	if _, err := pool.GenerateIP("c", ""); !errors.Is(err, ErrExhausted) {
+		t.Fatalf("GenerateIP() on busy pool error = %v, want ErrExhausted", err)
+	}
+
+	// a is older, but traffic towards it makes b the least recently used.
+	clock.now = clock.now.Add(2 * time.Minute)
+	_, _, _ = pool.GetDomain(a)
+	clock.now = clock.now.Add(2 * time.Minute)
+	c, err := pool.GenerateIP("c", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Equal(b) {
+		t.Fatalf("recycled IP = %s, want %s", c, b)
+	}
+	if domain, _, _ := pool.GetDomain(a); domain != "a" {
+		t.Fatalf("recently used entry was recycled, now maps to %q", domain)
+	}
+	if domain, _, _ := pool.GetDomain(c); domain != "c" {
+		t.Fatalf("recycled entry maps to %q, want c", domain)
+	}
+}
+
+func TestFlushDomainReleasesAddress(t *testing.T) {
+	pool, _ := newTestPool(t, "198.18.0.0/30")
+	a, _ := pool.GenerateIP("a", "")
+	_, _ = pool.GenerateIP("b", "")
+	if n := pool.FlushDomain("a"); n != 1 {
+		t.Fatalf("FlushDomain() = %d, want 1", n)
+	}
+	if _, _, ok := pool.GetDomain(a); ok {
+		t.Fatal("flushed entry is still mapped")
+	}
+	c, err := pool.GenerateIP("c", "")
+	if err != nil || !c.Equal(a) {
+		t.Fatalf("GenerateIP() after flush = %s, %v, want %s", c, err, a)
+	}
+	if n := pool.Flush(); n != 2 {
+		t.Fatalf("Flush() = %d, want 2", n)
+	}
+}
+
+func TestGenerateIP6UsesIPv6Range(t *testing.T) {
+	pool, _ := newTestPool(t, "198.18.0.0/16", "fdfe:dcba:9876::/64")
+	ip, err := pool.GenerateIP6("a.example.com", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip.To4() != nil || !pool.Contains(ip) {
+		t.Fatalf("GenerateIP6() = %s, want address in IPv6 range", ip)
+	}
+	ip4, _ := pool.GenerateIP("a.example.com", "a")
+	if ip4.To4() == nil {
+		t.Fatalf("GenerateIP() = %s, want IPv4", ip4)
+	}
+	if domain, _, ok := pool.GetDomain(ip); !ok || domain != "a.example.com" {
+		t.Fatalf("GetDomain(%s) = %q, %t", ip, domain, ok)
+	}
+}
+
+func TestSaveAndLoadRestoresMappings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fake-ip.json")
+	pool, clock := newTestPool(t, "198.18.0.0/16")
+	a, _ := pool.GenerateIP("a", "resource-a")
+	clock.now = clock.now.Add(time.Second)
+	b, _ := pool.GenerateIP("b", "resource-b")
+	if err := pool.SetIPDomain(net.IPv4(10, 0, 0, 1), "static", "s"); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// Resources are matched again on load: b no longer is a resource, and
+	// the resource of a has changed.
+	restored, _ := newTestPool(t, "198.18.0.0/16")
+	loaded, err := restored.Load(path, func(domain string) (string, bool) {
+		return "current-" + domain, domain == "a"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded != 1 {
+		t.Fatalf("Load() = %d, want 1", loaded)
+	}
+	if _, resource, ok := restored.GetDomain(a); !ok || resource != "current-a" {
+		t.Fatalf("restored %s = %q, %t, want current-a", a, resource, ok)
+	}
+	if _, _, ok := restored.GetDomain(b); ok {
+		t.Fatalf("%s of a domain that is no resource anymore was restored", b)
+	}
+	if content, _ := os.ReadFile(path); strings.Contains(string(content), "resource-") {
+		t.Fatalf("resources were saved: %s", content)
+	}
+	c, err := restored.GenerateIP("c", "")
+	if err != nil || c.Equal(a) {
+		t.Fatalf("GenerateIP() after Load = %s, %v, want a new address", c, err)
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	pool, _ := newTestPool(t, "198.18.0.0/16")
+	if loaded, err := pool.Load(filepath.Join(t.TempDir(), "missing.json"), func(string) (string, bool) { return "", true }); err != nil || loaded != 0 {
+		t.Fatalf("Load() = %d, %v, want 0, nil", loaded, err)
+	}
+}
@@ -0,0 +1,192 @@
+package ippool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const persistVersion = 1
+
+// maxRestoredGap bounds how many skipped addresses below a restored entry are
+// put back on the free list, so that a sparse IPv6 file cannot blow up memory.
+const maxRestoredGap = 1 << 16
+
+type persistedEntry struct {
+	IP       string `json:"ip"`
+	Domain   string `json:"domain"`
+	LastUsed int64  `json:"last_used"`
+}
+
+type persistedPool struct {
+	Version int              `json:"version"`
+	Entries []persistedEntry `json:"entries"`
+}
+
+// Save writes the fake IP mappings to path. Static mappings recorded with
+// SetIPDomain are not saved as they are rebuilt from DNS answers, and neither
+// are the resources of the domains, which may have changed by the next Load.
+func (p *IPPool[T]) Save(path string) error {
+	p.mu.RLock()
+	data := persistedPool{Version: persistVersion}
+	for _, e := range p.ipToDomain {
+		if !e.fake {
+			continue
+		}
+		data.Entries = append(data.Entries, persistedEntry{
+			IP:       e.addr.String(),
+			Domain:   e.domain,
+			LastUsed: e.lastUsed.Load(),
+		})
+	}
+	p.dirty.Store(false)
+	p.mu.RUnlock()
+
+	content, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	if _, err = tmp.Write(content); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmpName)
+		p.dirty.Store(true)
+		return err
+	}
+	return nil
+}
+
+// Load restores fake IP mappings saved by Save. match returns the current
+// resource of a domain; entries of domains it no longer matches, outside the
+// configured ranges or conflicting with existing mappings are skipped. A
+// missing file is not an error.
+func (p *IPPool[T]) Load(path string, match func(domain string) (T, bool)) (int, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	var data persistedPool
+	if err = json.Unmarshal(content, &data); err != nil {
+		return 0, err
+	}
+	if data.Version != persistVersion {
+		return 0, errors.New("unsupported fake IP file version")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	loaded := 0
+	for _, saved := range data.Entries {
+		addr, err := netip.ParseAddr(saved.IP)
+		if err != nil {
+			continue
+		}
+		r := p.rangeFor(addr)
+		if r == nil || addr.Less(r.prefix.Addr().Next().Next()) {
+			continue
+		}
+		key := domainKey{domain: saved.Domain, ipv6: addr.Is6()}
+		if _, ok := p.ipToDomain[addr]; ok {
+			continue
+		}
+		if _, ok := p.domainToIP[key]; ok {
+			continue
+		}
+		resource, ok := match(saved.Domain)
+		if !ok {
+			continue
+		}
+
+		e := &entry[T]{
+			addr:     addr,
+			domain:   saved.Domain,
+			resource: resource,
+			listedAt: saved.LastUsed,
+			fake:     true,
+		}
+		e.lastUsed.Store(saved.LastUsed)
+		e.element = r.lru.PushFront(e)
+		p.domainToIP[key] = e
+		p.ipToDomain[addr] = e
+		loaded++
+
+		if !addr.Less(r.next) {
+			gaps := 0
+			for gap := r.next; gap.Less(addr) && gaps < maxRestoredGap; gap = gap.Next() {
+				r.free = append(r.free, gap)
+				gaps++
+			}
+			r.next = addr.Next()
+		}
+	}
+
+	// Restored entries were pushed in file order; sort the LRU lists so that
+	// the least recently used entries are recycled first.
+	for _, r := range []*addrRange{p.range4, p.range6} {
+		if r != nil {
+			sortLRU[T](r)
+		}
+	}
+
+	return loaded, nil
+}
+
+func sortLRU[T any](r *addrRange) {
+	entries := make([]*entry[T], 0, r.lru.Len())
+	for element := r.lru.Front(); element != nil; element = element.Next() {
+		entries = append(entries, element.Value.(*entry[T]))
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].listedAt > entries[j].listedAt
+	})
+	r.lru.Init()
+	for _, e := range entries {
+		e.element = r.lru.PushBack(e)
+	}
+}
+
+// AutoSave periodically saves the pool to path when it has changed, and once
+// more when ctx is done.
+func (p *IPPool[T]) AutoSave(ctx context.Context, path string, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := p.Save(path); err != nil && onError != nil {
+				onError(err)
+			}
+			return
+		case <-ticker.C:
+			if !p.dirty.Load() {
+				continue
+			}
+			if err := p.Save(path); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
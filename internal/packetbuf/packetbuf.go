@@ -0,0 +1,62 @@
+// Package packetbuf provides pooled buffers for packets passed between the
+// network stacks and the L3 connections of the clients.
+package packetbuf
+
+import "sync"
+
+// Buffers are pooled in size classes from minSize to maxSize, doubling in
+// size. Larger buffers are not pooled.
+const (
+	minSize    = 2048
+	numClasses = 6
+	maxSize    = minSize << (numClasses - 1)
+)
+
+var pools [numClasses]sync.Pool
+
+// Buffer holds one packet.
+type Buffer struct {
+	data  []byte
+	class int
+}
+
+func class(size int) int {
+	c := 0
+	for s := minSize; s < size; s *= 2 {
+		c++
+	}
+	return c
+}
+
+// Get returns a buffer of length size. It must be handed back with Release
+// once the packet is no longer used.
+func Get(size int) *Buffer {
+	if size > maxSize {
+		return &Buffer{data: make([]byte, size), class: -1}
+	}
+	c := class(size)
+	if b, ok := pools[c].Get().(*Buffer); ok {
+		b.data = b.data[:size]
+		return b
+	}
+	return &Buffer{data: make([]byte, size, minSize<<c), class: c}
+}
+
+// Bytes returns the packet. It is only valid until Release.
+func (b *Buffer) Bytes() []byte {
+	return b.data
+}
+
+// Truncate shortens the packet to n bytes.
+func (b *Buffer) Truncate(n int) {
+	b.data = b.data[:n]
+}
+
+// Release hands the buffer back to its pool.
+func (b *Buffer) Release() {
+	if b.class < 0 {
+		return
+	}
+	b.data = b.data[:0]
+	pools[b.class].Put(b)
+}
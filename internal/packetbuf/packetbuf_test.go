@@ -0,0 +1,35 @@
+package packetbuf
+
+import "testing"
+
+func TestGetReturnsRequestedLength(t *testing.T) {
+	for _, size := range []int{0, 1, 1500, minSize, minSize + 1, maxSize, maxSize + 1} {
+		b := Get(size)
+		if len(b.Bytes()) != size {
+			t.Fatalf("Get(%d) has length %d", size, len(b.Bytes()))
+		}
+		b.Release()
+	}
+}
+
+func TestBufferHasCapacityOfSizeClass(t *testing.T) {
+	b := Get(minSize + 1)
+	defer b.Release()
+	if cap(b.Bytes()) != 2*minSize {
+		t.Fatalf("Get(%d) has capacity %d, want %d", minSize+1, cap(b.Bytes()), 2*minSize)
+	}
+}
+
+func TestGetDoesNotAllocateAfterWarmUp(t *testing.T) {
+	Get(1500).Release()
+	if allocs := testing.AllocsPerRun(1000, func() { Get(1500).Release() }); allocs != 0 {
+		t.Fatalf("Get and Release allocated %v times, want 0", allocs)
+	}
+}
+
+func BenchmarkGetRelease(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Get(1500).Release()
+	}
+}
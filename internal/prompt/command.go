@@ -0,0 +1,55 @@
+package prompt
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/mythologyli/zju-connect/internal/extcmd"
+)
+
+// Command runs an external program for each prompt and uses the first line of
+// its standard output as the answer. The request is passed in the environment
+// as ZJU_CONNECT_PROMPT_KIND, _MESSAGE, _URL and _IMAGE (the path of a
+// temporary file holding the image).
+type Command struct {
+	cmd extcmd.Command
+}
+
+// NewCommand returns a Command running commandLine, see extcmd.Parse.
+func NewCommand(commandLine string) (*Command, error) {
+	cmd, err := extcmd.Parse(commandLine)
+	if err != nil {
+		return nil, fmt.Errorf("prompt command: %w", err)
+	}
+	return &Command{cmd: cmd}, nil
+}
+
+func (c *Command) Prompt(ctx context.Context, req Request) (string, error) {
+	env := []string{
+		envPrefix + "KIND=" + string(req.Kind),
+		envPrefix + "MESSAGE=" + req.Message,
+		envPrefix + "URL=" + req.URL,
+	}
+	if req.Image != nil {
+		imageFile, err := os.CreateTemp("", "zju-connect-prompt-*")
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(imageFile.Name())
+		_, err = imageFile.Write(req.Image)
+		if closeErr := imageFile.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return "", err
+		}
+		env = append(env, envPrefix+"IMAGE="+imageFile.Name())
+	}
+
+	answer, err := c.cmd.Run(ctx, env, nil)
+	if err != nil {
+		return "", fmt.Errorf("prompt command failed: %w", err)
+	}
+	return answer, nil
+}
@@ -0,0 +1,93 @@
+package prompt
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mythologyli/zju-connect/log"
+)
+
+const filePollInterval = 500 * time.Millisecond
+
+// File reads answers from a named pipe or a regular file. For a named pipe the
+// first line written to it is the answer. A regular file is removed before
+// each prompt, and the answer is its first line once it appears; it is removed
+// again after reading so that it is never used twice.
+type File struct {
+	path string
+}
+
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+func (f *File) Prompt(ctx context.Context, req Request) (string, error) {
+	info, err := os.Stat(f.path)
+	if err == nil && info.Mode()&os.ModeNamedPipe != 0 {
+		log.Printf("%s (write the answer to %s)", req.Message, f.path)
+		return f.readPipe(ctx)
+	}
+	if err == nil {
+		if err := os.Remove(f.path); err != nil {
+			return "", err
+		}
+	}
+
+	log.Printf("%s (write the answer to %s)", req.Message, f.path)
+	ticker := time.NewTicker(filePollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-ticker.C:
+		}
+		content, err := os.ReadFile(f.path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if !strings.Contains(string(content), "\n") {
+			// Wait for the writer to finish the line.
+			continue
+		}
+		_ = os.Remove(f.path)
+		line, _, _ := strings.Cut(string(content), "\n")
+		return strings.TrimSpace(line), nil
+	}
+}
+
+func (f *File) readPipe(ctx context.Context) (string, error) {
+	type result struct {
+		line string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		// Opening a named pipe for reading blocks until a writer opens it.
+		pipe, err := os.Open(f.path)
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		defer pipe.Close()
+		line, err := bufio.NewReader(pipe).ReadString('\n')
+		if line != "" {
+			err = nil
+		}
+		done <- result{line: strings.TrimSpace(line), err: err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case r := <-done:
+		return r.line, r.err
+	}
+}
@@ -0,0 +1,98 @@
+package prompt
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+
+	"github.com/mythologyli/zju-connect/log"
+)
+
+var formTemplate = template.Must(template.New("form").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="UTF-8">
+<meta name="viewport" content="width=device-width, initial-scale=1.0">
+<title>zju-connect</title>
+<style>
+  body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Roboto, sans-serif; background: #f0f2f5; display: flex; justify-content: center; padding-top: 10vh; }
+  form { background: #fff; border-radius: 12px; box-shadow: 0 2px 12px rgba(0,0,0,0.1); padding: 32px; max-width: 480px; }
+  p { color: #333; word-break: break-all; }
+  img { display: block; margin: 12px 0; }
+  input[type=text] { width: 100%; padding: 8px; box-sizing: border-box; margin-bottom: 12px; }
+  button { width: 100%; padding: 10px; background: #1890ff; color: #fff; border: none; border-radius: 6px; font-size: 16px; }
+</style>
+</head>
+<body>
+<form method="post" action="/submit">
+  <p>{{.Message}}</p>
+  {{if .URL}}<p><a href="{{.URL}}" target="_blank" rel="noopener">{{.URL}}</a></p>{{end}}
+  {{if .Image}}<img src="/image" alt="">{{end}}
+  <input type="text" name="value" autofocus autocomplete="off">
+  <button type="submit">Submit</button>
+</form>
+</body>
+</html>`))
+
+// HTTPForm serves a one-off web form for each prompt and waits for it to be
+// submitted.
+type HTTPForm struct {
+	bind string
+}
+
+// NewHTTPForm listens on bind for each prompt; an empty bind means a random
+// port on the loopback interface.
+func NewHTTPForm(bind string) *HTTPForm {
+	if bind == "" {
+		bind = "127.0.0.1:0"
+	}
+	return &HTTPForm{bind: bind}
+}
+
+func (f *HTTPForm) Prompt(ctx context.Context, req Request) (string, error) {
+	answerCh := make(chan string, 1)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = formTemplate.Execute(w, req)
+	})
+	if req.Image != nil {
+		mux.HandleFunc("GET /image", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", http.DetectContentType(req.Image))
+			w.Header().Set("Cache-Control", "no-store")
+			_, _ = w.Write(req.Image)
+		})
+	}
+	mux.HandleFunc("POST /submit", func(w http.ResponseWriter, r *http.Request) {
+		value := r.FormValue("value")
+		if value == "" {
+			http.Error(w, "empty value", http.StatusBadRequest)
+			return
+		}
+		select {
+		case answerCh <- value:
+			_, _ = w.Write([]byte("ok"))
+		default:
+			http.Error(w, "already submitted", http.StatusConflict)
+		}
+	})
+
+	listener, err := net.Listen("tcp", f.bind)
+	if err != nil {
+		return "", fmt.Errorf("failed to start prompt server: %w", err)
+	}
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+
+	log.Printf("%s (open http://%s to answer)", req.Message, listener.Addr())
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case answer := <-answerCh:
+		return answer, nil
+	}
+}
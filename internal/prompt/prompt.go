@@ -0,0 +1,63 @@
+// Package prompt asks the user for the values needed during interactive
+// authentication, such as SMS codes, TOTP tokens and login callbacks.
+package prompt
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+type Kind string
+
+const (
+	KindSMSCode        Kind = "sms_code"
+	KindTOTP           Kind = "totp"
+	KindRadiusToken    Kind = "radius_token"
+	KindRandCode       Kind = "rand_code"
+	KindGraphCheckCode Kind = "graph_check_code"
+	KindCASCallback    Kind = "cas_callback"
+	KindOAuth2Callback Kind = "oauth2_callback"
+)
+
+const envPrefix = "ZJU_CONNECT_PROMPT_"
+
+var ErrEmptyAnswer = errors.New("empty answer")
+
+// Request describes a value the user has to provide.
+type Request struct {
+	Kind    Kind
+	Message string
+	// URL is a page the user has to visit first, e.g. the CAS login page.
+	URL string
+	// Image is shown to the user when set, e.g. a captcha.
+	Image []byte
+}
+
+type Prompter interface {
+	Prompt(ctx context.Context, req Request) (string, error)
+}
+
+// Func adapts a function to Prompter.
+type Func func(ctx context.Context, req Request) (string, error)
+
+func (f Func) Prompt(ctx context.Context, req Request) (string, error) {
+	return f(ctx, req)
+}
+
+// Ask prompts with p, or with the terminal when p is nil, and returns the
+// trimmed answer. An empty answer is an error.
+func Ask(ctx context.Context, p Prompter, req Request) (string, error) {
+	if p == nil {
+		p = Default
+	}
+	answer, err := p.Prompt(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	answer = strings.TrimSpace(answer)
+	if answer == "" {
+		return "", ErrEmptyAnswer
+	}
+	return answer, nil
+}
@@ -0,0 +1,331 @@
+package prompt
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mythologyli/zju-connect/internal/extcmd"
+)
+
+func TestAskTrimsAndRejectsEmpty(t *testing.T) {
+	answer, err := Ask(context.Background(), NewTerminal(strings.NewReader("  123456 \n")), Request{Kind: KindSMSCode})
+	if err != nil || answer != "123456" {
+		t.Fatalf("Ask() = %q, %v", answer, err)
+	}
+
+	_, err = Ask(context.Background(), NewTerminal(strings.NewReader("\n")), Request{Kind: KindSMSCode})
+	if !errors.Is(err, ErrEmptyAnswer) {
+		t.Fatalf("Ask() error = %v, want ErrEmptyAnswer", err)
+	}
+}
+
+func TestTerminalReadsOneLinePerPrompt(t *testing.T) {
+	terminal := NewTerminal(strings.NewReader("first\nsecond"))
+	for _, want := range []string{"first", "second"} {
+		got, err := terminal.Prompt(context.Background(), Request{})
+		if err != nil || got != want {
+			t.Fatalf("Prompt() = %q, %v, want %q", got, err, want)
+		}
+	}
+	if _, err := terminal.Prompt(context.Background(), Request{}); !errors.Is(err, io.EOF) {
+		t.Fatalf("Prompt() error = %v, want EOF", err)
+	}
+}
+
+func TestCommandReceivesRequest(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	if _, err := NewCommand("  "); err == nil {
+		t.Fatal("NewCommand accepted an empty command line")
+	}
+	command := &Command{cmd: extcmd.Command{Name: "sh", Args: []string{"-c", `printf '%s:%s:%s\nignored\n' "$ZJU_CONNECT_PROMPT_KIND" "$ZJU_CONNECT_PROMPT_URL" "$(cat "$ZJU_CONNECT_PROMPT_IMAGE")"`}}}
+
+	answer, err := command.Prompt(context.Background(), Request{
+		Kind:  KindCASCallback,
+		URL:   "https://cas.example/login",
+		Image: []byte("img"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "cas_callback:https://cas.example/login:img"; answer != want {
+		t.Fatalf("Prompt() = %q, want %q", answer, want)
+	}
+
+	failing := &Command{cmd: extcmd.Command{Name: "sh", Args: []string{"-c", "echo denied >&2; exit 3"}}}
+	if _, err := failing.Prompt(context.Background(), Request{}); err == nil || !strings.Contains(err.Error(), "denied") {
+		t.Fatalf("Prompt() error = %v, want stderr in error", err)
+	}
+}
+
+func TestFileWaitsForAnswer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "answer")
+	if err := os.WriteFile(path, []byte("stale\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan string, 1)
+	go func() {
+		answer, err := NewFile(path).Prompt(context.Background(), Request{})
+		if err != nil {
+			t.Error(err)
+		}
+		done <- answer
+	}()
+
+	// Give the prompter time to remove the stale answer before writing.
+	time.Sleep(2 * filePollInterval)
+	if err := os.WriteFile(path, []byte("fresh\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case answer := <-done:
+		if answer != "fresh" {
+			t.Fatalf("Prompt() = %q, want fresh", answer)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out")
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("answer file still exists: %v", err)
+	}
+}
+
+func TestFileReadsNamedPipe(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("named pipes are not files on windows")
+	}
+	path := filepath.Join(t.TempDir(), "fifo")
+	if err := syscall.Mkfifo(path, 0o600); err != nil {
+		t.Skip(err)
+	}
+
+	go func() {
+		pipe, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			return
+		}
+		defer pipe.Close()
+		_, _ = pipe.WriteString("654321\n")
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	answer, err := NewFile(path).Prompt(ctx, Request{})
+	if err != nil || answer != "654321" {
+		t.Fatalf("Prompt() = %q, %v", answer, err)
+	}
+}
+
+func TestQueueAnswer(t *testing.T) {
+	queue := NewQueue()
+	done := make(chan string, 1)
+	go func() {
+		answer, err := queue.Prompt(context.Background(), Request{Kind: KindTOTP, Message: "TOTP", Image: []byte("png")})
+		if err != nil {
+			t.Error(err)
+		}
+		done <- answer
+	}()
+
+	var pending []Pending
+	for deadline := time.Now().Add(5 * time.Second); len(pending) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("prompt never queued")
+		}
+		time.Sleep(10 * time.Millisecond)
+		pending = queue.Pending()
+	}
+	if pending[0].Kind != KindTOTP || !pending[0].HasImage {
+		t.Fatalf("unexpected pending prompt %+v", pending[0])
+	}
+	if image, ok := queue.Image(pending[0].ID); !ok || string(image) != "png" {
+		t.Fatalf("Image() = %q, %v", image, ok)
+	}
+	if err := queue.Answer("missing", "x"); !errors.Is(err, ErrUnknownPrompt) {
+		t.Fatalf("Answer() error = %v, want ErrUnknownPrompt", err)
+	}
+	if err := queue.Answer(pending[0].ID, "000000"); err != nil {
+		t.Fatal(err)
+	}
+	if answer := <-done; answer != "000000" {
+		t.Fatalf("Prompt() = %q", answer)
+	}
+	if err := queue.Answer(pending[0].ID, "000000"); !errors.Is(err, ErrUnknownPrompt) {
+		t.Fatalf("second Answer() error = %v, want ErrUnknownPrompt", err)
+	}
+}
+
+func TestQueueCancel(t *testing.T) {
+	queue := NewQueue()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := queue.Prompt(ctx, Request{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Prompt() error = %v", err)
+	}
+	if len(queue.Pending()) != 0 {
+		t.Fatal("cancelled prompt is still pending")
+	}
+}
+
+func TestHTTPFormSubmit(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	done := make(chan string, 1)
+	go func() {
+		answer, err := NewHTTPForm(addr).Prompt(context.Background(), Request{Message: "Code <b>", Image: []byte("\x89PNG\r\n\x1a\n")})
+		if err != nil {
+			t.Error(err)
+		}
+		done <- answer
+	}()
+
+	var resp *http.Response
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		resp, err = http.Get("http://" + addr + "/")
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(page), "Code &lt;b&gt;") || !strings.Contains(string(page), `src="/image"`) {
+		t.Fatalf("unexpected page %s", page)
+	}
+
+	resp, err = http.Get("http://" + addr + "/image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "image/png" {
+		t.Fatalf("image Content-Type = %q", resp.Header.Get("Content-Type"))
+	}
+
+	resp, err = http.PostForm("http://"+addr+"/submit", url.Values{"value": {"abcd"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if answer := <-done; answer != "abcd" {
+		t.Fatalf("Prompt() = %q", answer)
+	}
+}
+
+func TestSMSInboxExtract(t *testing.T) {
+	inbox, err := NewSMSInbox(nil, "secret", nil, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for message, want := range map[string]string{
+		"【浙江大学】您的验证码为：482913，5分钟内有效。":                        "482913",
+		"Your verification code is A7K2Q9. Do not share it.": "A7K2Q9",
+		"Login 123456 from new device":                       "123456",
+		"Your code for login is 123456":                      "123456",
+	} {
+		if got, ok := inbox.Extract(message); !ok || got != want {
+			t.Errorf("Extract(%q) = %q, %v, want %q", message, got, ok, want)
+		}
+	}
+	if _, ok := inbox.Extract("hello"); ok {
+		t.Error("Extract found a code in a message without one")
+	}
+	if _, err := NewSMSInbox(nil, "", nil, time.Second); err == nil {
+		t.Error("NewSMSInbox accepted an empty token")
+	}
+}
+
+func TestSMSInboxAnswersPrompt(t *testing.T) {
+	fallback := Func(func(ctx context.Context, req Request) (string, error) {
+		return "typed", nil
+	})
+	inbox, err := NewSMSInbox(fallback, "secret", []string{`code (\d+)`}, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(inbox)
+	defer srv.Close()
+
+	if answer, err := inbox.Prompt(context.Background(), Request{Kind: KindTOTP}); err != nil || answer != "typed" {
+		t.Fatalf("TOTP Prompt() = %q, %v", answer, err)
+	}
+
+	done := make(chan string, 1)
+	go func() {
+		answer, err := inbox.Prompt(context.Background(), Request{Kind: KindSMSCode})
+		if err != nil {
+			t.Error(err)
+		}
+		done <- answer
+	}()
+
+	resp, err := http.Post(srv.URL, "application/json", strings.NewReader(`{"text": "code 4242"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unauthenticated status = %d", resp.StatusCode)
+	}
+
+	// Let the prompt start waiting so that the code is delivered to it.
+	time.Sleep(50 * time.Millisecond)
+	resp, err = http.PostForm(srv.URL+"?token=secret", url.Values{"message": {"your code 4242"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	if answer := <-done; answer != "4242" {
+		t.Fatalf("Prompt() = %q", answer)
+	}
+
+	// A code arriving before the prompt is kept for it.
+	req, _ := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader("code 777"))
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if answer, err := inbox.Prompt(context.Background(), Request{Kind: KindSMSCode}); err != nil || answer != "777" {
+		t.Fatalf("Prompt() = %q, %v", answer, err)
+	}
+}
+
+func TestSMSInboxFallsBackOnTimeout(t *testing.T) {
+	fallback := Func(func(ctx context.Context, req Request) (string, error) {
+		return "typed", nil
+	})
+	inbox, err := NewSMSInbox(fallback, "secret", nil, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer, err := inbox.Prompt(context.Background(), Request{Kind: KindSMSCode}); err != nil || answer != "typed" {
+		t.Fatalf("Prompt() = %q, %v", answer, err)
+	}
+}
@@ -0,0 +1,112 @@
+package prompt
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mythologyli/zju-connect/log"
+)
+
+var ErrUnknownPrompt = errors.New("unknown or already answered prompt")
+
+// Pending is a prompt waiting in a Queue.
+type Pending struct {
+	ID       string    `json:"id"`
+	Kind     Kind      `json:"kind"`
+	Message  string    `json:"message"`
+	URL      string    `json:"url,omitempty"`
+	HasImage bool      `json:"has_image"`
+	Created  time.Time `json:"created"`
+}
+
+type queuedPrompt struct {
+	pending Pending
+	image   []byte
+	answer  chan string
+}
+
+// Queue keeps prompts until they are answered from elsewhere, e.g. the admin
+// API.
+type Queue struct {
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[string]*queuedPrompt
+}
+
+func NewQueue() *Queue {
+	return &Queue{pending: make(map[string]*queuedPrompt)}
+}
+
+func (q *Queue) Prompt(ctx context.Context, req Request) (string, error) {
+	q.mu.Lock()
+	q.nextID++
+	id := strconv.FormatUint(q.nextID, 10)
+	queued := &queuedPrompt{
+		pending: Pending{
+			ID:       id,
+			Kind:     req.Kind,
+			Message:  req.Message,
+			URL:      req.URL,
+			HasImage: req.Image != nil,
+			Created:  time.Now(),
+		},
+		image:  req.Image,
+		answer: make(chan string, 1),
+	}
+	q.pending[id] = queued
+	q.mu.Unlock()
+
+	defer func() {
+		q.mu.Lock()
+		delete(q.pending, id)
+		q.mu.Unlock()
+	}()
+
+	log.Printf("%s (answer prompt %s via the admin API)", req.Message, id)
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case answer := <-queued.answer:
+		return answer, nil
+	}
+}
+
+// Pending lists the prompts waiting for an answer, oldest first.
+func (q *Queue) Pending() []Pending {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	pending := make([]Pending, 0, len(q.pending))
+	for _, queued := range q.pending {
+		pending = append(pending, queued.pending)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].Created.Before(pending[j].Created)
+	})
+	return pending
+}
+
+func (q *Queue) Image(id string) ([]byte, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	queued, ok := q.pending[id]
+	if !ok || queued.image == nil {
+		return nil, false
+	}
+	return queued.image, true
+}
+
+func (q *Queue) Answer(id, answer string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	queued, ok := q.pending[id]
+	if !ok {
+		return ErrUnknownPrompt
+	}
+	delete(q.pending, id)
+	queued.answer <- answer
+	return nil
+}
@@ -0,0 +1,211 @@
+package prompt
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mythologyli/zju-connect/log"
+)
+
+// DefaultSMSPatterns extract the code from messages like "您的验证码为 123456"
+// or "Your verification code is 123456". Codes contain a digit, so that words
+// like "for" in "Your code for login is 123456" are skipped.
+var DefaultSMSPatterns = []string{
+	`(?i)(?:验证码|校验码|动态码|code)\D{0,16}?\b([A-Za-z]{0,7}\d[0-9A-Za-z]{0,7})\b`,
+	`\b(\d{6})\b`,
+}
+
+// smsGrace accepts codes that arrived shortly before the prompt: the SMS is
+// sent by the server before the login step asks for it, and a fast forwarder
+// may deliver it first.
+const smsGrace = time.Minute
+
+const maxSMSBody = 64 << 10
+
+// SMSInbox answers SMS code prompts with codes pushed to it over HTTP, e.g. by
+// an Android SMS forwarder. Other prompts, and SMS prompts that see no code
+// within the timeout, are passed on to the next prompter.
+type SMSInbox struct {
+	next     Prompter
+	token    string
+	patterns []*regexp.Regexp
+	timeout  time.Duration
+
+	mu       sync.Mutex
+	waiters  map[chan string]struct{}
+	last     string
+	lastTime time.Time
+}
+
+// NewSMSInbox creates an inbox that authenticates senders with token and
+// extracts codes with the first matching pattern. A pattern's first capturing
+// group is the code, or the whole match when it has none. Empty patterns means
+// DefaultSMSPatterns.
+func NewSMSInbox(next Prompter, token string, patterns []string, timeout time.Duration) (*SMSInbox, error) {
+	if token == "" {
+		return nil, errors.New("SMS inbox requires a token")
+	}
+	if len(patterns) == 0 {
+		patterns = DefaultSMSPatterns
+	}
+	inbox := &SMSInbox{
+		next:    next,
+		token:   token,
+		timeout: timeout,
+		waiters: make(map[chan string]struct{}),
+	}
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SMS pattern %q: %w", pattern, err)
+		}
+		inbox.patterns = append(inbox.patterns, re)
+	}
+	return inbox, nil
+}
+
+// Extract returns the code found in an SMS message.
+func (i *SMSInbox) Extract(message string) (string, bool) {
+	for _, re := range i.patterns {
+		match := re.FindStringSubmatch(message)
+		if match == nil {
+			continue
+		}
+		if len(match) > 1 && match[1] != "" {
+			return match[1], true
+		}
+		return match[0], true
+	}
+	return "", false
+}
+
+func (i *SMSInbox) Prompt(ctx context.Context, req Request) (string, error) {
+	if req.Kind != KindSMSCode {
+		return Ask(ctx, i.next, req)
+	}
+
+	code := make(chan string, 1)
+	i.mu.Lock()
+	if i.last != "" && time.Since(i.lastTime) < smsGrace {
+		last := i.last
+		i.last = ""
+		i.mu.Unlock()
+		log.Println("SMS code taken from the SMS inbox")
+		return last, nil
+	}
+	i.waiters[code] = struct{}{}
+	i.mu.Unlock()
+	defer func() {
+		i.mu.Lock()
+		delete(i.waiters, code)
+		i.mu.Unlock()
+	}()
+
+	log.Printf("%s (waiting %v for the SMS inbox)", req.Message, i.timeout)
+	timer := time.NewTimer(i.timeout)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case answer := <-code:
+		log.Println("SMS code received by the SMS inbox")
+		return answer, nil
+	case <-timer.C:
+		log.Println("No SMS code received by the SMS inbox, asking instead")
+		return Ask(ctx, i.next, req)
+	}
+}
+
+// deliver hands code to every waiting prompt, or keeps it for the next one.
+func (i *SMSInbox) deliver(code string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if len(i.waiters) == 0 {
+		i.last = code
+		i.lastTime = time.Now()
+		return
+	}
+	for waiter := range i.waiters {
+		select {
+		case waiter <- code:
+		default:
+		}
+		delete(i.waiters, waiter)
+	}
+}
+
+// ServeHTTP accepts POST requests carrying an SMS message, either as JSON with
+// a "text", "message", "content" or "body" field, as a form with one of these
+// fields, or as a plain text body. The token is taken from a Bearer
+// Authorization header or the token query parameter, as many forwarders can
+// only be given a URL.
+func (i *SMSInbox) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		token = r.URL.Query().Get("token")
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(i.token)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	message, err := readSMSMessage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	code, ok := i.Extract(message)
+	if !ok {
+		http.Error(w, "no code found", http.StatusUnprocessableEntity)
+		return
+	}
+	i.deliver(code)
+	_, _ = w.Write([]byte("ok"))
+}
+
+var smsMessageFields = []string{"text", "message", "content", "body", "msg"}
+
+func readSMSMessage(r *http.Request) (string, error) {
+	r.Body = http.MaxBytesReader(nil, r.Body, maxSMSBody)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		for _, field := range smsMessageFields {
+			if value := r.FormValue(field); value != "" {
+				return value, nil
+			}
+		}
+		return "", errors.New("no message field in form")
+	case "application/json":
+		var fields map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+			return "", err
+		}
+		for _, field := range smsMessageFields {
+			if value, ok := fields[field].(string); ok && value != "" {
+				return value, nil
+			}
+		}
+		return "", errors.New("no message field in JSON")
+	default:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return "", err
+		}
+		return string(body), nil
+	}
+}
@@ -0,0 +1,42 @@
+package prompt
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/mythologyli/zju-connect/log"
+)
+
+// Default is the terminal prompter on the standard input.
+var Default Prompter = NewTerminal(os.Stdin)
+
+// Terminal reads answers line by line. A pending read cannot be interrupted,
+// so ctx is only checked before reading.
+type Terminal struct {
+	mu     sync.Mutex
+	reader *bufio.Reader
+}
+
+func NewTerminal(in io.Reader) *Terminal {
+	return &Terminal{reader: bufio.NewReader(in)}
+}
+
+func (t *Terminal) Prompt(ctx context.Context, req Request) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	log.Print(req.Message)
+	line, err := t.reader.ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
@@ -0,0 +1,32 @@
+// Package rtnetlink manages IPv4 routes, policy routing rules and addresses
+// of network interfaces through the Linux rtnetlink socket, without running
+// ip(8).
+package rtnetlink
+
+import "net/netip"
+
+// Protocol marks the routes added by this package, so that routes left behind
+// by a crashed process can be found and removed. It is unused by iproute2 and
+// routing daemons.
+const Protocol = 122
+
+// Route is a route through a network interface.
+type Route struct {
+	Dst       netip.Prefix
+	LinkIndex int
+	// Src is the preferred source address. The kernel removes the route when
+	// the address is removed from the interface.
+	Src netip.Addr
+	// Table is the routing table, 0 means the main table.
+	Table uint32
+}
+
+// Rule is a policy routing rule that looks up Table for packets whose
+// firewall mark is Fwmark, or is not Fwmark if Invert is set. A zero Fwmark
+// leaves the mark out of the rule.
+type Rule struct {
+	Priority uint32
+	Table    uint32
+	Fwmark   uint32
+	Invert   bool
+}
@@ -0,0 +1,116 @@
+//go:build linux
+
+package rtnetlink
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+
+	"github.com/sagernet/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// AddRoute adds r. It fails with unix.EEXIST if its table has a route to the
+// same destination, which may belong to someone else.
+func AddRoute(r Route) error {
+	return netlink.RouteAdd(netlinkRoute(r))
+}
+
+// ReplaceRoute adds r, replacing the route to the same destination in its
+// table.
+func ReplaceRoute(r Route) error {
+	return netlink.RouteReplace(netlinkRoute(r))
+}
+
+// DeleteRoute removes r. It fails with unix.ESRCH if there is no such route.
+func DeleteRoute(r Route) error {
+	return netlink.RouteDel(netlinkRoute(r))
+}
+
+// FlushRoutes removes the IPv4 routes through the interface linkIndex in all
+// tables that were added by this package, and returns how many were removed.
+// Routes of other interfaces, e.g. of another running process, are kept.
+func FlushRoutes(linkIndex int) (int, error) {
+	filter := &netlink.Route{LinkIndex: linkIndex, Protocol: Protocol, Table: unix.RT_TABLE_UNSPEC}
+	routes, err := netlink.RouteListFiltered(netlink.FAMILY_V4, filter, netlink.RT_FILTER_OIF|netlink.RT_FILTER_PROTOCOL|netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return 0, err
+	}
+	flushed := 0
+	for _, route := range routes {
+		if err := netlink.RouteDel(&route); err != nil && !errors.Is(err, unix.ESRCH) {
+			return flushed, err
+		}
+		flushed++
+	}
+	return flushed, nil
+}
+
+// AddRule adds r. It fails with unix.EEXIST if the same rule exists.
+func AddRule(r Rule) error {
+	return netlink.RuleAdd(netlinkRule(r))
+}
+
+// DeleteRule removes r. It fails with unix.ENOENT if there is no such rule.
+func DeleteRule(r Rule) error {
+	return netlink.RuleDel(netlinkRule(r))
+}
+
+// ReplaceAddr assigns the address prefix to the interface, or updates it if
+// it is assigned already.
+func ReplaceAddr(linkIndex int, prefix netip.Prefix) error {
+	return netlink.AddrReplace(netlinkLink(linkIndex), netlinkAddr(prefix))
+}
+
+// DeleteAddr removes the address prefix from the interface.
+func DeleteAddr(linkIndex int, prefix netip.Prefix) error {
+	return netlink.AddrDel(netlinkLink(linkIndex), netlinkAddr(prefix))
+}
+
+func netlinkRoute(r Route) *netlink.Route {
+	table := int(r.Table)
+	if table == 0 {
+		table = unix.RT_TABLE_MAIN
+	}
+	route := &netlink.Route{
+		LinkIndex: r.LinkIndex,
+		Scope:     netlink.SCOPE_LINK,
+		Dst:       ipNet(r.Dst.Masked()),
+		Protocol:  Protocol,
+		Table:     table,
+	}
+	if r.Src.IsValid() {
+		// netlink sends Src as the preferred source address.
+		route.Src = ipNet(netip.PrefixFrom(r.Src, 32))
+	}
+	return route
+}
+
+func netlinkRule(r Rule) *netlink.Rule {
+	rule := netlink.NewRule()
+	rule.Family = netlink.FAMILY_V4
+	rule.Priority = int(r.Priority)
+	rule.Table = int(r.Table)
+	rule.Invert = r.Invert
+	if r.Fwmark != 0 {
+		// The kernel matches the whole mark unless a mask is given.
+		rule.Mark = r.Fwmark
+		rule.MarkSet = true
+	}
+	return rule
+}
+
+// netlinkLink stands for the interface linkIndex, of which netlink only needs
+// the index.
+func netlinkLink(linkIndex int) netlink.Link {
+	return &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Index: linkIndex}}
+}
+
+func netlinkAddr(prefix netip.Prefix) *netlink.Addr {
+	return &netlink.Addr{IPNet: ipNet(prefix), Scope: unix.RT_SCOPE_UNIVERSE}
+}
+
+func ipNet(prefix netip.Prefix) *net.IPNet {
+	return &net.IPNet{IP: prefix.Addr().AsSlice(), Mask: net.CIDRMask(prefix.Bits(), prefix.Addr().BitLen())}
+}
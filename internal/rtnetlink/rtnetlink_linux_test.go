@@ -0,0 +1,47 @@
+//go:build linux
+
+package rtnetlink
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/sagernet/netlink"
+	"golang.org/x/sys/unix"
+)
+
+func TestNetlinkRoute(t *testing.T) {
+	route := netlinkRoute(Route{
+		Dst:       netip.MustParsePrefix("10.1.2.3/8"),
+		LinkIndex: 7,
+		Src:       netip.MustParseAddr("10.5.0.1"),
+		Table:     1000,
+	})
+	if route.Dst.String() != "10.0.0.0/8" {
+		t.Errorf("destination = %s, want the masked 10.0.0.0/8", route.Dst)
+	}
+	if !route.Src.IP.Equal(netip.MustParseAddr("10.5.0.1").AsSlice()) {
+		t.Errorf("preferred source = %s", route.Src)
+	}
+	if route.Table != 1000 || route.LinkIndex != 7 || route.Protocol != Protocol || route.Scope != netlink.SCOPE_LINK {
+		t.Errorf("route = %+v, want table 1000 through interface 7 with our protocol", route)
+	}
+
+	if route := netlinkRoute(Route{Dst: netip.MustParsePrefix("10.0.0.0/8"), LinkIndex: 7}); route.Table != unix.RT_TABLE_MAIN || route.Src != nil {
+		t.Errorf("route = %+v, want the main table without source", route)
+	}
+}
+
+func TestNetlinkRule(t *testing.T) {
+	rule := netlinkRule(Rule{Priority: 6200, Table: 1000, Fwmark: 1000, Invert: true})
+	if rule.Priority != 6200 || rule.Table != 1000 || rule.Family != netlink.FAMILY_V4 {
+		t.Errorf("rule = %+v, want an IPv4 lookup of table 1000 at 6200", rule)
+	}
+	if !rule.MarkSet || rule.Mark != 1000 || !rule.Invert {
+		t.Errorf("rule = %+v, want an inverted match of mark 1000", rule)
+	}
+
+	if rule := netlinkRule(Rule{Priority: 6200, Table: 1000}); rule.MarkSet {
+		t.Errorf("rule = %+v, want no mark", rule)
+	}
+}
@@ -0,0 +1,173 @@
+// Package securefile stores small secrets, such as login sessions, in files
+// that are only readable by their owner and optionally encrypted with a
+// passphrase.
+package securefile
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// An encrypted file is
+//
+//	magic | version | salt | nonce | AES-256-GCM ciphertext
+//
+// where the key is derived from the passphrase and salt with Argon2id, and
+// the header before the ciphertext is authenticated as additional data.
+const (
+	magic     = "ZJUCSEC"
+	version1  = 1
+	saltSize  = 16
+	nonceSize = 12
+	keySize   = 32
+	headerLen = len(magic) + 1 + saltSize + nonceSize
+
+	// Argon2id parameters of version 1, the second recommendation of
+	// RFC 9106.
+	argonTime    = 3
+	argonMemory  = 64 * 1024
+	argonThreads = 4
+)
+
+var (
+	// ErrNotEncrypted is returned by Decrypt for data that is not encrypted,
+	// and by ReadFile for a plaintext file when a passphrase is given.
+	ErrNotEncrypted = errors.New("data is not encrypted")
+	// ErrTampered means that the passphrase is wrong or the file was modified.
+	ErrTampered = errors.New("wrong key or file has been tampered with")
+	// ErrKeyRequired is returned by ReadFile for an encrypted file when no
+	// passphrase is given.
+	ErrKeyRequired = errors.New("file is encrypted, but no key is given")
+)
+
+// IsEncrypted reports whether data starts with the header of an encrypted
+// file.
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(magic))
+}
+
+// Encrypt encrypts plaintext with a key derived from passphrase.
+func Encrypt(passphrase string, plaintext []byte) ([]byte, error) {
+	header := make([]byte, headerLen)
+	copy(header, magic)
+	header[len(magic)] = version1
+	if _, err := rand.Read(header[len(magic)+1:]); err != nil {
+		return nil, err
+	}
+	salt := header[len(magic)+1 : len(magic)+1+saltSize]
+	nonce := header[len(magic)+1+saltSize:]
+
+	aead, err := newAEAD(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(header, nonce, plaintext, header), nil
+}
+
+// Decrypt decrypts data produced by Encrypt.
+func Decrypt(passphrase string, data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return nil, ErrNotEncrypted
+	}
+	if len(data) < headerLen {
+		return nil, ErrTampered
+	}
+	if v := data[len(magic)]; v != version1 {
+		return nil, fmt.Errorf("unsupported encrypted file version %d", v)
+	}
+	header := data[:headerLen]
+	salt := header[len(magic)+1 : len(magic)+1+saltSize]
+	nonce := header[len(magic)+1+saltSize:]
+
+	aead, err := newAEAD(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, nonce, data[headerLen:], header)
+	if err != nil {
+		return nil, ErrTampered
+	}
+	return plaintext, nil
+}
+
+func newAEAD(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key := argon2.IDKey([]byte(passphrase), salt, argonTime, argonMemory, argonThreads, keySize)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// ReadFile reads a file written by WriteFile. With an empty passphrase only
+// plaintext files can be read, and with a passphrase only encrypted ones,
+// unless migrate is set to accept a plaintext file once so that it is
+// encrypted on the next write. encrypted reports whether the file was
+// encrypted.
+func ReadFile(path, passphrase string, migrate bool) (data []byte, encrypted bool, err error) {
+	data, err = os.ReadFile(path)
+	if err != nil {
+		return nil, false, err
+	}
+	if !IsEncrypted(data) {
+		if passphrase != "" && !migrate {
+			return nil, false, ErrNotEncrypted
+		}
+		return data, false, nil
+	}
+	if passphrase == "" {
+		return nil, true, ErrKeyRequired
+	}
+	data, err = Decrypt(passphrase, data)
+	return data, true, err
+}
+
+// WriteFile writes data to path, encrypted when passphrase is not empty. The
+// file is created with mode 0600 next to path and renamed over it, so that
+// readers never see a partial file.
+func WriteFile(path, passphrase string, data []byte) error {
+	if passphrase != "" {
+		var err error
+		if data, err = Encrypt(passphrase, data); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpName := f.Name()
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmpName)
+		}
+	}()
+
+	// CreateTemp already uses 0600, but be explicit about it.
+	if err = f.Chmod(0600); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	err = os.Rename(tmpName, path)
+	return err
+}
@@ -0,0 +1,99 @@
+package securefile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	plaintext := []byte(`{"cookies":[{"name":"sid","value":"secret"}]}`)
+	data, err := Encrypt("passphrase", plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(data) {
+		t.Fatal("encrypted data has no header")
+	}
+
+	got, err := Decrypt("passphrase", data)
+	if err != nil || string(got) != string(plaintext) {
+		t.Fatalf("Decrypt() = %q, %v", got, err)
+	}
+	if _, err := Decrypt("wrong", data); !errors.Is(err, ErrTampered) {
+		t.Fatalf("Decrypt() with wrong key error = %v", err)
+	}
+	if _, err := Decrypt("passphrase", plaintext); !errors.Is(err, ErrNotEncrypted) {
+		t.Fatalf("Decrypt() of plaintext error = %v", err)
+	}
+
+	// Flipping any bit, in the header or the ciphertext, is detected.
+	for _, i := range []int{len(magic) + 1, headerLen - 1, headerLen, len(data) - 1} {
+		tampered := append([]byte(nil), data...)
+		tampered[i] ^= 1
+		if _, err := Decrypt("passphrase", tampered); !errors.Is(err, ErrTampered) {
+			t.Errorf("Decrypt() with byte %d modified error = %v", i, err)
+		}
+	}
+	if _, err := Decrypt("passphrase", data[:headerLen-1]); !errors.Is(err, ErrTampered) {
+		t.Errorf("Decrypt() of truncated data error = %v", err)
+	}
+
+	future := append([]byte(nil), data...)
+	future[len(magic)] = 2
+	if _, err := Decrypt("passphrase", future); err == nil || errors.Is(err, ErrTampered) {
+		t.Errorf("Decrypt() of unknown version error = %v", err)
+	}
+}
+
+func TestWriteReadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "client_data.json")
+	if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A plaintext file is only readable with a key when migrating it.
+	if _, _, err := ReadFile(path, "key", false); !errors.Is(err, ErrNotEncrypted) {
+		t.Fatalf("ReadFile() of plaintext file with key error = %v, want ErrNotEncrypted", err)
+	}
+	data, encrypted, err := ReadFile(path, "key", true)
+	if err != nil || encrypted || string(data) != "old" {
+		t.Fatalf("ReadFile() = %q, %v, %v", data, encrypted, err)
+	}
+
+	if err := WriteFile(path, "key", []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Fatalf("file mode = %v, want 0600", info.Mode().Perm())
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Fatalf("temporary files left behind: %v", entries)
+	}
+
+	data, encrypted, err = ReadFile(path, "key", false)
+	if err != nil || !encrypted || string(data) != "new" {
+		t.Fatalf("ReadFile() = %q, %v, %v", data, encrypted, err)
+	}
+	if _, _, err := ReadFile(path, "", false); !errors.Is(err, ErrKeyRequired) {
+		t.Fatalf("ReadFile() without key error = %v", err)
+	}
+	if _, _, err := ReadFile(path, "other", false); !errors.Is(err, ErrTampered) {
+		t.Fatalf("ReadFile() with wrong key error = %v", err)
+	}
+
+	if err := WriteFile(path, "", []byte("plain")); err != nil {
+		t.Fatal(err)
+	}
+	data, encrypted, err = ReadFile(path, "", false)
+	if err != nil || encrypted || string(data) != "plain" {
+		t.Fatalf("ReadFile() = %q, %v, %v", data, encrypted, err)
+	}
+}
@@ -23,3 +23,27 @@ func bindInterface(dialer *net.Dialer, interfaceName string) error {
 	}
 	return nil
 }
+
+// markSocket sets the firewall mark of the sockets dialer creates, keeping the
+// control function set by bindInterface.
+func markSocket(dialer *net.Dialer, fwmark uint32) {
+	if fwmark == 0 {
+		return
+	}
+	control := dialer.ControlContext
+	dialer.ControlContext = func(ctx context.Context, network, address string, conn syscall.RawConn) error {
+		if control != nil {
+			if err := control(ctx, network, address, conn); err != nil {
+				return err
+			}
+		}
+		var markErr error
+		err := conn.Control(func(fd uintptr) {
+			markErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_MARK, int(fwmark))
+		})
+		if err != nil {
+			return err
+		}
+		return markErr
+	}
+}
@@ -26,6 +26,7 @@ type Dialer struct {
 	requireBound   bool
 	capture        *pcapCapture
 	localDNSServer string
+	fwmark         uint32
 }
 
 type Options struct {
@@ -38,6 +39,9 @@ type Options struct {
 	// LocalDNSServer overrides the system DNS for VPN server hostname resolution.
 	// It must be an IP address with an optional port.
 	LocalDNSServer string
+	// Fwmark marks underlay sockets on Linux, so that policy routing can keep
+	// them out of the TUN interface.
+	Fwmark uint32
 }
 
 func (d *Dialer) DialTLSContext(ctx context.Context, network, address string, config *tls.Config) (*tls.Conn, error) {
@@ -69,7 +73,7 @@ func New(options ...Options) (*Dialer, error) {
 	if len(options) > 0 {
 		option = options[0]
 	}
-	d := &Dialer{autoDetect: option.AutoDetect}
+	d := &Dialer{autoDetect: option.AutoDetect, fwmark: option.Fwmark}
 	localDNSServer, err := normalizeLocalDNSServer(option.LocalDNSServer)
 	if err != nil {
 		return nil, err
@@ -137,7 +141,7 @@ func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.
 		}
 	}
 
-	conn, err := dialOnInterface(ctx, network, address, interfaceName, d.localDNSServer)
+	conn, err := dialOnInterface(ctx, network, address, interfaceName, d.localDNSServer, d.fwmark)
 	if err == nil {
 		return d.wrapCapture(conn), nil
 	}
@@ -147,7 +151,7 @@ func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.
 		return nil, err
 	}
 
-	conn, retryErr := dialOnInterface(ctx, network, address, refreshedInterface, d.localDNSServer)
+	conn, retryErr := dialOnInterface(ctx, network, address, refreshedInterface, d.localDNSServer, d.fwmark)
 	if retryErr != nil {
 		return nil, fmt.Errorf("dial underlay via %q failed after %q failed: %w", refreshedInterface, interfaceName, retryErr)
 	}
@@ -181,20 +185,21 @@ func (d *Dialer) wrapCapture(conn net.Conn) net.Conn {
 	return d.capture.Wrap(conn)
 }
 
-func dialContextOnInterface(ctx context.Context, network, address, interfaceName, localDNSServer string) (net.Conn, error) {
+func dialContextOnInterface(ctx context.Context, network, address, interfaceName, localDNSServer string, fwmark uint32) (net.Conn, error) {
 	nd := &net.Dialer{}
 	if interfaceName != "" {
 		if err := bindInterface(nd, interfaceName); err != nil {
 			return nil, fmt.Errorf("bind underlay interface %q: %w", interfaceName, err)
 		}
 	}
-	if interfaceName != "" || localDNSServer != "" {
-		nd.Resolver = newUnderlayResolver(interfaceName, localDNSServer)
+	markSocket(nd, fwmark)
+	if interfaceName != "" || localDNSServer != "" || fwmark != 0 {
+		nd.Resolver = newUnderlayResolver(interfaceName, localDNSServer, fwmark)
 	}
 	return nd.DialContext(ctx, network, address)
 }
 
-func newUnderlayResolver(interfaceName, localDNSServer string) *net.Resolver {
+func newUnderlayResolver(interfaceName, localDNSServer string, fwmark uint32) *net.Resolver {
 	return &net.Resolver{
 		PreferGo: true,
 		Dial: func(ctx context.Context, network, systemDNSServer string) (net.Conn, error) {
@@ -208,6 +213,7 @@ func newUnderlayResolver(interfaceName, localDNSServer string) *net.Resolver {
 					return nil, fmt.Errorf("bind local DNS interface %q: %w", interfaceName, err)
 				}
 			}
+			markSocket(dialer, fwmark)
 			return dialer.DialContext(ctx, network, target)
 		},
 	}
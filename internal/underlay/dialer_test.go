@@ -71,7 +71,7 @@ func TestAutoDetectionIsDeferredAndSharedByConcurrentFirstDials(t *testing.T) {
 		_ = client.Close()
 		_ = server.Close()
 	})
-	dialOnInterface = func(_ context.Context, _, _, gotInterface, _ string) (net.Conn, error) {
+	dialOnInterface = func(_ context.Context, _, _, gotInterface, _ string, _ uint32) (net.Conn, error) {
 		if gotInterface != interfaceName {
 			return nil, errors.New("dial did not use detected interface")
 		}
@@ -146,7 +146,7 @@ func TestDialContextRedetectsAndRetriesOnNewInterface(t *testing.T) {
 		_ = client.Close()
 		_ = server.Close()
 	})
-	dialOnInterface = func(_ context.Context, _, _, interfaceName, _ string) (net.Conn, error) {
+	dialOnInterface = func(_ context.Context, _, _, interfaceName, _ string, _ uint32) (net.Conn, error) {
 		attempts = append(attempts, interfaceName)
 		if interfaceName == "old-interface" {
 			return nil, firstErr
@@ -184,7 +184,7 @@ func TestDialContextDoesNotReplaceManualInterface(t *testing.T) {
 		return "new-interface"
 	}
 	wantErr := errors.New("manual interface failed")
-	dialOnInterface = func(_ context.Context, _, _, _, _ string) (net.Conn, error) {
+	dialOnInterface = func(_ context.Context, _, _, _, _ string, _ uint32) (net.Conn, error) {
 		return nil, wantErr
 	}
 
@@ -246,7 +246,7 @@ func TestUnderlayResolverUsesConfiguredLocalDNSServer(t *testing.T) {
 	}
 	defer listener.Close()
 
-	resolver := newUnderlayResolver("", listener.LocalAddr().String())
+	resolver := newUnderlayResolver("", listener.LocalAddr().String(), 0)
 	conn, err := resolver.Dial(t.Context(), "udp4", "192.0.2.53:53")
 	if err != nil {
 		t.Fatal(err)
@@ -266,7 +266,7 @@ func TestDialContextPassesConfiguredLocalDNSServer(t *testing.T) {
 		_ = client.Close()
 		_ = server.Close()
 	})
-	dialOnInterface = func(_ context.Context, _, _, _, localDNSServer string) (net.Conn, error) {
+	dialOnInterface = func(_ context.Context, _, _, _, localDNSServer string, _ uint32) (net.Conn, error) {
 		if localDNSServer != "223.5.5.5:53" {
 			return nil, fmt.Errorf("local DNS server = %q", localDNSServer)
 		}
@@ -318,6 +318,7 @@ func TestDialContextResolvesThroughConfiguredLocalDNSServer(t *testing.T) {
 		net.JoinHostPort("vpn-underlay.test", strconv.Itoa(port)),
 		"",
 		dnsConn.LocalAddr().String(),
+		0,
 	)
 	if err != nil {
 		t.Fatal(err)
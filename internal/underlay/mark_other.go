@@ -0,0 +1,8 @@
+//go:build !linux
+
+package underlay
+
+import "net"
+
+// markSocket does nothing, firewall marks only exist on Linux.
+func markSocket(_ *net.Dialer, _ uint32) {}
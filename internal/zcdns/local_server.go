@@ -12,3 +12,18 @@ type LocalServer interface {
 	HandleDnsMsg(ctx context.Context, msg *dns.Msg) (*dns.Msg, error)
 	CheckDnsHijack(dstIP net.IP) bool
 }
+
+type contextKey string
+
+var contextKeyClientAddr = contextKey("CLIENT_ADDR")
+
+// WithClientAddr records the address of the client that sent a DNS query, so
+// that a LocalServer can attribute queries it did not receive itself.
+func WithClientAddr(ctx context.Context, addr net.Addr) context.Context {
+	return context.WithValue(ctx, contextKeyClientAddr, addr)
+}
+
+func ClientAddrFromContext(ctx context.Context) (net.Addr, bool) {
+	addr, ok := ctx.Value(contextKeyClientAddr).(net.Addr)
+	return addr, ok
+}
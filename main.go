@@ -4,14 +4,17 @@ package main
 
 import (
 	"context"
-	"crypto"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"os/signal"
 	"runtime"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/containers/winquit/pkg/winquit"
 	"github.com/mythologyli/zju-connect/client"
@@ -19,23 +22,37 @@ import (
 	easyconnectclient "github.com/mythologyli/zju-connect/client/easyconnect"
 	"github.com/mythologyli/zju-connect/configs"
 	"github.com/mythologyli/zju-connect/dial"
+	"github.com/mythologyli/zju-connect/internal/captcha"
+	"github.com/mythologyli/zju-connect/internal/certificate"
 	"github.com/mythologyli/zju-connect/internal/hook_func"
+	"github.com/mythologyli/zju-connect/internal/ippool"
 	"github.com/mythologyli/zju-connect/internal/keylog"
+	"github.com/mythologyli/zju-connect/internal/prompt"
+	"github.com/mythologyli/zju-connect/internal/securefile"
 	"github.com/mythologyli/zju-connect/internal/underlay"
 	"github.com/mythologyli/zju-connect/log"
 	"github.com/mythologyli/zju-connect/resolve"
 	"github.com/mythologyli/zju-connect/service"
 	"github.com/mythologyli/zju-connect/stack"
 	"github.com/mythologyli/zju-connect/stack/gvisor"
+	"github.com/mythologyli/zju-connect/stack/hybrid"
 	"github.com/mythologyli/zju-connect/stack/tcptunnel"
 	"github.com/mythologyli/zju-connect/stack/tun"
-	"golang.org/x/crypto/pkcs12"
 	"inet.af/netaddr"
 )
 
 var conf configs.Config
 
+// logoutTimeout bounds how long shutdown waits for the server to end the
+// session.
+const logoutTimeout = 5 * time.Second
+
 func main() {
+	if atrustTrustDevice || atrustUntrustDevice {
+		setTrustedDevice()
+	}
+	resolveCredentials()
+
 	log.Init()
 
 	log.Println("Start ZJU Connect " + zjuConnectVersionString())
@@ -51,11 +68,81 @@ func main() {
 	if conf.Protocol != "easyconnect" && conf.Protocol != "atrust" {
 		log.Fatalf("Unsupported VPN protocol: %s", conf.Protocol)
 	}
+
+	var adminServer *service.AdminServer
+	if conf.AdminBind != "" {
+		// Serve before login so that the admin API can answer login prompts.
+		adminServer = service.NewAdminServer(conf.AdminToken)
+		go service.ServeAdmin(conf.AdminBind, adminServer)
+	}
+
+	var prompter prompt.Prompter
+	switch conf.Prompt {
+	case "", "terminal":
+	case "http":
+		prompter = prompt.NewHTTPForm(conf.PromptBind)
+	case "command":
+		commandPrompter, err := prompt.NewCommand(conf.PromptCommand)
+		if err != nil {
+			log.Fatalf("Create prompt command: %v", err)
+		}
+		prompter = commandPrompter
+	case "file":
+		if conf.PromptFile == "" {
+			log.Fatalf("prompt_file is required by the file prompt")
+		}
+		prompter = prompt.NewFile(conf.PromptFile)
+	case "admin":
+		if adminServer == nil {
+			log.Fatalf("admin_bind is required by the admin prompt")
+		}
+		promptQueue := prompt.NewQueue()
+		adminServer.HandlePrompt(promptQueue)
+		prompter = promptQueue
+	default:
+		log.Fatalf("Unsupported prompt: %s", conf.Prompt)
+	}
+
+	if conf.SMSInboxBind != "" {
+		smsInbox, err := prompt.NewSMSInbox(prompter, conf.SMSInboxToken, conf.SMSInboxPattern, time.Duration(conf.SMSInboxTimeout)*time.Second)
+		if err != nil {
+			log.Fatalf("Create SMS inbox: %v", err)
+		}
+		go service.ServeSMSInbox(conf.SMSInboxBind, smsInbox)
+		prompter = smsInbox
+	}
+
+	var captchaSolver captcha.Solver
+	switch conf.CaptchaSolver {
+	case "", "manual":
+	case "command":
+		commandSolver, err := captcha.NewCommand(conf.CaptchaCommand)
+		if err != nil {
+			log.Fatalf("Create captcha command: %v", err)
+		}
+		captchaSolver = commandSolver
+	case "webhook":
+		if conf.CaptchaWebhook == "" {
+			log.Fatalf("captcha_webhook is required by the webhook captcha solver")
+		}
+		captchaSolver = captcha.NewWebhook(conf.CaptchaWebhook, conf.CaptchaWebhookToken)
+	case "ocr":
+		model, err := captcha.LoadOCRModel(conf.CaptchaOCRModel)
+		if err != nil {
+			log.Fatalf("Load captcha OCR model: %v", err)
+		}
+		captchaSolver = model
+	default:
+		log.Fatalf("Unsupported captcha solver: %s", conf.CaptchaSolver)
+	}
+
+	tunRouteTable, tunFwmark := tunRoutePolicy(conf)
 	underlayDialer, underlayErr := underlay.New(underlay.Options{
 		InterfaceName:  conf.BindInterface,
 		AutoDetect:     conf.AutoDetectInterface,
 		DebugPCAPFile:  conf.DebugPCAPFile,
 		LocalDNSServer: conf.LocalDNSServer,
+		Fwmark:         tunFwmark,
 	})
 	if underlayErr != nil {
 		log.Fatalf("Create underlay dialer: %v", underlayErr)
@@ -71,28 +158,20 @@ func main() {
 	if conf.DebugTLSLogFile != "" {
 		log.Printf("TLS key logging enabled: %s", conf.DebugTLSLogFile)
 	}
-	var vpnClient client.Client
-	switch conf.Protocol {
-	case "easyconnect":
-		tlsCert := tls.Certificate{}
-		if conf.CertFile != "" {
-			p12Data, err := os.ReadFile(conf.CertFile)
-			if err != nil {
-				log.Fatalf("Read certificate file error: %s", err)
-			}
 
-			key, cert, err := pkcs12.Decode(p12Data, conf.CertPassword)
-			if err != nil {
-				log.Fatalf("Decode certificate file error: %s", err)
-			}
-
-			tlsCert = tls.Certificate{
-				Certificate: [][]byte{cert.Raw},
-				PrivateKey:  key.(crypto.PrivateKey),
-				Leaf:        cert,
-			}
+	tlsCert := tls.Certificate{}
+	if conf.CertFile != "" {
+		var err error
+		tlsCert, err = certificate.Load(conf.CertFile, conf.CertKeyFile, conf.CertPassword)
+		if err != nil {
+			log.Fatalf("Load certificate error: %s", err)
 		}
+		log.Printf("Client certificate: %s", tlsCert.Leaf.Subject)
+	}
 
+	var vpnClient client.Client
+	switch conf.Protocol {
+	case "easyconnect":
 		vpnClient = easyconnectclient.NewClient(
 			conf.ServerAddress+":"+fmt.Sprintf("%d", conf.ServerPort),
 			conf.Username,
@@ -106,6 +185,11 @@ func main() {
 			underlayDialer,
 			tlsKeyLogWriter,
 		)
+		vpnClient.(*easyconnectclient.Client).SetPrompter(prompter)
+		vpnClient.(*easyconnectclient.Client).SetCaptchaSolver(captchaSolver)
+		vpnClient.(*easyconnectclient.Client).SetCredentials(credentialResolver)
+		vpnClient.(*easyconnectclient.Client).SetSendConns(conf.SendConns)
+		vpnClient.(*easyconnectclient.Client).SetBatchWrites(conf.BatchWrites)
 
 		log.Printf("VPN protocol: %s", conf.Protocol)
 		err := vpnClient.(*easyconnectclient.Client).Setup(conf.GraphCodeFile)
@@ -130,7 +214,13 @@ func main() {
 
 		var clientData []byte
 		if conf.ClientDataFile != "" {
-			clientData, err = os.ReadFile(conf.ClientDataFile)
+			clientData, _, err = securefile.ReadFile(conf.ClientDataFile, conf.ClientDataKey, clientDataMigrate)
+			if errors.Is(err, securefile.ErrNotEncrypted) {
+				log.Fatalf("Read client data file error: %s. Run once with -client-data-migrate to encrypt it with client_data_key", err)
+			}
+			if errors.Is(err, securefile.ErrTampered) || errors.Is(err, securefile.ErrKeyRequired) {
+				log.Fatalf("Read client data file error: %s. Check client_data_key, or delete the file to log in again", err)
+			}
 			if err != nil {
 				log.Printf("Read client data file error: %s", err)
 				log.Println("Will create a new client data file if log in successfully")
@@ -138,6 +228,14 @@ func main() {
 		}
 
 		vpnClient = atrustclient.NewClient(conf.Username, conf.SID, conf.DeviceID, conf.SignKey, underlayDialer, tlsKeyLogWriter)
+		vpnClient.(*atrustclient.Client).SetPrompter(prompter)
+		vpnClient.(*atrustclient.Client).SetBrowserLogin(conf.BrowserLogin)
+		vpnClient.(*atrustclient.Client).SetCaptchaSolver(captchaSolver)
+		vpnClient.(*atrustclient.Client).SetCredentials(credentialResolver)
+		vpnClient.(*atrustclient.Client).SetClientCertificate(tlsCert)
+		vpnClient.(*atrustclient.Client).SetTCPTunnelPool(conf.TCPTunnelPoolSize, conf.TCPTunnelPoolPrewarm, time.Duration(conf.TCPTunnelPoolIdle)*time.Second)
+		vpnClient.(*atrustclient.Client).SetTCPTunnelUDP(conf.TCPTunnelUDP)
+		vpnClient.(*atrustclient.Client).SetL3TunnelConns(conf.L3TunnelConns, conf.L3TunnelMaxConns)
 
 		log.Printf("VPN protocol: %s", conf.Protocol)
 		clientData, err = vpnClient.(*atrustclient.Client).Setup(
@@ -166,15 +264,28 @@ func main() {
 		}
 
 		if conf.ClientDataFile != "" {
-			err = os.WriteFile(conf.ClientDataFile, clientData, 0644)
+			err = securefile.WriteFile(conf.ClientDataFile, conf.ClientDataKey, clientData)
 			if err != nil {
 				log.Fatalf("Write client data file error: %s", err)
 			}
-			log.Printf("Client data saved to %s", conf.ClientDataFile)
+			if conf.ClientDataKey != "" {
+				log.Printf("Client data saved encrypted to %s", conf.ClientDataFile)
+			} else {
+				log.Printf("Client data saved to %s, set client_data_key to encrypt it", conf.ClientDataFile)
+			}
 		}
 	}
 
 	log.Printf("VPN client started")
+	if logouter, ok := vpnClient.(client.Logouter); ok && conf.LogoutOnExit {
+		// Registered before CloseVPNClient, which stops the dialer the
+		// request goes through.
+		hook_func.RegisterTerminalFunc("LogoutVPNSession", func(ctx context.Context) error {
+			ctx, cancel := context.WithTimeout(ctx, logoutTimeout)
+			defer cancel()
+			return logouter.Logout(ctx)
+		})
+	}
 	if closer, ok := vpnClient.(interface{ Close() }); ok {
 		hook_func.RegisterTerminalFunc("CloseVPNClient", func(ctx context.Context) error {
 			closer.Close()
@@ -261,35 +372,71 @@ func main() {
 		}
 	}
 
+	stackTuning := newStackTuning(conf)
+	if err := stackTuning.Validate(); err != nil {
+		log.Fatalf("Stack tuning error: %s", err)
+	}
+
+	switch conf.TUNDNSConfig {
+	case "resolved", "resolv.conf", "off":
+	default:
+		log.Fatalf("Invalid TUN DNS config %q, must be resolved, resolv.conf or off", conf.TUNDNSConfig)
+	}
+
 	var vpnStack stack.Stack
+	var vpnTUNStack *tun.Stack
 	if conf.TCPTunnelMode {
 		vpnStack, err = tcptunnel.NewStack(vpnClient)
 		if err != nil {
 			log.Fatalf("TCP Tunnel stack setup error: %s", err)
 		}
+	} else if conf.HybridMode {
+		vpnStack, err = hybrid.NewStack(vpnClient, func() (stack.Stack, error) {
+			l3Stack, err := gvisor.NewStack(vpnClient, stackTuning)
+			if err != nil {
+				return nil, err
+			}
+			if err := l3Stack.Connect(); err != nil {
+				return nil, err
+			}
+			return l3Stack, nil
+		})
+		if err != nil {
+			log.Fatalf("Hybrid stack setup error: %s", err)
+		}
 	} else if conf.TUNMode {
-		vpnTUNStack, err := tun.NewStack(vpnClient, conf.DNSHijack, conf.FakeIP, ipResources)
+		vpnTUNStack, err = tun.NewStack(vpnClient, conf.DNSHijack, conf.FakeIP, ipResources, stackTuning)
 		if err != nil {
 			log.Fatalf("Tun stack setup error, make sure you are root user : %s", err)
 		}
 
+		if tunRouteTable != 0 {
+			if err := vpnTUNStack.SetRoutePolicy(tunRouteTable, tunFwmark); err != nil {
+				log.Fatalf("Set TUN routing table error: %s", err)
+			}
+		}
+
+		var routes []string
 		if conf.AddRoute && ipSet != nil {
 			for _, prefix := range ipSet.Prefixes() {
-				log.Printf("Add route to %s", prefix.String())
-				_ = vpnTUNStack.AddRoute(prefix.String())
+				routes = append(routes, prefix.String())
 			}
 		} else if !conf.AddRoute && !conf.DisableZJUConfig && conf.Protocol == "easyconnect" {
-			log.Println("Add route to 10.0.0.0/8")
-			_ = vpnTUNStack.AddRoute("10.0.0.0/8")
+			routes = append(routes, "10.0.0.0/8")
 		}
-
 		if conf.FakeIP {
-			_ = vpnTUNStack.AddRoute("198.18.0.0/16")
+			routes = append(routes, conf.FakeIPRange)
+		}
+		for _, route := range routes {
+			log.Printf("Add route to %s", route)
+			if err := vpnTUNStack.AddRoute(route); err != nil {
+				log.Printf("Add route to %s error: %s", route, err)
+			}
 		}
 
 		vpnStack = vpnTUNStack
 	} else {
-		vpnStack, err = gvisor.NewStack(vpnClient)
+		vpnStack, err = gvisor.NewStack(vpnClient, stackTuning)
 		if err != nil {
 			log.Fatalf("gVisor stack setup error: %s", err)
 		}
@@ -317,6 +464,17 @@ func main() {
 		}
 	}
 
+	// Hijacked DNS clients would get AAAA answers they cannot reach, as the
+	// TUN interface only carries IPv4.
+	if conf.TUNMode && conf.FakeIPRange6 != "" {
+		log.Fatalf("Fake IPv6 range %s cannot be used in TUN mode, which only supports IPv4", conf.FakeIPRange6)
+	}
+	fakeIPPool, err := ippool.NewIPPool[[]client.DomainResource](conf.FakeIPRange, conf.FakeIPRange6)
+	if err != nil {
+		log.Fatalf("Create Fake IP pool error: %s", err)
+	}
+	fakeIPPool.SetIdleTimeout(time.Duration(conf.FakeIPIdleTimeout) * time.Second)
+
 	vpnResolver := resolve.NewResolver(
 		vpnStack,
 		remoteDNSServer,
@@ -325,21 +483,94 @@ func main() {
 		domainResources,
 		dnsResource,
 		useRemoteDNS,
+		fakeIPPool,
 	)
 	hook_func.RegisterTerminalFunc("CloseResolver", func(ctx context.Context) error {
 		vpnResolver.Close()
 		return nil
 	})
 
+	if conf.FakeIPFile != "" {
+		loaded, err := fakeIPPool.Load(conf.FakeIPFile, vpnResolver.DomainResources)
+		if err != nil {
+			log.Printf("Load Fake IP file error: %s", err)
+		} else if loaded > 0 {
+			log.Printf("Restored %d Fake IP entries from %s", loaded, conf.FakeIPFile)
+		}
+		fakeIPSaveCtx, fakeIPSaveCancel := context.WithCancel(context.Background())
+		fakeIPSaveDone := make(chan struct{})
+		go func() {
+			defer close(fakeIPSaveDone)
+			fakeIPPool.AutoSave(fakeIPSaveCtx, conf.FakeIPFile, time.Minute, func(err error) {
+				log.Printf("Save Fake IP file error: %s", err)
+			})
+		}()
+		hook_func.RegisterTerminalFunc("SaveFakeIPFile", func(ctx context.Context) error {
+			fakeIPSaveCancel()
+			<-fakeIPSaveDone
+			return nil
+		})
+	}
+
+	var customDNSEntries []resolve.HostsEntry
 	for _, customDns := range conf.CustomDNSList {
-		ipAddr := net.ParseIP(customDns.IP)
-		if ipAddr == nil {
-			log.Printf("Custom DNS for host name %s is invalid, SKIP", customDns.HostName)
+		entry := resolve.HostsEntry{HostName: customDns.HostName, Alias: customDns.CNAME}
+		for _, ipString := range customDns.IPs {
+			ipAddr := net.ParseIP(ipString)
+			if ipAddr == nil {
+				log.Printf("Custom DNS %s for host name %s is invalid, SKIP", ipString, customDns.HostName)
+				continue
+			}
+			entry.IPs = append(entry.IPs, ipAddr)
+		}
+		if len(entry.IPs) == 0 && entry.Alias == "" {
+			continue
+		}
+		customDNSEntries = append(customDNSEntries, entry)
+		if entry.Alias != "" {
+			log.Printf("Add custom DNS: %s -> %s", customDns.HostName, entry.Alias)
+		} else {
+			log.Printf("Add custom DNS: %s -> %s", customDns.HostName, strings.Join(customDns.IPs, ", "))
+		}
+	}
+	if err := vpnResolver.SetCustomDNS(customDNSEntries); err != nil {
+		log.Fatalf("Custom DNS error: %s", err)
+	}
+	vpnResolver.SetStrict(conf.DNSStrict, conf.DNSStrictDomain)
+	if conf.DNSStrict && !useRemoteDNS {
+		log.Println("Warning: remote DNS is disabled, resource domains cannot be resolved in DNS strict mode")
+	}
+	if conf.HostsFile != "" {
+		if err := vpnResolver.LoadHostsFile(conf.HostsFile); err != nil {
+			log.Printf("Load hosts file error: %s", err)
 		}
-		vpnResolver.SetPermanentDNS(customDns.HostName, ipAddr)
-		log.Printf("Add custom DNS: %s -> %s\n", customDns.HostName, customDns.IP)
+		hostsWatchCtx, hostsWatchCancel := context.WithCancel(context.Background())
+		go vpnResolver.WatchHostsFile(hostsWatchCtx, conf.HostsFile, 5*time.Second)
+		hook_func.RegisterTerminalFunc("StopWatchingHostsFile", func(ctx context.Context) error {
+			hostsWatchCancel()
+			return nil
+		})
 	}
 	localResolver := service.NewDnsServer(vpnResolver, []string{remoteDNSServer, conf.SecondaryDNSServer})
+	if conf.DNSStrict {
+		localResolver = localResolver.WithHijackAll()
+	}
+	var dnsQueryLog *service.DNSQueryLog
+	if conf.DNSQueryLog || conf.DNSQueryLogFile != "" {
+		var dnsQueryLogWriter io.Writer
+		if conf.DNSQueryLogFile != "" {
+			dnsQueryLogFile, err := os.OpenFile(conf.DNSQueryLogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+			if err != nil {
+				log.Fatalf("Open DNS query log file error: %s", err)
+			}
+			dnsQueryLogWriter = dnsQueryLogFile
+			hook_func.RegisterTerminalFunc("CloseDNSQueryLogFile", func(ctx context.Context) error {
+				return dnsQueryLogFile.Close()
+			})
+		}
+		dnsQueryLog = service.NewDNSQueryLog(1000, dnsQueryLogWriter)
+		localResolver = localResolver.WithQueryLog(dnsQueryLog)
+	}
 	vpnStack.SetupResolve(localResolver)
 	vpnStack.SetupIPPool(vpnResolver.IPPool)
 
@@ -349,17 +580,54 @@ func main() {
 		conf.ProxyAll = false
 	}
 	vpnDialer := dial.NewDialer(vpnStack, vpnResolver, ipResources, conf.ProxyAll, conf.DialDirectProxy)
+	if pingHost != "" {
+		os.Exit(runPing(vpnDialer))
+	}
 
 	if conf.DNSServerBind != "" {
-		go service.ServeDNS(conf.DNSServerBind, localResolver)
+		dnsServer := localResolver
+		if conf.DNSServerFakeIP {
+			dnsServer = localResolver.WithFakeIP()
+		}
+		go service.ServeDNS(conf.DNSServerBind, dnsServer)
 	}
 	if conf.TUNMode {
 		clientIP, _ := vpnClient.IP()
-		go service.ServeDNS(clientIP.String()+":53", localResolver)
+		dnsCtx, stopDNS := context.WithCancel(context.Background())
+		go service.ServeDNSContext(dnsCtx, clientIP.String()+":53", localResolver)
+		if vpnTUNStack != nil {
+			// The system DNS points at the DNS server on the virtual IP, so
+			// it moves along with it.
+			vpnTUNStack.OnIPUpdate(func(ip net.IP) {
+				stopDNS()
+				dnsCtx, stopDNS = context.WithCancel(context.Background())
+				go service.ServeDNSContext(dnsCtx, ip.String()+":53", localResolver)
+			})
+		}
+		if vpnTUNStack != nil && conf.TUNDNSConfig != "off" {
+			domains := tunDNSDomains(conf, domainResources, dnsResource)
+			if err := vpnTUNStack.SetupSystemDNS(conf.TUNDNSConfig, clientIP, domains); err != nil {
+				log.Printf("Set system DNS for TUN interface error: %s", err)
+			}
+		}
+	}
+
+	if adminServer != nil {
+		adminServer.HandleFakeIP(vpnResolver.IPPool)
+		adminServer.HandlePing(vpnDialer)
+		if dnsQueryLog != nil {
+			adminServer.HandleDNSQueryLog(dnsQueryLog)
+		}
 	}
 
 	if conf.SocksBind != "" {
-		go service.ServeSocks5(conf.SocksBind, vpnDialer, vpnResolver, conf.SocksUser, conf.SocksPasswd)
+		// The TCP tunnel cannot ping, and in hybrid mode a ping starts the L3
+		// stack.
+		probeClosedPort := conf.SocksPortProbe && !conf.TCPTunnelMode && !conf.HybridMode
+		if conf.SocksPortProbe && !probeClosedPort {
+			log.Println("socks-probe-closed-port is ignored in TCP tunnel and hybrid mode")
+		}
+		go service.ServeSocks5(conf.SocksBind, vpnDialer, vpnResolver, conf.SocksUser, conf.SocksPasswd, probeClosedPort)
 	}
 
 	if conf.HTTPBind != "" {
@@ -413,3 +681,40 @@ func main() {
 		log.Println("Shutdown ZJU-Connect success, Bye~")
 	}
 }
+
+// runPing pings pingHost through the VPN like the system ping, logs out and
+// returns the exit code: 0 if a reply arrived, 1 if none did and 2 if the
+// host cannot be pinged at all.
+func runPing(pinger service.Pinger) int {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	fmt.Printf("PING %s through the VPN\n", pingHost)
+	result, err := service.Ping(ctx, pinger, pingHost, pingCount, pingTimeout, func(reply service.PingReply) {
+		if reply.Error != "" {
+			fmt.Printf("seq=%d %s\n", reply.Seq, reply.Error)
+		} else {
+			fmt.Printf("reply from %s: seq=%d time=%.3f ms\n", pingHost, reply.Seq, reply.RTT)
+		}
+	})
+	code := 0
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ZJU Connect: ping error:", err)
+		code = 2
+	} else {
+		fmt.Printf("\n--- %s (%s) ping statistics ---\n", result.Host, result.IP)
+		fmt.Printf("%d packets transmitted, %d received, %.1f%% packet loss\n", result.Sent, result.Received, result.Loss)
+		if result.Received > 0 {
+			fmt.Printf("rtt min/avg/max = %.3f/%.3f/%.3f ms\n", result.MinRTT, result.AvgRTT, result.MaxRTT)
+		} else {
+			code = 1
+		}
+	}
+
+	if errs := hook_func.ExecTerminalFunc(context.Background()); errs != nil {
+		for _, err := range errs {
+			log.Printf("Shutdown ZJU-Connect failed: %s", err)
+		}
+	}
+	return code
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/mythologyli/zju-connect/internal/underlay"
 	"github.com/mythologyli/zju-connect/log"
 	"github.com/mythologyli/zju-connect/stack/tun"
+	"github.com/mythologyli/zju-connect/stack/tuning"
 )
 
 var vpnClient *easyconnect.Client
@@ -112,7 +113,7 @@ func StartStack(fd int) {
 		return
 	}
 
-	vpnTUNStack, err := tun.NewStack(client, false, false, nil)
+	vpnTUNStack, err := tun.NewStack(client, false, false, nil, tuning.Default())
 	if err != nil {
 		return
 	}
@@ -15,6 +15,13 @@ func (r *Resolver) getDNSCache(host string) (net.IP, bool) {
 	}
 }
 
+// isPermanentDNS reports whether host has a custom DNS entry set by SetPermanentDNS.
+func (r *Resolver) isPermanentDNS(host string) bool {
+	host = normalizeHostname(host)
+	_, expiration, found := r.dnsCache.GetWithExpiration(host)
+	return found && expiration.IsZero()
+}
+
 func (r *Resolver) setDNSCache(host string, ip net.IP) {
 	host = normalizeHostname(host)
 	r.dnsCache.Set(host, ip, cache.DefaultExpiration)
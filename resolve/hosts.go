@@ -0,0 +1,239 @@
+package resolve
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mythologyli/zju-connect/log"
+)
+
+// maxAliasDepth bounds CNAME-style alias chains so that loops terminate.
+const maxAliasDepth = 8
+
+// HostsEntry maps a host name to addresses or to another name. HostName may
+// start with "*." to match every subdomain (but not the domain itself).
+type HostsEntry struct {
+	HostName string
+	IPs      []net.IP
+	Alias    string
+}
+
+type hostsRecord struct {
+	ips    []net.IP
+	alias  string
+	cursor atomic.Uint64
+}
+
+// hostsTable is immutable once built and swapped atomically on reload.
+type hostsTable struct {
+	exact    map[string]*hostsRecord
+	wildcard map[string]*hostsRecord
+}
+
+func newHostsTable(entries []HostsEntry) (*hostsTable, error) {
+	table := &hostsTable{
+		exact:    make(map[string]*hostsRecord),
+		wildcard: make(map[string]*hostsRecord),
+	}
+	for _, entry := range entries {
+		name := normalizeHostname(entry.HostName)
+		records := table.exact
+		if suffix, ok := strings.CutPrefix(name, "*."); ok {
+			name = suffix
+			records = table.wildcard
+		}
+		if name == "" {
+			return nil, fmt.Errorf("invalid host name %q", entry.HostName)
+		}
+		alias := normalizeHostname(entry.Alias)
+		if alias == "" && len(entry.IPs) == 0 {
+			return nil, fmt.Errorf("no IP or alias for host name %s", entry.HostName)
+		}
+
+		record := records[name]
+		if record == nil {
+			record = &hostsRecord{}
+			records[name] = record
+		}
+		if alias != "" {
+			record.alias = alias
+		}
+		record.ips = append(record.ips, entry.IPs...)
+	}
+	return table, nil
+}
+
+func (t *hostsTable) lookup(host string) *hostsRecord {
+	if t == nil {
+		return nil
+	}
+	if record, ok := t.exact[host]; ok {
+		return record
+	}
+	for suffix := host; ; {
+		dot := strings.IndexByte(suffix, '.')
+		if dot < 0 {
+			return nil
+		}
+		suffix = suffix[dot+1:]
+		if record, ok := t.wildcard[suffix]; ok {
+			return record
+		}
+	}
+}
+
+func (t *hostsTable) len() int {
+	if t == nil {
+		return 0
+	}
+	return len(t.exact) + len(t.wildcard)
+}
+
+func (r *Resolver) lookupHostsRecord(host string) *hostsRecord {
+	if record := r.customHosts.Load().lookup(host); record != nil {
+		return record
+	}
+	return r.fileHosts.Load().lookup(host)
+}
+
+// SetCustomDNS replaces the custom DNS entries from the config.
+func (r *Resolver) SetCustomDNS(entries []HostsEntry) error {
+	table, err := newHostsTable(entries)
+	if err != nil {
+		return err
+	}
+	r.customHosts.Store(table)
+	return nil
+}
+
+// CanonicalName follows custom DNS aliases of host and returns the final name.
+// It returns host itself when host has no alias.
+func (r *Resolver) CanonicalName(host string) string {
+	host = normalizeHostname(host)
+	for i := 0; i < maxAliasDepth; i++ {
+		record := r.lookupHostsRecord(host)
+		if record == nil || record.alias == "" {
+			break
+		}
+		host = record.alias
+	}
+	return host
+}
+
+// LookupHosts returns all custom DNS addresses of host after following aliases.
+func (r *Resolver) LookupHosts(host string) ([]net.IP, bool) {
+	record := r.lookupHostsRecord(r.CanonicalName(host))
+	if record == nil || len(record.ips) == 0 {
+		return nil, false
+	}
+	return record.ips, true
+}
+
+// pickHostsIP returns the custom DNS address of host, rotating over multiple
+// addresses and preferring IPv4 as the tunnels only carry IPv4.
+func (r *Resolver) pickHostsIP(host string) (net.IP, bool) {
+	record := r.lookupHostsRecord(host)
+	if record == nil || len(record.ips) == 0 {
+		return nil, false
+	}
+	ips := record.ips
+	var ip4s []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			ip4s = append(ip4s, ip)
+		}
+	}
+	if len(ip4s) > 0 {
+		ips = ip4s
+	}
+	return ips[(record.cursor.Add(1)-1)%uint64(len(ips))], true
+}
+
+// ParseHosts parses a hosts(5) style file. Host names may use a leading "*."
+// wildcard.
+func ParseHosts(reader io.Reader) ([]HostsEntry, error) {
+	var entries []HostsEntry
+	scanner := bufio.NewScanner(reader)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		if comment := strings.IndexByte(line, '#'); comment >= 0 {
+			line = line[:comment]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: missing host name", lineNumber)
+		}
+		ip := net.ParseIP(strings.Split(fields[0], "%")[0])
+		if ip == nil {
+			return nil, fmt.Errorf("line %d: invalid IP %s", lineNumber, fields[0])
+		}
+		for _, name := range fields[1:] {
+			entries = append(entries, HostsEntry{HostName: name, IPs: []net.IP{ip}})
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// LoadHostsFile replaces the hosts file entries with the content of path.
+func (r *Resolver) LoadHostsFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	entries, err := ParseHosts(file)
+	if err != nil {
+		return fmt.Errorf("parse hosts file %s: %w", path, err)
+	}
+	table, err := newHostsTable(entries)
+	if err != nil {
+		return fmt.Errorf("parse hosts file %s: %w", path, err)
+	}
+	r.fileHosts.Store(table)
+	log.Printf("Loaded %d host names from hosts file %s", table.len(), path)
+	return nil
+}
+
+// WatchHostsFile reloads the hosts file whenever its modification time or size
+// changes, until ctx is done. A broken file keeps the previous entries.
+func (r *Resolver) WatchHostsFile(ctx context.Context, path string, interval time.Duration) {
+	var lastModTime time.Time
+	var lastSize int64 = -1
+	if info, err := os.Stat(path); err == nil {
+		lastModTime, lastSize = info.ModTime(), info.Size()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			if info.ModTime().Equal(lastModTime) && info.Size() == lastSize {
+				continue
+			}
+			lastModTime, lastSize = info.ModTime(), info.Size()
+			if err := r.LoadHostsFile(path); err != nil {
+				log.Printf("Reload hosts file failed, keep previous entries: %s", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package resolve
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mythologyli/zju-connect/client"
+	"github.com/mythologyli/zju-connect/internal/ippool"
+	"github.com/patrickmn/go-cache"
+)
+
+func newHostsTestResolver(t *testing.T, entries ...HostsEntry) *Resolver {
+	t.Helper()
+	pool, err := ippool.NewIPPool[[]client.DomainResource](DefaultFakeIPRange)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := &Resolver{
+		domainIndex: newDomainResourceIndex(client.DomainResources{"zju.edu.cn": {{AppID: "vpn"}}}),
+		dnsCache:    cache.New(time.Minute, 0),
+		IPPool:      pool,
+	}
+	if err := resolver.SetCustomDNS(entries); err != nil {
+		t.Fatal(err)
+	}
+	return resolver
+}
+
+func TestCustomDNSWildcardPrefersMostSpecific(t *testing.T) {
+	resolver := newHostsTestResolver(t,
+		HostsEntry{HostName: "*.lab.example", IPs: []net.IP{net.ParseIP("192.0.2.1")}},
+		HostsEntry{HostName: "*.db.lab.example", IPs: []net.IP{net.ParseIP("192.0.2.2")}},
+		HostsEntry{HostName: "www.db.lab.example", IPs: []net.IP{net.ParseIP("192.0.2.3")}},
+	)
+	for host, want := range map[string]string{
+		"a.lab.example":      "192.0.2.1",
+		"x.db.lab.example":   "192.0.2.2",
+		"www.db.lab.example": "192.0.2.3",
+	} {
+		ips, ok := resolver.LookupHosts(host)
+		if !ok || len(ips) != 1 || ips[0].String() != want {
+			t.Fatalf("LookupHosts(%s) = %v, %t, want %s", host, ips, ok, want)
+		}
+	}
+	if _, ok := resolver.LookupHosts("lab.example"); ok {
+		t.Fatal("wildcard unexpectedly matched apex domain")
+	}
+}
+
+func TestCustomDNSRotatesMultipleAddresses(t *testing.T) {
+	resolver := newHostsTestResolver(t, HostsEntry{
+		HostName: "service.example",
+		IPs:      []net.IP{net.ParseIP("2001:db8::1"), net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2")},
+	})
+	var got []string
+	for i := 0; i < 3; i++ {
+		_, ip, err := resolver.Resolve(context.Background(), "service.example")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, ip.String())
+	}
+	if strings.Join(got, ",") != "192.0.2.1,192.0.2.2,192.0.2.1" {
+		t.Fatalf("rotated addresses = %v, want IPv4 addresses in turn", got)
+	}
+}
+
+func TestCustomDNSAliasSelectsTargetResource(t *testing.T) {
+	resolver := newHostsTestResolver(t,
+		HostsEntry{HostName: "git.example", Alias: "git.zju.edu.cn"},
+		HostsEntry{HostName: "git.zju.edu.cn", IPs: []net.IP{net.ParseIP("10.0.0.1")}},
+		HostsEntry{HostName: "loop-a.example", Alias: "loop-b.example"},
+		HostsEntry{HostName: "loop-b.example", Alias: "loop-a.example"},
+	)
+	ctx, ip, err := resolver.Resolve(context.Background(), "git.example")
+	if err != nil || !ip.Equal(net.ParseIP("10.0.0.1")) {
+		t.Fatalf("Resolve() = %s, %v, want 10.0.0.1", ip, err)
+	}
+	if host := ctx.Value(ContextKeyResolveHost); host != "git.zju.edu.cn" {
+		t.Fatalf("resolved host = %v, want alias target", host)
+	}
+	if ctx.Value(ContextKeyDomainResource) == nil {
+		t.Fatal("domain resource of alias target was not set")
+	}
+	if _, ok := resolver.LookupHosts("loop-a.example"); ok {
+		t.Fatal("alias loop resolved to an address")
+	}
+}
+
+func TestCustomDNSBeatsFakeIP(t *testing.T) {
+	resolver := newHostsTestResolver(t, HostsEntry{HostName: "*.zju.edu.cn", IPs: []net.IP{net.ParseIP("10.0.0.1")}})
+	ctx := context.WithValue(context.Background(), ContextKeyFakeIP, true)
+	_, ip, err := resolver.Resolve(ctx, "www.zju.edu.cn")
+	if err != nil || !ip.Equal(net.ParseIP("10.0.0.1")) {
+		t.Fatalf("Resolve() = %s, %v, want custom address", ip, err)
+	}
+}
+
+func TestLoadHostsFileKeepsPreviousEntriesOnError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	content := "# comment\n192.0.2.1 a.example *.b.example # trailing\n2001:db8::1 a.example\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	resolver := newHostsTestResolver(t)
+	if err := resolver.LoadHostsFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if ips, ok := resolver.LookupHosts("a.example"); !ok || len(ips) != 2 {
+		t.Fatalf("LookupHosts(a.example) = %v, %t, want two addresses", ips, ok)
+	}
+	if _, ok := resolver.LookupHosts("x.b.example"); !ok {
+		t.Fatal("wildcard from hosts file did not match")
+	}
+
+	if err := os.WriteFile(path, []byte("not-an-ip a.example\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := resolver.LoadHostsFile(path); err == nil {
+		t.Fatal("LoadHostsFile() accepted an invalid file")
+	}
+	if _, ok := resolver.LookupHosts("a.example"); !ok {
+		t.Fatal("invalid file dropped the previous entries")
+	}
+}
+
+func TestCustomDNSTakesPrecedenceOverHostsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(path, []byte("192.0.2.1 a.example\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	resolver := newHostsTestResolver(t, HostsEntry{HostName: "a.example", IPs: []net.IP{net.ParseIP("192.0.2.9")}})
+	if err := resolver.LoadHostsFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if ips, _ := resolver.LookupHosts("a.example"); len(ips) != 1 || !ips[0].Equal(net.ParseIP("192.0.2.9")) {
+		t.Fatalf("LookupHosts() = %v, want custom DNS entry", ips)
+	}
+}
@@ -3,6 +3,7 @@ package resolve
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net"
 	"strings"
 	"sync"
@@ -26,6 +27,12 @@ type Resolver struct {
 	dnsResourceCursor sync.Map
 	useRemoteDNS      bool
 
+	customHosts atomic.Pointer[hostsTable]
+	fileHosts   atomic.Pointer[hostsTable]
+
+	strict        bool
+	strictDomains *domainResourceIndex
+
 	dnsCache *cache.Cache
 
 	IPPool *ippool.IPPool[[]client.DomainResource]
@@ -44,6 +51,12 @@ type Resolver struct {
 
 const remoteDNSTCPFallbackDelay = 300 * time.Millisecond
 
+const DefaultFakeIPRange = "198.18.0.0/16"
+
+// ErrDNSLeakPrevented is returned in strict mode instead of asking the
+// secondary DNS about a resource or intranet domain.
+var ErrDNSLeakPrevented = errors.New("refuse to resolve with secondary DNS in strict mode")
+
 type lookupIPFunc func(context.Context, string, string) ([]net.IP, error)
 
 type dnsLookupResult struct {
@@ -71,8 +84,26 @@ var (
 	ContextKeyResolveHost    = contextKey("RESOLVE_HOST")
 	ContextKeyDomainResource = contextKey("DOMAIN_RESOURCE")
 	ContextKeyIPResource     = contextKey("IP_RESOURCE")
+	ContextKeyAnswerSource   = contextKey("ANSWER_SOURCE")
+)
+
+// AnswerSource tells where Resolve found an address. It is set in the returned
+// context as ANSWER_SOURCE.
+type AnswerSource string
+
+const (
+	AnswerSourceCustom      AnswerSource = "custom"
+	AnswerSourceCache       AnswerSource = "cache"
+	AnswerSourceDNSResource AnswerSource = "dns_resource"
+	AnswerSourceFakeIP      AnswerSource = "fake_ip"
+	AnswerSourceRemote      AnswerSource = "remote"
+	AnswerSourceSecondary   AnswerSource = "secondary"
 )
 
+func withAnswerSource(ctx context.Context, source AnswerSource) context.Context {
+	return context.WithValue(ctx, ContextKeyAnswerSource, source)
+}
+
 func TCPPrefersL3(ctx context.Context) bool {
 	if resource, ok := ctx.Value(ContextKeyDomainResource).(client.DomainResource); ok {
 		return resource.EnableTCPPrefL3
@@ -86,6 +117,12 @@ func TCPPrefersL3(ctx context.Context) bool {
 // Resolve ip address. If the host could be visited via VPN, this function set a DOMAIN_RESOURCE value in context. If resolve success, this function set a RESOLVE_HOST value in context.
 func (r *Resolver) Resolve(ctx context.Context, host string) (resCtx context.Context, resIP net.IP, resErr error) {
 	host = normalizeHostname(host)
+	// A custom DNS alias is followed before anything else, so the target
+	// decides both the address and the domain resource.
+	if canonical := r.CanonicalName(host); canonical != host {
+		log.DebugPrintf("Custom DNS alias: %s -> %s", host, canonical)
+		host = canonical
+	}
 	defer func() {
 		if resErr == nil {
 			resCtx = context.WithValue(resCtx, ContextKeyResolveHost, host)
@@ -100,9 +137,25 @@ func (r *Resolver) Resolve(ctx context.Context, host string) (resCtx context.Con
 		log.DebugPrintf("Domain resource found: %s", domain)
 	}
 
-	if cachedIP, found := r.getDNSCache(host); found {
-		log.Printf("%s -> %s", host, cachedIP.String())
-		return ctx, cachedIP, nil
+	if ip, found := r.pickHostsIP(host); found {
+		log.Printf("%s -> %s", host, ip.String())
+		if domainResourceFound {
+			if err := r.IPPool.SetIPDomain(ip, host, domainResources); err != nil {
+				log.DebugPrintf("Set IP err: %s", err)
+			}
+		}
+		return withAnswerSource(ctx, AnswerSourceCustom), ip, nil
+	}
+
+	// In fake IP mode, only custom DNS entries take precedence over fake IPs,
+	// otherwise a real address cached by a proxied connection would leak to
+	// DNS clients.
+	fakeIP := ctx.Value(ContextKeyFakeIP) != nil && domainResourceFound
+	if !fakeIP || r.isPermanentDNS(host) {
+		if cachedIP, found := r.getDNSCache(host); found {
+			log.Printf("%s -> %s", host, cachedIP.String())
+			return withAnswerSource(ctx, AnswerSourceCache), cachedIP, nil
+		}
 	}
 
 	if r.dnsResource != nil {
@@ -117,18 +170,20 @@ func (r *Resolver) Resolve(ctx context.Context, host string) (resCtx context.Con
 					log.DebugPrintf("Set IP err: %s", err)
 				}
 			}
-			return ctx, ip, nil
+			return withAnswerSource(ctx, AnswerSourceDNSResource), ip, nil
 		}
+	}
 
-		if fakeIPValue := ctx.Value(ContextKeyFakeIP); fakeIPValue != nil {
-			if domainResourceFound {
-				ip := r.IPPool.GenerateIP(host, domainResources)
-				log.Printf("%s -> %s (Fake IP)", host, ip.String())
-				return ctx, ip, nil
-			}
+	if fakeIP {
+		ip, err := r.IPPool.GenerateIP(host, domainResources)
+		if err == nil {
+			log.Printf("%s -> %s (Fake IP)", host, ip.String())
+			return withAnswerSource(ctx, AnswerSourceFakeIP), ip, nil
 		}
+		log.Printf("Generate Fake IP for %s failed: %s", host, err)
 	}
 
+	protected := r.strict && (domainResourceFound || r.isStrictDomain(host))
 	if r.useRemoteDNS {
 		ip, err := r.resolveCoordinated(ctx, host, func(lookupCtx context.Context) (net.IP, error) {
 			return r.resolveRemote(lookupCtx, host)
@@ -137,16 +192,61 @@ func (r *Resolver) Resolve(ctx context.Context, host string) (resCtx context.Con
 			if ctx.Err() != nil {
 				return ctx, nil, ctx.Err()
 			}
+			if protected {
+				log.Printf("Resolve IPv4 addr failed using remote DNS: %s, not using secondary DNS in strict mode", host)
+				return withAnswerSource(ctx, AnswerSourceRemote), nil, fmt.Errorf("%w: %s: %w", ErrDNSLeakPrevented, host, err)
+			}
 			log.Printf("Resolve IPv4 addr failed using remote DNS: %s, using secondary DNS instead", host)
 			return r.ResolveWithSecondaryDNS(ctx, host)
 		}
 		log.Printf("%s -> %s", host, ip.String())
-		return ctx, ip, nil
+		return withAnswerSource(ctx, AnswerSourceRemote), ip, nil
 	} else {
+		if protected {
+			log.Printf("Remote DNS is disabled, not resolving %s in strict mode", host)
+			return ctx, nil, fmt.Errorf("%w: %s", ErrDNSLeakPrevented, host)
+		}
 		return r.ResolveWithSecondaryDNS(ctx, host)
 	}
 }
 
+// SetStrict turns the DNS leak prevention on or off. In strict mode, names
+// matching a domain resource or one of domains are never sent to the secondary
+// DNS; Resolve returns ErrDNSLeakPrevented when the remote DNS cannot answer.
+func (r *Resolver) SetStrict(strict bool, domains []string) {
+	r.strict = strict
+	index := make(client.DomainResources, len(domains))
+	for _, domain := range domains {
+		index[domain] = nil
+	}
+	r.strictDomains = newDomainResourceIndex(index)
+}
+
+func (r *Resolver) isStrictDomain(host string) bool {
+	_, _, found := r.strictDomains.Match(host)
+	return found
+}
+
+// ResolveFakeIPv6 returns an IPv6 fake IP for host when the context asks for
+// fake IPs, host matches a domain resource and an IPv6 fake IP range is set.
+func (r *Resolver) ResolveFakeIPv6(ctx context.Context, host string) (net.IP, bool) {
+	if ctx.Value(ContextKeyFakeIP) == nil || r.IPPool == nil || !r.IPPool.HasIPv6() {
+		return nil, false
+	}
+	host = normalizeHostname(host)
+	_, resources, found := matchDomainResource(r.domainIndex, host)
+	if !found {
+		return nil, false
+	}
+	ip, err := r.IPPool.GenerateIP6(host, resources)
+	if err != nil {
+		log.Printf("Generate IPv6 Fake IP for %s failed: %s", host, err)
+		return nil, false
+	}
+	log.Printf("%s -> %s (Fake IP)", host, ip.String())
+	return ip, true
+}
+
 func (r *Resolver) resolveCoordinated(ctx context.Context, host string, lookup func(context.Context) (net.IP, error)) (net.IP, error) {
 	r.resolutionMu.Lock()
 	if r.resolutionClosed {
@@ -204,6 +304,12 @@ func matchDomainResource(index *domainResourceIndex, host string) (string, []cli
 	return index.Match(host)
 }
 
+// DomainResources returns the domain resources that host matches.
+func (r *Resolver) DomainResources(host string) ([]client.DomainResource, bool) {
+	_, resources, found := matchDomainResource(r.domainIndex, normalizeHostname(host))
+	return resources, found
+}
+
 func (r *Resolver) resolveRemote(ctx context.Context, host string) (net.IP, error) {
 	r.tcpLock.RLock()
 	useTCP := r.useTCP
@@ -333,6 +439,7 @@ func (r *Resolver) RemoteTCPResolver() (*net.Resolver, error) {
 }
 
 func (r *Resolver) ResolveWithSecondaryDNS(ctx context.Context, host string) (context.Context, net.IP, error) {
+	ctx = withAnswerSource(ctx, AnswerSourceSecondary)
 	if targets, err := r.secondaryResolver.LookupIP(ctx, "ip4", host); err != nil {
 		log.Printf("Resolve IPv4 addr failed using secondary DNS: %s. Try IPv6 addr", host)
 
@@ -367,7 +474,7 @@ func (r *Resolver) Close() {
 	})
 }
 
-func NewResolver(stack stack.Stack, remoteDNSServer, secondaryDNSServer string, ttl uint64, domainResources client.DomainResources, dnsResource map[string][]net.IP, useRemoteDNS bool) *Resolver {
+func NewResolver(vpnStack stack.Stack, remoteDNSServer, secondaryDNSServer string, ttl uint64, domainResources client.DomainResources, dnsResource map[string][]net.IP, useRemoteDNS bool, ipPool *ippool.IPPool[[]client.DomainResource]) *Resolver {
 	//domainSuffixTree := domainsuffixtrie.NewDomainSuffixTrie[bool]()
 	//for domain := range domainResource {
 	//	_ = domainSuffixTree.AddDomainSuffix(domain, true)
@@ -377,7 +484,7 @@ func NewResolver(stack stack.Stack, remoteDNSServer, secondaryDNSServer string,
 		remoteUDPResolver: &net.Resolver{
 			PreferGo: true,
 			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-				return stack.DialUDP(ctx, &net.UDPAddr{
+				return vpnStack.DialUDP(ctx, &net.UDPAddr{
 					IP:   net.ParseIP(remoteDNSServer),
 					Port: 53,
 				})
@@ -386,7 +493,7 @@ func NewResolver(stack stack.Stack, remoteDNSServer, secondaryDNSServer string,
 		remoteTCPResolver: &net.Resolver{
 			PreferGo: true,
 			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-				return stack.DialTCP(ctx, &net.TCPAddr{
+				return vpnStack.DialTCP(ctx, &net.TCPAddr{
 					IP:   net.ParseIP(remoteDNSServer),
 					Port: 53,
 				})
@@ -397,13 +504,24 @@ func NewResolver(stack stack.Stack, remoteDNSServer, secondaryDNSServer string,
 		dnsResource:  dnsResource,
 		dnsCache:     cache.New(time.Duration(ttl)*time.Second, time.Duration(ttl)*2*time.Second),
 		useRemoteDNS: useRemoteDNS,
+		IPPool:       ipPool,
 	}
 
 	if secondaryDNSServer != "" {
 		resolver.secondaryResolver = &net.Resolver{
 			PreferGo: true,
 			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-				return (&net.Dialer{}).DialContext(ctx, network, net.JoinHostPort(secondaryDNSServer, "53"))
+				conn, err := (&net.Dialer{}).DialContext(ctx, network, net.JoinHostPort(secondaryDNSServer, "53"))
+				if err != nil {
+					return nil, err
+				}
+				// The secondary DNS server may be routed through the TUN
+				// device, whose stack must not hijack these queries.
+				tracker, ok := vpnStack.(stack.DNSConnTracker)
+				if udpAddr, isUDP := conn.RemoteAddr().(*net.UDPAddr); ok && isUDP {
+					conn = tracker.TrackDNSConn(conn, udpAddr)
+				}
+				return conn, nil
 			},
 		}
 	} else {
@@ -411,10 +529,12 @@ func NewResolver(stack stack.Stack, remoteDNSServer, secondaryDNSServer string,
 			PreferGo: true,
 		}
 	}
-	var err error
-	resolver.IPPool, err = ippool.NewIPPool[[]client.DomainResource]("198.18.0.0/16")
-	if err != nil {
-		log.Fatalf("Create Fake IP Pool failed: %v", err)
+	if resolver.IPPool == nil {
+		var err error
+		resolver.IPPool, err = ippool.NewIPPool[[]client.DomainResource](DefaultFakeIPRange)
+		if err != nil {
+			log.Fatalf("Create Fake IP Pool failed: %v", err)
+		}
 	}
 
 	return resolver
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -357,3 +358,39 @@ func failingNetResolver() *net.Resolver {
 		},
 	}
 }
+
+func TestStrictResolverDoesNotLeakProtectedNames(t *testing.T) {
+	var secondaryQueries atomic.Int32
+	secondary := &net.Resolver{
+		PreferGo: true,
+		Dial: func(context.Context, string, string) (net.Conn, error) {
+			secondaryQueries.Add(1)
+			return nil, errors.New("DNS unavailable")
+		},
+	}
+	resolver := &Resolver{
+		remoteUDPResolver: failingNetResolver(),
+		remoteTCPResolver: failingNetResolver(),
+		secondaryResolver: secondary,
+		domainIndex:       newDomainResourceIndex(client.DomainResources{"resource.example": {{AppID: "vpn"}}}),
+		dnsCache:          cache.New(time.Minute, 0),
+		useRemoteDNS:      true,
+	}
+	resolver.SetStrict(true, []string{"intranet.example"})
+
+	for _, host := range []string{"www.resource.example", "www.intranet.example"} {
+		if _, _, err := resolver.Resolve(context.Background(), host); !errors.Is(err, ErrDNSLeakPrevented) {
+			t.Fatalf("Resolve(%s) error = %v, want ErrDNSLeakPrevented", host, err)
+		}
+	}
+	if n := secondaryQueries.Load(); n != 0 {
+		t.Fatalf("secondary DNS was queried %d times for protected names", n)
+	}
+
+	if _, _, err := resolver.Resolve(context.Background(), "public.example"); errors.Is(err, ErrDNSLeakPrevented) {
+		t.Fatal("public name was not allowed to use secondary DNS")
+	}
+	if secondaryQueries.Load() == 0 {
+		t.Fatal("public name did not fall back to secondary DNS")
+	}
+}
@@ -0,0 +1,241 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mythologyli/zju-connect/client"
+	"github.com/mythologyli/zju-connect/dial"
+	"github.com/mythologyli/zju-connect/internal/hook_func"
+	"github.com/mythologyli/zju-connect/internal/ippool"
+	"github.com/mythologyli/zju-connect/internal/prompt"
+	"github.com/mythologyli/zju-connect/log"
+)
+
+// AdminServer is a small JSON HTTP API for inspecting and controlling a
+// running instance. Features register their handlers on it, also while it is
+// already serving.
+type AdminServer struct {
+	token string
+	mux   *http.ServeMux
+}
+
+func NewAdminServer(token string) *AdminServer {
+	return &AdminServer{
+		token: token,
+		mux:   http.NewServeMux(),
+	}
+}
+
+func (a *AdminServer) Handle(pattern string, handler http.Handler) {
+	a.mux.Handle(pattern, handler)
+}
+
+func (a *AdminServer) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	a.mux.HandleFunc(pattern, handler)
+}
+
+func (a *AdminServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if a.token != "" {
+		token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+			writeAdminError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+	}
+	a.mux.ServeHTTP(w, req)
+}
+
+// HandleFakeIP exposes the fake IP pool: GET /fake-ip lists the mappings
+// (optionally filtered by ?domain=), DELETE /fake-ip flushes all of them or
+// only those of ?domain=.
+func (a *AdminServer) HandleFakeIP(pool *ippool.IPPool[[]client.DomainResource]) {
+	a.HandleFunc("GET /fake-ip", func(w http.ResponseWriter, req *http.Request) {
+		domain := req.URL.Query().Get("domain")
+		entries := pool.Entries()
+		if domain != "" {
+			filtered := entries[:0]
+			for _, entry := range entries {
+				if entry.Domain == domain {
+					filtered = append(filtered, entry)
+				}
+			}
+			entries = filtered
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].LastUsed.After(entries[j].LastUsed)
+		})
+		writeAdminJSON(w, http.StatusOK, map[string]any{
+			"stats":   pool.Stats(),
+			"entries": entries,
+		})
+	})
+	a.HandleFunc("DELETE /fake-ip", func(w http.ResponseWriter, req *http.Request) {
+		var flushed int
+		if domain := req.URL.Query().Get("domain"); domain != "" {
+			flushed = pool.FlushDomain(domain)
+		} else {
+			flushed = pool.Flush()
+		}
+		log.Printf("Admin: flushed %d fake IP entries", flushed)
+		writeAdminJSON(w, http.StatusOK, map[string]int{"flushed": flushed})
+	})
+}
+
+// HandleDNSQueryLog exposes the DNS query log: GET /dns/queries lists recent
+// queries (?limit=, ?name=, ?client=, ?result=), GET /dns/stats returns the
+// totals and the top ?top= names and clients.
+func (a *AdminServer) HandleDNSQueryLog(queryLog *DNSQueryLog) {
+	a.HandleFunc("GET /dns/queries", func(w http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		limit, err := adminIntParam(query.Get("limit"), 100)
+		if err != nil {
+			writeAdminError(w, http.StatusBadRequest, err)
+			return
+		}
+		name := strings.ToLower(strings.TrimSuffix(query.Get("name"), "."))
+		clientAddr, result := query.Get("client"), query.Get("result")
+		entries := queryLog.Recent(limit, func(entry *DNSQueryLogEntry) bool {
+			return (name == "" || entry.Name == name) &&
+				(clientAddr == "" || entry.Client == clientAddr) &&
+				(result == "" || entry.Result == result)
+		})
+		writeAdminJSON(w, http.StatusOK, map[string]any{"queries": entries})
+	})
+	a.HandleFunc("GET /dns/stats", func(w http.ResponseWriter, req *http.Request) {
+		top, err := adminIntParam(req.URL.Query().Get("top"), 20)
+		if err != nil {
+			writeAdminError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeAdminJSON(w, http.StatusOK, map[string]any{
+			"total":            queryLog.Total(),
+			"top_names":        queryLog.TopNames(top),
+			"top_failed_names": queryLog.TopFailedNames(top),
+			"top_clients":      queryLog.TopClients(top),
+		})
+	})
+}
+
+// HandlePrompt lets the admin API answer login prompts: GET /prompt lists the
+// pending prompts, GET /prompt/{id}/image returns the image of one (e.g. a
+// captcha), POST /prompt/{id} with {"value": "..."} answers it.
+func (a *AdminServer) HandlePrompt(queue *prompt.Queue) {
+	a.HandleFunc("GET /prompt", func(w http.ResponseWriter, req *http.Request) {
+		writeAdminJSON(w, http.StatusOK, map[string]any{"prompts": queue.Pending()})
+	})
+	a.HandleFunc("GET /prompt/{id}/image", func(w http.ResponseWriter, req *http.Request) {
+		image, ok := queue.Image(req.PathValue("id"))
+		if !ok {
+			writeAdminError(w, http.StatusNotFound, errors.New("no image for this prompt"))
+			return
+		}
+		w.Header().Set("Content-Type", http.DetectContentType(image))
+		w.Header().Set("Cache-Control", "no-store")
+		_, _ = w.Write(image)
+	})
+	a.HandleFunc("POST /prompt/{id}", func(w http.ResponseWriter, req *http.Request) {
+		var body struct {
+			Value string `json:"value"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, 64<<10)).Decode(&body); err != nil {
+			writeAdminError(w, http.StatusBadRequest, err)
+			return
+		}
+		if strings.TrimSpace(body.Value) == "" {
+			writeAdminError(w, http.StatusBadRequest, prompt.ErrEmptyAnswer)
+			return
+		}
+		if err := queue.Answer(req.PathValue("id"), body.Value); err != nil {
+			writeAdminError(w, http.StatusNotFound, err)
+			return
+		}
+		log.Printf("Admin: answered prompt %s", req.PathValue("id"))
+		writeAdminJSON(w, http.StatusOK, map[string]bool{"ok": true})
+	})
+}
+
+// HandlePing lets the admin API ping hosts through the VPN: GET /ping?host=
+// sends ?count= (at most 10) echo requests and returns the replies and their
+// statistics.
+func (a *AdminServer) HandlePing(pinger Pinger) {
+	a.HandleFunc("GET /ping", func(w http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		host := query.Get("host")
+		if host == "" {
+			writeAdminError(w, http.StatusBadRequest, errors.New("missing host"))
+			return
+		}
+		count, err := adminIntParam(query.Get("count"), DefaultPingCount)
+		if err != nil {
+			writeAdminError(w, http.StatusBadRequest, err)
+			return
+		}
+		result, err := Ping(req.Context(), pinger, host, min(count, maxAdminPingCount), DefaultPingTimeout, nil)
+		if err != nil {
+			status := http.StatusBadGateway
+			if errors.Is(err, dial.ErrNotVPNTarget) {
+				status = http.StatusBadRequest
+			}
+			writeAdminError(w, status, err)
+			return
+		}
+		writeAdminJSON(w, http.StatusOK, result)
+	})
+}
+
+func adminIntParam(value string, defaultValue int) (int, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid number %q", value)
+	}
+	return n, nil
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func writeAdminError(w http.ResponseWriter, status int, err error) {
+	writeAdminJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func ServeAdmin(bindAddr string, admin *AdminServer) {
+	server := newHTTPServer(bindAddr, admin)
+
+	log.Printf("Admin server listening on %s", bindAddr)
+	if admin.token == "" {
+		log.Println("Admin server has no token. DO NOT expose it to the network")
+	}
+
+	hook_func.RegisterTerminalFunc("CloseAdminListener", func(ctx context.Context) error {
+		log.Println("Closing admin listener...")
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			return fmt.Errorf("close admin listener failed: %w", err)
+		}
+		return nil
+	})
+
+	if err := server.ListenAndServe(); err != nil {
+		if errors.Is(err, http.ErrServerClosed) {
+			log.Println("Admin server closed")
+		} else {
+			log.Println("Admin listen failed: " + err.Error())
+		}
+	}
+}
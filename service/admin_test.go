@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mythologyli/zju-connect/internal/prompt"
+)
+
+func TestAdminAnswersPrompt(t *testing.T) {
+	queue := prompt.NewQueue()
+	admin := NewAdminServer("")
+	admin.HandlePrompt(queue)
+
+	done := make(chan string, 1)
+	go func() {
+		answer, err := queue.Prompt(context.Background(), prompt.Request{Kind: prompt.KindSMSCode, Message: "SMS code"})
+		if err != nil {
+			t.Error(err)
+		}
+		done <- answer
+	}()
+
+	var body struct {
+		Prompts []prompt.Pending `json:"prompts"`
+	}
+	for deadline := time.Now().Add(5 * time.Second); len(body.Prompts) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("prompt never listed")
+		}
+		time.Sleep(10 * time.Millisecond)
+		recorder := httptest.NewRecorder()
+		admin.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/prompt", nil))
+		if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	id := body.Prompts[0].ID
+	if body.Prompts[0].Kind != prompt.KindSMSCode {
+		t.Fatalf("kind = %q", body.Prompts[0].Kind)
+	}
+
+	recorder := httptest.NewRecorder()
+	admin.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/prompt/"+id+"/image", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Fatalf("image status = %d, want 404", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	admin.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/prompt/"+id, strings.NewReader(`{"value":" "}`)))
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("empty answer status = %d, want 400", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	admin.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/prompt/"+id, strings.NewReader(`{"value":"123456"}`)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("answer status = %d, body %s", recorder.Code, recorder.Body)
+	}
+	if answer := <-done; answer != "123456" {
+		t.Fatalf("answer = %q", answer)
+	}
+
+	recorder = httptest.NewRecorder()
+	admin.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/prompt/"+id, strings.NewReader(`{"value":"123456"}`)))
+	if recorder.Code != http.StatusNotFound {
+		t.Fatalf("second answer status = %d, want 404", recorder.Code)
+	}
+}
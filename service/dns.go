@@ -2,18 +2,51 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
+	"strings"
+	"time"
 
 	"github.com/miekg/dns"
 	"github.com/mythologyli/zju-connect/internal/hook_func"
+	"github.com/mythologyli/zju-connect/internal/zcdns"
 	"github.com/mythologyli/zju-connect/log"
 	"github.com/mythologyli/zju-connect/resolve"
 )
 
+// fakeIPAnswerTTL is kept short so that clients never hold on to a fake IP
+// that has been recycled for another domain.
+const fakeIPAnswerTTL = 1
+
 type DNSServer struct {
 	resolver *resolve.Resolver
 	localDNS []net.IP
+	fakeIP   bool
+	queryLog *DNSQueryLog
+	// hijackAll also hijacks queries to the remote and secondary DNS servers.
+	hijackAll bool
+}
+
+// WithFakeIP returns a copy of d which answers resource domains with fake IPs.
+// Connections to those addresses made through the SOCKS5, HTTP or Shadowsocks
+// servers are mapped back to the domain by the dialer.
+func (d DNSServer) WithFakeIP() DNSServer {
+	d.fakeIP = true
+	return d
+}
+
+// WithHijackAll returns a copy of d which asks the TUN stack to hijack DNS
+// queries to any destination, as in DNS strict mode.
+func (d DNSServer) WithHijackAll() DNSServer {
+	d.hijackAll = true
+	return d
+}
+
+// WithQueryLog returns a copy of d which records every query in queryLog.
+func (d DNSServer) WithQueryLog(queryLog *DNSQueryLog) DNSServer {
+	d.queryLog = queryLog
+	return d
 }
 
 func (d DNSServer) serveDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
@@ -21,7 +54,11 @@ func (d DNSServer) serveDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
 	m.SetReply(r)
 	m.Compress = false
 
-	_ = d.handleSingleDNSResolve(context.Background(), r, m)
+	ctx := zcdns.WithClientAddr(context.Background(), w.RemoteAddr())
+	if d.fakeIP {
+		ctx = context.WithValue(ctx, resolve.ContextKeyFakeIP, true)
+	}
+	_ = d.handleSingleDNSResolve(ctx, r, m)
 
 	_ = w.WriteMsg(m)
 }
@@ -36,6 +73,9 @@ func (d DNSServer) HandleDnsMsg(ctx context.Context, requestMsg *dns.Msg) (*dns.
 }
 
 func (d DNSServer) CheckDnsHijack(dstIP net.IP) bool {
+	if d.hijackAll {
+		return true
+	}
 	for _, ip := range d.localDNS {
 		if ip.Equal(dstIP) {
 			return false
@@ -48,36 +88,129 @@ func (d DNSServer) handleSingleDNSResolve(ctx context.Context, requestMsg *dns.M
 	switch requestMsg.Opcode {
 	case dns.OpcodeQuery:
 		for _, q := range requestMsg.Question {
-			name := q.Name
-			if len(name) > 1 && name[len(name)-1] == '.' {
-				name = name[:len(name)-1]
+			start := time.Now()
+			answers, source, err := d.resolveQuestion(ctx, q)
+			resMsg.Answer = append(resMsg.Answer, answers...)
+			if errors.Is(err, resolve.ErrDNSLeakPrevented) {
+				resMsg.Rcode = dns.RcodeServerFailure
 			}
-
-			switch q.Qtype {
-			case dns.TypeA:
-				if _, ip, err := d.resolver.Resolve(ctx, name); err == nil {
-					if ip.To4() != nil {
-						rr, err := dns.NewRR(fmt.Sprintf("%s A %s", q.Name, ip))
-						if err == nil {
-							resMsg.Answer = append(resMsg.Answer, rr)
-						}
-					}
-				}
-			case dns.TypeAAAA:
-				if _, ip, err := d.resolver.Resolve(ctx, name); err == nil {
-					if ip.To4() == nil {
-						rr, err := dns.NewRR(fmt.Sprintf("%s AAAA %s", q.Name, ip))
-						if err == nil {
-							resMsg.Answer = append(resMsg.Answer, rr)
-						}
-					}
-				}
+			if d.queryLog != nil {
+				d.logQuery(ctx, q, answers, source, err, time.Since(start))
 			}
 		}
 	}
 	return nil
 }
 
+func (d DNSServer) resolveQuestion(ctx context.Context, q dns.Question) ([]dns.RR, resolve.AnswerSource, error) {
+	name := q.Name
+	if len(name) > 1 && name[len(name)-1] == '.' {
+		name = name[:len(name)-1]
+	}
+
+	if q.Qtype != dns.TypeA && q.Qtype != dns.TypeAAAA {
+		return nil, "", nil
+	}
+
+	// Custom DNS aliases are answered as CNAME records followed by the
+	// addresses of the target.
+	var answers []dns.RR
+	answerName := q.Name
+	if canonical := d.resolver.CanonicalName(name); canonical != strings.ToLower(name) {
+		if rr, err := dns.NewRR(fmt.Sprintf("%s CNAME %s", q.Name, dns.Fqdn(canonical))); err == nil {
+			answers = append(answers, rr)
+			answerName = dns.Fqdn(canonical)
+		}
+	}
+	if ips, ok := d.resolver.LookupHosts(name); ok {
+		for _, ip := range ips {
+			var rr dns.RR
+			var err error
+			if q.Qtype == dns.TypeA && ip.To4() != nil {
+				rr, err = d.newAnswer(answerName, "A", ip)
+			} else if q.Qtype == dns.TypeAAAA && ip.To4() == nil {
+				rr, err = d.newAnswer(answerName, "AAAA", ip)
+			} else {
+				continue
+			}
+			if err == nil {
+				answers = append(answers, rr)
+			}
+		}
+		return answers, resolve.AnswerSourceCustom, nil
+	}
+
+	if q.Qtype == dns.TypeAAAA {
+		if ip, ok := d.resolver.ResolveFakeIPv6(ctx, name); ok {
+			if rr, err := d.newAnswer(answerName, "AAAA", ip); err == nil {
+				answers = append(answers, rr)
+			}
+			return answers, resolve.AnswerSourceFakeIP, nil
+		}
+	}
+
+	resCtx, ip, err := d.resolver.Resolve(ctx, name)
+	var source resolve.AnswerSource
+	if resCtx != nil {
+		source, _ = resCtx.Value(resolve.ContextKeyAnswerSource).(resolve.AnswerSource)
+	}
+	if err != nil {
+		return answers, source, err
+	}
+	if q.Qtype == dns.TypeA && ip.To4() != nil {
+		if rr, err := d.newAnswer(answerName, "A", ip); err == nil {
+			answers = append(answers, rr)
+		}
+	} else if q.Qtype == dns.TypeAAAA && ip.To4() == nil {
+		if rr, err := d.newAnswer(answerName, "AAAA", ip); err == nil {
+			answers = append(answers, rr)
+		}
+	}
+	return answers, source, nil
+}
+
+func (d DNSServer) logQuery(ctx context.Context, q dns.Question, answers []dns.RR, source resolve.AnswerSource, err error, latency time.Duration) {
+	entry := DNSQueryLogEntry{
+		Time:      time.Now(),
+		Client:    "local",
+		Name:      strings.ToLower(strings.TrimSuffix(q.Name, ".")),
+		Type:      dns.TypeToString[q.Qtype],
+		Source:    source,
+		LatencyMS: float64(latency.Microseconds()) / 1000,
+		Result:    DNSQueryResultOK,
+	}
+	if addr, ok := zcdns.ClientAddrFromContext(ctx); ok {
+		entry.Client = addr.String()
+		if host, _, splitErr := net.SplitHostPort(entry.Client); splitErr == nil {
+			entry.Client = host
+		}
+	}
+	for _, rr := range answers {
+		switch rr := rr.(type) {
+		case *dns.A:
+			entry.Answers = append(entry.Answers, rr.A.String())
+		case *dns.AAAA:
+			entry.Answers = append(entry.Answers, rr.AAAA.String())
+		case *dns.CNAME:
+			entry.Answers = append(entry.Answers, rr.Target)
+		}
+	}
+	if err != nil {
+		entry.Result = DNSQueryResultError
+		entry.Error = err.Error()
+	} else if len(answers) == 0 {
+		entry.Result = DNSQueryResultEmpty
+	}
+	d.queryLog.Record(entry)
+}
+
+func (d DNSServer) newAnswer(name, rrType string, ip net.IP) (dns.RR, error) {
+	if d.resolver.IPPool != nil && d.resolver.IPPool.Contains(ip) {
+		return dns.NewRR(fmt.Sprintf("%s %d %s %s", name, fakeIPAnswerTTL, rrType, ip))
+	}
+	return dns.NewRR(fmt.Sprintf("%s %s %s", name, rrType, ip))
+}
+
 func NewDnsServer(resolver *resolve.Resolver, dnsServers []string) DNSServer {
 	netIPs := make([]net.IP, len(dnsServers))
 	for _, dnsServer := range dnsServers {
@@ -89,12 +222,25 @@ func NewDnsServer(resolver *resolve.Resolver, dnsServers []string) DNSServer {
 }
 
 func ServeDNS(bindAddr string, dnsServer DNSServer) {
-	dns.HandleFunc(".", dnsServer.serveDNSRequest)
+	ServeDNSContext(context.Background(), bindAddr, dnsServer)
+}
 
-	server := &dns.Server{Addr: bindAddr, Net: "udp"}
+// ServeDNSContext is like ServeDNS, but also closes the listener when ctx is
+// done, e.g. to listen on another address instead.
+func ServeDNSContext(ctx context.Context, bindAddr string, dnsServer DNSServer) {
+	server := &dns.Server{Addr: bindAddr, Net: "udp", Handler: dns.HandlerFunc(dnsServer.serveDNSRequest)}
 	log.Printf("Starting DNS server at %s", server.Addr)
+	// The server can only be shut down once it started.
+	server.NotifyStartedFunc = func() {
+		context.AfterFunc(ctx, func() {
+			_ = server.Shutdown()
+		})
+	}
 
-	hook_func.RegisterTerminalFunc("CloseDNSListener", func(ctx context.Context) error {
+	hook_func.RegisterTerminalFunc("CloseDNSListener", func(context.Context) error {
+		if ctx.Err() != nil {
+			return nil
+		}
 		log.Println("Closing DNS listener...")
 		if err := server.Shutdown(); err != nil {
 			return fmt.Errorf("close DNS listener failed: %w", err)
@@ -0,0 +1,196 @@
+package service
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mythologyli/zju-connect/log"
+	"github.com/mythologyli/zju-connect/resolve"
+)
+
+const (
+	DNSQueryResultOK    = "ok"
+	DNSQueryResultEmpty = "empty"
+	DNSQueryResultError = "error"
+)
+
+// maxDNSStatsKeys bounds the number of names and clients with their own
+// counters. Queries for new keys beyond it only count towards the totals.
+const maxDNSStatsKeys = 10000
+
+type DNSQueryLogEntry struct {
+	Time      time.Time            `json:"time"`
+	Client    string               `json:"client"`
+	Name      string               `json:"name"`
+	Type      string               `json:"type"`
+	Source    resolve.AnswerSource `json:"source,omitempty"`
+	LatencyMS float64              `json:"latency_ms"`
+	Result    string               `json:"result"`
+	Answers   []string             `json:"answers,omitempty"`
+	Error     string               `json:"error,omitempty"`
+}
+
+type DNSQueryStats struct {
+	Key         string                          `json:"key"`
+	Queries     uint64                          `json:"queries"`
+	Failures    uint64                          `json:"failures"`
+	FailureRate float64                         `json:"failure_rate"`
+	Sources     map[resolve.AnswerSource]uint64 `json:"sources,omitempty"`
+}
+
+func (s *DNSQueryStats) add(entry *DNSQueryLogEntry) {
+	s.Queries++
+	if entry.Result == DNSQueryResultError {
+		s.Failures++
+	}
+	s.FailureRate = float64(s.Failures) / float64(s.Queries)
+	if entry.Source != "" {
+		if s.Sources == nil {
+			s.Sources = make(map[resolve.AnswerSource]uint64)
+		}
+		s.Sources[entry.Source]++
+	}
+}
+
+func (s *DNSQueryStats) clone() DNSQueryStats {
+	c := *s
+	if s.Sources != nil {
+		c.Sources = make(map[resolve.AnswerSource]uint64, len(s.Sources))
+		for source, count := range s.Sources {
+			c.Sources[source] = count
+		}
+	}
+	return c
+}
+
+// DNSQueryLog keeps the most recent DNS queries and per-name and per-client
+// counters. When a writer is set, every query is also written to it as a JSON
+// line.
+type DNSQueryLog struct {
+	mu      sync.Mutex
+	recent  []DNSQueryLogEntry
+	next    int
+	full    bool
+	total   DNSQueryStats
+	names   map[string]*DNSQueryStats
+	clients map[string]*DNSQueryStats
+	encoder *json.Encoder
+}
+
+func NewDNSQueryLog(capacity int, writer io.Writer) *DNSQueryLog {
+	l := &DNSQueryLog{
+		recent:  make([]DNSQueryLogEntry, capacity),
+		names:   make(map[string]*DNSQueryStats),
+		clients: make(map[string]*DNSQueryStats),
+	}
+	if writer != nil {
+		l.encoder = json.NewEncoder(writer)
+	}
+	return l
+}
+
+func (l *DNSQueryLog) Record(entry DNSQueryLogEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.recent) > 0 {
+		l.recent[l.next] = entry
+		l.next = (l.next + 1) % len(l.recent)
+		if l.next == 0 {
+			l.full = true
+		}
+	}
+
+	l.total.add(&entry)
+	addDNSQueryStats(l.names, entry.Name, &entry)
+	addDNSQueryStats(l.clients, entry.Client, &entry)
+
+	if l.encoder != nil {
+		if err := l.encoder.Encode(entry); err != nil {
+			log.Printf("Write DNS query log failed: %s", err)
+			l.encoder = nil
+		}
+	}
+}
+
+func addDNSQueryStats(stats map[string]*DNSQueryStats, key string, entry *DNSQueryLogEntry) {
+	s := stats[key]
+	if s == nil {
+		if len(stats) >= maxDNSStatsKeys {
+			return
+		}
+		s = &DNSQueryStats{Key: key}
+		stats[key] = s
+	}
+	s.add(entry)
+}
+
+// Recent returns up to limit most recent queries, newest first, which match
+// filter. A nil filter matches all queries.
+func (l *DNSQueryLog) Recent(limit int, filter func(*DNSQueryLogEntry) bool) []DNSQueryLogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	count := l.next
+	if l.full {
+		count = len(l.recent)
+	}
+	entries := make([]DNSQueryLogEntry, 0, min(limit, count))
+	for i := 1; i <= count && len(entries) < limit; i++ {
+		entry := &l.recent[(l.next-i+len(l.recent))%len(l.recent)]
+		if filter == nil || filter(entry) {
+			entries = append(entries, *entry)
+		}
+	}
+	return entries
+}
+
+// Total returns the counters over all queries.
+func (l *DNSQueryLog) Total() DNSQueryStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.total.clone()
+}
+
+// TopNames returns the n most queried names.
+func (l *DNSQueryLog) TopNames(n int) []DNSQueryStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return topDNSQueryStats(l.names, n, func(s *DNSQueryStats) uint64 { return s.Queries })
+}
+
+// TopFailedNames returns the n names with the most failed queries.
+func (l *DNSQueryLog) TopFailedNames(n int) []DNSQueryStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return topDNSQueryStats(l.names, n, func(s *DNSQueryStats) uint64 { return s.Failures })
+}
+
+// TopClients returns the n clients which sent the most queries.
+func (l *DNSQueryLog) TopClients(n int) []DNSQueryStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return topDNSQueryStats(l.clients, n, func(s *DNSQueryStats) uint64 { return s.Queries })
+}
+
+func topDNSQueryStats(stats map[string]*DNSQueryStats, n int, by func(*DNSQueryStats) uint64) []DNSQueryStats {
+	result := make([]DNSQueryStats, 0, len(stats))
+	for _, s := range stats {
+		if by(s) > 0 {
+			result = append(result, s.clone())
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if by(&result[i]) != by(&result[j]) {
+			return by(&result[i]) > by(&result[j])
+		}
+		return result[i].Key < result[j].Key
+	})
+	if len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
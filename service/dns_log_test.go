@@ -0,0 +1,131 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/mythologyli/zju-connect/internal/zcdns"
+	"github.com/mythologyli/zju-connect/resolve"
+)
+
+func TestDNSQueryLogKeepsRecentEntriesNewestFirst(t *testing.T) {
+	queryLog := NewDNSQueryLog(2, nil)
+	for _, name := range []string{"a", "b", "c"} {
+		queryLog.Record(DNSQueryLogEntry{Name: name, Result: DNSQueryResultOK})
+	}
+	entries := queryLog.Recent(10, nil)
+	if len(entries) != 2 || entries[0].Name != "c" || entries[1].Name != "b" {
+		t.Fatalf("Recent() = %+v, want c, b", entries)
+	}
+	if total := queryLog.Total(); total.Queries != 3 {
+		t.Fatalf("Total().Queries = %d, want 3", total.Queries)
+	}
+}
+
+func TestDNSQueryLogCountsFailuresPerName(t *testing.T) {
+	var out bytes.Buffer
+	queryLog := NewDNSQueryLog(10, &out)
+	queryLog.Record(DNSQueryLogEntry{Client: "10.0.0.2", Name: "a", Result: DNSQueryResultOK, Source: resolve.AnswerSourceRemote})
+	queryLog.Record(DNSQueryLogEntry{Client: "10.0.0.2", Name: "a", Result: DNSQueryResultError, Source: resolve.AnswerSourceSecondary})
+	queryLog.Record(DNSQueryLogEntry{Client: "10.0.0.3", Name: "b", Result: DNSQueryResultEmpty})
+
+	top := queryLog.TopNames(1)
+	if len(top) != 1 || top[0].Key != "a" || top[0].Queries != 2 || top[0].FailureRate != 0.5 {
+		t.Fatalf("TopNames(1) = %+v, want a with failure rate 0.5", top)
+	}
+	if top[0].Sources[resolve.AnswerSourceSecondary] != 1 {
+		t.Fatalf("sources = %v, want one secondary answer", top[0].Sources)
+	}
+	if failed := queryLog.TopFailedNames(10); len(failed) != 1 || failed[0].Key != "a" {
+		t.Fatalf("TopFailedNames() = %+v, want only a", failed)
+	}
+	if clients := queryLog.TopClients(10); len(clients) != 2 || clients[0].Key != "10.0.0.2" {
+		t.Fatalf("TopClients() = %+v", clients)
+	}
+	if lines := bytes.Count(out.Bytes(), []byte("\n")); lines != 3 {
+		t.Fatalf("query log file has %d lines, want 3", lines)
+	}
+}
+
+func TestDNSServerLogsQuerySource(t *testing.T) {
+	resolver := resolve.NewResolver(nil, "", "", 60, nil, nil, false, nil)
+	if err := resolver.SetCustomDNS([]resolve.HostsEntry{{HostName: "www.example", IPs: []net.IP{net.ParseIP("192.0.2.1")}}}); err != nil {
+		t.Fatal(err)
+	}
+	queryLog := NewDNSQueryLog(10, nil)
+	server := NewDnsServer(resolver, nil).WithQueryLog(queryLog)
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("www.example.", dns.TypeA)
+	ctx := zcdns.WithClientAddr(context.Background(), &net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 5353})
+	if _, err := server.HandleDnsMsg(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := queryLog.Recent(1, nil)
+	if len(entries) != 1 {
+		t.Fatalf("Recent() returned %d entries, want 1", len(entries))
+	}
+	entry := entries[0]
+	if entry.Client != "10.0.0.2" || entry.Name != "www.example" || entry.Type != "A" ||
+		entry.Source != resolve.AnswerSourceCustom || entry.Result != DNSQueryResultOK ||
+		len(entry.Answers) != 1 || entry.Answers[0] != "192.0.2.1" {
+		t.Fatalf("query log entry = %+v", entry)
+	}
+}
+
+func TestAdminDNSStatsRequiresToken(t *testing.T) {
+	queryLog := NewDNSQueryLog(10, nil)
+	queryLog.Record(DNSQueryLogEntry{Name: "a", Result: DNSQueryResultOK})
+	admin := NewAdminServer("secret")
+	admin.HandleDNSQueryLog(queryLog)
+
+	recorder := httptest.NewRecorder()
+	admin.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/dns/stats", nil))
+	if recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("status without token = %d, want 401", recorder.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/dns/stats?top=5", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	recorder = httptest.NewRecorder()
+	admin.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", recorder.Code, recorder.Body)
+	}
+	var body struct {
+		TopNames []DNSQueryStats `json:"top_names"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.TopNames) != 1 || body.TopNames[0].Key != "a" {
+		t.Fatalf("top_names = %+v", body.TopNames)
+	}
+}
+
+func TestDNSServerAnswersServFailInStrictMode(t *testing.T) {
+	resolver := resolve.NewResolver(nil, "", "", 60, nil, nil, false, nil)
+	resolver.SetStrict(true, []string{"intranet.example"})
+	queryLog := NewDNSQueryLog(10, nil)
+	server := NewDnsServer(resolver, nil).WithQueryLog(queryLog)
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("www.intranet.example.", dns.TypeA)
+	resMsg, err := server.HandleDnsMsg(context.Background(), msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resMsg.Rcode != dns.RcodeServerFailure || len(resMsg.Answer) != 0 {
+		t.Fatalf("response = %s, want SERVFAIL without answers", resMsg)
+	}
+	if entries := queryLog.Recent(1, nil); len(entries) != 1 || entries[0].Result != DNSQueryResultError {
+		t.Fatalf("query log = %+v, want one failed query", entries)
+	}
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/mythologyli/zju-connect/dial"
+	"github.com/mythologyli/zju-connect/stack"
+)
+
+const (
+	DefaultPingCount   = 4
+	DefaultPingTimeout = time.Second
+	maxAdminPingCount  = 10
+	socksPingTimeout   = time.Second
+)
+
+// pingInterval is the time between two echo requests, a variable for tests.
+var pingInterval = time.Second
+
+// Pinger pings hosts through the VPN, see dial.Dialer.Ping.
+type Pinger interface {
+	Ping(ctx context.Context, host string) (net.IP, time.Duration, error)
+}
+
+type PingReply struct {
+	Seq   int     `json:"seq"`
+	RTT   float64 `json:"rtt_ms,omitempty"`
+	Error string  `json:"error,omitempty"`
+}
+
+type PingResult struct {
+	Host     string      `json:"host"`
+	IP       string      `json:"ip,omitempty"`
+	Sent     int         `json:"sent"`
+	Received int         `json:"received"`
+	Loss     float64     `json:"loss_percent"`
+	MinRTT   float64     `json:"min_rtt_ms"`
+	AvgRTT   float64     `json:"avg_rtt_ms"`
+	MaxRTT   float64     `json:"max_rtt_ms"`
+	Replies  []PingReply `json:"replies"`
+}
+
+// Ping pings host count times, a second apart, waiting up to timeout for
+// each reply. onReply, if not nil, is called as each reply arrives. Pinging
+// stops early when ctx is done. An error is only returned when the host
+// cannot be pinged at all, e.g. when it is not reached through the VPN; lost
+// replies are counted in the result.
+func Ping(ctx context.Context, pinger Pinger, host string, count int, timeout time.Duration, onReply func(PingReply)) (*PingResult, error) {
+	result := &PingResult{Host: host, Replies: []PingReply{}}
+	var total time.Duration
+	for seq := 1; seq <= count; seq++ {
+		start := time.Now()
+		pingCtx, cancel := context.WithTimeout(ctx, timeout)
+		ip, rtt, err := pinger.Ping(pingCtx, host)
+		cancel()
+		if ctx.Err() != nil {
+			break
+		}
+		if err != nil && pingFailsForGood(ip, err) {
+			return nil, err
+		}
+		if ip != nil {
+			result.IP = ip.String()
+		}
+
+		result.Sent++
+		reply := PingReply{Seq: seq}
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				reply.Error = "timeout"
+			} else {
+				reply.Error = err.Error()
+			}
+		} else {
+			reply.RTT = milliseconds(rtt)
+			if result.Received == 0 || reply.RTT < result.MinRTT {
+				result.MinRTT = reply.RTT
+			}
+			result.MaxRTT = max(result.MaxRTT, reply.RTT)
+			result.Received++
+			total += rtt
+		}
+		result.Replies = append(result.Replies, reply)
+		if onReply != nil {
+			onReply(reply)
+		}
+
+		if seq < count {
+			select {
+			case <-ctx.Done():
+			case <-time.After(pingInterval - time.Since(start)):
+			}
+		}
+	}
+
+	if result.Sent > 0 {
+		result.Loss = 100 * float64(result.Sent-result.Received) / float64(result.Sent)
+	}
+	if result.Received > 0 {
+		result.AvgRTT = milliseconds(total / time.Duration(result.Received))
+	}
+	return result, nil
+}
+
+// pingFailsForGood reports whether err means that no echo request could be
+// sent at all, so that pinging again is pointless.
+func pingFailsForGood(ip net.IP, err error) bool {
+	return ip == nil ||
+		errors.Is(err, dial.ErrNotVPNTarget) ||
+		errors.Is(err, dial.ErrFakeIPUnresolvable) ||
+		errors.Is(err, stack.ErrICMPNotSupported)
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
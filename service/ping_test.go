@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mythologyli/zju-connect/dial"
+)
+
+// fakePinger answers pings with the next of its RTTs, where 0 is a lost reply.
+type fakePinger struct {
+	rtts  []time.Duration
+	err   error
+	pings int
+}
+
+func (p *fakePinger) Ping(ctx context.Context, host string) (net.IP, time.Duration, error) {
+	p.pings++
+	if p.err != nil {
+		return net.ParseIP(host), 0, p.err
+	}
+	rtt := p.rtts[(p.pings-1)%len(p.rtts)]
+	if rtt == 0 {
+		<-ctx.Done()
+		return net.ParseIP(host), 0, ctx.Err()
+	}
+	return net.ParseIP(host), rtt, nil
+}
+
+func (p *fakePinger) DialIPPort(context.Context, string, string) (net.Conn, error) {
+	return nil, errors.New("i/o timeout")
+}
+
+func TestPingCountsRepliesAndLoss(t *testing.T) {
+	defer func(interval time.Duration) { pingInterval = interval }(pingInterval)
+	pingInterval = 0
+	pinger := &fakePinger{rtts: []time.Duration{2 * time.Millisecond, 0, 4 * time.Millisecond, 6 * time.Millisecond}}
+
+	var replies []PingReply
+	result, err := Ping(context.Background(), pinger, "10.0.0.1", 4, 10*time.Millisecond, func(reply PingReply) {
+		replies = append(replies, reply)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.IP != "10.0.0.1" || result.Sent != 4 || result.Received != 3 || result.Loss != 25 {
+		t.Fatalf("result = %+v, want 3 of 4 replies from 10.0.0.1", result)
+	}
+	if result.MinRTT != 2 || result.AvgRTT != 4 || result.MaxRTT != 6 {
+		t.Fatalf("RTT min/avg/max = %v/%v/%v, want 2/4/6", result.MinRTT, result.AvgRTT, result.MaxRTT)
+	}
+	if len(replies) != 4 || replies[1].Error != "timeout" || replies[3].Seq != 4 {
+		t.Fatalf("replies = %+v", replies)
+	}
+}
+
+func TestPingStopsWhenHostCannotBePinged(t *testing.T) {
+	pinger := &fakePinger{err: dial.ErrNotVPNTarget}
+	if _, err := Ping(context.Background(), pinger, "192.0.2.1", 4, time.Second, nil); !errors.Is(err, dial.ErrNotVPNTarget) {
+		t.Fatalf("Ping() error = %v, want ErrNotVPNTarget", err)
+	}
+	if pinger.pings != 1 {
+		t.Fatalf("pinged %d times, want 1", pinger.pings)
+	}
+}
+
+func TestSocksDialReportsClosedPortOfLiveHost(t *testing.T) {
+	_, err := socksDial(&fakePinger{rtts: []time.Duration{time.Millisecond}})(context.Background(), "tcp", "10.0.0.1:22")
+	if err == nil || !errors.As(err, new(*portClosedError)) || !strings.Contains(err.Error(), "refused") {
+		t.Fatalf("dial error = %v, want connection refused", err)
+	}
+
+	_, err = socksDial(&fakePinger{err: dial.ErrNotVPNTarget})(context.Background(), "tcp", "10.0.0.1:22")
+	if err == nil || errors.As(err, new(*portClosedError)) {
+		t.Fatalf("dial error = %v, want the original error", err)
+	}
+}
@@ -0,0 +1,37 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mythologyli/zju-connect/internal/hook_func"
+	"github.com/mythologyli/zju-connect/log"
+)
+
+// ServeSMSInbox serves the endpoint SMS forwarders post verification codes to.
+func ServeSMSInbox(bindAddr string, inbox http.Handler) {
+	server := newHTTPServer(bindAddr, inbox)
+
+	log.Printf("SMS inbox listening on %s", bindAddr)
+
+	hook_func.RegisterTerminalFunc("CloseSMSInboxListener", func(ctx context.Context) error {
+		log.Println("Closing SMS inbox listener...")
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			return fmt.Errorf("close SMS inbox listener failed: %w", err)
+		}
+		return nil
+	})
+
+	if err := server.ListenAndServe(); err != nil {
+		if errors.Is(err, http.ErrServerClosed) {
+			log.Println("SMS inbox closed")
+		} else {
+			log.Println("SMS inbox listen failed: " + err.Error())
+		}
+	}
+}
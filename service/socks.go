@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"strings"
 
 	"github.com/mythologyli/zju-connect/dial"
 	"github.com/mythologyli/zju-connect/internal/hook_func"
@@ -13,7 +14,7 @@ import (
 	"github.com/things-go/go-socks5"
 )
 
-func ServeSocks5(bindAddr string, dialer *dial.Dialer, resolver *resolve.Resolver, user string, password string) {
+func ServeSocks5(bindAddr string, dialer *dial.Dialer, resolver *resolve.Resolver, user string, password string, probeClosedPort bool) {
 	var authMethods []socks5.Authenticator
 	if user != "" && password != "" {
 		authMethods = append(authMethods, socks5.UserPassAuthenticator{
@@ -26,10 +27,14 @@ func ServeSocks5(bindAddr string, dialer *dial.Dialer, resolver *resolve.Resolve
 		authMethods = append(authMethods, socks5.NoAuthAuthenticator{})
 	}
 
+	dialFunc := dialer.DialIPPort
+	if probeClosedPort {
+		dialFunc = socksDial(dialer)
+	}
 	server := socks5.NewServer(
 		socks5.WithAuthMethods(authMethods),
 		socks5.WithResolver(resolver),
-		socks5.WithDial(dialer.DialIPPort),
+		socks5.WithDial(dialFunc),
 		socks5.WithLogger(socks5.NewLogger(log.NewLogger("[SOCKS5] "))),
 	)
 
@@ -56,3 +61,49 @@ func ServeSocks5(bindAddr string, dialer *dial.Dialer, resolver *resolve.Resolve
 		}
 	}
 }
+
+type socksDialer interface {
+	Pinger
+	DialIPPort(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// socksDial tells a closed port apart from a host that is down. go-socks5
+// replies "host unreachable" to every dial error unless its message says
+// "refused", and a port that is closed behind the VPN usually shows up as a
+// timeout or reset. So when a TCP connection fails, the host is pinged, and
+// if it answers the error is reported as refused. The ping delays the error
+// by up to socksPingTimeout, so the probe is opt-in.
+func socksDial(dialer socksDialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialIPPort(ctx, network, addr)
+		if err == nil || network != "tcp" || ctx.Err() != nil ||
+			errors.Is(err, dial.ErrACLDenied) || strings.Contains(err.Error(), "refused") {
+			return conn, err
+		}
+		host, _, splitErr := net.SplitHostPort(addr)
+		if splitErr != nil {
+			return conn, err
+		}
+
+		pingCtx, cancel := context.WithTimeout(ctx, socksPingTimeout)
+		defer cancel()
+		if _, rtt, pingErr := dialer.Ping(pingCtx, host); pingErr == nil {
+			log.DebugPrintf("%s answers ping in %s, reporting the port as closed", host, rtt)
+			return nil, &portClosedError{err: err}
+		}
+		return conn, err
+	}
+}
+
+// portClosedError is a dial error to a host that answers ping.
+type portClosedError struct {
+	err error
+}
+
+func (e *portClosedError) Error() string {
+	return e.err.Error() + " (host is up, connection refused)"
+}
+
+func (e *portClosedError) Unwrap() error {
+	return e.err
+}
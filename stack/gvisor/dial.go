@@ -2,30 +2,104 @@ package gvisor
 
 import (
 	"context"
+	"errors"
 	"net"
+	"time"
 
 	"github.com/mythologyli/zju-connect/resolve"
+	zcstack "github.com/mythologyli/zju-connect/stack"
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/icmp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/waiter"
 )
 
 func (s *Stack) DialTCP(ctx context.Context, addr *net.TCPAddr) (net.Conn, error) {
 	if s.endpoint.client.CanUseTCPTunnel() && !resolve.TCPPrefersL3(ctx) {
-		return s.endpoint.client.DialTCP(ctx, addr)
+		conn, err := s.endpoint.client.DialTCP(ctx, addr)
+		if err != nil {
+			return nil, err
+		}
+		return s.tuning.TCPConn(conn), nil
 	}
 
-	return gonet.DialTCP(s.gvisorStack, tcpip.FullAddress{
+	conn, err := s.dialGVisorTCP(ctx, tcpip.FullAddress{
 		NIC:  NICID,
 		Port: uint16(addr.Port),
 		Addr: tcpip.AddrFromSlice(addr.IP),
-	}, header.IPv4ProtocolNumber)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.tuning.TCPConn(conn), nil
+}
+
+// dialGVisorTCP is like gonet.DialContextTCP, but tunes the endpoint before it
+// connects.
+func (s *Stack) dialGVisorTCP(ctx context.Context, addr tcpip.FullAddress) (*gonet.TCPConn, error) {
+	var wq waiter.Queue
+	ep, tcpipErr := s.gvisorStack.NewEndpoint(tcp.ProtocolNumber, header.IPv4ProtocolNumber, &wq)
+	if tcpipErr != nil {
+		return nil, errors.New(tcpipErr.String())
+	}
+	TuneTCPEndpoint(ep, s.tuning)
+
+	waitEntry, notifyCh := waiter.NewChannelEntry(waiter.WritableEvents)
+	wq.EventRegister(&waitEntry)
+	defer wq.EventUnregister(&waitEntry)
+
+	tcpipErr = ep.Connect(addr)
+	if _, ok := tcpipErr.(*tcpip.ErrConnectStarted); ok {
+		select {
+		case <-ctx.Done():
+			ep.Close()
+			return nil, ctx.Err()
+		case <-notifyCh:
+		}
+		tcpipErr = ep.LastError()
+	}
+	if tcpipErr != nil {
+		ep.Close()
+		return nil, &net.OpError{
+			Op:   "connect",
+			Net:  "tcp",
+			Addr: &net.TCPAddr{IP: net.IP(addr.Addr.AsSlice()), Port: int(addr.Port)},
+			Err:  errors.New(tcpipErr.String()),
+		}
+	}
+	return gonet.NewTCPConn(&wq, ep), nil
 }
 
 func (s *Stack) DialUDP(ctx context.Context, addr *net.UDPAddr) (net.Conn, error) {
-	return gonet.DialUDP(s.gvisorStack, nil, &tcpip.FullAddress{
+	conn, err := gonet.DialUDP(s.gvisorStack, nil, &tcpip.FullAddress{
 		NIC:  NICID,
 		Port: uint16(addr.Port),
 		Addr: tcpip.AddrFromSlice(addr.IP),
 	}, header.IPv4ProtocolNumber)
+	if err != nil {
+		return nil, err
+	}
+	return s.tuning.UDPConn(conn), nil
+}
+
+// Ping sends the echo request from an ICMP ping endpoint of the gVisor stack.
+func (s *Stack) Ping(ctx context.Context, ip net.IP) (time.Duration, error) {
+	ip = ip.To4()
+	if ip == nil {
+		return 0, errors.New("only IPv4 can be pinged")
+	}
+	var wq waiter.Queue
+	ep, tcpipErr := s.gvisorStack.NewEndpoint(icmp.ProtocolNumber4, header.IPv4ProtocolNumber, &wq)
+	if tcpipErr != nil {
+		return 0, errors.New(tcpipErr.String())
+	}
+	if tcpipErr = ep.Connect(tcpip.FullAddress{NIC: NICID, Addr: tcpip.AddrFromSlice(ip)}); tcpipErr != nil {
+		ep.Close()
+		return 0, errors.New(tcpipErr.String())
+	}
+	conn := gonet.NewUDPConn(&wq, ep)
+	defer conn.Close()
+	return zcstack.Echo(ctx, conn)
 }
@@ -7,18 +7,22 @@ import (
 	"net"
 	"os"
 	"sync"
+	"sync/atomic"
 
 	clientpkg "github.com/mythologyli/zju-connect/client"
 	"github.com/mythologyli/zju-connect/client/easyconnect"
 	"github.com/mythologyli/zju-connect/internal/hook_func"
 	"github.com/mythologyli/zju-connect/internal/ippool"
+	"github.com/mythologyli/zju-connect/internal/packetbuf"
 	"github.com/mythologyli/zju-connect/internal/zcdns"
 	"github.com/mythologyli/zju-connect/log"
+	"github.com/mythologyli/zju-connect/stack/tuning"
 	"gvisor.dev/gvisor/pkg/buffer"
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
 	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/icmp"
 	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
 	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
 )
@@ -31,30 +35,39 @@ type Stack struct {
 	endpoint *Endpoint
 	ipMu     sync.Mutex
 	ip       tcpip.Address
+	tuning   tuning.Options
 }
 
 const NICID tcpip.NICID = 1
-const MTU uint32 = 1400
 const maxInboundPacketSize = 1500
 
 type Endpoint struct {
 	client clientpkg.Client
+	mtu    uint32
 
-	l3Conn io.ReadWriteCloser
+	// link is set once the L3 connection is open.
+	link atomic.Pointer[l3Link]
 
 	dispatcher stack.NetworkDispatcher
 }
 
+// l3Link is the L3 connection of an endpoint.
+type l3Link struct {
+	conn io.ReadWriteCloser
+	// packetConn is conn if it takes packets to send in pooled buffers.
+	packetConn clientpkg.PacketConn
+}
+
 func (ep *Endpoint) ParseHeader(*stack.PacketBuffer) bool {
 	return true
 }
 
 func (ep *Endpoint) MTU() uint32 {
-	return MTU
+	return ep.mtu
 }
 
 func (ep *Endpoint) SetMTU(mtu uint32) {
-	log.Printf("don't support change MTU from %d to %d", MTU, mtu)
+	log.Printf("don't support change MTU from %d to %d", ep.mtu, mtu)
 }
 
 func (ep *Endpoint) MaxHeaderLength() uint16 {
@@ -93,11 +106,10 @@ func (ep *Endpoint) SetOnCloseAction(func()) {}
 
 // WritePackets is called when get packets from gVisor stack. Then it sends them to VPN server
 func (ep *Endpoint) WritePackets(list stack.PacketBufferList) (int, tcpip.Error) {
+	link := ep.link.Load()
 	for _, packetBuffer := range list.AsSlice() {
-		buf := joinPacketSlices(packetBuffer.AsSlices())
-
-		if ep.l3Conn != nil {
-			n, err := ep.l3Conn.Write(buf)
+		if link != nil {
+			err := link.writePacket(packetBuffer)
 			if err != nil {
 				if errors.Is(err, clientpkg.ErrResourceNotFound) {
 					log.Printf("%v", err)
@@ -124,38 +136,67 @@ func (ep *Endpoint) WritePackets(list stack.PacketBufferList) (int, tcpip.Error)
 					panic(err)
 				}
 			}
-			log.DebugPrintf("Send: wrote %d bytes", n)
-			log.DebugDumpHex(buf[:n])
 		}
 	}
 
 	return list.Len(), nil
 }
 
+// writePacket sends a packet to the VPN server. With a packet connection the
+// packet is copied once into a pooled buffer; otherwise it is joined into a
+// new slice for Write.
+func (l *l3Link) writePacket(packetBuffer *stack.PacketBuffer) error {
+	if l.packetConn != nil {
+		buf := packetbuf.Get(packetBuffer.Size())
+		n := copyPacketSlices(buf.Bytes(), packetBuffer.AsSlices())
+		buf.Truncate(n)
+		if log.DebugEnabled() {
+			log.DebugPrintf("Send: wrote %d bytes", n)
+			log.DebugDumpHex(buf.Bytes())
+		}
+		return l.packetConn.WritePacket(buf)
+	}
+
+	buf := joinPacketSlices(packetBuffer.AsSlices())
+	n, err := l.conn.Write(buf)
+	if err != nil {
+		return err
+	}
+	log.DebugPrintf("Send: wrote %d bytes", n)
+	log.DebugDumpHex(buf[:n])
+	return nil
+}
+
 func joinPacketSlices(slices [][]byte) []byte {
 	total := 0
 	for _, slice := range slices {
 		total += len(slice)
 	}
 	buf := make([]byte, total)
+	copyPacketSlices(buf, slices)
+	return buf
+}
+
+func copyPacketSlices(dst []byte, slices [][]byte) int {
 	offset := 0
 	for _, slice := range slices {
-		offset += copy(buf[offset:], slice)
+		offset += copy(dst[offset:], slice)
 	}
-	return buf
+	return offset
 }
 
-func NewStack(client clientpkg.Client) (*Stack, error) {
-	s := &Stack{}
+func NewStack(client clientpkg.Client, opts tuning.Options) (*Stack, error) {
+	s := &Stack{tuning: opts}
 
 	s.gvisorStack = stack.New(stack.Options{
 		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
-		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol, udp.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol, udp.NewProtocol, icmp.NewProtocol4},
 		HandleLocal:        true,
 	})
 
 	s.endpoint = &Endpoint{
 		client: client,
+		mtu:    opts.MTUOrDefault(),
 	}
 
 	tcpipErr := s.gvisorStack.CreateNIC(NICID, s.endpoint)
@@ -184,10 +225,9 @@ func NewStack(client clientpkg.Client) (*Stack, error) {
 	}
 	clientpkg.RegisterIPUpdateHandler(client, s.updateIP)
 
-	sOpt := tcpip.TCPSACKEnabled(true)
-	s.gvisorStack.SetTransportProtocolOption(tcp.ProtocolNumber, &sOpt)
-	cOpt := tcpip.CongestionControlOption("cubic")
-	s.gvisorStack.SetTransportProtocolOption(tcp.ProtocolNumber, &cOpt)
+	if err := ApplyTuning(s.gvisorStack, opts); err != nil {
+		return nil, err
+	}
 	s.gvisorStack.AddRoute(tcpip.Route{Destination: header.IPv4EmptySubnet, NIC: NICID})
 
 	return s, nil
@@ -227,34 +267,59 @@ func (s *Stack) SetupIPPool(ipPool *ippool.IPPool[[]clientpkg.DomainResource]) {
 	s.ipPool = ipPool
 }
 
+// Connect opens the L3 connection of the stack. Run opens it itself if
+// Connect was not called.
+func (s *Stack) Connect() error {
+	l3Conn, err := s.endpoint.client.NewL3Conn()
+	if err != nil {
+		return err
+	}
+	s.endpoint.setL3Conn(l3Conn)
+	return nil
+}
+
 func (s *Stack) Run() {
-	var connErr error
-	s.endpoint.l3Conn, connErr = s.endpoint.client.NewL3Conn()
-	if connErr != nil {
-		panic(connErr)
+	if s.endpoint.link.Load() == nil {
+		if err := s.Connect(); err != nil {
+			panic(err)
+		}
 	}
 	// Read from VPN server and send to gVisor stack
-	buf := make([]byte, maxInboundPacketSize)
 	for {
-		n, err := s.endpoint.l3Conn.Read(buf)
-		if err != nil {
+		if err := s.endpoint.receivePacket(); err != nil {
 			if hook_func.IsTerminal() {
 				return
 			} else {
 				panic(err)
 			}
 		}
-		log.DebugPrintf("Recv: read %d bytes", n)
-		log.DebugDumpHex(buf[:n])
-
-		packetBuffer := makeInboundPacketBuffer(buf, n)
-		s.endpoint.dispatcher.DeliverNetworkPacket(header.IPv4ProtocolNumber, packetBuffer)
-		packetBuffer.DecRef()
 	}
 }
 
-func makeInboundPacketBuffer(buf []byte, n int) *stack.PacketBuffer {
-	return stack.NewPacketBuffer(stack.PacketBufferOptions{
-		Payload: buffer.MakeWithData(buf[:n]),
+func (ep *Endpoint) setL3Conn(l3Conn io.ReadWriteCloser) {
+	link := &l3Link{conn: l3Conn}
+	link.packetConn, _ = l3Conn.(clientpkg.PacketConn)
+	ep.link.Store(link)
+}
+
+// receivePacket reads a packet from the VPN server and delivers it to the
+// gVisor stack. The packet is read straight into a pooled view of gVisor,
+// which the packet buffer takes over without copying.
+func (ep *Endpoint) receivePacket() error {
+	view := buffer.NewViewSize(max(maxInboundPacketSize, int(ep.mtu)))
+	n, err := ep.link.Load().conn.Read(view.AsSlice())
+	if err != nil {
+		view.Release()
+		return err
+	}
+	view.CapLength(n)
+	log.DebugPrintf("Recv: read %d bytes", n)
+	log.DebugDumpHex(view.AsSlice())
+
+	packetBuffer := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Payload: buffer.MakeWithView(view),
 	})
+	ep.dispatcher.DeliverNetworkPacket(header.IPv4ProtocolNumber, packetBuffer)
+	packetBuffer.DecRef()
+	return nil
 }
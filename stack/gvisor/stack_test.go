@@ -2,7 +2,13 @@ package gvisor
 
 import (
 	"bytes"
+	"io"
 	"testing"
+
+	"github.com/mythologyli/zju-connect/internal/packetbuf"
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
 )
 
 var joinedPacketSink []byte
@@ -15,26 +21,17 @@ func TestJoinPacketSlicesPreservesContent(t *testing.T) {
 	}
 }
 
-func TestInboundPacketBufferPreservesReadBytes(t *testing.T) {
-	buf := []byte{0x45, 0x00, 0x12, 0x34, 0x00, 0x00}
-	const bytesRead = 4
-	packet := makeInboundPacketBuffer(buf, bytesRead)
-	defer packet.DecRef()
-	got := joinPacketSlices(packet.AsSlices())
-	if !bytes.Equal(got[:bytesRead], buf[:bytesRead]) {
-		t.Fatalf("packet prefix = % X, want % X", got[:bytesRead], buf[:bytesRead])
+func TestReceivePacketExcludesUnreadCapacity(t *testing.T) {
+	want := []byte{0x45, 0x00, 0x12, 0x34}
+	dispatcher := &recordingDispatcher{record: true}
+	ep := &Endpoint{}
+	ep.Attach(dispatcher)
+	ep.setL3Conn(byteStreamConn{&packetReader{packets: [][]byte{want}}})
+	if err := ep.receivePacket(); err != nil {
+		t.Fatal(err)
 	}
-}
-
-func TestInboundPacketBufferExcludesUnreadCapacity(t *testing.T) {
-	buf := []byte{0x45, 0x00, 0x12, 0x34, 0xde, 0xad}
-	const bytesRead = 4
-	packet := makeInboundPacketBuffer(buf, bytesRead)
-	defer packet.DecRef()
-
-	got := joinPacketSlices(packet.AsSlices())
-	if !bytes.Equal(got, buf[:bytesRead]) {
-		t.Fatalf("packet = % X, want only read bytes % X", got, buf[:bytesRead])
+	if len(dispatcher.packets) != 1 || !bytes.Equal(dispatcher.packets[0], want) {
+		t.Fatalf("delivered packets = % X, want only read bytes % X", dispatcher.packets, want)
 	}
 }
 
@@ -53,3 +50,129 @@ func BenchmarkJoinPacketSlices(b *testing.B) {
 		joinedPacketSink = joinPacketSlices(slices)
 	}
 }
+
+// loopbackConn sends written packets back to the reader.
+type loopbackConn struct {
+	packets chan *packetbuf.Buffer
+}
+
+func newLoopbackConn() *loopbackConn {
+	return &loopbackConn{packets: make(chan *packetbuf.Buffer, 1)}
+}
+
+func (c *loopbackConn) Read(p []byte) (int, error) {
+	buf := <-c.packets
+	n := copy(p, buf.Bytes())
+	buf.Release()
+	return n, nil
+}
+
+func (c *loopbackConn) Write(p []byte) (int, error) {
+	buf := packetbuf.Get(len(p))
+	copy(buf.Bytes(), p)
+	c.packets <- buf
+	return len(p), nil
+}
+
+func (c *loopbackConn) Close() error { return nil }
+
+func (c *loopbackConn) WritePacket(buf *packetbuf.Buffer) error {
+	c.packets <- buf
+	return nil
+}
+
+// packetReader returns one of packets per Read.
+type packetReader struct {
+	packets [][]byte
+}
+
+func (r *packetReader) Read(p []byte) (int, error) {
+	if len(r.packets) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.packets[0])
+	r.packets = r.packets[1:]
+	return n, nil
+}
+
+func (*packetReader) Write(p []byte) (int, error) { return len(p), nil }
+func (*packetReader) Close() error                { return nil }
+
+// byteStreamConn hides the packet methods of the connection it wraps.
+type byteStreamConn struct {
+	io.ReadWriteCloser
+}
+
+type recordingDispatcher struct {
+	record  bool
+	packets [][]byte
+}
+
+func (d *recordingDispatcher) DeliverNetworkPacket(_ tcpip.NetworkProtocolNumber, packet *stack.PacketBuffer) {
+	if d.record {
+		d.packets = append(d.packets, joinPacketSlices(packet.AsSlices()))
+	}
+}
+
+func (*recordingDispatcher) DeliverLinkPacket(tcpip.NetworkProtocolNumber, *stack.PacketBuffer) {}
+
+func writeTestPacket(t testing.TB, ep *Endpoint, payload []byte) {
+	packet := stack.NewPacketBuffer(stack.PacketBufferOptions{Payload: buffer.MakeWithData(payload)})
+	var list stack.PacketBufferList
+	list.PushBack(packet)
+	if _, err := ep.WritePackets(list); err != nil {
+		t.Fatalf("WritePackets() error = %v", err)
+	}
+	list.DecRef()
+}
+
+func TestEndpointRoundTrip(t *testing.T) {
+	for name, l3Conn := range map[string]io.ReadWriteCloser{
+		"packet":      newLoopbackConn(),
+		"byte stream": byteStreamConn{newLoopbackConn()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dispatcher := &recordingDispatcher{record: true}
+			ep := &Endpoint{}
+			ep.Attach(dispatcher)
+			ep.setL3Conn(l3Conn)
+			if _, isPacketConn := l3Conn.(*loopbackConn); isPacketConn != (ep.link.Load().packetConn != nil) {
+				t.Fatalf("packet connection detected = %v, want %v", ep.link.Load().packetConn != nil, isPacketConn)
+			}
+
+			want := []byte{0x45, 0x00, 0x00, 0x06, 0x12, 0x34}
+			writeTestPacket(t, ep, want)
+			if err := ep.receivePacket(); err != nil {
+				t.Fatal(err)
+			}
+			if len(dispatcher.packets) != 1 || !bytes.Equal(dispatcher.packets[0], want) {
+				t.Fatalf("delivered packets = % X, want % X", dispatcher.packets, want)
+			}
+		})
+	}
+}
+
+func benchmarkEndpointRoundTrip(b *testing.B, l3Conn io.ReadWriteCloser) {
+	ep := &Endpoint{}
+	ep.Attach(&recordingDispatcher{})
+	ep.setL3Conn(l3Conn)
+	payload := make([]byte, 1200)
+	payload[0] = 0x45
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(payload)))
+	for i := 0; i < b.N; i++ {
+		writeTestPacket(b, ep, payload)
+		if err := ep.receivePacket(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEndpointRoundTripPacketConn(b *testing.B) {
+	benchmarkEndpointRoundTrip(b, newLoopbackConn())
+}
+
+func BenchmarkEndpointRoundTripByteStream(b *testing.B) {
+	benchmarkEndpointRoundTrip(b, byteStreamConn{newLoopbackConn()})
+}
@@ -0,0 +1,54 @@
+package gvisor
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mythologyli/zju-connect/stack/tuning"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+)
+
+// ApplyTuning sets the TCP options of a gVisor stack. It is also used by the
+// TCP listener stack of the TUN stack.
+func ApplyTuning(s *stack.Stack, opts tuning.Options) error {
+	sackOpt := tcpip.TCPSACKEnabled(true)
+	ccOpt := tcpip.CongestionControlOption(opts.CongestionControl)
+	if ccOpt == "" {
+		ccOpt = "cubic"
+	}
+	var sendOpt tcpip.TCPSendBufferSizeRangeOption
+	sendOpt.Min, sendOpt.Default, sendOpt.Max = opts.SendBufferRange()
+	var receiveOpt tcpip.TCPReceiveBufferSizeRangeOption
+	receiveOpt.Min, receiveOpt.Default, receiveOpt.Max = opts.ReceiveBufferRange()
+	autoTuneOpt := tcpip.TCPModerateReceiveBufferOption(opts.BufferAutoTune)
+
+	for _, opt := range []struct {
+		name   string
+		option tcpip.SettableTransportProtocolOption
+	}{
+		{"SACK", &sackOpt},
+		{"congestion control", &ccOpt},
+		{"send buffer size", &sendOpt},
+		{"receive buffer size", &receiveOpt},
+		{"receive buffer auto-tuning", &autoTuneOpt},
+	} {
+		if err := s.SetTransportProtocolOption(tcp.ProtocolNumber, opt.option); err != nil {
+			return fmt.Errorf("set TCP %s: %w", opt.name, errors.New(err.String()))
+		}
+	}
+	return nil
+}
+
+// TuneTCPEndpoint sets up keepalive on a TCP endpoint of a gVisor stack.
+func TuneTCPEndpoint(ep tcpip.Endpoint, opts tuning.Options) {
+	if opts.KeepAlive <= 0 {
+		return
+	}
+	idleOpt := tcpip.KeepaliveIdleOption(opts.KeepAlive)
+	intervalOpt := tcpip.KeepaliveIntervalOption(opts.KeepAliveIntervalOrDefault())
+	_ = ep.SetSockOpt(&idleOpt)
+	_ = ep.SetSockOpt(&intervalOpt)
+	ep.SocketOptions().SetKeepAlive(true)
+}
@@ -0,0 +1,45 @@
+package hybrid
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/mythologyli/zju-connect/client"
+	"github.com/mythologyli/zju-connect/log"
+	"github.com/mythologyli/zju-connect/resolve"
+)
+
+func (s *Stack) DialTCP(ctx context.Context, addr *net.TCPAddr) (net.Conn, error) {
+	if !resolve.TCPPrefersL3(ctx) && s.client.CanUseTCPTunnel() {
+		conn, err := s.tcpTunnel.DialTCP(ctx, addr)
+		if err == nil || !errors.Is(err, client.ErrResourceNotFound) {
+			return conn, err
+		}
+		log.DebugPrintf("TCP tunnel does not cover %s, use L3 tunnel: %v", addr, err)
+	}
+
+	l3Stack, err := s.l3(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("start L3 stack: %w", err)
+	}
+	return l3Stack.DialTCP(ctx, addr)
+}
+
+func (s *Stack) DialUDP(ctx context.Context, addr *net.UDPAddr) (net.Conn, error) {
+	l3Stack, err := s.l3(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("start L3 stack: %w", err)
+	}
+	return l3Stack.DialUDP(ctx, addr)
+}
+
+func (s *Stack) Ping(ctx context.Context, ip net.IP) (time.Duration, error) {
+	l3Stack, err := s.l3(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("start L3 stack: %w", err)
+	}
+	return l3Stack.Ping(ctx, ip)
+}
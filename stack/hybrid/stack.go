@@ -0,0 +1,123 @@
+package hybrid
+
+import (
+	"context"
+	"sync"
+
+	"github.com/mythologyli/zju-connect/client"
+	"github.com/mythologyli/zju-connect/internal/ippool"
+	"github.com/mythologyli/zju-connect/internal/zcdns"
+	"github.com/mythologyli/zju-connect/log"
+	"github.com/mythologyli/zju-connect/stack"
+	"github.com/mythologyli/zju-connect/stack/tcptunnel"
+)
+
+// Stack sends plain TCP through the aTrust TCP tunnel, which needs no L3
+// connection and no per-flow L3 authentication. UDP, resources preferring L3
+// and everything the TCP tunnel does not cover go through an L3 stack, which
+// is only created when it is first needed.
+type Stack struct {
+	client    client.Client
+	tcpTunnel *tcptunnel.Stack
+	// newL3Stack creates the L3 stack and opens its L3 connection.
+	newL3Stack func() (stack.Stack, error)
+
+	mu      sync.Mutex
+	l3Stack stack.Stack
+	l3Start *l3Start
+	resolve zcdns.LocalServer
+	ipPool  *ippool.IPPool[[]client.DomainResource]
+}
+
+// l3Start is a creation of the L3 stack in progress.
+type l3Start struct {
+	done  chan struct{}
+	stack stack.Stack
+	err   error
+}
+
+// Run does nothing, the L3 stack is run when it is created.
+func (s *Stack) Run() {}
+
+func NewStack(client client.Client, newL3Stack func() (stack.Stack, error)) (*Stack, error) {
+	tcpTunnel, err := tcptunnel.NewStack(client)
+	if err != nil {
+		return nil, err
+	}
+	s := &Stack{
+		client:     client,
+		tcpTunnel:  tcpTunnel,
+		newL3Stack: newL3Stack,
+	}
+	return s, nil
+}
+
+func (s *Stack) SetupResolve(r zcdns.LocalServer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.resolve = r
+	s.tcpTunnel.SetupResolve(r)
+	if s.l3Stack != nil {
+		s.l3Stack.SetupResolve(r)
+	}
+}
+
+func (s *Stack) SetupIPPool(ipPool *ippool.IPPool[[]client.DomainResource]) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ipPool = ipPool
+	s.tcpTunnel.SetupIPPool(ipPool)
+	if s.l3Stack != nil {
+		s.l3Stack.SetupIPPool(ipPool)
+	}
+}
+
+// l3 returns the L3 stack, creating it on the first call and running it once
+// its L3 connection is open. Callers wait for the creation until ctx is done.
+// A failed creation is retried on the next call.
+func (s *Stack) l3(ctx context.Context) (stack.Stack, error) {
+	s.mu.Lock()
+	if s.l3Stack != nil {
+		defer s.mu.Unlock()
+		return s.l3Stack, nil
+	}
+	start := s.l3Start
+	if start == nil {
+		start = &l3Start{done: make(chan struct{})}
+		s.l3Start = start
+		go s.startL3(start)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-start.done:
+		return start.stack, start.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *Stack) startL3(start *l3Start) {
+	log.Println("Start L3 stack on demand")
+	l3Stack, err := s.newL3Stack()
+
+	s.mu.Lock()
+	if err == nil {
+		if s.resolve != nil {
+			l3Stack.SetupResolve(s.resolve)
+		}
+		if s.ipPool != nil {
+			l3Stack.SetupIPPool(s.ipPool)
+		}
+		go l3Stack.Run()
+		s.l3Stack = l3Stack
+	} else {
+		log.Printf("Start L3 stack error: %s", err)
+	}
+	s.l3Start = nil
+	start.stack, start.err = l3Stack, err
+	s.mu.Unlock()
+	close(start.done)
+}
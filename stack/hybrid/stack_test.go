@@ -0,0 +1,170 @@
+package hybrid
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mythologyli/zju-connect/client"
+	"github.com/mythologyli/zju-connect/internal/ippool"
+	"github.com/mythologyli/zju-connect/internal/zcdns"
+	"github.com/mythologyli/zju-connect/resolve"
+	"github.com/mythologyli/zju-connect/stack"
+	"inet.af/netaddr"
+)
+
+var errTCPTunnel = errors.New("dialed by TCP tunnel")
+
+var errL3 = errors.New("dialed by L3 stack")
+
+// fakeClient dials TCP tunnel connections for the addresses in covered only.
+type fakeClient struct {
+	covered map[string]bool
+}
+
+func (c *fakeClient) IP() (net.IP, error)                              { return nil, nil }
+func (c *fakeClient) IPSet() (*netaddr.IPSet, error)                   { return nil, nil }
+func (c *fakeClient) IPResources() ([]client.IPResource, error)        { return nil, nil }
+func (c *fakeClient) DomainResources() (client.DomainResources, error) { return nil, nil }
+func (c *fakeClient) DNSResource() (map[string][]net.IP, error)        { return nil, nil }
+func (c *fakeClient) DNSServer() (string, error)                       { return "", nil }
+func (c *fakeClient) DNSServers() ([]string, error)                    { return nil, nil }
+func (c *fakeClient) CanUseTCPTunnel() bool                            { return true }
+func (c *fakeClient) NewL3Conn() (io.ReadWriteCloser, error)           { return nil, errors.New("unused") }
+
+func (c *fakeClient) DialTCP(ctx context.Context, addr *net.TCPAddr) (net.Conn, error) {
+	if !c.covered[addr.String()] {
+		return nil, client.ErrResourceNotFound
+	}
+	return nil, errTCPTunnel
+}
+
+type fakeL3Stack struct {
+	resolve zcdns.LocalServer
+	run     chan struct{}
+}
+
+func (s *fakeL3Stack) Run() { close(s.run) }
+
+func (s *fakeL3Stack) SetupResolve(r zcdns.LocalServer) { s.resolve = r }
+
+func (s *fakeL3Stack) SetupIPPool(*ippool.IPPool[[]client.DomainResource]) {}
+
+func (s *fakeL3Stack) DialTCP(context.Context, *net.TCPAddr) (net.Conn, error) { return nil, errL3 }
+
+func (s *fakeL3Stack) DialUDP(context.Context, *net.UDPAddr) (net.Conn, error) { return nil, errL3 }
+
+func (s *fakeL3Stack) Ping(context.Context, net.IP) (time.Duration, error) { return 0, errL3 }
+
+func newTestStack(t *testing.T, covered ...string) (*Stack, *int) {
+	t.Helper()
+	c := &fakeClient{covered: make(map[string]bool)}
+	for _, addr := range covered {
+		c.covered[addr] = true
+	}
+	created := new(int)
+	s, err := NewStack(c, func() (stack.Stack, error) {
+		*created++
+		return &fakeL3Stack{run: make(chan struct{})}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, created
+}
+
+func TestDialTCPUsesTCPTunnel(t *testing.T) {
+	s, created := newTestStack(t, "10.0.0.1:80")
+	if _, err := s.DialTCP(context.Background(), &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 80}); !errors.Is(err, errTCPTunnel) {
+		t.Fatalf("DialTCP() error = %v, want TCP tunnel", err)
+	}
+	if *created != 0 {
+		t.Fatal("L3 stack was created for a TCP tunnel resource")
+	}
+}
+
+func TestDialFallsBackToL3(t *testing.T) {
+	s, created := newTestStack(t, "10.0.0.1:80")
+	preferL3 := context.WithValue(context.Background(), resolve.ContextKeyDomainResource, client.DomainResource{EnableTCPPrefL3: true})
+
+	for _, dial := range []func() error{
+		func() error {
+			_, err := s.DialTCP(preferL3, &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 80})
+			return err
+		},
+		func() error {
+			_, err := s.DialTCP(context.Background(), &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 80})
+			return err
+		},
+		func() error {
+			_, err := s.DialUDP(context.Background(), &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 53})
+			return err
+		},
+	} {
+		if err := dial(); !errors.Is(err, errL3) {
+			t.Fatalf("dial error = %v, want L3 stack", err)
+		}
+	}
+	if *created != 1 {
+		t.Fatalf("L3 stack created %d times, want 1", *created)
+	}
+	<-s.l3Stack.(*fakeL3Stack).run
+}
+
+func TestL3StackGetsEarlierSetup(t *testing.T) {
+	s, _ := newTestStack(t)
+	var r zcdns.LocalServer = &struct{ zcdns.LocalServer }{}
+	s.SetupResolve(r)
+	if _, err := s.DialUDP(context.Background(), &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 53}); !errors.Is(err, errL3) {
+		t.Fatal(err)
+	}
+	if s.l3Stack.(*fakeL3Stack).resolve != r {
+		t.Fatal("L3 stack did not get the resolver set up before its creation")
+	}
+}
+
+func TestL3StackCreationIsRetried(t *testing.T) {
+	c := &fakeClient{}
+	attempts := 0
+	s, err := NewStack(c, func() (stack.Stack, error) {
+		attempts++
+		if attempts == 1 {
+			return nil, errors.New("no L3 connection")
+		}
+		return &fakeL3Stack{run: make(chan struct{})}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	udpAddr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 53}
+	if _, err := s.DialUDP(context.Background(), udpAddr); err == nil || errors.Is(err, errL3) {
+		t.Fatalf("first DialUDP() error = %v, want creation error", err)
+	}
+	if _, err := s.DialUDP(context.Background(), udpAddr); !errors.Is(err, errL3) {
+		t.Fatalf("second DialUDP() error = %v, want L3 stack", err)
+	}
+}
+
+func TestL3StackCreationWaitHonorsContext(t *testing.T) {
+	release := make(chan struct{})
+	s, err := NewStack(&fakeClient{}, func() (stack.Stack, error) {
+		<-release
+		return &fakeL3Stack{run: make(chan struct{})}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	udpAddr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 53}
+	if _, err := s.DialUDP(ctx, udpAddr); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("DialUDP() error = %v, want the deadline of the context", err)
+	}
+	close(release)
+	if _, err := s.DialUDP(context.Background(), udpAddr); !errors.Is(err, errL3) {
+		t.Fatalf("DialUDP() after creation error = %v, want L3 stack", err)
+	}
+}
@@ -0,0 +1,92 @@
+package stack
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// ErrICMPNotSupported is returned by Ping of stacks that cannot send ICMP.
+var ErrICMPNotSupported = errors.New("ICMP is not supported by this stack")
+
+// ErrDestinationUnreachable is returned by Echo when an ICMP destination
+// unreachable message answers the echo request.
+var ErrDestinationUnreachable = errors.New("destination unreachable")
+
+var echoSeq atomic.Uint32
+
+// Echo sends an ICMP echo request on conn, which reads and writes ICMP
+// messages without IP header, and waits for the reply until ctx is done. Ping
+// sockets rewrite the identifier, so replies are matched by sequence number
+// and payload.
+func Echo(ctx context.Context, conn net.Conn) (time.Duration, error) {
+	seq := int(uint16(echoSeq.Add(1)))
+	payload := make([]byte, 16)
+	_, _ = rand.Read(payload)
+	request, err := (&icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: os.Getpid() & 0xffff, Seq: seq, Data: payload},
+	}).Marshal(nil)
+	if err != nil {
+		return 0, err
+	}
+
+	// Only expire the deadline once ctx is done, so that a timed out read
+	// always reports the error of ctx.
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	start := time.Now()
+	if _, err := conn.Write(request); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return 0, ctx.Err()
+			}
+			return 0, err
+		}
+		reply, err := icmp.ParseMessage(ipv4.ICMPTypeEcho.Protocol(), buf[:n])
+		if err != nil {
+			continue
+		}
+		switch body := reply.Body.(type) {
+		case *icmp.Echo:
+			if reply.Type == ipv4.ICMPTypeEchoReply && body.Seq == seq && bytes.Equal(body.Data, payload) {
+				return time.Since(start), nil
+			}
+		case *icmp.DstUnreach:
+			if quotesEcho(body.Data, seq) {
+				return 0, ErrDestinationUnreachable
+			}
+		}
+	}
+}
+
+// quotesEcho reports whether the IP packet quoted by an ICMP error is the echo
+// request with sequence number seq.
+func quotesEcho(quoted []byte, seq int) bool {
+	if len(quoted) < ipv4.HeaderLen {
+		return false
+	}
+	headerLen := int(quoted[0]&0x0f) * 4
+	if len(quoted) < headerLen+8 {
+		return false
+	}
+	echo := quoted[headerLen:]
+	return echo[0] == byte(ipv4.ICMPTypeEcho) && int(binary.BigEndian.Uint16(echo[6:8])) == seq
+}
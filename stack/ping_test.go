@@ -0,0 +1,92 @@
+package stack
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// answerEcho reads an echo request from conn and answers it with the replies
+// reply builds, like a ping socket that rewrites the identifier.
+func answerEcho(t *testing.T, conn net.Conn, reply func(request *icmp.Echo) []*icmp.Message) {
+	buf := make([]byte, 1500)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	message, err := icmp.ParseMessage(1, buf[:n])
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, message := range reply(message.Body.(*icmp.Echo)) {
+		b, err := message.Marshal(nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := conn.Write(b); err != nil {
+			return
+		}
+	}
+}
+
+func TestEchoMatchesReply(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	go answerEcho(t, remote, func(request *icmp.Echo) []*icmp.Message {
+		return []*icmp.Message{
+			// A reply to another request is skipped.
+			{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: 1, Seq: request.Seq + 1, Data: request.Data}},
+			{Type: ipv4.ICMPTypeEchoReply, Body: &icmp.Echo{ID: 1, Seq: request.Seq, Data: request.Data}},
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := Echo(ctx, local); err != nil {
+		t.Fatalf("Echo() error = %v", err)
+	}
+}
+
+func TestEchoDestinationUnreachable(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	go answerEcho(t, remote, func(request *icmp.Echo) []*icmp.Message {
+		quoted, _ := (&icmp.Message{Type: ipv4.ICMPTypeEcho, Body: request}).Marshal(nil)
+		header := make([]byte, ipv4.HeaderLen)
+		header[0] = 0x45
+		return []*icmp.Message{{
+			Type: ipv4.ICMPTypeDestinationUnreachable,
+			Code: 1,
+			Body: &icmp.DstUnreach{Data: append(header, quoted...)},
+		}}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := Echo(ctx, local); !errors.Is(err, ErrDestinationUnreachable) {
+		t.Fatalf("Echo() error = %v, want ErrDestinationUnreachable", err)
+	}
+}
+
+func TestEchoTimesOut(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	go answerEcho(t, remote, func(*icmp.Echo) []*icmp.Message { return nil })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := Echo(ctx, local); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Echo() error = %v, want context.DeadlineExceeded", err)
+	}
+}
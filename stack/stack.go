@@ -3,6 +3,7 @@ package stack
 import (
 	"context"
 	"net"
+	"time"
 
 	"github.com/mythologyli/zju-connect/client"
 	"github.com/mythologyli/zju-connect/internal/ippool"
@@ -15,4 +16,13 @@ type Stack interface {
 	SetupIPPool(ipPool *ippool.IPPool[[]client.DomainResource])
 	DialTCP(ctx context.Context, addr *net.TCPAddr) (net.Conn, error)
 	DialUDP(ctx context.Context, addr *net.UDPAddr) (net.Conn, error)
+	// Ping sends an ICMP echo request to ip and returns the round-trip time
+	// of the reply.
+	Ping(ctx context.Context, ip net.IP) (time.Duration, error)
+}
+
+// DNSConnTracker is implemented by stacks which hijack DNS queries. Queries
+// sent on a tracked connection are the resolver's own and are passed through.
+type DNSConnTracker interface {
+	TrackDNSConn(conn net.Conn, addr *net.UDPAddr) net.Conn
 }
@@ -4,8 +4,12 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"time"
 
+	"github.com/mythologyli/zju-connect/client"
+	"github.com/mythologyli/zju-connect/log"
 	"github.com/mythologyli/zju-connect/resolve"
+	"github.com/mythologyli/zju-connect/stack"
 )
 
 func (s *Stack) DialTCP(ctx context.Context, addr *net.TCPAddr) (net.Conn, error) {
@@ -19,6 +23,24 @@ func (s *Stack) DialTCP(ctx context.Context, addr *net.TCPAddr) (net.Conn, error
 	return nil, fmt.Errorf("not implemented")
 }
 
+// DialUDP sends DNS queries over TCP, which every server carries. Other UDP
+// is relayed through the TCP tunnel if the client supports it.
 func (s *Stack) DialUDP(ctx context.Context, addr *net.UDPAddr) (net.Conn, error) {
-	return nil, fmt.Errorf("not implemented")
+	if addr.Port == 53 {
+		log.DebugPrintf("Send DNS queries to %s over TCP", addr)
+		conn, err := s.DialTCP(ctx, &net.TCPAddr{IP: addr.IP, Port: addr.Port, Zone: addr.Zone})
+		if err != nil {
+			return nil, err
+		}
+		return newDNSConn(conn, addr), nil
+	}
+	if udpDialer, ok := s.client.(client.UDPDialer); ok && s.client.CanUseTCPTunnel() {
+		return udpDialer.DialUDP(ctx, addr)
+	}
+	return nil, client.ErrUDPNotSupported
+}
+
+// Ping is not supported, the TCP tunnel carries no ICMP.
+func (s *Stack) Ping(context.Context, net.IP) (time.Duration, error) {
+	return 0, stack.ErrICMPNotSupported
 }
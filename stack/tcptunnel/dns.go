@@ -0,0 +1,76 @@
+package tcptunnel
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// dnsConn exchanges DNS messages written and read as datagrams over a TCP
+// connection, where each message is prefixed with its length as described
+// in RFC 1035 section 4.2.2. It is a net.PacketConn, so that net.Resolver
+// does not add a length prefix of its own.
+type dnsConn struct {
+	net.Conn
+	addr *net.UDPAddr
+
+	readMu  sync.Mutex
+	writeMu sync.Mutex
+}
+
+func newDNSConn(conn net.Conn, addr *net.UDPAddr) *dnsConn {
+	return &dnsConn{Conn: conn, addr: addr}
+}
+
+// Read reads one DNS message. Like a UDP socket, it discards the part that
+// does not fit into b.
+func (c *dnsConn) Read(b []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	var lengthBytes [2]byte
+	if _, err := io.ReadFull(c.Conn, lengthBytes[:]); err != nil {
+		return 0, err
+	}
+	length := int(binary.BigEndian.Uint16(lengthBytes[:]))
+	if length <= len(b) {
+		return io.ReadFull(c.Conn, b[:length])
+	}
+	n, err := io.ReadFull(c.Conn, b)
+	if err != nil {
+		return n, err
+	}
+	_, err = io.CopyN(io.Discard, c.Conn, int64(length-n))
+	return n, err
+}
+
+func (c *dnsConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, err := c.Read(b)
+	return n, c.addr, err
+}
+
+func (c *dnsConn) Write(b []byte) (int, error) {
+	if len(b) > 0xFFFF {
+		return 0, errors.New("DNS message too large")
+	}
+	msg := make([]byte, 2+len(b))
+	binary.BigEndian.PutUint16(msg, uint16(len(b)))
+	copy(msg[2:], b)
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if _, err := c.Conn.Write(msg); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *dnsConn) WriteTo(b []byte, _ net.Addr) (int, error) {
+	return c.Write(b)
+}
+
+func (c *dnsConn) RemoteAddr() net.Addr {
+	return c.addr
+}
@@ -0,0 +1,116 @@
+package tcptunnel
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/mythologyli/zju-connect/client"
+	"inet.af/netaddr"
+)
+
+// fakeClient answers DNS over TCP and fails to relay UDP with udpErr.
+type fakeClient struct {
+	udpErr error
+}
+
+func (c *fakeClient) IP() (net.IP, error)                              { return nil, nil }
+func (c *fakeClient) IPSet() (*netaddr.IPSet, error)                   { return nil, nil }
+func (c *fakeClient) IPResources() ([]client.IPResource, error)        { return nil, nil }
+func (c *fakeClient) DomainResources() (client.DomainResources, error) { return nil, nil }
+func (c *fakeClient) DNSResource() (map[string][]net.IP, error)        { return nil, nil }
+func (c *fakeClient) DNSServer() (string, error)                       { return "", nil }
+func (c *fakeClient) DNSServers() ([]string, error)                    { return nil, nil }
+func (c *fakeClient) CanUseTCPTunnel() bool                            { return true }
+func (c *fakeClient) NewL3Conn() (io.ReadWriteCloser, error)           { return nil, errors.New("unused") }
+
+func (c *fakeClient) DialUDP(context.Context, *net.UDPAddr) (net.Conn, error) {
+	return nil, c.udpErr
+}
+
+func (c *fakeClient) DialTCP(ctx context.Context, addr *net.TCPAddr) (net.Conn, error) {
+	local, remote := net.Pipe()
+	go serveTCPDNS(remote)
+	return local, nil
+}
+
+func serveTCPDNS(conn net.Conn) {
+	defer conn.Close()
+	for {
+		var lengthBytes [2]byte
+		if _, err := io.ReadFull(conn, lengthBytes[:]); err != nil {
+			return
+		}
+		query := make([]byte, binary.BigEndian.Uint16(lengthBytes[:]))
+		if _, err := io.ReadFull(conn, query); err != nil {
+			return
+		}
+		var msg dns.Msg
+		if err := msg.Unpack(query); err != nil {
+			return
+		}
+		reply := new(dns.Msg)
+		reply.SetReply(&msg)
+		if msg.Question[0].Qtype == dns.TypeA {
+			rr, _ := dns.NewRR(msg.Question[0].Name + " 60 IN A 10.10.98.98")
+			reply.Answer = append(reply.Answer, rr)
+		}
+		packed, _ := reply.Pack()
+		if _, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(packed))), packed...)); err != nil {
+			return
+		}
+	}
+}
+
+func TestDialUDPSendsDNSOverTCP(t *testing.T) {
+	// The UDP relay is never used for DNS, even if it is available.
+	s, err := NewStack(&fakeClient{udpErr: errors.New("UDP relay used")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return s.DialUDP(ctx, &net.UDPAddr{IP: net.IPv4(10, 10, 0, 21), Port: 53})
+		},
+	}
+	addrs, err := resolver.LookupHost(context.Background(), "www.cc98.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0] != "10.10.98.98" {
+		t.Fatalf("LookupHost() = %v, want [10.10.98.98]", addrs)
+	}
+}
+
+func TestDialUDPRelaysOtherPorts(t *testing.T) {
+	s, err := NewStack(&fakeClient{udpErr: client.ErrUDPNotSupported})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.DialUDP(context.Background(), &net.UDPAddr{IP: net.IPv4(10, 10, 0, 1), Port: 123}); !errors.Is(err, client.ErrUDPNotSupported) {
+		t.Fatalf("DialUDP() error = %v, want client.ErrUDPNotSupported", err)
+	}
+}
+
+func TestDNSConnTruncatesLongMessages(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	conn := newDNSConn(local, &net.UDPAddr{IP: net.IPv4(10, 10, 0, 21), Port: 53})
+
+	go func() {
+		_, _ = remote.Write([]byte{0x00, 0x04, 'a', 'b', 'c', 'd', 0x00, 0x01, 'e'})
+	}()
+	buf := make([]byte, 2)
+	if n, err := conn.Read(buf); err != nil || string(buf[:n]) != "ab" {
+		t.Fatalf("Read() = %q, %v; want %q", buf[:n], err, "ab")
+	}
+	if n, err := conn.Read(buf); err != nil || string(buf[:n]) != "e" {
+		t.Fatalf("second Read() = %q, %v; want %q", buf[:n], err, "e")
+	}
+}
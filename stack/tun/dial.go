@@ -0,0 +1,53 @@
+package tun
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/mythologyli/zju-connect/resolve"
+	"github.com/mythologyli/zju-connect/stack"
+)
+
+func (s *Stack) DialTCP(ctx context.Context, addr *net.TCPAddr) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	if s.endpoint.client.CanUseTCPTunnel() && !resolve.TCPPrefersL3(ctx) {
+		conn, err = s.endpoint.client.DialTCP(ctx, addr)
+	} else {
+		conn, err = s.dialTCP(addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.tuning.TCPConn(conn), nil
+}
+
+func (s *Stack) DialUDP(ctx context.Context, addr *net.UDPAddr) (net.Conn, error) {
+	conn, err := s.dialUDP(addr)
+	if err != nil {
+		return nil, err
+	}
+	return s.TrackDNSConn(s.tuning.UDPConn(conn), addr), nil
+}
+
+// Ping sends the echo request from a raw ICMP socket bound to the virtual IP,
+// so that it is routed through the TUN device like other traffic.
+func (s *Stack) Ping(ctx context.Context, ip net.IP) (time.Duration, error) {
+	conn, err := net.DialIP("ip4:icmp", &net.IPAddr{IP: s.localIP()}, &net.IPAddr{IP: ip})
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	return stack.Echo(ctx, icmpConn{conn})
+}
+
+// icmpConn reads ICMP messages without the IPv4 header from a raw socket.
+type icmpConn struct {
+	*net.IPConn
+}
+
+func (c icmpConn) Read(p []byte) (int, error) {
+	n, _, err := c.ReadFrom(p)
+	return n, err
+}
@@ -1,18 +1,12 @@
 package tun
 
 import (
-	"context"
 	"net"
 
-	"github.com/mythologyli/zju-connect/resolve"
 	"inet.af/netaddr"
 )
 
-func (s *Stack) DialTCP(ctx context.Context, addr *net.TCPAddr) (net.Conn, error) {
-	if s.endpoint.client.CanUseTCPTunnel() && !resolve.TCPPrefersL3(ctx) {
-		return s.endpoint.client.DialTCP(ctx, addr)
-	}
-
+func (s *Stack) dialTCP(addr *net.TCPAddr) (net.Conn, error) {
 	s.endpoint.configMu.RLock()
 	defer s.endpoint.configMu.RUnlock()
 	prefix, ok := netaddr.FromStdIP(addr.IP)
@@ -22,7 +16,7 @@ func (s *Stack) DialTCP(ctx context.Context, addr *net.TCPAddr) (net.Conn, error
 	return net.DialTCP("tcp4", nil, addr)
 }
 
-func (s *Stack) DialUDP(ctx context.Context, addr *net.UDPAddr) (net.Conn, error) {
+func (s *Stack) dialUDP(addr *net.UDPAddr) (net.Conn, error) {
 	s.endpoint.configMu.RLock()
 	defer s.endpoint.configMu.RUnlock()
 	prefix, ok := netaddr.FromStdIP(addr.IP)
@@ -31,3 +25,9 @@ func (s *Stack) DialUDP(ctx context.Context, addr *net.UDPAddr) (net.Conn, error
 	}
 	return net.DialUDP("udp4", nil, addr)
 }
+
+func (s *Stack) localIP() net.IP {
+	s.endpoint.configMu.RLock()
+	defer s.endpoint.configMu.RUnlock()
+	return s.endpoint.ip
+}
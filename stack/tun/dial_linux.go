@@ -1,24 +1,23 @@
 package tun
 
 import (
-	"context"
 	"net"
-
-	"github.com/mythologyli/zju-connect/resolve"
 )
 
-func (s *Stack) DialTCP(ctx context.Context, addr *net.TCPAddr) (net.Conn, error) {
-	if s.endpoint.client.CanUseTCPTunnel() && !resolve.TCPPrefersL3(ctx) {
-		return s.endpoint.client.DialTCP(ctx, addr)
-	}
-
+func (s *Stack) dialTCP(addr *net.TCPAddr) (net.Conn, error) {
 	s.endpoint.configMu.RLock()
 	defer s.endpoint.configMu.RUnlock()
 	return s.endpoint.tcpDialer.Dial("tcp4", addr.String())
 }
 
-func (s *Stack) DialUDP(ctx context.Context, addr *net.UDPAddr) (net.Conn, error) {
+func (s *Stack) dialUDP(addr *net.UDPAddr) (net.Conn, error) {
 	s.endpoint.configMu.RLock()
 	defer s.endpoint.configMu.RUnlock()
 	return s.endpoint.udpDialer.Dial("udp4", addr.String())
 }
+
+func (s *Stack) localIP() net.IP {
+	s.endpoint.configMu.RLock()
+	defer s.endpoint.configMu.RUnlock()
+	return s.endpoint.ip
+}
@@ -1,26 +1,23 @@
 package tun
 
 import (
-	"context"
 	"net"
-
-	"github.com/mythologyli/zju-connect/resolve"
 )
 
-func (s *Stack) DialTCP(ctx context.Context, addr *net.TCPAddr) (net.Conn, error) {
-	if s.endpoint.client.CanUseTCPTunnel() && !resolve.TCPPrefersL3(ctx) {
-		return s.endpoint.client.DialTCP(ctx, addr)
-	}
-
+func (s *Stack) dialTCP(addr *net.TCPAddr) (net.Conn, error) {
 	return net.DialTCP("tcp4", &net.TCPAddr{
 		IP:   s.endpoint.ip,
 		Port: 0,
 	}, addr)
 }
 
-func (s *Stack) DialUDP(ctx context.Context, addr *net.UDPAddr) (net.Conn, error) {
+func (s *Stack) dialUDP(addr *net.UDPAddr) (net.Conn, error) {
 	return net.DialUDP("udp4", &net.UDPAddr{
 		IP:   s.endpoint.ip,
 		Port: 0,
 	}, addr)
 }
+
+func (s *Stack) localIP() net.IP {
+	return s.endpoint.ip
+}
@@ -0,0 +1,11 @@
+//go:build !linux || android
+
+package tun
+
+import "net"
+
+// SetupSystemDNS does nothing outside Linux. On macOS the DNS server is set
+// when creating the stack with DNS hijacking.
+func (s *Stack) SetupSystemDNS(string, net.IP, []string) error {
+	return nil
+}
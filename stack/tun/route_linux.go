@@ -0,0 +1,107 @@
+//go:build !android
+
+package tun
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"sync"
+
+	"github.com/mythologyli/zju-connect/internal/rtnetlink"
+	"github.com/mythologyli/zju-connect/log"
+	"golang.org/x/sys/unix"
+)
+
+// tunRulePriority is the priority of the rule that sends unmarked traffic to
+// the routing table of the TUN interface. It is looked up before the main
+// table (32766).
+const tunRulePriority = 6200
+
+// routeManager adds the routes to the TUN interface through netlink. It keeps
+// them so that they can be added again with a new virtual IP, and removes
+// them and the policy routing rule on exit.
+type routeManager struct {
+	mu        sync.Mutex
+	linkIndex int
+	src       netip.Addr
+	table     uint32
+	rule      *rtnetlink.Rule
+	routes    []netip.Prefix
+}
+
+// setPolicy puts the routes into table and adds a rule that looks it up for
+// all packets that are not marked with fwmark, such as the underlay
+// connections to the VPN server.
+func (m *routeManager) setPolicy(table, fwmark uint32) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.routes) > 0 || m.rule != nil {
+		return errors.New("routing policy must be set before adding routes")
+	}
+	rule := rtnetlink.Rule{Priority: tunRulePriority, Table: table, Fwmark: fwmark, Invert: true}
+	// The same rule may be left behind by a crashed instance.
+	if err := rtnetlink.AddRule(rule); err != nil && !errors.Is(err, unix.EEXIST) {
+		return fmt.Errorf("add rule to routing table %d: %w", table, err)
+	}
+	m.table = table
+	m.rule = &rule
+	log.Printf("Route packets without fwmark %d through routing table %d", fwmark, table)
+	return nil
+}
+
+// add adds the route to dst. An existing route to dst in the same table, such
+// as a route to a LAN, is kept and not removed on exit.
+func (m *routeManager) add(dst netip.Prefix) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := rtnetlink.AddRoute(m.route(dst)); err != nil {
+		if errors.Is(err, unix.EEXIST) {
+			return fmt.Errorf("keep the existing route to %s: %w", dst.Masked(), err)
+		}
+		return err
+	}
+	m.routes = append(m.routes, dst)
+	return nil
+}
+
+// setSource adds the routes again with the new virtual IP as their source,
+// since the kernel removes them together with the old address. Only routes
+// added by add are replaced.
+func (m *routeManager) setSource(src netip.Addr) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.src = src
+	var errs []error
+	for _, dst := range m.routes {
+		if err := rtnetlink.ReplaceRoute(m.route(dst)); err != nil {
+			errs = append(errs, fmt.Errorf("route to %s: %w", dst, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// cleanup removes the routes and the rule. Routes that are gone already,
+// e.g. because the TUN interface was closed, are skipped.
+func (m *routeManager) cleanup() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var errs []error
+	for _, dst := range m.routes {
+		if err := rtnetlink.DeleteRoute(m.route(dst)); err != nil && !errors.Is(err, unix.ESRCH) && !errors.Is(err, unix.ENODEV) {
+			errs = append(errs, fmt.Errorf("remove route to %s: %w", dst, err))
+		}
+	}
+	m.routes = nil
+	if m.rule != nil {
+		if err := rtnetlink.DeleteRule(*m.rule); err != nil && !errors.Is(err, unix.ENOENT) {
+			errs = append(errs, fmt.Errorf("remove rule to routing table %d: %w", m.table, err))
+		}
+		m.rule = nil
+	}
+	return errors.Join(errs...)
+}
+
+func (m *routeManager) route(dst netip.Prefix) rtnetlink.Route {
+	return rtnetlink.Route{Dst: dst.Masked(), LinkIndex: m.linkIndex, Src: m.src, Table: m.table}
+}
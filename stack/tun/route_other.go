@@ -0,0 +1,10 @@
+//go:build !linux || android
+
+package tun
+
+import "errors"
+
+// SetRoutePolicy is only supported on Linux.
+func (s *Stack) SetRoutePolicy(_, _ uint32) error {
+	return errors.New("custom routing tables are only supported on Linux")
+}
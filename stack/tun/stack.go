@@ -20,12 +20,12 @@ import (
 	"github.com/mythologyli/zju-connect/internal/zctcpip"
 	"github.com/mythologyli/zju-connect/log"
 	"github.com/mythologyli/zju-connect/resolve"
+	"github.com/mythologyli/zju-connect/stack/tuning"
 	"gvisor.dev/gvisor/pkg/buffer"
 	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
 	gvisorstack "gvisor.dev/gvisor/pkg/tcpip/stack"
 )
 
-const MTU uint32 = 1400
 const maxInboundPacketSize = 1500
 
 type Stack struct {
@@ -40,6 +40,15 @@ type Stack struct {
 	resourceCache       *resourceDecisionCache
 	ipPool              *ippool.IPPool[[]client.DomainResource]
 	fakeIP              bool
+	tuning              tuning.Options
+	// ownDNSPorts holds the local ports of the DNS queries of the resolver,
+	// which are sent through the TUN device too and must not be hijacked.
+	ownDNSPorts sync.Map
+	// systemDNS is what SetupSystemDNS set up, or on macOS the network
+	// services whose DNS server is the virtual IP. It is set up again when
+	// the virtual IP changes.
+	systemDNS systemDNS
+	ipUpdated func(net.IP)
 }
 
 func (s *Stack) SetupResolve(r zcdns.LocalServer) {
@@ -68,7 +77,7 @@ func (s *Stack) Run() {
 
 	// Read from VPN server and send to TUN stack
 	go func() {
-		buf := make([]byte, maxInboundPacketSize+tun.PacketOffset)
+		buf := make([]byte, max(maxInboundPacketSize, int(s.tuning.MTUOrDefault()))+tun.PacketOffset)
 		for {
 			n, err := s.l3Conn.Read(buf)
 			if err != nil {
@@ -93,7 +102,7 @@ func (s *Stack) Run() {
 	}()
 
 	// Read from TUN stack and send to VPN server
-	buf := make([]byte, MTU+tun.PacketOffset)
+	buf := make([]byte, s.tuning.MTUOrDefault()+tun.PacketOffset)
 	for {
 		n, err := s.endpoint.Read(buf)
 		if err != nil {
@@ -287,9 +296,35 @@ func (s *Stack) shouldHijackUDPDns(ipHeader zctcpip.IPv4Packet, udpHeader zctcpi
 	if udpHeader.DestinationPort() != 53 {
 		return false
 	}
+	if _, own := s.ownDNSPorts.Load(udpHeader.SourcePort()); own {
+		return false
+	}
 	return s.resolve.CheckDnsHijack(ipHeader.DestinationIP())
 }
 
+// TrackDNSConn remembers the local port of conn while it is open if it sends
+// DNS queries, so that they are not hijacked.
+func (s *Stack) TrackDNSConn(conn net.Conn, addr *net.UDPAddr) net.Conn {
+	localAddr, ok := conn.LocalAddr().(*net.UDPAddr)
+	if addr.Port != 53 || !ok {
+		return conn
+	}
+	port := uint16(localAddr.Port)
+	s.ownDNSPorts.Store(port, struct{}{})
+	return &dnsConn{Conn: conn, release: func() { s.ownDNSPorts.Delete(port) }}
+}
+
+type dnsConn struct {
+	net.Conn
+	closeOnce sync.Once
+	release   func()
+}
+
+func (c *dnsConn) Close() error {
+	c.closeOnce.Do(c.release)
+	return c.Conn.Close()
+}
+
 func (s *Stack) doHijackUDPDns(ipHeader zctcpip.IPv4Packet, udpHeader zctcpip.UDPPacket) {
 	log.DebugPrintf("hijack dns %s:%d -> %s:%d", ipHeader.SourceIP(), udpHeader.SourcePort(), ipHeader.DestinationIP(), udpHeader.DestinationPort())
 	msg := dns.Msg{}
@@ -297,7 +332,7 @@ func (s *Stack) doHijackUDPDns(ipHeader zctcpip.IPv4Packet, udpHeader zctcpip.UD
 		log.Printf("unpack dns msg error: %v", err)
 		return
 	}
-	ctx := context.Background()
+	ctx := zcdns.WithClientAddr(context.Background(), &net.UDPAddr{IP: ipHeader.SourceIP(), Port: int(udpHeader.SourcePort())})
 	if s.fakeIP && s.endpoint.client.CanUseTCPTunnel() {
 		ctx = context.WithValue(ctx, resolve.ContextKeyFakeIP, true)
 	}
@@ -11,6 +11,7 @@ import (
 	"github.com/mythologyli/zju-connect/internal/ippool"
 	"github.com/mythologyli/zju-connect/internal/zcdns"
 	"github.com/mythologyli/zju-connect/log"
+	"github.com/mythologyli/zju-connect/stack/tuning"
 	"golang.org/x/net/ipv4"
 )
 
@@ -20,6 +21,7 @@ const maxInboundPacketSize = 1500
 type Stack struct {
 	endpoint *Endpoint
 	l3Conn   io.ReadWriteCloser
+	tuning   tuning.Options
 }
 
 func (s *Stack) Run() {
@@ -108,8 +110,14 @@ func (s *Stack) SetupResolve(zcdns.LocalServer) {}
 
 func (s *Stack) SetupIPPool(*ippool.IPPool[[]client.DomainResource]) {}
 
-func NewStack(client client.Client, _ bool, _ bool, _ []client.IPResource) (*Stack, error) {
-	s := &Stack{}
+// TrackDNSConn does nothing, as DNS queries are not hijacked on Android.
+func (s *Stack) TrackDNSConn(conn net.Conn, _ *net.UDPAddr) net.Conn {
+	return conn
+}
+
+// NewStack ignores the MTU of opts, which is set up by the Android VPN service.
+func NewStack(client client.Client, _ bool, _ bool, _ []client.IPResource, opts tuning.Options) (*Stack, error) {
+	s := &Stack{tuning: opts}
 
 	s.endpoint = &Endpoint{
 		client: client,
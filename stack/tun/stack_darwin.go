@@ -14,6 +14,7 @@ import (
 	"github.com/mythologyli/zju-connect/client"
 	"github.com/mythologyli/zju-connect/internal/hook_func"
 	"github.com/mythologyli/zju-connect/log"
+	"github.com/mythologyli/zju-connect/stack/tuning"
 	"golang.org/x/sys/unix"
 	"inet.af/netaddr"
 )
@@ -86,9 +87,9 @@ func (s *Stack) AddDnsServer(dnsServer string, targetHost string) error {
 	return nil
 }
 
-func NewStack(vpnClient client.Client, dnsHijack, fakeIP bool, ipResources []client.IPResource) (*Stack, error) {
+func NewStack(vpnClient client.Client, dnsHijack, fakeIP bool, ipResources []client.IPResource, opts tuning.Options) (*Stack, error) {
 	var err error
-	s := &Stack{}
+	s := &Stack{tuning: opts}
 	s.ipResources = ipResources
 	s.fakeIP = fakeIP
 	s.endpoint = &Endpoint{
@@ -105,7 +106,7 @@ func NewStack(vpnClient client.Client, dnsHijack, fakeIP bool, ipResources []cli
 	tunName = tun.CalculateInterfaceName(tunName)
 	tunOptions := tun.Options{
 		Name: tunName,
-		MTU:  MTU,
+		MTU:  opts.MTUOrDefault(),
 		Inet4Address: []netip.Prefix{
 			ipPrefix,
 		},
@@ -169,13 +170,23 @@ func NewStack(vpnClient client.Client, dnsHijack, fakeIP bool, ipResources []cli
 		for _, dnsServer := range dnsServers {
 			if hook_func.SetDNSServerWithHook(dnsServer, s.endpoint.ip.String()) != nil {
 				log.Println("Warning: failed to set DNS server", s.endpoint.ifceName)
+				continue
 			}
+			s.systemDNS.services = append(s.systemDNS.services, dnsServer)
 		}
 	}
 	client.RegisterIPUpdateHandler(vpnClient, s.updateIP)
 	return s, nil
 }
 
+// OnIPUpdate makes f be called with the new virtual IP after it changed and
+// before the system DNS is pointed at it, e.g. to move a DNS server there.
+func (s *Stack) OnIPUpdate(f func(ip net.IP)) {
+	s.endpoint.configMu.Lock()
+	s.ipUpdated = f
+	s.endpoint.configMu.Unlock()
+}
+
 func (s *Stack) updateIP(ip net.IP) error {
 	newIP := ip.To4()
 	if newIP == nil {
@@ -199,5 +210,17 @@ func (s *Stack) updateIP(ip net.IP) error {
 	s.endpoint.ip = append(net.IP(nil), newIP...)
 	s.endpoint.tcpDialer.LocalAddr = &net.TCPAddr{IP: append(net.IP(nil), newIP...)}
 	s.endpoint.udpDialer.LocalAddr = &net.UDPAddr{IP: append(net.IP(nil), newIP...)}
+	if s.ipUpdated != nil {
+		s.ipUpdated(newIP)
+	}
+	for _, service := range s.systemDNS.services {
+		if err := hook_func.SetDNSServer(service, newIP.String()); err != nil {
+			return fmt.Errorf("set DNS server of %s to the new virtual IP: %w", service, err)
+		}
+	}
 	return nil
 }
+
+type systemDNS struct {
+	services []string
+}
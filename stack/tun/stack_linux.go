@@ -7,14 +7,15 @@ import (
 	"fmt"
 	"net"
 	"net/netip"
-	"os/exec"
 	"sync"
 	"syscall"
 
 	tun "github.com/mythologyli/sing-tun"
 	"github.com/mythologyli/zju-connect/client"
 	"github.com/mythologyli/zju-connect/internal/hook_func"
+	"github.com/mythologyli/zju-connect/internal/rtnetlink"
 	"github.com/mythologyli/zju-connect/log"
+	"github.com/mythologyli/zju-connect/stack/tuning"
 )
 
 type Endpoint struct {
@@ -22,6 +23,8 @@ type Endpoint struct {
 
 	ifce      tun.Tun
 	ifceName  string
+	ifceIndex int
+	routes    routeManager
 	readLock  sync.Mutex
 	writeLock sync.Mutex
 	configMu  sync.RWMutex
@@ -48,18 +51,43 @@ func (ep *Endpoint) Read(buf []byte) (int, error) {
 }
 
 func (s *Stack) AddRoute(target string) error {
-	command := exec.Command("ip", "route", "add", target, "dev", s.endpoint.ifceName)
-	err := command.Run()
+	prefix, err := netip.ParsePrefix(target)
 	if err != nil {
 		return err
 	}
+	if !prefix.Addr().Is4() {
+		return fmt.Errorf("not an IPv4 prefix: %s", target)
+	}
+	return s.endpoint.routes.add(prefix)
+}
+
+// SetRoutePolicy makes AddRoute add routes to table instead of the main table,
+// which is only looked up for packets without fwmark. It must be called
+// before AddRoute.
+func (s *Stack) SetRoutePolicy(table, fwmark uint32) error {
+	return s.endpoint.routes.setPolicy(table, fwmark)
+}
 
+// SetupSystemDNS makes the system ask dnsServer for domains through the TUN
+// interface. See hook_func.SetLinkDNSWithHook for the modes.
+func (s *Stack) SetupSystemDNS(mode string, dnsServer net.IP, domains []string) error {
+	s.endpoint.configMu.Lock()
+	defer s.endpoint.configMu.Unlock()
+	if err := hook_func.SetLinkDNSWithHook(mode, s.endpoint.ifceName, dnsServer, domains); err != nil {
+		return err
+	}
+	s.systemDNS = systemDNS{mode: mode, domains: domains}
 	return nil
 }
 
-func NewStack(vpnClient client.Client, dnsHijack, fakeIP bool, ipResources []client.IPResource) (*Stack, error) {
+type systemDNS struct {
+	mode    string
+	domains []string
+}
+
+func NewStack(vpnClient client.Client, dnsHijack, fakeIP bool, ipResources []client.IPResource, opts tuning.Options) (*Stack, error) {
 	var err error
-	s := &Stack{}
+	s := &Stack{tuning: opts}
 	s.ipResources = ipResources
 	s.fakeIP = fakeIP
 	s.endpoint = &Endpoint{
@@ -76,7 +104,7 @@ func NewStack(vpnClient client.Client, dnsHijack, fakeIP bool, ipResources []cli
 
 	tunOptions := tun.Options{
 		Name: tunName,
-		MTU:  MTU,
+		MTU:  opts.MTUOrDefault(),
 		Inet4Address: []netip.Prefix{
 			ipPrefix,
 		},
@@ -89,11 +117,29 @@ func NewStack(vpnClient client.Client, dnsHijack, fakeIP bool, ipResources []cli
 	if err != nil {
 		return nil, err
 	}
+	link, err := net.InterfaceByName(tunName)
+	if err != nil {
+		_ = ifce.Close()
+		return nil, err
+	}
+	// A crashed process may have left routes through a persistent interface
+	// of the same name.
+	if flushed, err := rtnetlink.FlushRoutes(link.Index); err != nil {
+		log.Printf("Remove stale TUN routes error: %s", err)
+	} else if flushed > 0 {
+		log.Printf("Removed %d stale TUN routes", flushed)
+	}
+	hook_func.RegisterTerminalFunc("Remove TUN Routes", func(ctx context.Context) error {
+		return s.endpoint.routes.cleanup()
+	})
 	hook_func.RegisterTerminalFunc("Close Tun Device", func(ctx context.Context) error {
 		return ifce.Close()
 	})
 	s.endpoint.ifce = ifce
 	s.endpoint.ifceName = tunName
+	s.endpoint.ifceIndex = link.Index
+	s.endpoint.routes.linkIndex = link.Index
+	s.endpoint.routes.src = ipPrefix.Addr()
 	log.Printf("Interface Name: %s\n", tunName)
 
 	// We need this dialer to bind to device otherwise packets will not be sent via TUN
@@ -129,6 +175,14 @@ func NewStack(vpnClient client.Client, dnsHijack, fakeIP bool, ipResources []cli
 	return s, nil
 }
 
+// OnIPUpdate makes f be called with the new virtual IP after it changed and
+// before the system DNS is pointed at it, e.g. to move a DNS server there.
+func (s *Stack) OnIPUpdate(f func(ip net.IP)) {
+	s.endpoint.configMu.Lock()
+	s.ipUpdated = f
+	s.endpoint.configMu.Unlock()
+}
+
 func (s *Stack) updateIP(ip net.IP) error {
 	newIP := ip.To4()
 	if newIP == nil {
@@ -140,17 +194,28 @@ func (s *Stack) updateIP(ip net.IP) error {
 	if oldIP.Equal(newIP) {
 		return nil
 	}
-	add := exec.Command("ip", "address", "replace", newIP.String()+"/32", "dev", s.endpoint.ifceName)
-	if output, err := add.CombinedOutput(); err != nil {
-		return fmt.Errorf("add virtual IP: %w: %s", err, output)
+	newAddr, _ := netip.AddrFromSlice(newIP)
+	oldAddr, _ := netip.AddrFromSlice(oldIP.To4())
+	if err := rtnetlink.ReplaceAddr(s.endpoint.ifceIndex, netip.PrefixFrom(newAddr, 32)); err != nil {
+		return fmt.Errorf("add virtual IP: %w", err)
 	}
-	del := exec.Command("ip", "address", "del", oldIP.String()+"/32", "dev", s.endpoint.ifceName)
-	if output, err := del.CombinedOutput(); err != nil {
-		_ = exec.Command("ip", "address", "del", newIP.String()+"/32", "dev", s.endpoint.ifceName).Run()
-		return fmt.Errorf("remove old virtual IP: %w: %s", err, output)
+	if err := rtnetlink.DeleteAddr(s.endpoint.ifceIndex, netip.PrefixFrom(oldAddr, 32)); err != nil {
+		_ = rtnetlink.DeleteAddr(s.endpoint.ifceIndex, netip.PrefixFrom(newAddr, 32))
+		return fmt.Errorf("remove old virtual IP: %w", err)
 	}
 	s.endpoint.ip = append(net.IP(nil), newIP...)
 	s.endpoint.tcpDialer.LocalAddr = &net.TCPAddr{IP: append(net.IP(nil), newIP...)}
 	s.endpoint.udpDialer.LocalAddr = &net.UDPAddr{IP: append(net.IP(nil), newIP...)}
+	if err := s.endpoint.routes.setSource(newAddr); err != nil {
+		return fmt.Errorf("re-add routes for the new virtual IP: %w", err)
+	}
+	if s.ipUpdated != nil {
+		s.ipUpdated(newIP)
+	}
+	if s.systemDNS.mode != "" {
+		if err := hook_func.UpdateLinkDNS(s.systemDNS.mode, s.endpoint.ifceName, newIP, s.systemDNS.domains); err != nil {
+			return fmt.Errorf("set system DNS to the new virtual IP: %w", err)
+		}
+	}
 	return nil
 }
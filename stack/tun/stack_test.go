@@ -11,6 +11,7 @@ import (
 
 	"github.com/mythologyli/zju-connect/client"
 	"github.com/mythologyli/zju-connect/client/atrust"
+	"github.com/mythologyli/zju-connect/internal/zcdns"
 	"github.com/mythologyli/zju-connect/internal/zctcpip"
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
@@ -160,3 +161,34 @@ func TestProcessIPV4TCPPreferringL3BypassesTCPListener(t *testing.T) {
 		t.Fatalf("L3 packet = %x, want %x", l3Conn.packet, packet)
 	}
 }
+
+type hijackAllDNS struct{ zcdns.LocalServer }
+
+func (hijackAllDNS) CheckDnsHijack(net.IP) bool { return true }
+
+func TestResolverDNSQueriesAreNotHijacked(t *testing.T) {
+	s := &Stack{resolve: hijackAllDNS{}}
+	packet := make(zctcpip.IPv4Packet, zctcpip.IPv4HeaderSize+zctcpip.UDPHeaderSize)
+	packet[0] = zctcpip.IPv4Version << 4
+	packet.SetHeaderLen(zctcpip.IPv4HeaderSize)
+	packet.SetTotalLength(uint16(len(packet)))
+	packet.SetProtocol(zctcpip.UDP)
+	packet.SetSourceIP(net.IPv4(192, 0, 2, 1))
+	packet.SetDestinationIP(net.IPv4(10, 10, 0, 21))
+	udpPacket := zctcpip.UDPPacket(packet.Payload())
+	udpPacket.SetDestinationPort(53)
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracked := s.TrackDNSConn(conn, &net.UDPAddr{IP: net.IPv4(10, 10, 0, 21), Port: 53})
+	udpPacket.SetSourcePort(uint16(conn.LocalAddr().(*net.UDPAddr).Port))
+	if s.shouldHijackUDPDns(packet, udpPacket) {
+		t.Fatal("query of the resolver is hijacked")
+	}
+	tracked.Close()
+	if !s.shouldHijackUDPDns(packet, udpPacket) {
+		t.Fatal("query from the same port after close is not hijacked")
+	}
+}
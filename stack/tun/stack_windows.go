@@ -11,6 +11,7 @@ import (
 	"github.com/mythologyli/zju-connect/client"
 	"github.com/mythologyli/zju-connect/internal/hook_func"
 	"github.com/mythologyli/zju-connect/log"
+	"github.com/mythologyli/zju-connect/stack/tuning"
 	"golang.org/x/sys/windows"
 	"golang.zx2c4.com/wireguard/tun"
 	"golang.zx2c4.com/wireguard/windows/tunnel/winipcfg"
@@ -78,8 +79,8 @@ func (s *Stack) AddRoute(target string) error {
 	return nil
 }
 
-func NewStack(client client.Client, dnsHijack, fakeIP bool, ipResources []client.IPResource) (*Stack, error) {
-	s := &Stack{}
+func NewStack(client client.Client, dnsHijack, fakeIP bool, ipResources []client.IPResource, opts tuning.Options) (*Stack, error) {
+	s := &Stack{tuning: opts}
 	s.ipResources = ipResources
 	s.fakeIP = fakeIP
 
@@ -88,7 +89,7 @@ func NewStack(client client.Client, dnsHijack, fakeIP bool, ipResources []client
 		return nil, err
 	}
 
-	dev, err := tun.CreateTUNWithRequestedGUID(interfaceName, &guid, int(MTU))
+	dev, err := tun.CreateTUNWithRequestedGUID(interfaceName, &guid, int(opts.MTUOrDefault()))
 	if err != nil {
 		return nil, err
 	}
@@ -118,8 +119,8 @@ func NewStack(client client.Client, dnsHijack, fakeIP bool, ipResources []client
 		log.Printf("Set IP address failed: %v", err)
 	}
 
-	// Set MTU to 1400 otherwise error may occur when packets are large
-	command := exec.Command("netsh", "interface", "ipv4", "set", "subinterface", interfaceName, fmt.Sprintf("mtu=%d", MTU), "store=persistent")
+	// Set MTU, 1400 by default, otherwise error may occur when packets are large
+	command := exec.Command("netsh", "interface", "ipv4", "set", "subinterface", interfaceName, fmt.Sprintf("mtu=%d", opts.MTUOrDefault()), "store=persistent")
 	err = command.Run()
 	if err != nil {
 		log.Printf("Run %s failed: %v", command.String(), err)
@@ -157,3 +158,8 @@ func NewStack(client client.Client, dnsHijack, fakeIP bool, ipResources []client
 	})
 	return s, nil
 }
+
+// OnIPUpdate does nothing on Windows, where the virtual IP does not change.
+func (s *Stack) OnIPUpdate(func(ip net.IP)) {}
+
+type systemDNS struct{}
@@ -12,6 +12,7 @@ import (
 	"github.com/mythologyli/zju-connect/internal/hook_func"
 	"github.com/mythologyli/zju-connect/log"
 	"github.com/mythologyli/zju-connect/resolve"
+	zcgvisor "github.com/mythologyli/zju-connect/stack/gvisor"
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
@@ -26,16 +27,17 @@ const NICID tcpip.NICID = 2
 type TCPListenerEndpoint struct {
 	tunEndpoint *Endpoint
 	dispatcher  stack.NetworkDispatcher
+	mtu         uint32
 }
 
 func (ep *TCPListenerEndpoint) ParseHeader(*stack.PacketBuffer) bool {
 	return true
 }
 
-func (ep *TCPListenerEndpoint) MTU() uint32 { return MTU }
+func (ep *TCPListenerEndpoint) MTU() uint32 { return ep.mtu }
 
 func (ep *TCPListenerEndpoint) SetMTU(mtu uint32) {
-	log.Printf("don't support change MTU from %d to %d", MTU, mtu)
+	log.Printf("don't support change MTU from %d to %d", ep.mtu, mtu)
 }
 
 func (ep *TCPListenerEndpoint) MaxHeaderLength() uint16 {
@@ -104,6 +106,7 @@ func (ep *TCPListenerEndpoint) WritePackets(list stack.PacketBufferList) (int, t
 func (s *Stack) CreateTCPListener() error {
 	s.tcpListenerEndpoint = &TCPListenerEndpoint{
 		tunEndpoint: s.endpoint,
+		mtu:         s.tuning.MTUOrDefault(),
 	}
 	s.tcpListenerStack = stack.New(stack.Options{
 		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
@@ -117,10 +120,7 @@ func (s *Stack) CreateTCPListener() error {
 	s.tcpListenerStack.SetPromiscuousMode(NICID, true)
 	s.tcpListenerStack.SetSpoofing(NICID, true)
 	s.tcpListenerStack.AddRoute(tcpip.Route{Destination: header.IPv4EmptySubnet, NIC: NICID})
-	sOpt := tcpip.TCPSACKEnabled(true)
-	s.tcpListenerStack.SetTransportProtocolOption(tcp.ProtocolNumber, &sOpt)
-
-	return nil
+	return zcgvisor.ApplyTuning(s.tcpListenerStack, s.tuning)
 }
 
 func (s *Stack) StartTCPListener() {
@@ -137,7 +137,8 @@ func (s *Stack) StartTCPListener() {
 			return
 		}
 		r.Complete(false)
-		localConn := gonet.NewTCPConn(&w, ep)
+		zcgvisor.TuneTCPEndpoint(ep, s.tuning)
+		localConn := s.tuning.TCPConn(gonet.NewTCPConn(&w, ep))
 		go s.handleInboundConn(localConn, outboundAddr, outboundPort)
 	})
 	s.tcpListenerStack.SetTransportProtocolHandler(tcp.ProtocolNumber, forwarder.HandlePacket)
@@ -0,0 +1,113 @@
+package tuning
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// timeoutConn closes the connection when it has been idle for idleTimeout or
+// open for maxLifetime.
+type timeoutConn struct {
+	net.Conn
+	idleTimeout time.Duration
+	// lastActive is the time of the last read or write in Unix nanoseconds.
+	lastActive atomic.Int64
+
+	timerLock sync.Mutex
+	idleTimer *time.Timer
+	lifeTimer *time.Timer
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// halfCloser is implemented by TCP connections, which callers half close when
+// one direction is done.
+type halfCloser interface {
+	CloseRead() error
+	CloseWrite() error
+}
+
+// halfCloseTimeoutConn is a timeoutConn that forwards half closes, so that
+// wrapping a TCP connection does not hide them.
+type halfCloseTimeoutConn struct {
+	*timeoutConn
+	halfCloser halfCloser
+}
+
+func (c *halfCloseTimeoutConn) CloseRead() error {
+	return c.halfCloser.CloseRead()
+}
+
+func (c *halfCloseTimeoutConn) CloseWrite() error {
+	return c.halfCloser.CloseWrite()
+}
+
+func withTimeouts(conn net.Conn, idleTimeout, maxLifetime time.Duration) net.Conn {
+	if idleTimeout <= 0 && maxLifetime <= 0 {
+		return conn
+	}
+	c := &timeoutConn{Conn: conn, idleTimeout: idleTimeout}
+	c.lastActive.Store(time.Now().UnixNano())
+	c.timerLock.Lock()
+	defer c.timerLock.Unlock()
+	if idleTimeout > 0 {
+		c.idleTimer = time.AfterFunc(idleTimeout, c.checkIdle)
+	}
+	if maxLifetime > 0 {
+		c.lifeTimer = time.AfterFunc(maxLifetime, func() { _ = c.Close() })
+	}
+	if h, ok := conn.(halfCloser); ok {
+		return &halfCloseTimeoutConn{timeoutConn: c, halfCloser: h}
+	}
+	return c
+}
+
+// checkIdle closes the connection if it has been idle long enough, otherwise
+// it checks again when it would be.
+func (c *timeoutConn) checkIdle() {
+	idle := time.Since(time.Unix(0, c.lastActive.Load()))
+	if idle >= c.idleTimeout {
+		_ = c.Close()
+		return
+	}
+	c.timerLock.Lock()
+	defer c.timerLock.Unlock()
+	if c.idleTimer != nil {
+		c.idleTimer.Reset(c.idleTimeout - idle)
+	}
+}
+
+func (c *timeoutConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.lastActive.Store(time.Now().UnixNano())
+	}
+	return n, err
+}
+
+func (c *timeoutConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.lastActive.Store(time.Now().UnixNano())
+	}
+	return n, err
+}
+
+func (c *timeoutConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.timerLock.Lock()
+		if c.idleTimer != nil {
+			c.idleTimer.Stop()
+			c.idleTimer = nil
+		}
+		if c.lifeTimer != nil {
+			c.lifeTimer.Stop()
+			c.lifeTimer = nil
+		}
+		c.timerLock.Unlock()
+		c.closeErr = c.Conn.Close()
+	})
+	return c.closeErr
+}
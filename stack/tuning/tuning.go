@@ -0,0 +1,163 @@
+// Package tuning holds the TCP and UDP settings shared by the gVisor and TUN
+// stacks.
+package tuning
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	DefaultMTU uint32 = 1400
+	minMTU     uint32 = 576
+	maxMTU     uint32 = 9000
+
+	// The buffer sizes gVisor uses unless they are configured.
+	MinBufferSize            = 4 << 10
+	DefaultSendBufferSize    = 1 << 20
+	DefaultReceiveBufferSize = 1 << 20
+	DefaultMaxBufferSize     = 4 << 20
+
+	DefaultKeepAliveInterval = 15 * time.Second
+)
+
+// Options tunes the TCP and UDP connections of a stack. The zero value of a
+// field keeps the default.
+type Options struct {
+	// SendBuffer and ReceiveBuffer are the initial buffer sizes of a TCP
+	// connection in bytes, the Max fields the sizes auto-tuning may grow them
+	// to.
+	SendBuffer        int
+	SendBufferMax     int
+	ReceiveBuffer     int
+	ReceiveBufferMax  int
+	BufferAutoTune    bool
+	CongestionControl string
+	MTU               uint32
+
+	// KeepAlive is the idle time before TCP keepalive probes are sent, which
+	// are then sent every KeepAliveInterval.
+	KeepAlive         time.Duration
+	KeepAliveInterval time.Duration
+
+	// TCPIdleTimeout and TCPMaxLifetime close TCP connections without traffic
+	// for that long and TCP connections open for that long.
+	TCPIdleTimeout time.Duration
+	TCPMaxLifetime time.Duration
+	// UDPIdleTimeout closes UDP flows without traffic for that long.
+	UDPIdleTimeout time.Duration
+}
+
+// Default returns the options used when nothing is configured.
+func Default() Options {
+	return Options{
+		CongestionControl: "cubic",
+		MTU:               DefaultMTU,
+		KeepAliveInterval: DefaultKeepAliveInterval,
+	}
+}
+
+func (o Options) Validate() error {
+	switch o.CongestionControl {
+	case "", "cubic", "reno":
+	default:
+		return fmt.Errorf("unsupported congestion control %q, use cubic or reno", o.CongestionControl)
+	}
+	if o.MTU != 0 && (o.MTU < minMTU || o.MTU > maxMTU) {
+		return fmt.Errorf("MTU %d is not between %d and %d", o.MTU, minMTU, maxMTU)
+	}
+	for _, buffer := range []struct {
+		name          string
+		size, maxSize int
+		defaultSize   int
+	}{
+		{"send", o.SendBuffer, o.SendBufferMax, DefaultSendBufferSize},
+		{"receive", o.ReceiveBuffer, o.ReceiveBufferMax, DefaultReceiveBufferSize},
+	} {
+		if buffer.size < 0 || buffer.maxSize < 0 {
+			return fmt.Errorf("negative TCP %s buffer size", buffer.name)
+		}
+		_, size, maxSize := bufferRange(buffer.size, buffer.maxSize, buffer.defaultSize)
+		if size < MinBufferSize {
+			return fmt.Errorf("TCP %s buffer size %d is less than %d", buffer.name, size, MinBufferSize)
+		}
+		if size > maxSize {
+			return fmt.Errorf("TCP %s buffer size %d is larger than the maximum %d", buffer.name, size, maxSize)
+		}
+	}
+	for _, timeout := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"TCP keepalive", o.KeepAlive},
+		{"TCP keepalive interval", o.KeepAliveInterval},
+		{"TCP idle timeout", o.TCPIdleTimeout},
+		{"TCP max lifetime", o.TCPMaxLifetime},
+		{"UDP idle timeout", o.UDPIdleTimeout},
+	} {
+		if timeout.value < 0 {
+			return fmt.Errorf("negative %s", timeout.name)
+		}
+	}
+	return nil
+}
+
+// SendBufferRange returns the minimum, initial and maximum TCP send buffer
+// sizes.
+func (o Options) SendBufferRange() (int, int, int) {
+	return bufferRange(o.SendBuffer, o.SendBufferMax, DefaultSendBufferSize)
+}
+
+// ReceiveBufferRange returns the minimum, initial and maximum TCP receive
+// buffer sizes.
+func (o Options) ReceiveBufferRange() (int, int, int) {
+	return bufferRange(o.ReceiveBuffer, o.ReceiveBufferMax, DefaultReceiveBufferSize)
+}
+
+// bufferRange fills in the defaults. The maximum is raised to a configured
+// initial size, so that only setting that is enough.
+func bufferRange(size, maxSize, defaultSize int) (int, int, int) {
+	if size == 0 {
+		size = defaultSize
+	}
+	if maxSize == 0 {
+		maxSize = max(DefaultMaxBufferSize, size)
+	}
+	return min(MinBufferSize, size), size, maxSize
+}
+
+// MTUOrDefault returns the configured MTU or DefaultMTU.
+func (o Options) MTUOrDefault() uint32 {
+	if o.MTU == 0 {
+		return DefaultMTU
+	}
+	return o.MTU
+}
+
+// KeepAliveIntervalOrDefault returns the configured keepalive interval or
+// DefaultKeepAliveInterval.
+func (o Options) KeepAliveIntervalOrDefault() time.Duration {
+	if o.KeepAliveInterval == 0 {
+		return DefaultKeepAliveInterval
+	}
+	return o.KeepAliveInterval
+}
+
+// TCPConn sets up keepalive on conn if it is a socket and applies the TCP
+// timeouts to it.
+func (o Options) TCPConn(conn net.Conn) net.Conn {
+	if tcpConn, ok := conn.(*net.TCPConn); ok && o.KeepAlive > 0 {
+		_ = tcpConn.SetKeepAliveConfig(net.KeepAliveConfig{
+			Enable:   true,
+			Idle:     o.KeepAlive,
+			Interval: o.KeepAliveIntervalOrDefault(),
+		})
+	}
+	return withTimeouts(conn, o.TCPIdleTimeout, o.TCPMaxLifetime)
+}
+
+// UDPConn applies the UDP idle timeout to conn.
+func (o Options) UDPConn(conn net.Conn) net.Conn {
+	return withTimeouts(conn, o.UDPIdleTimeout, 0)
+}
@@ -0,0 +1,145 @@
+package tuning
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		options Options
+		ok      bool
+	}{
+		{"default", Default(), true},
+		{"zero", Options{}, true},
+		{"reno", Options{CongestionControl: "reno"}, true},
+		{"bbr", Options{CongestionControl: "bbr"}, false},
+		{"small MTU", Options{MTU: 500}, false},
+		{"jumbo MTU", Options{MTU: 9000}, true},
+		{"large buffers", Options{ReceiveBuffer: 8 << 20, ReceiveBufferMax: 32 << 20}, true},
+		{"buffer above max", Options{SendBuffer: 2 << 20, SendBufferMax: 1 << 20}, false},
+		{"tiny buffer", Options{ReceiveBuffer: 1024}, false},
+		{"negative timeout", Options{UDPIdleTimeout: -time.Second}, false},
+	} {
+		if err := tt.options.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestBufferRange(t *testing.T) {
+	if minSize, size, maxSize := (Options{}).ReceiveBufferRange(); minSize != MinBufferSize || size != DefaultReceiveBufferSize || maxSize != DefaultMaxBufferSize {
+		t.Errorf("default ReceiveBufferRange() = %d, %d, %d", minSize, size, maxSize)
+	}
+	if _, size, maxSize := (Options{SendBuffer: 8 << 20}).SendBufferRange(); size != 8<<20 || maxSize != 8<<20 {
+		t.Errorf("SendBufferRange() = %d, %d, want the maximum raised to the initial size", size, maxSize)
+	}
+}
+
+// closeConn records when it is closed.
+type closeConn struct {
+	net.Conn
+	closed chan struct{}
+}
+
+func (c *closeConn) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func (c *closeConn) Read(p []byte) (int, error)  { return len(p), nil }
+func (c *closeConn) Write(p []byte) (int, error) { return len(p), nil }
+
+func TestIdleTimeoutClosesIdleConn(t *testing.T) {
+	conn := &closeConn{closed: make(chan struct{})}
+	wrapped := Options{UDPIdleTimeout: 100 * time.Millisecond}.UDPConn(conn)
+
+	// Traffic keeps the connection open past the timeout.
+	deadline := time.Now().Add(200 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		if _, err := wrapped.Write([]byte("x")); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-conn.closed:
+			t.Fatal("connection with traffic was closed")
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+
+	select {
+	case <-conn.closed:
+	case <-time.After(time.Second):
+		t.Fatal("idle connection was not closed")
+	}
+	if err := wrapped.Close(); err != nil {
+		t.Fatalf("second Close() = %v", err)
+	}
+}
+
+func TestMaxLifetimeClosesConn(t *testing.T) {
+	conn := &closeConn{closed: make(chan struct{})}
+	wrapped := Options{TCPMaxLifetime: 50 * time.Millisecond}.TCPConn(conn)
+	if _, err := wrapped.Read(make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-conn.closed:
+	case <-time.After(time.Second):
+		t.Fatal("connection was not closed at its max lifetime")
+	}
+}
+
+func TestNoTimeoutsKeepsConn(t *testing.T) {
+	conn := &closeConn{closed: make(chan struct{})}
+	if wrapped := Default().TCPConn(conn); wrapped != net.Conn(conn) {
+		t.Fatalf("TCPConn() = %T, want the connection itself", wrapped)
+	}
+}
+
+func TestTimeoutsKeepHalfClose(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	opts := Options{TCPIdleTimeout: time.Minute}
+	if _, ok := opts.TCPConn(client).(halfCloser); ok {
+		t.Fatal("connection without half close gained it")
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	wrapped := opts.TCPConn(conn)
+	defer wrapped.Close()
+	h, ok := wrapped.(halfCloser)
+	if !ok {
+		t.Fatalf("TCPConn() = %T, which cannot half close", wrapped)
+	}
+	if err := h.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	// The peer sees EOF while the connection stays open for its reply.
+	if n, err := peer.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Fatalf("peer Read() = %d, %v, want EOF", n, err)
+	}
+	if _, err := peer.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wrapped.Read(make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+}